@@ -0,0 +1,31 @@
+// Command mockoidc runs a throwaway OpenID Connect provider for trying single
+// sign-on locally. It trusts whatever is entered in its login form.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"user-team-asset-management/internal/oidc/mockoidc"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL, which OIDC_ISSUER must match")
+	email := flag.String("email", "mock.user@example.com", "email address the login form starts with")
+	groups := flag.String("groups", "", "comma-separated groups the login form starts with")
+	flag.Parse()
+
+	provider, err := mockoidc.New(*issuer)
+	if err != nil {
+		log.Fatal("Failed to create the mock provider:", err)
+	}
+	provider.Identity.Email = *email
+	if *groups != "" {
+		provider.Identity.Groups = strings.Split(*groups, ",")
+	}
+
+	log.Printf("Mock OIDC provider for %s listening on %s", provider.Issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, provider))
+}
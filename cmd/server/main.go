@@ -1,40 +1,183 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"time"
+	"user-team-asset-management/internal/access"
+	"user-team-asset-management/internal/accounts"
+	"user-team-asset-management/internal/assets"
+	"user-team-asset-management/internal/auth"
 	"user-team-asset-management/internal/config"
 	"user-team-asset-management/internal/database"
+	"user-team-asset-management/internal/events"
 	"user-team-asset-management/internal/graphql"
 	"user-team-asset-management/internal/handlers"
 	"user-team-asset-management/internal/logger"
+	"user-team-asset-management/internal/mail"
 	"user-team-asset-management/internal/middleware"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/oidc"
+	"user-team-asset-management/internal/persisted"
+	"user-team-asset-management/internal/policy"
+	"user-team-asset-management/internal/scim"
+	"user-team-asset-management/internal/search"
+	"user-team-asset-management/internal/trash"
+	"user-team-asset-management/internal/utils"
 
 	"github.com/gin-gonic/gin"
-	"github.com/graphql-go/handler"
+	"gorm.io/gorm"
 )
 
 func main() {
 	cfg := config.Load()
 	db := database.Connect(cfg.DatabaseURL)
 
+	keys, err := loadKeyring(cfg)
+	if err != nil {
+		log.Fatal("Failed to load JWT signing keys:", err)
+	}
+
+	sessions := &auth.SessionManager{
+		DB:              db,
+		Keys:            keys,
+		AccessTokenTTL:  cfg.AccessTokenTTL,
+		RefreshTokenTTL: cfg.RefreshTokenTTL,
+	}
+
+	mailer, err := mail.New(cfg.MailDriver, cfg.MailDir, cfg.MailFrom, db)
+	if err != nil {
+		log.Fatal("Failed to set up mail:", err)
+	}
+	logins := &auth.LoginGuard{
+		DB: db,
+		Account: auth.LoginLimits{
+			BackoffAfter: cfg.LoginAccountBackoffAfter,
+			LockoutAfter: cfg.LoginAccountLockoutAfter,
+		},
+		IP: auth.LoginLimits{
+			BackoffAfter: cfg.LoginIPBackoffAfter,
+			LockoutAfter: cfg.LoginIPLockoutAfter,
+		},
+		BaseDelay:       cfg.LoginBackoffBase,
+		MaxDelay:        cfg.LoginBackoffMax,
+		LockoutDuration: cfg.LoginLockoutDuration,
+		FailureWindow:   cfg.LoginFailureWindow,
+	}
+
+	passwords := &auth.PasswordManager{
+		DB: db,
+		Policy: auth.PasswordPolicy{
+			MinLength:     cfg.PasswordMinLength,
+			RequireUpper:  cfg.PasswordRequireUpper,
+			RequireLower:  cfg.PasswordRequireLower,
+			RequireDigit:  cfg.PasswordRequireDigit,
+			RequireSymbol: cfg.PasswordRequireSymbol,
+		},
+		Mailer:        mailer,
+		ResetTokenTTL: cfg.PasswordResetTTL,
+		ResetURL:      cfg.PasswordResetURL,
+		Logins:        logins,
+		ResetRequests: &auth.LoginGuard{
+			DB:            db,
+			Scope:         "reset",
+			Account:       auth.LoginLimits{BackoffAfter: cfg.PasswordResetAccountLimit},
+			IP:            auth.LoginLimits{BackoffAfter: cfg.PasswordResetIPLimit},
+			BaseDelay:     cfg.LoginBackoffBase,
+			MaxDelay:      cfg.LoginBackoffMax,
+			FailureWindow: cfg.LoginFailureWindow,
+		},
+	}
+
+	if err := seedAdmin(cfg, db, passwords); err != nil {
+		log.Fatal("Failed to create initial admin:", err)
+	}
+
+	accountService := &accounts.Service{
+		DB:              db,
+		Sessions:        sessions,
+		Mailer:          mailer,
+		VerificationTTL: cfg.EmailVerificationTTL,
+		VerifyURL:       cfg.EmailVerificationURL,
+	}
+	tokens := &auth.PATManager{DB: db, MaxTTL: cfg.PersonalAccessTokenMaxTTL}
+
+	for _, role := range cfg.TwoFactorRequiredRoles {
+		if !policy.IsRole(role) {
+			log.Fatalf("Unknown role %q in TWO_FACTOR_REQUIRED_ROLES", role)
+		}
+	}
+	twoFactor := &auth.TwoFactorManager{
+		DB:            db,
+		Issuer:        cfg.TwoFactorIssuer,
+		ChallengeTTL:  cfg.TwoFactorChallengeTTL,
+		RequiredRoles: cfg.TwoFactorRequiredRoles,
+		Logins:        logins,
+	}
+
+	authz := policy.NewAuthorizer(db)
+	authz.TwoFactorRoles = cfg.TwoFactorRequiredRoles
+	accessResolver := access.NewResolver(db)
+	searchService := &search.Service{DB: db}
+	bus := events.NewBus()
+	assetService := &assets.Service{
+		DB:             db,
+		Authz:          authz,
+		Access:         accessResolver,
+		Events:         bus,
+		TrashRetention: cfg.TrashRetention,
+	}
+
+	sso, err := newOIDCService(cfg, db, accountService)
+	if err != nil {
+		log.Fatal("Failed to set up single sign-on:", err)
+	}
+
+	persistedQueries, err := loadPersistedQueries(cfg, db)
+	if err != nil {
+		log.Fatal("Failed to load persisted queries:", err)
+	}
+
 	// GraphQL setup
-	resolver := &graphql.Resolver{DB: db, JWTSecret: cfg.JWTSecret}
+	resolver := &graphql.Resolver{
+		DB:        db,
+		Sessions:  sessions,
+		Passwords: passwords,
+		Logins:    logins,
+		TwoFactor: twoFactor,
+		Accounts:  accountService,
+		Authz:     authz,
+		Access:    accessResolver,
+		Search:    searchService,
+		Assets:    assetService,
+		Events:    bus,
+		Limits: graphql.Limits{
+			MaxDepth:      cfg.GraphQLMaxDepth,
+			MaxComplexity: cfg.GraphQLMaxComplexity,
+			FieldCosts:    cfg.GraphQLFieldCosts,
+		},
+		PersistedQueries: persistedQueries,
+		OIDC:             sso,
+	}
 	schema, err := resolver.CreateSchema()
 	if err != nil {
 		log.Fatal("Failed to create GraphQL schema:", err)
 	}
 
-	graphqlHandler := handler.New(&handler.Config{
-		Schema:   &schema,
-		Pretty:   true,
-		GraphiQL: true,
-	})
+	graphqlHandler := graphql.NewHandler(schema, resolver, cfg.IsDevelopment())
 
 	// REST API setup
-	teamHandler := &handlers.TeamHandler{DB: db}
-	assetHandler := &handlers.AssetHandler{DB: db}
-	userHandler := &handlers.UserHandler{DB: db}
-	importHandler := &handlers.ImportHandler{DB: db, JWTSecret: cfg.JWTSecret}
+	teamHandler := &handlers.TeamHandler{DB: db, Authz: authz, Events: bus}
+	assetHandler := &handlers.AssetHandler{DB: db, Authz: authz, Access: accessResolver, Assets: assetService}
+	userHandler := &handlers.UserHandler{DB: db, Authz: authz, Accounts: accountService}
+	importHandler := &handlers.ImportHandler{DB: db, Authz: authz, Passwords: passwords, Accounts: accountService}
+	roleHandler := &handlers.RoleHandler{DB: db, Authz: authz}
+	searchHandler := &handlers.SearchHandler{Service: searchService}
+	authHandler := &handlers.AuthHandler{Keys: keys}
+	securityHandler := &handlers.SecurityHandler{DB: db, Authz: authz, Logins: logins}
+	tokenHandler := &handlers.TokenHandler{DB: db, Authz: authz, Tokens: tokens, Accounts: accountService}
 
 	r := gin.Default()
 
@@ -42,59 +185,278 @@ func main() {
 	r.Use(logger.GinLogger())
 	r.Use(logger.Recovery())
 
-	// GraphQL endpoint
-	r.POST("/graphql", gin.WrapH(graphqlHandler))
-	r.GET("/graphql", gin.WrapH(graphqlHandler))
+	// Public keys for verifying access tokens
+	r.GET("/.well-known/jwks.json", authHandler.JWKS)
+
+	// The mailed token authenticates email verification
+	r.POST("/api/verify-email", userHandler.VerifyEmail)
+
+	// Single sign-on through the OpenID Connect provider
+	if sso != nil {
+		oidcHandler := &handlers.OIDCHandler{OIDC: sso, FrontendURL: cfg.OIDCFrontendURL}
+		r.GET("/auth/oidc/login", oidcHandler.Login)
+		r.GET("/auth/oidc/callback", oidcHandler.Callback)
+	}
+
+	// Provisioning by the identity provider, authenticated with SCIM_TOKEN
+	if cfg.SCIMToken != "" {
+		if !policy.IsGlobalRole(cfg.SCIMDefaultRole) {
+			log.Fatalf("Unknown role %q in SCIM_DEFAULT_ROLE", cfg.SCIMDefaultRole)
+		}
+		scimServer := &scim.Server{
+			DB:          db,
+			Accounts:    accountService,
+			Passwords:   passwords,
+			Events:      bus,
+			Token:       cfg.SCIMToken,
+			BaseURL:     cfg.SCIMBaseURL,
+			DefaultRole: cfg.SCIMDefaultRole,
+		}
+		scimServer.Register(r.Group("/scim/v2"))
+	}
+
+	// Mail kept by the outbox driver, with the links it holds
+	if cfg.IsDevelopment() && cfg.MailDriver == "outbox" {
+		outboxHandler := &handlers.OutboxHandler{DB: db}
+		r.GET("/dev/outbox", outboxHandler.ListMessages)
+	}
+
+	// GraphQL endpoint. Resolvers read the caller from the request context.
+	// WebSocket upgrades carry subscriptions and authenticate themselves.
+	serveGraphQL := func(c *gin.Context) {
+		ctx := graphql.WithClientIP(c.Request.Context(), c.ClientIP())
+		ctx = graphql.WithSessionID(ctx, c.GetString("sessionID"))
+		ctx = resolver.RequestContext(ctx, c.GetString("userID"))
+		graphqlHandler.ServeHTTP(c.Writer, c.Request.WithContext(ctx))
+	}
+	r.POST("/graphql", middleware.OptionalAuth(sessions), serveGraphQL)
+	r.GET("/graphql", middleware.OptionalAuth(sessions), serveGraphQL)
 
 	// Protected REST API routes
 	api := r.Group("/api")
-	api.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+	api.Use(middleware.AuthMiddleware(sessions, tokens))
 	{
+		// Personal access tokens only reach the routes their scopes allow
+		teamsRead := middleware.RequireScope(auth.ScopeTeamsRead)
+		teamsWrite := middleware.RequireScope(auth.ScopeTeamsWrite)
+		assetsRead := middleware.RequireScope(auth.ScopeAssetsRead)
+		assetsWrite := middleware.RequireScope(auth.ScopeAssetsWrite)
+		usersRead := middleware.RequireScope(auth.ScopeUsersRead)
+		usersWrite := middleware.RequireScope(auth.ScopeUsersWrite)
+		auditRead := middleware.RequireScope(auth.ScopeAuditRead)
+
 		// User routes
-		api.GET("/profile", userHandler.GetProfile)
-		api.GET("/my-teams", userHandler.GetUserTeams)
-		api.GET("/my-folders", assetHandler.GetUserFolders)
+		api.GET("/profile", usersRead, userHandler.GetProfile)
+		api.PATCH("/profile", usersWrite, userHandler.UpdateProfile)
+		api.POST("/profile/resend-verification", usersWrite, userHandler.ResendVerification)
+
+		// Only the routes above are open to users who have not verified
+		// their email address yet
+		if cfg.EmailVerificationRequired {
+			api.Use(middleware.RequireVerifiedEmail(db))
+		}
+
+		api.GET("/my-teams", teamsRead, userHandler.GetUserTeams)
+		api.GET("/my-folders", assetsRead, assetHandler.GetUserFolders)
+		api.GET("/search", assetsRead, searchHandler.Search)
 
 		// Team routes
-		api.GET("/teams", teamHandler.SearchTeams) // NEW: Search teams
-		api.GET("/teams/:teamId", teamHandler.GetTeam)
-		api.GET("/teams/:teamId/assets", assetHandler.GetTeamAssets)
+		api.GET("/teams", teamsRead, teamHandler.SearchTeams) // NEW: Search teams
+		api.GET("/teams/:teamId", teamsRead, teamHandler.GetTeam)
+		api.GET("/teams/:teamId/assets", assetsRead, assetHandler.GetTeamAssets)
 
 		// Asset routes
-		api.GET("/folders/:folderId", assetHandler.GetFolder)
-		api.PUT("/folders/:folderId", assetHandler.UpdateFolder)
-		api.DELETE("/folders/:folderId", assetHandler.DeleteFolder)
-		api.GET("/notes/:noteId", assetHandler.GetNote)
-		api.PUT("/notes/:noteId", assetHandler.UpdateNote)
-		api.DELETE("/notes/:noteId", assetHandler.DeleteNote)
+		api.GET("/folders/:folderId", assetsRead, assetHandler.GetFolder)
+		api.PUT("/folders/:folderId", assetsWrite, assetHandler.UpdateFolder)
+		api.DELETE("/folders/:folderId", assetsWrite, assetHandler.DeleteFolder)
+		api.PUT("/folders/:folderId/move", assetsWrite, assetHandler.MoveFolder)
+		api.GET("/folders/:folderId/permissions", assetsRead, assetHandler.GetFolderPermissions)
+		api.GET("/notes/:noteId", assetsRead, assetHandler.GetNote)
+		api.GET("/notes/:noteId/permissions", assetsRead, assetHandler.GetNotePermissions)
+		api.PUT("/notes/:noteId", assetsWrite, assetHandler.UpdateNote)
+		api.DELETE("/notes/:noteId", assetsWrite, assetHandler.DeleteNote)
+
+		// Trash
+		api.GET("/trash", assetsRead, assetHandler.ListTrash)
+		api.POST("/trash/:trashId/restore", assetsWrite, assetHandler.RestoreTrash)
+		api.DELETE("/trash/:trashId", assetsWrite, assetHandler.PurgeTrash)
+
+		// Note history
+		api.GET("/notes/:noteId/revisions", assetsRead, assetHandler.ListNoteRevisions)
+		api.GET("/notes/:noteId/revisions/diff", assetsRead, assetHandler.DiffNoteRevisions)
+		api.GET("/notes/:noteId/revisions/:revision", assetsRead, assetHandler.GetNoteRevision)
+		api.POST("/notes/:noteId/revisions/:revision/restore", assetsWrite, assetHandler.RestoreNoteRevision)
 
 		// Sharing routes
-		api.DELETE("/folders/:folderId/share/:userId", assetHandler.RevokeFolderShare)
-		api.POST("/notes/:noteId/share", assetHandler.ShareNote)
-		api.DELETE("/notes/:noteId/share/:userId", assetHandler.RevokeNoteShare)
+		api.DELETE("/folders/:folderId/share/:userId", assetsWrite, assetHandler.RevokeFolderShare)
+		api.POST("/notes/:noteId/share", assetsWrite, assetHandler.ShareNote)
+		api.DELETE("/notes/:noteId/share/:userId", assetsWrite, assetHandler.RevokeNoteShare)
 
 		// Manager-only routes
-		api.GET("/users/:userId/assets", assetHandler.GetUserAssets)
-		api.POST("/import-users", importHandler.ImportUsers)
+		api.GET("/users/:userId/assets", assetsRead, assetHandler.GetUserAssets)
+		api.POST("/import-users", usersWrite, importHandler.ImportUsers)
+		api.POST("/users/:userId/deactivate", usersWrite, userHandler.DeactivateUser)
+		api.POST("/users/:userId/reactivate", usersWrite, userHandler.ReactivateUser)
+
+		// Role management
+		api.GET("/roles", usersRead, roleHandler.ListRoles)
+		api.GET("/users/:userId/roles", usersRead, roleHandler.GetUserRoles)
+		api.POST("/users/:userId/roles", usersWrite, roleHandler.AssignRole)
+		api.DELETE("/users/:userId/roles/:assignmentId", usersWrite, roleHandler.RevokeRole)
+
+		// Login lockouts and the audit log
+		api.POST("/users/:userId/unlock", usersWrite, securityHandler.UnlockUser)
+		api.GET("/audit", auditRead, securityHandler.ListAuditEntries)
 
-		// Team management (managers only)
+		// Team management (permission checked per team)
 		teams := api.Group("/teams")
-		teams.Use(middleware.RequireManager())
 		{
-			teams.POST("", teamHandler.CreateTeam)
-			teams.POST("/:teamId/members", teamHandler.AddMember)
-			teams.DELETE("/:teamId/members/:memberId", teamHandler.RemoveMember)
-			teams.POST("/:teamId/managers", teamHandler.AddManager)
-			teams.DELETE("/:teamId/managers/:managerId", teamHandler.RemoveManager)
-			teams.GET("/all", teamHandler.GetAllTeams) // NEW: Get all teams (manager only)
+			teams.POST("", teamsWrite, teamHandler.CreateTeam)
+			teams.POST("/:teamId/members", teamsWrite, teamHandler.AddMember)
+			teams.DELETE("/:teamId/members/:memberId", teamsWrite, teamHandler.RemoveMember)
+			teams.POST("/:teamId/managers", teamsWrite, teamHandler.AddManager)
+			teams.DELETE("/:teamId/managers/:managerId", teamsWrite, teamHandler.RemoveManager)
+			teams.GET("/all", teamsRead, teamHandler.GetAllTeams) // NEW: Get all teams (manager only)
 		}
 
 		// Asset management
-		api.POST("/folders", assetHandler.CreateFolder)
-		api.POST("/folders/:folderId/notes", assetHandler.CreateNote)
-		api.POST("/folders/:folderId/share", assetHandler.ShareFolder)
+		api.POST("/folders", assetsWrite, assetHandler.CreateFolder)
+		api.POST("/folders/:folderId/notes", assetsWrite, assetHandler.CreateNote)
+		api.POST("/folders/:folderId/share", assetsWrite, assetHandler.ShareFolder)
+
+		// Personal access tokens and service accounts, managed after logging in
+		tokenRoutes := api.Group("", middleware.RequireSession())
+		{
+			tokenRoutes.GET("/tokens", tokenHandler.ListTokens)
+			tokenRoutes.POST("/tokens", tokenHandler.CreateToken)
+			tokenRoutes.GET("/tokens/scopes", tokenHandler.ListScopes)
+			tokenRoutes.DELETE("/tokens/:tokenId", tokenHandler.RevokeToken)
+			tokenRoutes.GET("/service-accounts", tokenHandler.ListServiceAccounts)
+			tokenRoutes.POST("/service-accounts", tokenHandler.CreateServiceAccount)
+			tokenRoutes.GET("/service-accounts/:userId/tokens", tokenHandler.ListServiceAccountTokens)
+			tokenRoutes.POST("/service-accounts/:userId/tokens", tokenHandler.CreateServiceAccountToken)
+			tokenRoutes.DELETE("/service-accounts/:userId/tokens/:tokenId", tokenHandler.RevokeServiceAccountToken)
+		}
 	}
 
+	// Permanently remove trashed items once their retention period is over
+	purger := &trash.Purger{DB: db, Interval: cfg.TrashPurgeInterval}
+	go purger.Run(context.Background())
+
+	// Forget failed logins once they no longer count
+	go logins.Run(context.Background(), cfg.LoginFailureWindow)
+
 	log.Printf("Server starting on port %s", cfg.Port)
 	r.Run(":" + cfg.Port)
 }
+
+// newOIDCService returns nil when no provider is configured.
+func newOIDCService(cfg *config.Config, db *gorm.DB, accountService *accounts.Service) (*oidc.Service, error) {
+	if cfg.OIDCIssuer == "" {
+		return nil, nil
+	}
+	if cfg.OIDCClientID == "" {
+		return nil, errors.New("OIDC_CLIENT_ID is required with OIDC_ISSUER")
+	}
+	if !policy.IsGlobalRole(cfg.OIDCDefaultRole) {
+		return nil, fmt.Errorf("unknown role %q in OIDC_DEFAULT_ROLE", cfg.OIDCDefaultRole)
+	}
+	mapping, err := oidc.ParseRoleMapping(cfg.OIDCRoleMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidc.Service{
+		DB: db,
+		Provider: &oidc.Provider{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+		},
+		Accounts:      accountService,
+		AutoProvision: cfg.OIDCAutoProvision,
+		LinkByEmail:   cfg.OIDCLinkByEmail,
+		RoleClaim:     cfg.OIDCRoleClaim,
+		RoleMapping:   mapping,
+		DefaultRole:   cfg.OIDCDefaultRole,
+		SyncRoles:     cfg.OIDCSyncRoles,
+	}, nil
+}
+
+func loadPersistedQueries(cfg *config.Config, db *gorm.DB) (*persisted.Store, error) {
+	// Without the database, documents live in this instance's memory only
+	if !cfg.PersistedQueryDatabase {
+		db = nil
+	}
+	store := persisted.NewStore(db, cfg.PersistedQueryCacheSize)
+	store.Allowlist = cfg.PersistedQueryAllowlist
+
+	if cfg.PersistedQueryManifest != "" {
+		n, err := store.LoadManifest(cfg.PersistedQueryManifest)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %d persisted queries from %s", n, cfg.PersistedQueryManifest)
+	} else if store.Allowlist && !cfg.PersistedQueryDatabase {
+		return nil, errors.New("the allowlist needs GRAPHQL_PERSISTED_QUERIES_MANIFEST or GRAPHQL_PERSISTED_QUERIES_DATABASE")
+	}
+	return store, nil
+}
+
+func loadKeyring(cfg *config.Config) (*auth.Keyring, error) {
+	if cfg.JWTKeysDir != "" {
+		keys, err := auth.LoadKeyring(cfg.JWTKeysDir, cfg.JWTActiveKeyID)
+		// Development setups such as docker-compose.yml mount an empty key
+		// directory until a key is generated
+		if errors.Is(err, auth.ErrNoKeys) && cfg.IsDevelopment() {
+			log.Printf("No keys in %s, generating an ephemeral signing key", cfg.JWTKeysDir)
+			return auth.GenerateKeyring(cfg.JWTSigningAlg)
+		}
+		return keys, err
+	}
+	log.Println("JWT_KEYS_DIR not set, generating an ephemeral signing key")
+	return auth.GenerateKeyring(cfg.JWTSigningAlg)
+}
+
+// seedAdmin creates the first admin from ADMIN_EMAIL and ADMIN_PASSWORD while
+// the users table is still empty. Every later account is created by a user
+// holding user.create.
+func seedAdmin(cfg *config.Config, db *gorm.DB, passwords *auth.PasswordManager) error {
+	var count int64
+	if err := db.Model(&models.User{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	if cfg.AdminEmail == "" || cfg.AdminPassword == "" {
+		log.Println("No users yet; set ADMIN_EMAIL and ADMIN_PASSWORD to create the first admin")
+		return nil
+	}
+
+	email, err := mail.NormalizeAddress(cfg.AdminEmail)
+	if err != nil {
+		return err
+	}
+	hash, err := passwords.Hash(cfg.AdminPassword, cfg.AdminUsername, email)
+	if err != nil {
+		return err
+	}
+	// The operator chose this address, so there is nothing to verify.
+	now := time.Now()
+	admin := models.User{
+		ID:              utils.GenerateID(),
+		Username:        cfg.AdminUsername,
+		Email:           email,
+		PasswordHash:    hash,
+		Role:            policy.RoleAdmin,
+		EmailVerifiedAt: &now,
+	}
+	if err := db.Create(&admin).Error; err != nil {
+		return err
+	}
+	log.Printf("Created initial admin %s", admin.Email)
+	return nil
+}
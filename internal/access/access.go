@@ -0,0 +1,80 @@
+// Package access resolves a user's effective permission on folders and notes
+// by combining ownership and shares, including those inherited from parent
+// folders.
+package access
+
+import "encoding/json"
+
+// Level is an access level. Higher levels include all lower ones.
+type Level int
+
+const (
+	None Level = iota
+	Read
+	Write
+	Owner
+)
+
+func (l Level) String() string {
+	switch l {
+	case Read:
+		return "read"
+	case Write:
+		return "write"
+	case Owner:
+		return "owner"
+	default:
+		return "none"
+	}
+}
+
+func (l Level) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.String())
+}
+
+// ParseLevel converts a share's access column into a Level.
+func ParseLevel(s string) Level {
+	switch s {
+	case "read":
+		return Read
+	case "write":
+		return Write
+	case "owner":
+		return Owner
+	default:
+		return None
+	}
+}
+
+const (
+	SourceNoteOwner   = "note_owner"
+	SourceNoteShare   = "note_share"
+	SourceFolderOwner = "folder_owner"
+	SourceFolderShare = "folder_share"
+)
+
+// Grant explains one reason the user has access.
+type Grant struct {
+	Source    string `json:"source"`
+	Access    Level  `json:"access"`
+	FolderID  string `json:"folderId,omitempty"`
+	NoteID    string `json:"noteId,omitempty"`
+	Inherited bool   `json:"inherited"`
+}
+
+// Decision is the effective access together with every grant behind it.
+type Decision struct {
+	Access Level   `json:"access"`
+	Grants []Grant `json:"grants"`
+}
+
+func (d *Decision) add(g Grant) {
+	d.Grants = append(d.Grants, g)
+	if g.Access > d.Access {
+		d.Access = g.Access
+	}
+}
+
+func (d Decision) CanRead() bool  { return d.Access >= Read }
+func (d Decision) CanWrite() bool { return d.Access >= Write }
+func (d Decision) IsOwner() bool  { return d.Access == Owner }
@@ -0,0 +1,91 @@
+package access
+
+import (
+	"errors"
+	"user-team-asset-management/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidOwnership is returned for an ownership filter other than
+// "owned" or "shared".
+var ErrInvalidOwnership = errors.New("ownership must be 'owned' or 'shared'")
+
+// FoldersOf returns a query over the folders owned by or directly shared
+// with any of userIDs. ownership narrows it to "owned" or "shared" folders;
+// an empty ownership includes both.
+func (r *Resolver) FoldersOf(userIDs []string, ownership string) (*gorm.DB, error) {
+	query := r.DB.Model(&models.Folder{})
+	shared := "folders.id IN (SELECT folder_id FROM folder_shares WHERE user_id IN ?)"
+
+	switch ownership {
+	case "":
+		return query.Where("(folders.owner_id IN ? OR "+shared+")", userIDs, userIDs), nil
+	case "owned":
+		return query.Where("folders.owner_id IN ?", userIDs), nil
+	case "shared":
+		return query.Where(shared, userIDs), nil
+	}
+	return nil, ErrInvalidOwnership
+}
+
+// FolderUsersColumn identifies the user a row of FoldersByUser belongs to.
+const FolderUsersColumn = "folder_users.user_id"
+
+// FoldersByUser is like FoldersOf, but joins each folder to the user it was
+// listed for so that the folders of several users can be paged per user.
+// A folder listed for two users appears twice, once with each user in
+// FolderUsersColumn.
+func (r *Resolver) FoldersByUser(userIDs []string, ownership string) (*gorm.DB, error) {
+	owned := "SELECT id AS folder_id, owner_id AS user_id FROM folders"
+	shared := "SELECT folder_id, user_id FROM folder_shares"
+
+	var users string
+	switch ownership {
+	case "":
+		users = owned + " UNION " + shared
+	case "owned":
+		users = owned
+	case "shared":
+		users = shared
+	default:
+		return nil, ErrInvalidOwnership
+	}
+
+	return r.DB.Model(&models.Folder{}).
+		Joins("JOIN ("+users+") AS folder_users ON folder_users.folder_id = folders.id").
+		Where(FolderUsersColumn+" IN ?", userIDs), nil
+}
+
+// ReadableFolderIDs returns every live folder the user can read, for callers
+// that check many folders at once instead of resolving them one by one.
+func (r *Resolver) ReadableFolderIDs(userID string) (map[string]bool, error) {
+	var ids []string
+	err := r.DB.Raw(
+		`WITH RECURSIVE `+ReadableFoldersCTE+` SELECT id FROM readable_folders`,
+		map[string]interface{}{"user_id": userID},
+	).Scan(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	readable := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		readable[id] = true
+	}
+	return readable, nil
+}
+
+// SharedNoteIDs returns the notes shared with the user directly.
+func (r *Resolver) SharedNoteIDs(userID string) (map[string]bool, error) {
+	var ids []string
+	if err := r.DB.Model(&models.NoteShare{}).Where("user_id = ?", userID).Pluck("note_id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	shared := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		shared[id] = true
+	}
+	return shared, nil
+}
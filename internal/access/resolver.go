@@ -0,0 +1,98 @@
+package access
+
+import (
+	"errors"
+	"user-team-asset-management/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Resolver computes effective access from the database.
+type Resolver struct {
+	DB *gorm.DB
+}
+
+func NewResolver(db *gorm.DB) *Resolver {
+	return &Resolver{DB: db}
+}
+
+// Folder resolves access to a folder. Owning the folder makes the user its
+// owner; owning an ancestor grants write access; shares on the folder or any
+// ancestor grant their own access level.
+func (r *Resolver) Folder(userID, folderID string) (Decision, error) {
+	decision := Decision{Grants: []Grant{}}
+
+	ancestors, err := r.Ancestors(folderID)
+	if err != nil || len(ancestors) == 0 {
+		return decision, err
+	}
+	ids := crumbIDs(ancestors)
+
+	var owned []string
+	if err := r.DB.Model(&models.Folder{}).Where("id IN ? AND owner_id = ?", ids, userID).Pluck("id", &owned).Error; err != nil {
+		return decision, err
+	}
+	for _, id := range owned {
+		level := Owner
+		if id != folderID {
+			level = Write
+		}
+		decision.add(Grant{Source: SourceFolderOwner, Access: level, FolderID: id, Inherited: id != folderID})
+	}
+
+	var shares []models.FolderShare
+	if err := r.DB.Where("folder_id IN ? AND user_id = ?", ids, userID).Find(&shares).Error; err != nil {
+		return decision, err
+	}
+	for _, share := range shares {
+		decision.add(Grant{
+			Source:    SourceFolderShare,
+			Access:    ParseLevel(share.Access),
+			FolderID:  share.FolderID,
+			Inherited: share.FolderID != folderID,
+		})
+	}
+
+	return decision, nil
+}
+
+// Note resolves access to a note from note ownership, note shares and
+// whatever access the user has on the folder containing it. Folder access
+// never makes the user the note's owner.
+func (r *Resolver) Note(userID, noteID string) (Decision, error) {
+	decision := Decision{Grants: []Grant{}}
+
+	var note models.Note
+	if err := r.DB.Select("id", "owner_id", "folder_id").Where("id = ?", noteID).First(&note).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return decision, nil
+		}
+		return decision, err
+	}
+
+	if note.OwnerID == userID {
+		decision.add(Grant{Source: SourceNoteOwner, Access: Owner, NoteID: noteID})
+	}
+
+	var shares []models.NoteShare
+	if err := r.DB.Where("note_id = ? AND user_id = ?", noteID, userID).Find(&shares).Error; err != nil {
+		return decision, err
+	}
+	for _, share := range shares {
+		decision.add(Grant{Source: SourceNoteShare, Access: ParseLevel(share.Access), NoteID: noteID})
+	}
+
+	folder, err := r.Folder(userID, note.FolderID)
+	if err != nil {
+		return decision, err
+	}
+	for _, grant := range folder.Grants {
+		if grant.Access > Write {
+			grant.Access = Write
+		}
+		grant.Inherited = true
+		decision.add(grant)
+	}
+
+	return decision, nil
+}
@@ -0,0 +1,79 @@
+package access
+
+import "user-team-asset-management/internal/models"
+
+// maxFolderDepth bounds the recursive queries so that corrupted data can
+// never make them loop forever.
+const maxFolderDepth = 64
+
+// Ancestors returns the folder and all of its ancestors, starting with the
+// folder itself and ending at the root. Trashed folders are skipped.
+func (r *Resolver) Ancestors(folderID string) ([]models.FolderCrumb, error) {
+	var crumbs []models.FolderCrumb
+	err := r.DB.Raw(`
+		WITH RECURSIVE ancestors AS (
+			SELECT id, name, parent_id, 0 AS depth FROM folders WHERE id = ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT f.id, f.name, f.parent_id, a.depth + 1
+			FROM folders f JOIN ancestors a ON f.id = a.parent_id
+			WHERE a.depth < ? AND f.deleted_at IS NULL
+		)
+		SELECT id, name FROM ancestors ORDER BY depth
+	`, folderID, maxFolderDepth).Scan(&crumbs).Error
+	return crumbs, err
+}
+
+// DescendantIDs returns the folder and every live folder nested below it.
+func (r *Resolver) DescendantIDs(folderID string) ([]string, error) {
+	var ids []string
+	err := r.DB.Raw(`
+		WITH RECURSIVE descendants AS (
+			SELECT id, 0 AS depth FROM folders WHERE id = ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT f.id, d.depth + 1
+			FROM folders f JOIN descendants d ON f.parent_id = d.id
+			WHERE d.depth < ? AND f.deleted_at IS NULL
+		)
+		SELECT id FROM descendants
+	`, folderID, maxFolderDepth).Scan(&ids).Error
+	return ids, err
+}
+
+// Path returns the breadcrumb trail from the root down to the folder.
+func (r *Resolver) Path(folderID string) ([]models.FolderCrumb, error) {
+	crumbs, err := r.Ancestors(folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(crumbs)-1; i < j; i, j = i+1, j-1 {
+		crumbs[i], crumbs[j] = crumbs[j], crumbs[i]
+	}
+	return crumbs, nil
+}
+
+func crumbIDs(crumbs []models.FolderCrumb) []string {
+	ids := make([]string, len(crumbs))
+	for i, crumb := range crumbs {
+		ids[i] = crumb.ID
+	}
+	return ids
+}
+
+// ReadableFoldersCTE is a recursive CTE named readable_folders holding the
+// IDs of every live folder the user identified by @user_id can read, either
+// directly or through a parent folder. Queries that list content filter
+// against it instead of checking folders one at a time. The depth bound
+// mirrors maxFolderDepth.
+const ReadableFoldersCTE = `
+	readable_folders AS (
+		SELECT f.id, 0 AS depth FROM folders f
+		WHERE f.deleted_at IS NULL AND (
+			f.owner_id = @user_id OR
+			EXISTS (SELECT 1 FROM folder_shares s WHERE s.folder_id = f.id AND s.user_id = @user_id)
+		)
+		UNION
+		SELECT c.id, r.depth + 1 FROM folders c
+		JOIN readable_folders r ON c.parent_id = r.id
+		WHERE c.deleted_at IS NULL AND r.depth < 64
+	)`
@@ -0,0 +1,518 @@
+// Package accounts registers users and verifies their email addresses, lets
+// users edit their own profile and lets managers deactivate and reactivate
+// accounts.
+package accounts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"user-team-asset-management/internal/audit"
+	"user-team-asset-management/internal/auth"
+	"user-team-asset-management/internal/logger"
+	"user-team-asset-management/internal/mail"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/utils"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUserNotFound             = errors.New("user not found")
+	ErrInvalidUsername          = errors.New("username must not be empty")
+	ErrInvalidEmail             = errors.New("invalid email address")
+	ErrEmailTaken               = errors.New("email address is already in use")
+	ErrPasswordRequired         = errors.New("current password is required to change the email address")
+	ErrInvalidVerificationToken = errors.New("invalid or expired email verification token")
+	ErrEmailAlreadyVerified     = errors.New("email address is already verified")
+	ErrVerificationRecentlySent = errors.New("a verification link was sent recently, please wait a minute")
+	ErrDeactivateSelf           = errors.New("you cannot deactivate your own account")
+	ErrAlreadyDeactivated       = errors.New("user is already deactivated")
+	ErrNotDeactivated           = errors.New("user is not deactivated")
+	ErrInvalidTransferTarget    = errors.New("assets can only be transferred to another active user")
+	ErrNotServiceAccount        = errors.New("user is not a service account")
+)
+
+// resendInterval is how long ResendVerification waits between mails.
+const resendInterval = time.Minute
+
+// serviceAccountDomain gets the made-up email addresses of service accounts.
+// The .invalid top-level domain is reserved, so no mail reaches them.
+const serviceAccountDomain = "service-accounts.invalid"
+
+// Service changes accounts. New users and email changes get a link mailed
+// to the address, and email changes only take effect once it is opened.
+type Service struct {
+	DB       *gorm.DB
+	Sessions *auth.SessionManager
+	Mailer   mail.Mailer
+
+	VerificationTTL time.Duration
+
+	// VerifyURL is the page that confirms an email address. The token is
+	// added to it as the "token" query parameter.
+	VerifyURL string
+}
+
+// Register creates user with its email address normalized and mails a link
+// to verify the address. The user can log in right away; whether they may
+// use the REST API before verifying is up to middleware.RequireVerifiedEmail.
+func (s *Service) Register(user *models.User) error {
+	email, err := mail.NormalizeAddress(user.Email)
+	if err != nil {
+		return ErrInvalidEmail
+	}
+	user.Email = email
+	user.Active = true
+	user.EmailVerifiedAt = nil
+
+	var rawToken string
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := s.checkEmailFree(tx, email, user.ID); err != nil {
+			return err
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		rawToken, err = s.createToken(tx, user.ID, email)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	s.sendVerification(*user, email, rawToken)
+	return nil
+}
+
+// CreateServiceAccount creates a user for automation, with a random
+// password and an address that receives no mail. Service accounts cannot
+// log in; they use personal access tokens created for them.
+func (s *Service) CreateServiceAccount(actorID, username, role string) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, ErrInvalidUsername
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(utils.GenerateSecret()), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	id := utils.GenerateID()
+	now := time.Now()
+	user := &models.User{
+		ID:              id,
+		Username:        username,
+		Email:           id + "@" + serviceAccountDomain,
+		PasswordHash:    string(hash),
+		Role:            role,
+		EmailVerifiedAt: &now,
+		ServiceAccount:  true,
+		Active:          true,
+	}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, models.AuditEntry{
+			Action:       audit.ActionServiceAccountCreated,
+			ActorID:      actorID,
+			TargetUserID: user.ID,
+			Detail:       fmt.Sprintf("%s as %s", username, role),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ServiceAccount returns the service account with the given ID.
+func (s *Service) ServiceAccount(userID string) (*models.User, error) {
+	var users []models.User
+	if err := s.DB.Where("id = ?", userID).Limit(1).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrUserNotFound
+	}
+	if !users[0].ServiceAccount {
+		return nil, ErrNotServiceAccount
+	}
+	return &users[0], nil
+}
+
+// ResendVerification mails a new link for the user's pending email address,
+// or for their current one while it is unverified, replacing earlier links.
+func (s *Service) ResendVerification(userID string) error {
+	var user models.User
+	if err := s.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		return ErrUserNotFound
+	}
+	email := user.PendingEmail
+	if email == "" {
+		if user.EmailVerified() {
+			return ErrEmailAlreadyVerified
+		}
+		email = user.Email
+	}
+
+	var rawToken string
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var recent int64
+		if err := tx.Model(&models.EmailVerificationToken{}).
+			Where("user_id = ? AND created_at > ?", user.ID, time.Now().Add(-resendInterval)).
+			Count(&recent).Error; err != nil {
+			return err
+		}
+		if recent > 0 {
+			return ErrVerificationRecentlySent
+		}
+
+		if err := invalidateTokens(tx, user.ID); err != nil {
+			return err
+		}
+		var err error
+		rawToken, err = s.createToken(tx, user.ID, email)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	s.sendVerification(user, email, rawToken)
+	return nil
+}
+
+// ProfileUpdate holds the fields to change; nil fields are left alone.
+// Changing the email address needs the current password.
+type ProfileUpdate struct {
+	Username        *string
+	Email           *string
+	CurrentPassword string
+}
+
+// UpdateProfile applies update to the user's profile and returns the
+// result. A new email address is stored as pending and a verification link
+// is mailed to it; asking for the current address again cancels a pending
+// change.
+func (s *Service) UpdateProfile(userID string, update ProfileUpdate) (*models.User, error) {
+	var user models.User
+	if err := s.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	changes := map[string]interface{}{}
+	if update.Username != nil {
+		username := strings.TrimSpace(*update.Username)
+		if username == "" {
+			return nil, ErrInvalidUsername
+		}
+		changes["username"] = username
+	}
+
+	var newEmail string
+	if update.Email != nil {
+		address, err := mail.NormalizeAddress(*update.Email)
+		if err != nil {
+			return nil, ErrInvalidEmail
+		}
+		if strings.EqualFold(address, user.Email) {
+			changes["pending_email"] = ""
+		} else {
+			if update.CurrentPassword == "" {
+				return nil, ErrPasswordRequired
+			}
+			if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(update.CurrentPassword)); err != nil {
+				return nil, auth.ErrWrongPassword
+			}
+			if err := s.checkEmailFree(s.DB, address, user.ID); err != nil {
+				return nil, err
+			}
+			newEmail = address
+			changes["pending_email"] = newEmail
+		}
+	}
+
+	var rawToken string
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if len(changes) > 0 {
+			if err := tx.Model(&user).Updates(changes).Error; err != nil {
+				return err
+			}
+		}
+		if _, ok := changes["pending_email"]; !ok {
+			return nil
+		}
+
+		if err := invalidateTokens(tx, user.ID); err != nil {
+			return err
+		}
+		if newEmail == "" {
+			return nil
+		}
+
+		var err error
+		rawToken, err = s.createToken(tx, user.ID, newEmail)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rawToken != "" {
+		s.sendVerification(user, newEmail, rawToken)
+	}
+	return &user, nil
+}
+
+func (s *Service) checkEmailFree(tx *gorm.DB, email, userID string) error {
+	var count int64
+	if err := tx.Model(&models.User{}).
+		Where("LOWER(email) = LOWER(?) AND id <> ?", email, userID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrEmailTaken
+	}
+	return nil
+}
+
+// invalidateTokens uses up the user's open tokens, so that only the latest
+// requested address can be verified.
+func invalidateTokens(tx *gorm.DB, userID string) error {
+	return tx.Model(&models.EmailVerificationToken{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", time.Now()).Error
+}
+
+func (s *Service) createToken(tx *gorm.DB, userID, email string) (string, error) {
+	rawToken := utils.GenerateSecret()
+	err := tx.Create(&models.EmailVerificationToken{
+		ID:        utils.GenerateID(),
+		UserID:    userID,
+		Email:     email,
+		TokenHash: auth.HashToken(rawToken),
+		ExpiresAt: time.Now().Add(s.VerificationTTL),
+	}).Error
+	return rawToken, err
+}
+
+// sendVerification mails the link for email, worded for confirming the
+// user's current address or for changing to a new one.
+func (s *Service) sendVerification(user models.User, email, rawToken string) {
+	msg := mail.Message{
+		To:      email,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"To use this address for your account from now on, open\n\n"+
+			"%s\n\n"+
+			"The link expires in %s. Until then your account keeps using %s. If you did not ask for this, you can ignore this message.\n",
+			user.Username, withToken(s.VerifyURL, rawToken), s.VerificationTTL, user.Email),
+	}
+	if email == user.Email {
+		msg.Subject = "Confirm your email address"
+		msg.Body = fmt.Sprintf("Hi %s,\n\n"+
+			"To confirm that this is your address, open\n\n"+
+			"%s\n\n"+
+			"The link expires in %s. If you did not sign up, you can ignore this message.\n",
+			user.Username, withToken(s.VerifyURL, rawToken), s.VerificationTTL)
+	}
+	go func() {
+		if err := s.Mailer.Send(context.Background(), msg); err != nil {
+			logger.DefaultLogger.Error(fmt.Sprintf("Failed to send email verification mail: %v", err))
+		}
+	}()
+}
+
+func withToken(base, rawToken string) string {
+	link, err := url.Parse(base)
+	if err != nil {
+		return base + "?token=" + url.QueryEscape(rawToken)
+	}
+	query := link.Query()
+	query.Set("token", rawToken)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// VerifyEmail marks the address the token was sent to as verified, switching
+// the user to it when it came from UpdateProfile, and uses the token up.
+func (s *Service) VerifyEmail(rawToken string) (*models.User, error) {
+	var user models.User
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var token models.EmailVerificationToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", auth.HashToken(rawToken)).
+			First(&token).Error; err != nil {
+			return ErrInvalidVerificationToken
+		}
+		if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+			return ErrInvalidVerificationToken
+		}
+
+		if err := tx.Where("id = ?", token.UserID).First(&user).Error; err != nil || !user.Active {
+			return ErrInvalidVerificationToken
+		}
+		if err := s.checkEmailFree(tx, token.Email, user.ID); err != nil {
+			return err
+		}
+
+		previous := user.Email
+		now := time.Now()
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"email":             token.Email,
+			"pending_email":     "",
+			"email_verified_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&token).Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		entry := models.AuditEntry{
+			Action:       audit.ActionEmailVerified,
+			ActorID:      user.ID,
+			TargetUserID: user.ID,
+		}
+		if previous != token.Email {
+			entry.Action = audit.ActionEmailChanged
+			entry.Detail = fmt.Sprintf("changed from %s to %s", previous, token.Email)
+		}
+		return audit.Record(tx, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Deactivate signs the user out everywhere and keeps them from logging in
+// again. With transferTo set, the folders, notes and trash the user owns
+// are handed over to that user first.
+func (s *Service) Deactivate(actorID, userID, transferTo string) (*models.User, error) {
+	if actorID == userID {
+		return nil, ErrDeactivateSelf
+	}
+
+	var user models.User
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", userID).
+			First(&user).Error; err != nil {
+			return ErrUserNotFound
+		}
+		if !user.Active {
+			return ErrAlreadyDeactivated
+		}
+
+		detail := ""
+		if transferTo != "" {
+			var target models.User
+			if transferTo == userID ||
+				tx.Where("id = ?", transferTo).First(&target).Error != nil ||
+				!target.Active {
+				return ErrInvalidTransferTarget
+			}
+			if err := transferAssets(tx, userID, transferTo); err != nil {
+				return err
+			}
+			detail = fmt.Sprintf("assets transferred to %s", target.Username)
+		}
+
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"active":         false,
+			"deactivated_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.LoginChallenge{}).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, models.AuditEntry{
+			Action:       audit.ActionUserDeactivated,
+			ActorID:      actorID,
+			TargetUserID: userID,
+			Detail:       detail,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate already refuses the user's tokens; this also ends the sessions
+	if err := s.Sessions.RevokeAll(userID); err != nil {
+		logger.DefaultLogger.Error(fmt.Sprintf("Failed to revoke sessions of deactivated user %s: %v", userID, err))
+	}
+	return &user, nil
+}
+
+// transferAssets makes newOwnerID the owner of everything ownerID owns,
+// including trashed items so that they can still be restored. Shares the
+// new owner held on those items are dropped, since owners need none.
+func transferAssets(tx *gorm.DB, ownerID, newOwnerID string) error {
+	var folderIDs, noteIDs []string
+	if err := tx.Unscoped().Model(&models.Folder{}).Where("owner_id = ?", ownerID).Pluck("id", &folderIDs).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Model(&models.Note{}).Where("owner_id = ?", ownerID).Pluck("id", &noteIDs).Error; err != nil {
+		return err
+	}
+
+	if len(folderIDs) > 0 {
+		if err := tx.Where("folder_id IN ? AND user_id = ?", folderIDs, newOwnerID).Delete(&models.FolderShare{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&models.Folder{}).Where("id IN ?", folderIDs).Update("owner_id", newOwnerID).Error; err != nil {
+			return err
+		}
+	}
+	if len(noteIDs) > 0 {
+		if err := tx.Where("note_id IN ? AND user_id = ?", noteIDs, newOwnerID).Delete(&models.NoteShare{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&models.Note{}).Where("id IN ?", noteIDs).Update("owner_id", newOwnerID).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&models.TrashEntry{}).Where("owner_id = ?", ownerID).Update("owner_id", newOwnerID).Error
+}
+
+// Reactivate lets a deactivated user log in again. Assets transferred away
+// on deactivation stay with their new owner.
+func (s *Service) Reactivate(actorID, userID string) (*models.User, error) {
+	var user models.User
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", userID).
+			First(&user).Error; err != nil {
+			return ErrUserNotFound
+		}
+		if user.Active {
+			return ErrNotDeactivated
+		}
+
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"active":         true,
+			"deactivated_at": nil,
+		}).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, models.AuditEntry{
+			Action:       audit.ActionUserReactivated,
+			ActorID:      actorID,
+			TargetUserID: userID,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
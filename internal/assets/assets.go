@@ -0,0 +1,102 @@
+// Package assets changes folders and notes on behalf of a user. The REST
+// handlers and the GraphQL resolvers both go through it, so that permission
+// checks, version checks, revisions and the trash behave the same in either
+// API. Reads stay with the callers.
+package assets
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"user-team-asset-management/internal/access"
+	"user-team-asset-management/internal/events"
+	"user-team-asset-management/internal/policy"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrFolderNotFound  = errors.New("folder not found")
+	ErrNoteNotFound    = errors.New("note not found")
+	ErrTrashNotFound   = errors.New("trash item not found")
+	ErrFolderCycle     = errors.New("cannot move a folder into itself or one of its subfolders")
+	ErrNothingToUpdate = errors.New("nothing to update")
+	ErrAlreadyShared   = errors.New("already shared with this user")
+)
+
+// AccessError is returned when the user lacks a permission, or access to
+// the folder or note, for what was asked.
+type AccessError struct {
+	Reason string
+}
+
+func (e *AccessError) Error() string {
+	return e.Reason
+}
+
+func denied(format string, args ...interface{}) error {
+	return &AccessError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// VersionConflictError is returned when the caller expected another version
+// than the one stored.
+type VersionConflictError struct {
+	Current int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("resource was modified by someone else (current version %d)", e.Current)
+}
+
+// RevisionNotFoundError is returned for a revision the note does not have.
+type RevisionNotFoundError struct {
+	Revision int
+}
+
+func (e *RevisionNotFoundError) Error() string {
+	return fmt.Sprintf("revision %d not found", e.Revision)
+}
+
+// VersionCheck reports whether the caller accepts the current version of
+// what it changes, such as an If-Match header does. A nil check accepts any
+// version.
+type VersionCheck func(current int) bool
+
+func (check VersionCheck) verify(current int) error {
+	if check != nil && !check(current) {
+		return &VersionConflictError{Current: current}
+	}
+	return nil
+}
+
+type Service struct {
+	DB             *gorm.DB
+	Authz          policy.Authorizer
+	Access         *access.Resolver
+	Events         *events.Bus
+	TrashRetention time.Duration
+}
+
+func (s *Service) authorize(userID string, perm policy.Permission) error {
+	allowed, err := s.Authz.Authorize(userID, perm, "")
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return denied("permission %s required", perm)
+	}
+	return nil
+}
+
+// folderAccess and noteAccess resolve the user's access, treating
+// resolution errors as no access like the read paths do.
+
+func (s *Service) folderAccess(userID, folderID string) access.Decision {
+	decision, _ := s.Access.Folder(userID, folderID)
+	return decision
+}
+
+func (s *Service) noteAccess(userID, noteID string) access.Decision {
+	decision, _ := s.Access.Note(userID, noteID)
+	return decision
+}
@@ -0,0 +1,167 @@
+package assets
+
+import (
+	"errors"
+	"user-team-asset-management/internal/access"
+	"user-team-asset-management/internal/events"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/policy"
+	"user-team-asset-management/internal/trash"
+	"user-team-asset-management/internal/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CreateFolder creates a folder owned by the user, at the top level when
+// parentID is nil.
+func (s *Service) CreateFolder(userID, name string, parentID *string) (*models.Folder, error) {
+	if err := s.authorize(userID, policy.FolderCreate); err != nil {
+		return nil, err
+	}
+	if parentID != nil && !s.folderAccess(userID, *parentID).CanWrite() {
+		return nil, denied("no write access to parent folder")
+	}
+
+	folder := &models.Folder{
+		ID:       utils.GenerateID(),
+		Name:     name,
+		OwnerID:  userID,
+		ParentID: parentID,
+	}
+	if err := s.DB.Create(folder).Error; err != nil {
+		return nil, err
+	}
+
+	s.Events.FolderChanged(events.ActionCreated, folder.ID, folder.ParentID, userID)
+	return folder, nil
+}
+
+func (s *Service) RenameFolder(userID, folderID, name string, check VersionCheck) (*models.Folder, error) {
+	if !s.folderAccess(userID, folderID).IsOwner() {
+		return nil, denied("only folder owner can update")
+	}
+	return s.updateFolder(userID, folderID, map[string]interface{}{"name": name}, check, nil)
+}
+
+// MoveFolder moves the folder below parentID, or to the top level when
+// parentID is nil.
+func (s *Service) MoveFolder(userID, folderID string, parentID *string, check VersionCheck) (*models.Folder, error) {
+	if !s.folderAccess(userID, folderID).IsOwner() {
+		return nil, denied("only folder owner can move")
+	}
+
+	var guard func(tx *gorm.DB) error
+	if parentID != nil {
+		if !s.folderAccess(userID, *parentID).CanWrite() {
+			return nil, denied("no write access to destination folder")
+		}
+		guard = func(tx *gorm.DB) error {
+			return lockAncestors(tx, folderID, *parentID)
+		}
+	}
+
+	return s.updateFolder(userID, folderID, map[string]interface{}{"parent_id": parentID}, check, guard)
+}
+
+// updateFolder applies updates under a row lock, checking and bumping the
+// folder's version. A VersionConflictError carries the current version.
+// guard, when set, runs in the same transaction once the folder is locked.
+func (s *Service) updateFolder(userID, folderID string, updates map[string]interface{}, check VersionCheck, guard func(tx *gorm.DB) error) (*models.Folder, error) {
+	var folder models.Folder
+	var previousParentID *string
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockFolder(tx, folderID, &folder); err != nil {
+			return err
+		}
+		if err := check.verify(folder.Version); err != nil {
+			return err
+		}
+		if guard != nil {
+			if err := guard(tx); err != nil {
+				return err
+			}
+		}
+		previousParentID = folder.ParentID
+
+		updates["version"] = folder.Version + 1
+		return tx.Model(&folder).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, moved := updates["parent_id"]; moved {
+		s.Events.FolderMoved(folder.ID, previousParentID, folder.ParentID, userID)
+	} else {
+		s.Events.FolderChanged(events.ActionUpdated, folder.ID, folder.ParentID, userID)
+	}
+	return &folder, nil
+}
+
+// DeleteFolder moves the folder and everything below it to the trash, where
+// it is purged after the retention period.
+func (s *Service) DeleteFolder(userID, folderID string, check VersionCheck) (*models.TrashEntry, error) {
+	if !s.folderAccess(userID, folderID).IsOwner() {
+		return nil, denied("only folder owner can delete")
+	}
+
+	var entry *models.TrashEntry
+	var folders []models.Folder
+	var notes []models.Note
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var folder models.Folder
+		if err := lockFolder(tx, folderID, &folder); err != nil {
+			return err
+		}
+		if err := check.verify(folder.Version); err != nil {
+			return err
+		}
+
+		// Subfolders go together with their parent
+		folderIDs, err := access.NewResolver(tx).DescendantIDs(folderID)
+		if err != nil {
+			return err
+		}
+		if entry, err = trash.TrashFolders(tx, &folder, folderIDs, userID, s.TrashRetention); err != nil {
+			return err
+		}
+		folders, notes, err = trash.Contents(tx, entry)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.Events.TrashChanged(events.ActionDeleted, folders, notes, userID)
+	return entry, nil
+}
+
+// lockAncestors locks parentID and every folder above it, refusing the move
+// when folderID is among them. Held locks keep the chain from changing until
+// the move commits, so two opposite moves cannot both pass the check; the
+// moved folder is already locked by the caller.
+func lockAncestors(tx *gorm.DB, folderID, parentID string) error {
+	seen := make(map[string]bool)
+	for id := &parentID; id != nil && !seen[*id]; {
+		if *id == folderID {
+			return ErrFolderCycle
+		}
+		seen[*id] = true
+
+		var folder models.Folder
+		if err := lockFolder(tx, *id, &folder); err != nil {
+			return err
+		}
+		id = folder.ParentID
+	}
+	return nil
+}
+
+func lockFolder(tx *gorm.DB, folderID string, folder *models.Folder) error {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", folderID).First(folder).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrFolderNotFound
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+package assets
+
+import (
+	"errors"
+	"user-team-asset-management/internal/events"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/policy"
+	"user-team-asset-management/internal/revisions"
+	"user-team-asset-management/internal/trash"
+	"user-team-asset-management/internal/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CreateNote creates a note owned by the user, recorded as its first
+// revision.
+func (s *Service) CreateNote(userID, folderID, title, body string) (*models.Note, error) {
+	if err := s.authorize(userID, policy.NoteCreate); err != nil {
+		return nil, err
+	}
+	if !s.folderAccess(userID, folderID).CanWrite() {
+		return nil, denied("no write access to this folder")
+	}
+
+	note := &models.Note{
+		ID:       utils.GenerateID(),
+		Title:    title,
+		Body:     body,
+		FolderID: folderID,
+		OwnerID:  userID,
+	}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(note).Error; err != nil {
+			return err
+		}
+		_, err := revisions.Record(tx, note, userID, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.Events.NoteChanged(events.ActionCreated, note.ID, note.FolderID, userID)
+	return note, nil
+}
+
+// UpdateNote changes the title and body, leaving empty ones as they are.
+// Every update is kept as a revision so edits can be reviewed and undone.
+func (s *Service) UpdateNote(userID, noteID, title, body string, check VersionCheck) (*models.Note, *models.NoteRevision, error) {
+	if !s.noteAccess(userID, noteID).CanWrite() {
+		return nil, nil, denied("no write access to this note")
+	}
+
+	updates := make(map[string]interface{})
+	if title != "" {
+		updates["title"] = title
+	}
+	if body != "" {
+		updates["body"] = body
+	}
+	if len(updates) == 0 {
+		return nil, nil, ErrNothingToUpdate
+	}
+
+	var note models.Note
+	var revision *models.NoteRevision
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockNote(tx, noteID, &note); err != nil {
+			return err
+		}
+		if err := check.verify(note.Version); err != nil {
+			return err
+		}
+		if err := revisions.EnsureBase(tx, &note); err != nil {
+			return err
+		}
+
+		updates["version"] = note.Version + 1
+		if err := tx.Model(&note).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		var err error
+		revision, err = revisions.Record(tx, &note, userID, nil)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.Events.NoteChanged(events.ActionUpdated, note.ID, note.FolderID, userID)
+	return &note, revision, nil
+}
+
+// DeleteNote moves the note to the trash. Shares and history are kept until
+// it is purged.
+func (s *Service) DeleteNote(userID, noteID string, check VersionCheck) (*models.TrashEntry, error) {
+	if !s.noteAccess(userID, noteID).IsOwner() {
+		return nil, denied("only note owner can delete")
+	}
+
+	var note models.Note
+	var entry *models.TrashEntry
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockNote(tx, noteID, &note); err != nil {
+			return err
+		}
+		if err := check.verify(note.Version); err != nil {
+			return err
+		}
+
+		var err error
+		entry, err = trash.TrashNote(tx, &note, userID, s.TrashRetention)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.Events.NoteChanged(events.ActionDeleted, note.ID, note.FolderID, userID)
+	return entry, nil
+}
+
+// RestoreRevision copies an old revision's content back onto the note. The
+// restore is recorded as a new revision rather than rewriting history.
+func (s *Service) RestoreRevision(userID, noteID string, number int, check VersionCheck) (*models.Note, *models.NoteRevision, error) {
+	if !s.noteAccess(userID, noteID).CanWrite() {
+		return nil, nil, denied("no write access to this note")
+	}
+
+	target, err := s.FindRevision(noteID, number)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var note models.Note
+	var head *models.NoteRevision
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockNote(tx, noteID, &note); err != nil {
+			return err
+		}
+		if err := check.verify(note.Version); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&note).Updates(map[string]interface{}{
+			"title":   target.Title,
+			"body":    target.Body,
+			"version": note.Version + 1,
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		head, err = revisions.Record(tx, &note, userID, &target.Revision)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.Events.NoteChanged(events.ActionUpdated, note.ID, note.FolderID, userID)
+	return &note, head, nil
+}
+
+// FindRevision loads one revision of a note. It does not check access.
+func (s *Service) FindRevision(noteID string, number int) (*models.NoteRevision, error) {
+	var revision models.NoteRevision
+	err := s.DB.Where("note_id = ? AND revision = ?", noteID, number).First(&revision).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, &RevisionNotFoundError{Revision: number}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+func lockNote(tx *gorm.DB, noteID string, note *models.Note) error {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", noteID).First(note).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNoteNotFound
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package assets
+
+import (
+	"errors"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/policy"
+
+	"gorm.io/gorm"
+)
+
+// ShareFolder gives another user read or write access to the folder and
+// everything below it. Sharing twice with the same user returns
+// ErrAlreadyShared.
+func (s *Service) ShareFolder(userID, folderID, shareUserID, level string) (*models.FolderShare, error) {
+	if err := s.authorize(userID, policy.FolderShare); err != nil {
+		return nil, err
+	}
+	if !s.folderAccess(userID, folderID).IsOwner() {
+		return nil, denied("only folder owner can share")
+	}
+
+	share := &models.FolderShare{
+		FolderID: folderID,
+		UserID:   shareUserID,
+		Access:   level,
+	}
+	if err := s.DB.Create(share).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrAlreadyShared
+		}
+		return nil, err
+	}
+	return share, nil
+}
+
+func (s *Service) RevokeFolderShare(userID, folderID, shareUserID string) error {
+	if !s.folderAccess(userID, folderID).IsOwner() {
+		return denied("only folder owner can revoke sharing")
+	}
+	return s.DB.Where("folder_id = ? AND user_id = ?", folderID, shareUserID).Delete(&models.FolderShare{}).Error
+}
+
+func (s *Service) ShareNote(userID, noteID, shareUserID, level string) (*models.NoteShare, error) {
+	if err := s.authorize(userID, policy.NoteShare); err != nil {
+		return nil, err
+	}
+	if !s.noteAccess(userID, noteID).IsOwner() {
+		return nil, denied("only note owner can share")
+	}
+
+	share := &models.NoteShare{
+		NoteID: noteID,
+		UserID: shareUserID,
+		Access: level,
+	}
+	if err := s.DB.Create(share).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrAlreadyShared
+		}
+		return nil, err
+	}
+	return share, nil
+}
+
+func (s *Service) RevokeNoteShare(userID, noteID, shareUserID string) error {
+	if !s.noteAccess(userID, noteID).IsOwner() {
+		return denied("only note owner can revoke sharing")
+	}
+	return s.DB.Where("note_id = ? AND user_id = ?", noteID, shareUserID).Delete(&models.NoteShare{}).Error
+}
@@ -0,0 +1,59 @@
+package assets
+
+import (
+	"errors"
+	"user-team-asset-management/internal/events"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/trash"
+
+	"gorm.io/gorm"
+)
+
+// RestoreTrash brings back one of the user's trashed items, returning
+// trash.ErrParentTrashed when its folder is gone.
+func (s *Service) RestoreTrash(userID, trashID string) (*models.TrashEntry, error) {
+	entry, err := s.findTrashEntry(userID, trashID)
+	if err != nil {
+		return nil, err
+	}
+
+	var folders []models.Folder
+	var notes []models.Note
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if folders, notes, err = trash.Contents(tx, entry); err != nil {
+			return err
+		}
+		return trash.Restore(tx, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.Events.TrashChanged(events.ActionRestored, folders, notes, userID)
+	return entry, nil
+}
+
+// PurgeTrash permanently deletes one of the user's trashed items without
+// waiting for the retention period.
+func (s *Service) PurgeTrash(userID, trashID string) error {
+	entry, err := s.findTrashEntry(userID, trashID)
+	if err != nil {
+		return err
+	}
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		return trash.Purge(tx, entry)
+	})
+}
+
+func (s *Service) findTrashEntry(userID, trashID string) (*models.TrashEntry, error) {
+	var entry models.TrashEntry
+	err := s.DB.Where("id = ? AND owner_id = ?", trashID, userID).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTrashNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
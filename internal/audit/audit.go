@@ -0,0 +1,38 @@
+// Package audit records security-relevant events, such as accounts being
+// locked after failed logins, for administrators to review.
+package audit
+
+import (
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ActionLoginLocked   = "login.locked"
+	ActionLoginUnlocked = "login.unlocked"
+
+	ActionTwoFactorEnabled  = "two_factor.enabled"
+	ActionTwoFactorDisabled = "two_factor.disabled"
+
+	ActionEmailVerified   = "user.email_verified"
+	ActionEmailChanged    = "user.email_changed"
+	ActionUserDeactivated = "user.deactivated"
+	ActionUserReactivated = "user.reactivated"
+
+	ActionUserProvisioned  = "user.provisioned"
+	ActionIdentityLinked   = "identity.linked"
+	ActionIdentityUnlinked = "identity.unlinked"
+
+	ActionServiceAccountCreated = "service_account.created"
+	ActionTokenCreated          = "token.created"
+	ActionTokenRevoked          = "token.revoked"
+)
+
+// Record stores entry. Pass the transaction making the change it describes,
+// if any, so that the entry is only kept when the change is.
+func Record(tx *gorm.DB, entry models.AuditEntry) error {
+	entry.ID = utils.GenerateID()
+	return tx.Create(&entry).Error
+}
@@ -8,30 +8,28 @@ import (
 )
 
 type Claims struct {
-    UserID string `json:"userId"`
-    Role   string `json:"role"`
+    UserID    string `json:"userId"`
+    Role      string `json:"role"`
+    SessionID string `json:"sid"`
     jwt.RegisteredClaims
 }
 
-func GenerateToken(userID, role, secret string) (string, error) {
+func GenerateToken(userID, role, sessionID string, keys *Keyring, ttl time.Duration) (string, error) {
     claims := &Claims{
-        UserID: userID,
-        Role:   role,
+        UserID:    userID,
+        Role:      role,
+        SessionID: sessionID,
         RegisteredClaims: jwt.RegisteredClaims{
-            ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+            ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
             IssuedAt:  jwt.NewNumericDate(time.Now()),
         },
     }
     
-    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-    return token.SignedString([]byte(secret))
+    return keys.Sign(claims)
 }
 
-func ValidateToken(tokenString, secret string) (*Claims, error) {
-    token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-        return []byte(secret), nil
-    })
-    
+func ValidateToken(tokenString string, keys *Keyring) (*Claims, error) {
+    token, err := keys.Parse(tokenString, &Claims{})
     if err != nil {
         return nil, err
     }
@@ -41,4 +39,4 @@ func ValidateToken(tokenString, secret string) (*Claims, error) {
     }
     
     return nil, errors.New("invalid token")
-}
\ No newline at end of file
+}
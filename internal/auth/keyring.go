@@ -0,0 +1,291 @@
+package auth
+
+import (
+    "crypto"
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "math/big"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "user-team-asset-management/internal/utils"
+
+    "github.com/golang-jwt/jwt/v5"
+)
+
+const (
+    AlgRS256 = "RS256"
+    AlgEdDSA = "EdDSA"
+)
+
+var (
+    ErrUnknownKey = errors.New("unknown signing key")
+    ErrNoKeys     = errors.New("no *.pem keys found")
+)
+
+// Key is a single entry of the keyring. Keys without a private half can only
+// verify tokens, which is how retired keys stay usable during rotation.
+type Key struct {
+    ID        string
+    Algorithm string
+    Private   crypto.Signer
+    Public    crypto.PublicKey
+}
+
+func (k *Key) method() jwt.SigningMethod {
+    if k.Algorithm == AlgEdDSA {
+        return jwt.SigningMethodEdDSA
+    }
+    return jwt.SigningMethodRS256
+}
+
+// Keyring holds the key used to sign new tokens plus every key that is still
+// accepted for verification.
+type Keyring struct {
+    mu         sync.RWMutex
+    keys       map[string]*Key
+    signingKID string
+}
+
+func NewKeyring() *Keyring {
+    return &Keyring{keys: make(map[string]*Key)}
+}
+
+func (k *Keyring) Add(key *Key) error {
+    if key.ID == "" {
+        return errors.New("key id is required")
+    }
+    if key.Algorithm != AlgRS256 && key.Algorithm != AlgEdDSA {
+        return fmt.Errorf("unsupported algorithm %q", key.Algorithm)
+    }
+
+    k.mu.Lock()
+    defer k.mu.Unlock()
+    k.keys[key.ID] = key
+    return nil
+}
+
+// SetSigningKey selects which key signs new tokens.
+func (k *Keyring) SetSigningKey(kid string) error {
+    k.mu.Lock()
+    defer k.mu.Unlock()
+
+    key, ok := k.keys[kid]
+    if !ok {
+        return ErrUnknownKey
+    }
+    if key.Private == nil {
+        return fmt.Errorf("key %q has no private key", kid)
+    }
+    k.signingKID = kid
+    return nil
+}
+
+// Remove stops accepting tokens signed with the given key.
+func (k *Keyring) Remove(kid string) {
+    k.mu.Lock()
+    defer k.mu.Unlock()
+
+    delete(k.keys, kid)
+    if k.signingKID == kid {
+        k.signingKID = ""
+    }
+}
+
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+    k.mu.RLock()
+    key, ok := k.keys[k.signingKID]
+    k.mu.RUnlock()
+    if !ok {
+        return "", ErrUnknownKey
+    }
+
+    token := jwt.NewWithClaims(key.method(), claims)
+    token.Header["kid"] = key.ID
+    return token.SignedString(key.Private)
+}
+
+// Parse verifies a token against the key named in its kid header. The alg
+// header must match the algorithm registered for that key.
+func (k *Keyring) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+    return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+        kid, _ := token.Header["kid"].(string)
+
+        k.mu.RLock()
+        key, ok := k.keys[kid]
+        k.mu.RUnlock()
+        if !ok {
+            return nil, ErrUnknownKey
+        }
+        if token.Method.Alg() != key.Algorithm {
+            return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+        }
+        return key.Public, nil
+    }, jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA}))
+}
+
+type JWK struct {
+    Kty string `json:"kty"`
+    Kid string `json:"kid"`
+    Alg string `json:"alg"`
+    Use string `json:"use"`
+    N   string `json:"n,omitempty"`
+    E   string `json:"e,omitempty"`
+    Crv string `json:"crv,omitempty"`
+    X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+    Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every verification key.
+func (k *Keyring) JWKS() JWKSet {
+    k.mu.RLock()
+    defer k.mu.RUnlock()
+
+    set := JWKSet{Keys: []JWK{}}
+    for _, key := range k.keys {
+        jwk := JWK{Kid: key.ID, Alg: key.Algorithm, Use: "sig"}
+        switch pub := key.Public.(type) {
+        case *rsa.PublicKey:
+            jwk.Kty = "RSA"
+            jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+            jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+        case ed25519.PublicKey:
+            jwk.Kty = "OKP"
+            jwk.Crv = "Ed25519"
+            jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+        default:
+            continue
+        }
+        set.Keys = append(set.Keys, jwk)
+    }
+    sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+    return set
+}
+
+// LoadKeyring reads every *.pem file in dir, using the file name as the kid.
+// Private keys can sign and verify; public keys only verify. activeKID picks
+// the signing key and may be empty when the directory holds one private key.
+func LoadKeyring(dir, activeKID string) (*Keyring, error) {
+    files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+    if err != nil {
+        return nil, err
+    }
+    if len(files) == 0 {
+        return nil, fmt.Errorf("%s: %w", dir, ErrNoKeys)
+    }
+
+    keyring := NewKeyring()
+    var signers []string
+    for _, file := range files {
+        data, err := os.ReadFile(file)
+        if err != nil {
+            return nil, err
+        }
+
+        kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+        key, err := parsePEMKey(kid, data)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", file, err)
+        }
+        if err := keyring.Add(key); err != nil {
+            return nil, fmt.Errorf("%s: %w", file, err)
+        }
+        if key.Private != nil {
+            signers = append(signers, kid)
+        }
+    }
+
+    if activeKID == "" {
+        if len(signers) != 1 {
+            return nil, fmt.Errorf("found %d private keys in %s, set the active key id", len(signers), dir)
+        }
+        activeKID = signers[0]
+    }
+    if err := keyring.SetSigningKey(activeKID); err != nil {
+        return nil, err
+    }
+
+    return keyring, nil
+}
+
+// GenerateKeyring creates a keyring with a single freshly generated key.
+// Tokens signed with it do not survive a restart.
+func GenerateKeyring(algorithm string) (*Keyring, error) {
+    key := &Key{ID: "ephemeral-" + utils.GenerateID()[:8], Algorithm: algorithm}
+    switch algorithm {
+    case AlgRS256:
+        priv, err := rsa.GenerateKey(rand.Reader, 2048)
+        if err != nil {
+            return nil, err
+        }
+        key.Private, key.Public = priv, &priv.PublicKey
+    case AlgEdDSA:
+        pub, priv, err := ed25519.GenerateKey(rand.Reader)
+        if err != nil {
+            return nil, err
+        }
+        key.Private, key.Public = priv, pub
+    default:
+        return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+    }
+
+    keyring := NewKeyring()
+    if err := keyring.Add(key); err != nil {
+        return nil, err
+    }
+    if err := keyring.SetSigningKey(key.ID); err != nil {
+        return nil, err
+    }
+    return keyring, nil
+}
+
+func parsePEMKey(kid string, data []byte) (*Key, error) {
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, errors.New("no PEM block found")
+    }
+
+    switch block.Type {
+    case "RSA PRIVATE KEY":
+        priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+        if err != nil {
+            return nil, err
+        }
+        return &Key{ID: kid, Algorithm: AlgRS256, Private: priv, Public: &priv.PublicKey}, nil
+    case "PRIVATE KEY":
+        parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+        if err != nil {
+            return nil, err
+        }
+        switch priv := parsed.(type) {
+        case *rsa.PrivateKey:
+            return &Key{ID: kid, Algorithm: AlgRS256, Private: priv, Public: &priv.PublicKey}, nil
+        case ed25519.PrivateKey:
+            return &Key{ID: kid, Algorithm: AlgEdDSA, Private: priv, Public: priv.Public()}, nil
+        }
+    case "PUBLIC KEY":
+        parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+        if err != nil {
+            return nil, err
+        }
+        switch pub := parsed.(type) {
+        case *rsa.PublicKey:
+            return &Key{ID: kid, Algorithm: AlgRS256, Public: pub}, nil
+        case ed25519.PublicKey:
+            return &Key{ID: kid, Algorithm: AlgEdDSA, Public: pub}, nil
+        }
+    }
+
+    return nil, errors.New("unsupported key type, expected RSA or Ed25519")
+}
@@ -0,0 +1,241 @@
+package auth
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/pem"
+    "errors"
+    "math/big"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/golang-jwt/jwt/v5"
+)
+
+// writeKeys writes each key to dir as <kid>.pem, the private half when it
+// has one and the public half otherwise.
+func writeKeys(t *testing.T, dir string, keys ...*Key) {
+    t.Helper()
+    for _, key := range keys {
+        block := &pem.Block{Type: "PUBLIC KEY"}
+        var err error
+        if key.Private != nil {
+            block.Type = "PRIVATE KEY"
+            block.Bytes, err = x509.MarshalPKCS8PrivateKey(key.Private)
+        } else {
+            block.Bytes, err = x509.MarshalPKIXPublicKey(key.Public)
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(filepath.Join(dir, key.ID+".pem"), pem.EncodeToMemory(block), 0o600); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func newRSAKey(t *testing.T, kid string) *Key {
+    t.Helper()
+    priv, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &Key{ID: kid, Algorithm: AlgRS256, Private: priv, Public: &priv.PublicKey}
+}
+
+func newEdDSAKey(t *testing.T, kid string) *Key {
+    t.Helper()
+    pub, priv, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &Key{ID: kid, Algorithm: AlgEdDSA, Private: priv, Public: pub}
+}
+
+func publicOnly(key *Key) *Key {
+    return &Key{ID: key.ID, Algorithm: key.Algorithm, Public: key.Public}
+}
+
+// signingKID signs a token with keys and returns the kid in its header.
+func signingKID(t *testing.T, keys *Keyring) string {
+    t.Helper()
+    token, err := GenerateToken("user", "member", "session", keys, time.Minute)
+    if err != nil {
+        t.Fatal(err)
+    }
+    parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    kid, _ := parsed.Header["kid"].(string)
+    return kid
+}
+
+func TestLoadKeyringSigningKey(t *testing.T) {
+    rsaKey := newRSAKey(t, "2024-01")
+    edKey := newEdDSAKey(t, "2024-02")
+    retired := publicOnly(newEdDSAKey(t, "2023-12"))
+
+    tests := []struct {
+        name      string
+        keys      []*Key
+        activeKID string
+        wantKID   string
+        wantErr   error
+    }{
+        {name: "single private key", keys: []*Key{edKey, retired}, wantKID: "2024-02"},
+        {name: "active key picked", keys: []*Key{rsaKey, edKey}, activeKID: "2024-01", wantKID: "2024-01"},
+        {name: "several private keys need an active key", keys: []*Key{rsaKey, edKey}, wantErr: errors.New("")},
+        {name: "unknown active key", keys: []*Key{rsaKey}, activeKID: "missing", wantErr: ErrUnknownKey},
+        {name: "public key cannot sign", keys: []*Key{rsaKey, retired}, activeKID: "2023-12", wantErr: errors.New("")},
+        {name: "empty directory", wantErr: ErrNoKeys},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := t.TempDir()
+            writeKeys(t, dir, tt.keys...)
+
+            keys, err := LoadKeyring(dir, tt.activeKID)
+            if tt.wantErr != nil {
+                if err == nil {
+                    t.Fatal("LoadKeyring succeeded, want an error")
+                }
+                if tt.wantErr.Error() != "" && !errors.Is(err, tt.wantErr) {
+                    t.Fatalf("LoadKeyring error = %v, want %v", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if kid := signingKID(t, keys); kid != tt.wantKID {
+                t.Errorf("token signed with %q, want %q", kid, tt.wantKID)
+            }
+        })
+    }
+}
+
+func TestKeyringRotation(t *testing.T) {
+    oldKey := newRSAKey(t, "old")
+    newKey := newEdDSAKey(t, "new")
+
+    keys := NewKeyring()
+    for _, key := range []*Key{oldKey, newKey} {
+        if err := keys.Add(key); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := keys.SetSigningKey("old"); err != nil {
+        t.Fatal(err)
+    }
+    oldToken, err := GenerateToken("user", "member", "session", keys, time.Minute)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := keys.SetSigningKey("new"); err != nil {
+        t.Fatal(err)
+    }
+    newToken, err := GenerateToken("user", "member", "session", keys, time.Minute)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Tokens of the retired key stay valid until it is removed
+    for _, token := range []string{oldToken, newToken} {
+        if _, err := ValidateToken(token, keys); err != nil {
+            t.Errorf("ValidateToken before removal: %v", err)
+        }
+    }
+    keys.Remove("old")
+    if _, err := ValidateToken(oldToken, keys); !errors.Is(err, ErrUnknownKey) {
+        t.Errorf("ValidateToken with removed key = %v, want %v", err, ErrUnknownKey)
+    }
+    if _, err := ValidateToken(newToken, keys); err != nil {
+        t.Errorf("ValidateToken with current key: %v", err)
+    }
+}
+
+func TestKeyringRejectsAlgorithmMismatch(t *testing.T) {
+    rsaKey := newRSAKey(t, "rsa")
+    edKey := newEdDSAKey(t, "ed")
+
+    keys := NewKeyring()
+    for _, key := range []*Key{rsaKey, edKey} {
+        if err := keys.Add(key); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    // An EdDSA signature presented under the kid of the RSA key
+    token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, &Claims{UserID: "user"})
+    token.Header["kid"] = "rsa"
+    signed, err := token.SignedString(edKey.Private)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := ValidateToken(signed, keys); err == nil {
+        t.Fatal("token with the wrong algorithm for its kid was accepted")
+    }
+}
+
+func TestJWKS(t *testing.T) {
+    rsaKey := newRSAKey(t, "b-rsa")
+    edKey := newEdDSAKey(t, "a-ed")
+    retired := publicOnly(newRSAKey(t, "c-retired"))
+
+    keys := NewKeyring()
+    for _, key := range []*Key{rsaKey, edKey, retired} {
+        if err := keys.Add(key); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    set := keys.JWKS()
+    if len(set.Keys) != 3 {
+        t.Fatalf("JWKS has %d keys, want 3", len(set.Keys))
+    }
+
+    tests := []struct {
+        jwk  JWK
+        key  *Key
+        kty  string
+        alg  string
+    }{
+        {jwk: set.Keys[0], key: edKey, kty: "OKP", alg: AlgEdDSA},
+        {jwk: set.Keys[1], key: rsaKey, kty: "RSA", alg: AlgRS256},
+        {jwk: set.Keys[2], key: retired, kty: "RSA", alg: AlgRS256},
+    }
+    for _, tt := range tests {
+        t.Run(tt.key.ID, func(t *testing.T) {
+            if tt.jwk.Kid != tt.key.ID || tt.jwk.Kty != tt.kty || tt.jwk.Alg != tt.alg || tt.jwk.Use != "sig" {
+                t.Fatalf("got kid=%s kty=%s alg=%s use=%s", tt.jwk.Kid, tt.jwk.Kty, tt.jwk.Alg, tt.jwk.Use)
+            }
+
+            switch pub := tt.key.Public.(type) {
+            case ed25519.PublicKey:
+                if tt.jwk.Crv != "Ed25519" {
+                    t.Errorf("crv = %q, want Ed25519", tt.jwk.Crv)
+                }
+                x, err := base64.RawURLEncoding.DecodeString(tt.jwk.X)
+                if err != nil || !pub.Equal(ed25519.PublicKey(x)) {
+                    t.Errorf("x does not decode to the public key")
+                }
+            case *rsa.PublicKey:
+                n, errN := base64.RawURLEncoding.DecodeString(tt.jwk.N)
+                e, errE := base64.RawURLEncoding.DecodeString(tt.jwk.E)
+                if errN != nil || errE != nil {
+                    t.Fatalf("n or e is not base64url: %v %v", errN, errE)
+                }
+                decoded := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+                if !pub.Equal(decoded) {
+                    t.Errorf("n and e do not decode to the public key")
+                }
+            }
+        })
+    }
+}
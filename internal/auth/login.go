@@ -0,0 +1,385 @@
+package auth
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math"
+    "strings"
+    "sync"
+    "time"
+    "user-team-asset-management/internal/audit"
+    "user-team-asset-management/internal/logger"
+    "user-team-asset-management/internal/models"
+
+    "golang.org/x/crypto/bcrypt"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+var (
+    ErrInvalidCredentials = errors.New("invalid credentials")
+    ErrAccountDeactivated = errors.New("account is deactivated")
+)
+
+// LoginThrottledError is returned instead of checking credentials while
+// failed attempts keep the email address or client IP from trying again.
+type LoginThrottledError struct {
+    RetryAfter time.Duration
+    Locked     bool
+}
+
+func (e *LoginThrottledError) Error() string {
+    return fmt.Sprintf("too many failed login attempts, try again in %s", e.RetryAfter)
+}
+
+// LoginLimits are the failure counts at which further attempts are slowed
+// down and locked out. Zero disables either step.
+type LoginLimits struct {
+    BackoffAfter int
+    LockoutAfter int
+}
+
+// LoginGuard checks credentials while tracking failed attempts per email
+// address and per client IP. Once BackoffAfter failures are reached, each
+// further attempt has to wait BaseDelay, doubling with every failure up to
+// MaxDelay; at LockoutAfter failures the subject is locked out for
+// LockoutDuration, which is recorded in the audit log. Failures are
+// forgotten FailureWindow after the last one, and a successful login clears
+// those of its email address.
+//
+// Unknown email addresses are tracked and compared against a dummy hash
+// exactly like known ones, so neither the responses nor their timing show
+// whether an address has an account.
+type LoginGuard struct {
+    DB              *gorm.DB
+    Account         LoginLimits
+    IP              LoginLimits
+    BaseDelay       time.Duration
+    MaxDelay        time.Duration
+    LockoutDuration time.Duration
+    FailureWindow   time.Duration
+
+    // Scope keeps the counts of guards for other actions, such as password
+    // reset requests, apart from those of logins. Run prunes every scope,
+    // so guards sharing a database should use the same FailureWindow.
+    Scope string
+
+    dummyOnce sync.Once
+    dummyHash []byte
+}
+
+type loginSubject struct {
+    key    string
+    limits LoginLimits
+    ip     bool
+}
+
+// Authenticate returns the user with the given email address and password.
+// ip may be empty when the client's address is unknown. The failures of a
+// user with two-factor authentication are kept until the second factor
+// passes too and the caller calls Succeed.
+func (g *LoginGuard) Authenticate(email, password, ip string) (*models.User, error) {
+    // Addresses are stored lower-cased
+    email = strings.ToLower(strings.TrimSpace(email))
+
+    var users []models.User
+    if err := g.DB.Where("email = ?", email).Limit(1).Find(&users).Error; err != nil {
+        return nil, err
+    }
+
+    hash := g.dummy()
+    var userID string
+    if len(users) == 1 {
+        hash = []byte(users[0].PasswordHash)
+        userID = users[0].ID
+    }
+
+    attempt, err := g.Begin(email, userID, ip)
+    if err != nil {
+        return nil, err
+    }
+    // Service accounts only use personal access tokens
+    if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || len(users) == 0 || users[0].ServiceAccount {
+        if err := attempt.Fail(); err != nil {
+            return nil, err
+        }
+        return nil, ErrInvalidCredentials
+    }
+    if err := attempt.Pass(); err != nil {
+        return nil, err
+    }
+
+    if !users[0].Active {
+        return nil, ErrAccountDeactivated
+    }
+    if !users[0].TwoFactorEnabled() {
+        if err := g.Succeed(email); err != nil {
+            return nil, err
+        }
+    }
+    return &users[0], nil
+}
+
+// LoginAttempt is an attempt that Begin has already counted as failed. The
+// caller checks the credentials and then calls exactly one of Fail or Pass.
+type LoginAttempt struct {
+    guard    *LoginGuard
+    email    string
+    userID   string
+    ip       string
+    reserved []reservation
+}
+
+type reservation struct {
+    subject loginSubject
+    // locked reports whether this attempt started a lockout
+    locked bool
+}
+
+// Begin counts an attempt for the email address and ip before any
+// credential is checked, or returns a *LoginThrottledError while either may
+// not try. Counting first, under a row lock, keeps concurrent guesses from
+// all passing the limits at once. userID is empty for unknown addresses.
+func (g *LoginGuard) Begin(email, userID, ip string) (*LoginAttempt, error) {
+    attempt := &LoginAttempt{guard: g, email: email, userID: userID, ip: ip}
+    for _, subject := range g.subjects(email, ip) {
+        var locked bool
+        err := g.DB.Transaction(func(tx *gorm.DB) error {
+            throttle, err := lockThrottle(tx, subject.key)
+            if err != nil {
+                return err
+            }
+            now := time.Now()
+            if err := throttledError(throttle, now); err != nil {
+                return err
+            }
+            locked = g.count(&throttle, subject.limits, now)
+            return tx.Save(&throttle).Error
+        })
+        if err != nil {
+            // Take back what the other subjects already counted
+            attempt.Pass()
+            return nil, err
+        }
+        attempt.reserved = append(attempt.reserved, reservation{subject: subject, locked: locked})
+    }
+    return attempt, nil
+}
+
+// Fail keeps the attempt counted and records any lockout it started in the
+// audit log.
+func (a *LoginAttempt) Fail() error {
+    for _, r := range a.reserved {
+        if !r.locked {
+            continue
+        }
+        if err := a.guard.recordLockout(a.guard.DB, r.subject, a.userID, a.email, a.ip); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Pass takes the attempt back, along with any backoff or lockout it
+// started.
+func (a *LoginAttempt) Pass() error {
+    for _, r := range a.reserved {
+        err := a.guard.DB.Transaction(func(tx *gorm.DB) error {
+            throttle, err := lockThrottle(tx, r.subject.key)
+            if err != nil {
+                return err
+            }
+            if throttle.Failures > 0 {
+                throttle.Failures--
+            }
+            limits := r.subject.limits
+            if r.locked || (throttle.LockedAt == nil && (limits.BackoffAfter == 0 || throttle.Failures < limits.BackoffAfter)) {
+                throttle.BlockedUntil = nil
+                throttle.LockedAt = nil
+            }
+            return tx.Save(&throttle).Error
+        })
+        if err != nil {
+            return err
+        }
+    }
+    a.reserved = nil
+    return nil
+}
+
+// Succeed clears the failures of the email address after a complete login.
+func (g *LoginGuard) Succeed(email string) error {
+    return g.DB.Where("subject = ?", g.accountSubject(email)).Delete(&models.LoginThrottle{}).Error
+}
+
+func (g *LoginGuard) subjects(email, ip string) []loginSubject {
+    subjects := []loginSubject{{key: g.accountSubject(email), limits: g.Account}}
+    if ip != "" {
+        subjects = append(subjects, loginSubject{key: g.scoped("ip:" + ip), limits: g.IP, ip: true})
+    }
+    return subjects
+}
+
+func (g *LoginGuard) accountSubject(email string) string {
+    return g.scoped("email:" + strings.ToLower(strings.TrimSpace(email)))
+}
+
+func (g *LoginGuard) scoped(key string) string {
+    if g.Scope == "" {
+        return key
+    }
+    return g.Scope + ":" + key
+}
+
+// dummy returns a hash to compare against for unknown email addresses, so
+// that they take as long as known ones.
+func (g *LoginGuard) dummy() []byte {
+    g.dummyOnce.Do(func() {
+        g.dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+    })
+    return g.dummyHash
+}
+
+// throttledError returns the error for a blocked throttle, or nil.
+func throttledError(throttle models.LoginThrottle, now time.Time) *LoginThrottledError {
+    if throttle.BlockedUntil == nil || !throttle.BlockedUntil.After(now) {
+        return nil
+    }
+    retryAfter := throttle.BlockedUntil.Sub(now).Round(time.Second)
+    if retryAfter < time.Second {
+        retryAfter = time.Second
+    }
+    return &LoginThrottledError{RetryAfter: retryAfter, Locked: throttle.LockedAt != nil}
+}
+
+// lockThrottle returns the throttle row of subject, creating it if needed,
+// locked until tx ends.
+func lockThrottle(tx *gorm.DB, subject string) (models.LoginThrottle, error) {
+    var throttle models.LoginThrottle
+    if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+        Create(&models.LoginThrottle{Subject: subject}).Error; err != nil {
+        return throttle, err
+    }
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("subject = ?", subject).
+        First(&throttle).Error
+    return throttle, err
+}
+
+// count adds a failure to throttle and blocks it further if a limit has
+// been reached. It reports whether this failure started a lockout.
+func (g *LoginGuard) count(throttle *models.LoginThrottle, limits LoginLimits, now time.Time) bool {
+    if now.Sub(throttle.LastFailureAt) > g.FailureWindow {
+        throttle.Failures = 0
+    }
+    wasLocked := throttle.LockedAt != nil && throttle.BlockedUntil != nil && throttle.BlockedUntil.After(now)
+
+    throttle.Failures++
+    throttle.LastFailureAt = now
+    throttle.LockedAt = nil
+    switch {
+    case limits.LockoutAfter > 0 && throttle.Failures >= limits.LockoutAfter:
+        until := now.Add(g.LockoutDuration)
+        throttle.BlockedUntil = &until
+        throttle.LockedAt = &now
+    case limits.BackoffAfter > 0 && throttle.Failures >= limits.BackoffAfter:
+        until := now.Add(g.backoff(throttle.Failures - limits.BackoffAfter))
+        throttle.BlockedUntil = &until
+    default:
+        throttle.BlockedUntil = nil
+    }
+    return throttle.LockedAt != nil && !wasLocked
+}
+
+// recordLockout writes the audit entry for a subject that has just been
+// locked out.
+func (g *LoginGuard) recordLockout(tx *gorm.DB, subject loginSubject, userID, email, ip string) error {
+    var throttle models.LoginThrottle
+    if err := tx.Where("subject = ?", subject.key).First(&throttle).Error; err != nil {
+        return err
+    }
+    if throttle.BlockedUntil == nil {
+        return nil
+    }
+    detail := fmt.Sprintf("%d failed logins for %s, locked until %s", throttle.Failures, email, throttle.BlockedUntil.UTC().Format(time.RFC3339))
+    if subject.ip {
+        userID = ""
+        detail = fmt.Sprintf("%d failed logins from %s, locked until %s", throttle.Failures, ip, throttle.BlockedUntil.UTC().Format(time.RFC3339))
+    }
+    return audit.Record(tx, models.AuditEntry{
+        Action:       audit.ActionLoginLocked,
+        TargetUserID: userID,
+        IP:           ip,
+        Detail:       detail,
+    })
+}
+
+// backoff returns BaseDelay doubled n times, capped at MaxDelay.
+func (g *LoginGuard) backoff(n int) time.Duration {
+    delay := float64(g.BaseDelay) * math.Pow(2, float64(n))
+    if delay > float64(g.MaxDelay) {
+        return g.MaxDelay
+    }
+    return time.Duration(delay)
+}
+
+// Unlock clears the failed logins of the user's email address, ending any
+// backoff or lockout, and records who did it. It reports whether the
+// address was blocked.
+func (g *LoginGuard) Unlock(userID, actorID, ip string) (bool, error) {
+    var user models.User
+    if err := g.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+        return false, err
+    }
+
+    var blocked bool
+    err := g.DB.Transaction(func(tx *gorm.DB) error {
+        var throttles []models.LoginThrottle
+        if err := tx.Where("subject = ?", g.accountSubject(user.Email)).Find(&throttles).Error; err != nil {
+            return err
+        }
+        if len(throttles) == 0 {
+            return nil
+        }
+        throttle := throttles[0]
+        blocked = throttle.BlockedUntil != nil && throttle.BlockedUntil.After(time.Now())
+
+        if err := tx.Delete(&throttle).Error; err != nil {
+            return err
+        }
+        if !blocked {
+            return nil
+        }
+        return audit.Record(tx, models.AuditEntry{
+            Action:       audit.ActionLoginUnlocked,
+            ActorID:      actorID,
+            TargetUserID: user.ID,
+            IP:           ip,
+            Detail:       fmt.Sprintf("cleared %d failed logins for %s", throttle.Failures, user.Email),
+        })
+    })
+    return blocked, err
+}
+
+// Run forgets failures older than FailureWindow every interval until ctx
+// is cancelled.
+func (g *LoginGuard) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        cutoff := time.Now().Add(-g.FailureWindow)
+        err := g.DB.Where("last_failure_at < ? AND (blocked_until IS NULL OR blocked_until < ?)", cutoff, time.Now()).
+            Delete(&models.LoginThrottle{}).Error
+        if err != nil {
+            logger.DefaultLogger.Error(fmt.Sprintf("Pruning login throttles failed: %v", err))
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
@@ -0,0 +1,232 @@
+package auth
+
+import (
+    "errors"
+    "sync"
+    "testing"
+    "time"
+    "user-team-asset-management/internal/audit"
+    "user-team-asset-management/internal/dbtest"
+    "user-team-asset-management/internal/models"
+
+    "golang.org/x/crypto/bcrypt"
+)
+
+func TestBackoff(t *testing.T) {
+    g := &LoginGuard{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+    tests := []struct {
+        n    int
+        want time.Duration
+    }{
+        {0, time.Second},
+        {1, 2 * time.Second},
+        {2, 4 * time.Second},
+        {3, 8 * time.Second},
+        {4, 10 * time.Second},
+        {100, 10 * time.Second},
+    }
+    for _, tt := range tests {
+        if got := g.backoff(tt.n); got != tt.want {
+            t.Errorf("backoff(%d) = %s, want %s", tt.n, got, tt.want)
+        }
+    }
+}
+
+func TestCountFailures(t *testing.T) {
+    g := &LoginGuard{
+        BaseDelay:       time.Second,
+        MaxDelay:        time.Minute,
+        LockoutDuration: time.Hour,
+        FailureWindow:   time.Hour,
+    }
+    limits := LoginLimits{BackoffAfter: 3, LockoutAfter: 5}
+    now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+    // Each failure comes right after the previous block ended
+    tests := []struct {
+        blockedFor time.Duration
+        locked     bool
+        started    bool
+    }{
+        {},
+        {},
+        {blockedFor: time.Second},
+        {blockedFor: 2 * time.Second},
+        {blockedFor: time.Hour, locked: true, started: true},
+        {blockedFor: time.Hour, locked: true, started: true},
+    }
+    var throttle models.LoginThrottle
+    for i, tt := range tests {
+        started := g.count(&throttle, limits, now)
+
+        var blockedFor time.Duration
+        if throttle.BlockedUntil != nil {
+            blockedFor = throttle.BlockedUntil.Sub(now)
+        }
+        if throttle.Failures != i+1 || blockedFor != tt.blockedFor || (throttle.LockedAt != nil) != tt.locked || started != tt.started {
+            t.Fatalf("failure %d: failures=%d blocked for %s locked=%t started=%t, want blocked for %s locked=%t started=%t",
+                i+1, throttle.Failures, blockedFor, throttle.LockedAt != nil, started, tt.blockedFor, tt.locked, tt.started)
+        }
+        if blockedFor > 0 {
+            now = now.Add(blockedFor)
+        }
+    }
+
+    // A failure while still locked does not start another lockout
+    if g.count(&throttle, limits, now.Add(-time.Minute)) {
+        t.Error("failure during a lockout started a new one")
+    }
+
+    // Failures are forgotten after FailureWindow
+    throttle = models.LoginThrottle{Failures: 4, LastFailureAt: now.Add(-2 * time.Hour)}
+    g.count(&throttle, limits, now)
+    if throttle.Failures != 1 || throttle.BlockedUntil != nil {
+        t.Errorf("after the window: failures=%d blocked=%v, want 1 and unblocked", throttle.Failures, throttle.BlockedUntil)
+    }
+}
+
+func TestThrottledError(t *testing.T) {
+    now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    at := func(d time.Duration) *time.Time { at := now.Add(d); return &at }
+
+    tests := []struct {
+        name       string
+        throttle   models.LoginThrottle
+        retryAfter time.Duration
+        locked     bool
+    }{
+        {name: "never blocked"},
+        {name: "block ended", throttle: models.LoginThrottle{BlockedUntil: at(-time.Second)}},
+        {name: "backing off", throttle: models.LoginThrottle{BlockedUntil: at(4 * time.Second)}, retryAfter: 4 * time.Second},
+        {name: "rounded up to a second", throttle: models.LoginThrottle{BlockedUntil: at(100 * time.Millisecond)}, retryAfter: time.Second},
+        {name: "locked out", throttle: models.LoginThrottle{BlockedUntil: at(time.Hour), LockedAt: at(0)}, retryAfter: time.Hour, locked: true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := throttledError(tt.throttle, now)
+            if tt.retryAfter == 0 {
+                if err != nil {
+                    t.Fatalf("got %v, want no error", err)
+                }
+                return
+            }
+            if err == nil || err.RetryAfter != tt.retryAfter || err.Locked != tt.locked {
+                t.Fatalf("got %+v, want retry after %s locked=%t", err, tt.retryAfter, tt.locked)
+            }
+        })
+    }
+}
+
+func newTestGuard(t *testing.T, account, ip LoginLimits) *LoginGuard {
+    t.Helper()
+    db := dbtest.Open(t)
+    hash, err := bcrypt.GenerateFromPassword([]byte("Correct-horse-1"), bcrypt.MinCost)
+    if err != nil {
+        t.Fatal(err)
+    }
+    dbtest.Seed(t, db, &models.User{ID: "alice", Username: "alice", Email: "alice@example.com", PasswordHash: string(hash), Role: "member", Active: true})
+    return &LoginGuard{
+        DB:              db,
+        Account:         account,
+        IP:              ip,
+        BaseDelay:       time.Millisecond,
+        MaxDelay:        time.Millisecond,
+        LockoutDuration: time.Hour,
+        FailureWindow:   time.Hour,
+    }
+}
+
+func TestAuthenticateLockout(t *testing.T) {
+    g := newTestGuard(t, LoginLimits{BackoffAfter: 2, LockoutAfter: 4}, LoginLimits{})
+
+    for i := 1; i <= 4; i++ {
+        // Let the backoff from the previous failure run out
+        time.Sleep(5 * time.Millisecond)
+        if _, err := g.Authenticate("alice@example.com", "wrong", "10.0.0.1"); !errors.Is(err, ErrInvalidCredentials) {
+            t.Fatalf("attempt %d: got %v, want %v", i, err, ErrInvalidCredentials)
+        }
+    }
+
+    // Locked out, even with the right password
+    var throttled *LoginThrottledError
+    if _, err := g.Authenticate("Alice@example.com", "Correct-horse-1", "10.0.0.1"); !errors.As(err, &throttled) || !throttled.Locked {
+        t.Fatalf("got %v, want a lockout", err)
+    }
+
+    var locks int64
+    if err := g.DB.Model(&models.AuditEntry{}).Where("action = ?", audit.ActionLoginLocked).Count(&locks).Error; err != nil {
+        t.Fatal(err)
+    }
+    if locks != 1 {
+        t.Errorf("recorded %d lockouts, want 1", locks)
+    }
+
+    if blocked, err := g.Unlock("alice", "admin", ""); err != nil || !blocked {
+        t.Fatalf("Unlock = (%t, %v), want (true, nil)", blocked, err)
+    }
+    if _, err := g.Authenticate("alice@example.com", "Correct-horse-1", "10.0.0.1"); err != nil {
+        t.Fatalf("login after unlock: %v", err)
+    }
+}
+
+func TestAuthenticateCountsConcurrentGuesses(t *testing.T) {
+    g := newTestGuard(t, LoginLimits{LockoutAfter: 3}, LoginLimits{})
+
+    var wg sync.WaitGroup
+    results := make(chan error, 10)
+    for i := 0; i < cap(results); i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            _, err := g.Authenticate("alice@example.com", "wrong", "")
+            results <- err
+        }()
+    }
+    wg.Wait()
+    close(results)
+
+    // Only the guesses counted before the lockout reach the password check
+    var checked int
+    for err := range results {
+        var throttled *LoginThrottledError
+        switch {
+        case errors.Is(err, ErrInvalidCredentials):
+            checked++
+        case errors.As(err, &throttled):
+        default:
+            t.Fatalf("unexpected error %v", err)
+        }
+    }
+    if checked != 3 {
+        t.Errorf("%d guesses were checked, want 3", checked)
+    }
+}
+
+func TestAuthenticatePassTakesAttemptBack(t *testing.T) {
+    g := newTestGuard(t, LoginLimits{BackoffAfter: 2}, LoginLimits{BackoffAfter: 2})
+
+    if _, err := g.Authenticate("alice@example.com", "wrong", "10.0.0.1"); !errors.Is(err, ErrInvalidCredentials) {
+        t.Fatalf("got %v, want %v", err, ErrInvalidCredentials)
+    }
+    // The right password does not count towards the backoff from the IP
+    if _, err := g.Authenticate("alice@example.com", "Correct-horse-1", "10.0.0.1"); err != nil {
+        t.Fatal(err)
+    }
+
+    var throttle models.LoginThrottle
+    if err := g.DB.Where("subject = ?", "ip:10.0.0.1").First(&throttle).Error; err != nil {
+        t.Fatal(err)
+    }
+    if throttle.Failures != 1 || throttle.BlockedUntil != nil {
+        t.Errorf("IP throttle has %d failures, blocked=%v; want 1 and unblocked", throttle.Failures, throttle.BlockedUntil)
+    }
+
+    var accounts int64
+    if err := g.DB.Model(&models.LoginThrottle{}).Where("subject = ?", "email:alice@example.com").Count(&accounts).Error; err != nil {
+        t.Fatal(err)
+    }
+    if accounts != 0 {
+        t.Error("a successful login kept the failures of the address")
+    }
+}
@@ -0,0 +1,287 @@
+package auth
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/url"
+    "strings"
+    "time"
+    "unicode"
+    "unicode/utf8"
+    "user-team-asset-management/internal/logger"
+    "user-team-asset-management/internal/mail"
+    "user-team-asset-management/internal/models"
+    "user-team-asset-management/internal/utils"
+
+    "golang.org/x/crypto/bcrypt"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// bcrypt ignores everything past the first 72 bytes, so longer passwords
+// are refused rather than silently truncated.
+const maxPasswordBytes = 72
+
+var (
+    ErrWrongPassword     = errors.New("current password is incorrect")
+    ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+)
+
+// PasswordPolicy describes which passwords are accepted.
+type PasswordPolicy struct {
+    MinLength     int
+    RequireUpper  bool
+    RequireLower  bool
+    RequireDigit  bool
+    RequireSymbol bool
+}
+
+// PasswordPolicyError lists every rule a password breaks.
+type PasswordPolicyError struct {
+    Problems []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+    problems := e.Problems
+    if len(problems) == 1 {
+        return "password must " + problems[0]
+    }
+    return "password must " + strings.Join(problems[:len(problems)-1], ", ") + " and " + problems[len(problems)-1]
+}
+
+// Check returns a *PasswordPolicyError if password breaks the policy. It
+// must not contain any of the identifiers, such as the username or the
+// local part of the email address, that are at least 3 characters long.
+func (p PasswordPolicy) Check(password string, identifiers ...string) error {
+    var problems []string
+    if utf8.RuneCountInString(password) < p.MinLength {
+        problems = append(problems, fmt.Sprintf("be at least %d characters long", p.MinLength))
+    }
+    if len(password) > maxPasswordBytes {
+        problems = append(problems, fmt.Sprintf("be at most %d bytes long", maxPasswordBytes))
+    }
+
+    var upper, lower, digit, symbol bool
+    for _, r := range password {
+        switch {
+        case unicode.IsUpper(r):
+            upper = true
+        case unicode.IsLower(r):
+            lower = true
+        case unicode.IsDigit(r):
+            digit = true
+        case unicode.IsPunct(r) || unicode.IsSymbol(r):
+            symbol = true
+        }
+    }
+    if p.RequireUpper && !upper {
+        problems = append(problems, "contain an uppercase letter")
+    }
+    if p.RequireLower && !lower {
+        problems = append(problems, "contain a lowercase letter")
+    }
+    if p.RequireDigit && !digit {
+        problems = append(problems, "contain a digit")
+    }
+    if p.RequireSymbol && !symbol {
+        problems = append(problems, "contain a symbol")
+    }
+
+    lowered := strings.ToLower(password)
+    for _, identifier := range identifiers {
+        identifier = strings.ToLower(identifier)
+        if len(identifier) >= 3 && strings.Contains(lowered, identifier) {
+            problems = append(problems, "not contain your username or email address")
+            break
+        }
+    }
+
+    if len(problems) > 0 {
+        return &PasswordPolicyError{Problems: problems}
+    }
+    return nil
+}
+
+// PasswordManager sets, changes and resets passwords. Resets go through
+// single-use tokens mailed to the user, and both changing and resetting a
+// password sign the user out of every session.
+type PasswordManager struct {
+    DB            *gorm.DB
+    Policy        PasswordPolicy
+    Mailer        mail.Mailer
+    ResetTokenTTL time.Duration
+
+    // ResetURL is the page where users choose a new password. The token is
+    // added to it as the "token" query parameter.
+    ResetURL string
+
+    // Logins counts wrong current passwords given to Change as failed
+    // logins. ResetRequests limits how often RequestReset may be called
+    // per address and per client IP. Either may be nil.
+    Logins        *LoginGuard
+    ResetRequests *LoginGuard
+}
+
+// Hash checks password against the policy for a user with the given
+// username and email address, and returns the hash to store.
+func (m *PasswordManager) Hash(password, username, email string) (string, error) {
+    localPart, _, _ := strings.Cut(email, "@")
+    if err := m.Policy.Check(password, username, localPart); err != nil {
+        return "", err
+    }
+
+    hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    if err != nil {
+        return "", err
+    }
+    return string(hash), nil
+}
+
+// Change replaces the user's password after checking the current one.
+// Wrong guesses are throttled like failed logins from ip.
+func (m *PasswordManager) Change(userID, currentPassword, newPassword, ip string) error {
+    var user models.User
+    if err := m.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+        return errors.New("user not found")
+    }
+
+    var attempt *LoginAttempt
+    if m.Logins != nil {
+        var err error
+        if attempt, err = m.Logins.Begin(user.Email, user.ID, ip); err != nil {
+            return err
+        }
+    }
+    if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(currentPassword)); err != nil {
+        if attempt != nil {
+            if err := attempt.Fail(); err != nil {
+                return err
+            }
+        }
+        return ErrWrongPassword
+    }
+    if attempt != nil {
+        if err := attempt.Pass(); err != nil {
+            return err
+        }
+    }
+
+    return m.DB.Transaction(func(tx *gorm.DB) error {
+        return m.setPassword(tx, &user, newPassword)
+    })
+}
+
+// RequestReset mails a reset link to the user with the given email address,
+// replacing any earlier link. Unknown addresses and deactivated users are
+// ignored without an error. Only the throttling for the address and ip is
+// done before returning; looking the user up, storing the token and mailing
+// happen in the background, so that neither the result nor the response
+// time reveals whether an address has an account.
+func (m *PasswordManager) RequestReset(email, ip string) error {
+    email = strings.ToLower(strings.TrimSpace(email))
+    if m.ResetRequests != nil {
+        attempt, err := m.ResetRequests.Begin(email, "", ip)
+        if err != nil {
+            return err
+        }
+        // Every request counts, whether or not the address has an account
+        if err := attempt.Fail(); err != nil {
+            return err
+        }
+    }
+
+    go func() {
+        if err := m.sendReset(email); err != nil {
+            logger.DefaultLogger.Error(fmt.Sprintf("Failed to send password reset mail: %v", err))
+        }
+    }()
+    return nil
+}
+
+func (m *PasswordManager) sendReset(email string) error {
+    var users []models.User
+    if err := m.DB.Where("email = ? AND active AND NOT service_account", email).Limit(1).Find(&users).Error; err != nil {
+        return err
+    }
+    if len(users) == 0 {
+        return nil
+    }
+    user := users[0]
+
+    rawToken := utils.GenerateSecret()
+    now := time.Now()
+    err := m.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Model(&models.PasswordResetToken{}).
+            Where("user_id = ? AND used_at IS NULL", user.ID).
+            Update("used_at", now).Error; err != nil {
+            return err
+        }
+        return tx.Create(&models.PasswordResetToken{
+            ID:        utils.GenerateID(),
+            UserID:    user.ID,
+            TokenHash: HashToken(rawToken),
+            ExpiresAt: now.Add(m.ResetTokenTTL),
+        }).Error
+    })
+    if err != nil {
+        return err
+    }
+
+    return m.Mailer.Send(context.Background(), mail.Message{
+        To:      user.Email,
+        Subject: "Reset your password",
+        Body: fmt.Sprintf("Hi %s,\n\n"+
+            "Someone asked to reset the password of your account. To choose a new password, open\n\n"+
+            "%s\n\n"+
+            "The link can be used once and expires in %s. If you did not ask for it, you can ignore this message.\n",
+            user.Username, m.resetLink(rawToken), m.ResetTokenTTL),
+    })
+}
+
+func (m *PasswordManager) resetLink(rawToken string) string {
+    link, err := url.Parse(m.ResetURL)
+    if err != nil {
+        return m.ResetURL + "?token=" + url.QueryEscape(rawToken)
+    }
+    query := link.Query()
+    query.Set("token", rawToken)
+    link.RawQuery = query.Encode()
+    return link.String()
+}
+
+// Reset sets a new password with a token from RequestReset and uses the
+// token up.
+func (m *PasswordManager) Reset(rawToken, newPassword string) error {
+    return m.DB.Transaction(func(tx *gorm.DB) error {
+        var token models.PasswordResetToken
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("token_hash = ?", HashToken(rawToken)).
+            First(&token).Error; err != nil {
+            return ErrInvalidResetToken
+        }
+        if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+            return ErrInvalidResetToken
+        }
+
+        var user models.User
+        if err := tx.Where("id = ?", token.UserID).First(&user).Error; err != nil || !user.Active {
+            return ErrInvalidResetToken
+        }
+        if err := m.setPassword(tx, &user, newPassword); err != nil {
+            return err
+        }
+        return tx.Model(&token).Update("used_at", time.Now()).Error
+    })
+}
+
+func (m *PasswordManager) setPassword(tx *gorm.DB, user *models.User, password string) error {
+    hash, err := m.Hash(password, user.Username, user.Email)
+    if err != nil {
+        return err
+    }
+    if err := tx.Model(user).Update("password_hash", hash).Error; err != nil {
+        return err
+    }
+    return revokeUserSessions(tx, user.ID)
+}
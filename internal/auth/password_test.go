@@ -0,0 +1,41 @@
+package auth
+
+import (
+    "errors"
+    "strings"
+    "testing"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+    policy := PasswordPolicy{MinLength: 10, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+
+    tests := []struct {
+        name        string
+        password    string
+        identifiers []string
+        want        string
+    }{
+        {name: "strong", password: "Correct-horse-1"},
+        {name: "too short", password: "Ab1-", want: "password must be at least 10 characters long"},
+        {name: "length counts characters", password: "Äbcdefgh1-"},
+        {name: "longer than bcrypt reads", password: "Aa1-" + strings.Repeat("x", 69), want: "password must be at most 72 bytes long"},
+        {name: "missing classes", password: "correcthorse", want: "password must contain an uppercase letter, contain a digit and contain a symbol"},
+        {name: "contains username", password: "Alice-horse-1", identifiers: []string{"alice"}, want: "password must not contain your username or email address"},
+        {name: "short identifiers ignored", password: "Correct-horse-1", identifiers: []string{"co"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := policy.Check(tt.password, tt.identifiers...)
+            if tt.want == "" {
+                if err != nil {
+                    t.Fatalf("Check: %v", err)
+                }
+                return
+            }
+            var policyErr *PasswordPolicyError
+            if !errors.As(err, &policyErr) || err.Error() != tt.want {
+                t.Fatalf("Check = %v, want %q", err, tt.want)
+            }
+        })
+    }
+}
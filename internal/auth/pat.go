@@ -0,0 +1,208 @@
+package auth
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+    "user-team-asset-management/internal/audit"
+    "user-team-asset-management/internal/models"
+    "user-team-asset-management/internal/utils"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// PATPrefix starts every personal access token, which tells them apart from
+// JWTs and makes leaked tokens easy to search for.
+const PATPrefix = "pat_"
+
+// patTouchInterval is how often the last use of a token is written down.
+const patTouchInterval = time.Minute
+
+// Scopes limit what a personal access token can do through the REST API,
+// on top of the permissions of its user.
+const (
+    ScopeTeamsRead   = "teams:read"
+    ScopeTeamsWrite  = "teams:write"
+    ScopeAssetsRead  = "assets:read"
+    ScopeAssetsWrite = "assets:write"
+    ScopeUsersRead   = "users:read"
+    ScopeUsersWrite  = "users:write"
+    ScopeAuditRead   = "audit:read"
+)
+
+// Scopes describes every scope, for clients choosing what to grant.
+var Scopes = map[string]string{
+    ScopeTeamsRead:   "Read teams and their members",
+    ScopeTeamsWrite:  "Create teams and change their members and managers",
+    ScopeAssetsRead:  "Read folders, notes, their history and permissions, the trash and search",
+    ScopeAssetsWrite: "Create, change, share, move, delete and restore folders and notes",
+    ScopeUsersRead:   "Read the profile, users' assets and role assignments",
+    ScopeUsersWrite:  "Change the profile, import, deactivate, unlock users and assign roles",
+    ScopeAuditRead:   "Read the audit log",
+}
+
+var (
+    ErrInvalidPAT       = errors.New("invalid, expired or revoked personal access token")
+    ErrPATNameRequired  = errors.New("token name must not be empty")
+    ErrPATScopeRequired = errors.New("at least one scope is required")
+    ErrUnknownScope     = errors.New("unknown scope")
+    ErrPATExpiry        = errors.New("token expiry is in the past or beyond the allowed lifetime")
+    ErrPATNotFound      = errors.New("personal access token not found")
+)
+
+// PATManager creates and checks personal access tokens. Tokens work until
+// they expire or are revoked, and stop working while their user is
+// deactivated.
+type PATManager struct {
+    DB *gorm.DB
+
+    // MaxTTL caps how far ahead tokens can expire. Zero allows tokens that
+    // never expire.
+    MaxTTL time.Duration
+}
+
+// Create issues a token for userID on behalf of createdBy and returns it
+// with the raw token, which is not stored and cannot be shown again.
+func (m *PATManager) Create(userID, createdBy, name string, scopes []string, expiresAt *time.Time) (*models.PersonalAccessToken, string, error) {
+    name = strings.TrimSpace(name)
+    if name == "" {
+        return nil, "", ErrPATNameRequired
+    }
+    scopes, err := normalizeScopes(scopes)
+    if err != nil {
+        return nil, "", err
+    }
+    if expiresAt != nil && !expiresAt.After(time.Now()) {
+        return nil, "", ErrPATExpiry
+    }
+    if m.MaxTTL > 0 {
+        limit := time.Now().Add(m.MaxTTL)
+        if expiresAt == nil {
+            expiresAt = &limit
+        } else if expiresAt.After(limit) {
+            return nil, "", ErrPATExpiry
+        }
+    }
+
+    rawToken := PATPrefix + utils.GenerateSecret()
+    token := &models.PersonalAccessToken{
+        ID:        utils.GenerateID(),
+        UserID:    userID,
+        Name:      name,
+        Prefix:    rawToken[:len(PATPrefix)+8],
+        TokenHash: HashToken(rawToken),
+        Scopes:    strings.Join(scopes, " "),
+        CreatedBy: createdBy,
+        ExpiresAt: expiresAt,
+    }
+    err = m.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(token).Error; err != nil {
+            return err
+        }
+        return audit.Record(tx, models.AuditEntry{
+            Action:       audit.ActionTokenCreated,
+            ActorID:      createdBy,
+            TargetUserID: userID,
+            Detail:       fmt.Sprintf("%s (%s) with %s", token.Name, token.Prefix, token.Scopes),
+        })
+    })
+    if err != nil {
+        return nil, "", err
+    }
+    return token, rawToken, nil
+}
+
+// normalizeScopes checks every scope and drops duplicates.
+func normalizeScopes(scopes []string) ([]string, error) {
+    seen := make(map[string]bool, len(scopes))
+    normalized := make([]string, 0, len(scopes))
+    for _, scope := range scopes {
+        scope = strings.ToLower(strings.TrimSpace(scope))
+        if _, ok := Scopes[scope]; !ok {
+            return nil, ErrUnknownScope
+        }
+        if !seen[scope] {
+            seen[scope] = true
+            normalized = append(normalized, scope)
+        }
+    }
+    if len(normalized) == 0 {
+        return nil, ErrPATScopeRequired
+    }
+    return normalized, nil
+}
+
+// List returns the user's tokens that have not been revoked, newest first.
+// Expired tokens are included so that users see why a script stopped.
+func (m *PATManager) List(userID string) ([]models.PersonalAccessToken, error) {
+    var tokens []models.PersonalAccessToken
+    err := m.DB.Where("user_id = ? AND revoked_at IS NULL", userID).
+        Order("created_at DESC").
+        Find(&tokens).Error
+    return tokens, err
+}
+
+// Revoke makes one of the user's tokens stop working. actorID is whoever
+// revokes it, the user or an admin managing a service account.
+func (m *PATManager) Revoke(userID, tokenID, actorID string) error {
+    return m.DB.Transaction(func(tx *gorm.DB) error {
+        var tokens []models.PersonalAccessToken
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).
+            Limit(1).Find(&tokens).Error; err != nil {
+            return err
+        }
+        if len(tokens) == 0 {
+            return ErrPATNotFound
+        }
+        token := tokens[0]
+        if err := tx.Model(&token).Update("revoked_at", time.Now()).Error; err != nil {
+            return err
+        }
+        return audit.Record(tx, models.AuditEntry{
+            Action:       audit.ActionTokenRevoked,
+            ActorID:      actorID,
+            TargetUserID: userID,
+            Detail:       fmt.Sprintf("%s (%s)", token.Name, token.Prefix),
+        })
+    })
+}
+
+// Validate returns the token and its user, and records that the token was
+// used from ip. To save writes, the last use is updated at most once a
+// minute.
+func (m *PATManager) Validate(rawToken, ip string) (*models.PersonalAccessToken, *models.User, error) {
+    var tokens []models.PersonalAccessToken
+    if err := m.DB.Where("token_hash = ?", HashToken(rawToken)).Limit(1).Find(&tokens).Error; err != nil {
+        return nil, nil, err
+    }
+    now := time.Now()
+    if len(tokens) == 0 || tokens[0].RevokedAt != nil ||
+        (tokens[0].ExpiresAt != nil && now.After(*tokens[0].ExpiresAt)) {
+        return nil, nil, ErrInvalidPAT
+    }
+    token := &tokens[0]
+
+    var users []models.User
+    if err := m.DB.Select("id", "role", "active").Where("id = ?", token.UserID).Limit(1).Find(&users).Error; err != nil {
+        return nil, nil, err
+    }
+    if len(users) == 0 {
+        return nil, nil, ErrInvalidPAT
+    }
+    if !users[0].Active {
+        return nil, nil, ErrAccountDeactivated
+    }
+
+    if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= patTouchInterval || token.LastUsedIP != ip {
+        if err := m.DB.Model(token).Updates(map[string]interface{}{
+            "last_used_at": now,
+            "last_used_ip": ip,
+        }).Error; err != nil {
+            return nil, nil, err
+        }
+    }
+    return token, &users[0], nil
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+    "errors"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+    "user-team-asset-management/internal/dbtest"
+    "user-team-asset-management/internal/models"
+)
+
+func TestNormalizeScopes(t *testing.T) {
+    tests := []struct {
+        name    string
+        scopes  []string
+        want    []string
+        wantErr error
+    }{
+        {name: "known scopes", scopes: []string{"teams:read", "assets:write"}, want: []string{"teams:read", "assets:write"}},
+        {name: "case and spaces", scopes: []string{" Teams:Read "}, want: []string{"teams:read"}},
+        {name: "duplicates dropped", scopes: []string{"audit:read", "AUDIT:READ"}, want: []string{"audit:read"}},
+        {name: "unknown scope", scopes: []string{"teams:read", "admin"}, wantErr: ErrUnknownScope},
+        {name: "no scopes", wantErr: ErrPATScopeRequired},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := normalizeScopes(tt.scopes)
+            if !errors.Is(err, tt.wantErr) {
+                t.Fatalf("error = %v, want %v", err, tt.wantErr)
+            }
+            if err == nil && !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("got %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestPATValidate(t *testing.T) {
+    db := dbtest.Open(t)
+    dbtest.Seed(t, db, &models.User{ID: "bot", Username: "bot", Email: "bot@example.com", PasswordHash: "x", Role: "member", Active: true, ServiceAccount: true})
+    m := &PATManager{DB: db, MaxTTL: 24 * time.Hour}
+
+    tests := []struct {
+        name  string
+        setup func(t *testing.T, token *models.PersonalAccessToken)
+        want  error
+    }{
+        {name: "valid"},
+        {
+            name: "revoked",
+            setup: func(t *testing.T, token *models.PersonalAccessToken) {
+                if err := m.Revoke("bot", token.ID, "admin"); err != nil {
+                    t.Fatal(err)
+                }
+            },
+            want: ErrInvalidPAT,
+        },
+        {
+            name: "expired",
+            setup: func(t *testing.T, token *models.PersonalAccessToken) {
+                if err := db.Model(token).Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+                    t.Fatal(err)
+                }
+            },
+            want: ErrInvalidPAT,
+        },
+        {
+            name: "deactivated user",
+            setup: func(t *testing.T, token *models.PersonalAccessToken) {
+                if err := db.Model(&models.User{}).Where("id = ?", "bot").Update("active", false).Error; err != nil {
+                    t.Fatal(err)
+                }
+                t.Cleanup(func() { db.Model(&models.User{}).Where("id = ?", "bot").Update("active", true) })
+            },
+            want: ErrAccountDeactivated,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            token, raw, err := m.Create("bot", "admin", tt.name, []string{"teams:read", "assets:read"}, nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !strings.HasPrefix(raw, PATPrefix) || token.ExpiresAt == nil {
+                t.Fatalf("token %q expires at %v, want the pat_ prefix and MaxTTL applied", raw, token.ExpiresAt)
+            }
+            if tt.setup != nil {
+                tt.setup(t, token)
+            }
+
+            got, user, err := m.Validate(raw, "10.0.0.1")
+            if !errors.Is(err, tt.want) {
+                t.Fatalf("Validate error = %v, want %v", err, tt.want)
+            }
+            if err != nil {
+                return
+            }
+            if user.ID != "bot" || !reflect.DeepEqual(got.ScopeList(), []string{"teams:read", "assets:read"}) {
+                t.Errorf("got user %s with scopes %v", user.ID, got.ScopeList())
+            }
+        })
+    }
+
+    if _, _, err := m.Validate(PATPrefix+"unknown", ""); !errors.Is(err, ErrInvalidPAT) {
+        t.Errorf("unknown token: got %v, want %v", err, ErrInvalidPAT)
+    }
+    past := time.Now().Add(-time.Hour)
+    beyond := time.Now().Add(48 * time.Hour)
+    for _, expiresAt := range []*time.Time{&past, &beyond} {
+        if _, _, err := m.Create("bot", "admin", "bad expiry", []string{"teams:read"}, expiresAt); !errors.Is(err, ErrPATExpiry) {
+            t.Errorf("expiry %s: got %v, want %v", expiresAt, err, ErrPATExpiry)
+        }
+    }
+}
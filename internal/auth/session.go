@@ -0,0 +1,202 @@
+package auth
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "time"
+    "user-team-asset-management/internal/models"
+    "user-team-asset-management/internal/utils"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+var (
+    ErrInvalidRefreshToken = errors.New("invalid refresh token")
+    ErrRefreshTokenReused  = errors.New("refresh token reuse detected, session revoked")
+    ErrSessionRevoked      = errors.New("session has been revoked")
+)
+
+type TokenPair struct {
+    AccessToken  string
+    RefreshToken string
+    ExpiresAt    time.Time
+}
+
+// SessionManager issues short-lived access tokens paired with rotating
+// refresh tokens persisted in the database.
+type SessionManager struct {
+    DB              *gorm.DB
+    Keys            *Keyring
+    AccessTokenTTL  time.Duration
+    RefreshTokenTTL time.Duration
+}
+
+// HashToken returns the digest under which opaque tokens are stored.
+func HashToken(raw string) string {
+    sum := sha256.Sum256([]byte(raw))
+    return hex.EncodeToString(sum[:])
+}
+
+// Issue starts a new session for the user and returns its first token pair.
+func (m *SessionManager) Issue(userID, role string) (*TokenPair, error) {
+    var pair *TokenPair
+    err := m.DB.Transaction(func(tx *gorm.DB) error {
+        session := models.Session{
+            ID:         utils.GenerateID(),
+            UserID:     userID,
+            LastUsedAt: time.Now(),
+        }
+        if err := tx.Create(&session).Error; err != nil {
+            return err
+        }
+
+        var err error
+        pair, err = m.issuePair(tx, session.ID, userID, role)
+        return err
+    })
+    return pair, err
+}
+
+// Refresh rotates a refresh token. Presenting a token that has already been
+// rotated revokes the whole session, since it means the token leaked.
+func (m *SessionManager) Refresh(rawToken string) (*TokenPair, *models.User, error) {
+    var pair *TokenPair
+    var user models.User
+    var reused bool
+
+    err := m.DB.Transaction(func(tx *gorm.DB) error {
+        var token models.RefreshToken
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("token_hash = ?", HashToken(rawToken)).
+            First(&token).Error; err != nil {
+            return ErrInvalidRefreshToken
+        }
+
+        var session models.Session
+        if err := tx.Where("id = ?", token.SessionID).First(&session).Error; err != nil {
+            return ErrInvalidRefreshToken
+        }
+        if session.RevokedAt != nil {
+            return ErrSessionRevoked
+        }
+
+        now := time.Now()
+        if token.RotatedAt != nil {
+            reused = true
+            return tx.Model(&models.Session{}).Where("id = ?", session.ID).Update("revoked_at", now).Error
+        }
+        if now.After(token.ExpiresAt) {
+            return ErrInvalidRefreshToken
+        }
+
+        if err := tx.Where("id = ?", session.UserID).First(&user).Error; err != nil {
+            return ErrInvalidRefreshToken
+        }
+        if !user.Active {
+            return ErrAccountDeactivated
+        }
+
+        if err := tx.Model(&token).Update("rotated_at", now).Error; err != nil {
+            return err
+        }
+        if err := tx.Model(&session).Update("last_used_at", now).Error; err != nil {
+            return err
+        }
+
+        var err error
+        pair, err = m.issuePair(tx, session.ID, user.ID, user.Role)
+        return err
+    })
+    if err != nil {
+        return nil, nil, err
+    }
+    if reused {
+        return nil, nil, ErrRefreshTokenReused
+    }
+
+    return pair, &user, nil
+}
+
+// Revoke ends a session so that its refresh and access tokens stop working.
+func (m *SessionManager) Revoke(sessionID string) error {
+    return m.DB.Model(&models.Session{}).
+        Where("id = ? AND revoked_at IS NULL", sessionID).
+        Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAll ends every session of the user, signing them out everywhere.
+func (m *SessionManager) RevokeAll(userID string) error {
+    return revokeUserSessions(m.DB, userID)
+}
+
+func revokeUserSessions(tx *gorm.DB, userID string) error {
+    return tx.Model(&models.Session{}).
+        Where("user_id = ? AND revoked_at IS NULL", userID).
+        Update("revoked_at", time.Now()).Error
+}
+
+// RevokeByRefreshToken ends the session the given refresh token belongs to.
+// Tokens of another user's sessions are rejected as invalid.
+func (m *SessionManager) RevokeByRefreshToken(userID, rawToken string) error {
+    var token models.RefreshToken
+    err := m.DB.Joins("JOIN sessions ON sessions.id = refresh_tokens.session_id").
+        Where("refresh_tokens.token_hash = ? AND sessions.user_id = ?", HashToken(rawToken), userID).
+        First(&token).Error
+    if err != nil {
+        return ErrInvalidRefreshToken
+    }
+    return m.Revoke(token.SessionID)
+}
+
+// Validate checks the access token signature and that its session is still
+// live and its user active.
+func (m *SessionManager) Validate(tokenString string) (*Claims, error) {
+    claims, err := ValidateToken(tokenString, m.Keys)
+    if err != nil {
+        return nil, err
+    }
+
+    var session models.Session
+    if err := m.DB.Where("id = ?", claims.SessionID).First(&session).Error; err != nil {
+        return nil, ErrSessionRevoked
+    }
+    if session.RevokedAt != nil {
+        return nil, ErrSessionRevoked
+    }
+
+    var user models.User
+    if err := m.DB.Select("active").Where("id = ?", claims.UserID).First(&user).Error; err != nil {
+        return nil, ErrSessionRevoked
+    }
+    if !user.Active {
+        return nil, ErrAccountDeactivated
+    }
+
+    return claims, nil
+}
+
+func (m *SessionManager) issuePair(tx *gorm.DB, sessionID, userID, role string) (*TokenPair, error) {
+    rawRefresh := utils.GenerateSecret()
+    refresh := models.RefreshToken{
+        ID:        utils.GenerateID(),
+        SessionID: sessionID,
+        TokenHash: HashToken(rawRefresh),
+        ExpiresAt: time.Now().Add(m.RefreshTokenTTL),
+    }
+    if err := tx.Create(&refresh).Error; err != nil {
+        return nil, err
+    }
+
+    accessToken, err := GenerateToken(userID, role, sessionID, m.Keys, m.AccessTokenTTL)
+    if err != nil {
+        return nil, err
+    }
+
+    return &TokenPair{
+        AccessToken:  accessToken,
+        RefreshToken: rawRefresh,
+        ExpiresAt:    time.Now().Add(m.AccessTokenTTL),
+    }, nil
+}
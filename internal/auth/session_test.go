@@ -0,0 +1,146 @@
+package auth
+
+import (
+    "errors"
+    "testing"
+    "time"
+    "user-team-asset-management/internal/dbtest"
+    "user-team-asset-management/internal/models"
+)
+
+func newTestSessions(t *testing.T) *SessionManager {
+    t.Helper()
+    db := dbtest.Open(t)
+    keys, err := GenerateKeyring(AlgEdDSA)
+    if err != nil {
+        t.Fatal(err)
+    }
+    dbtest.Seed(t, db,
+        &models.User{ID: "alice", Username: "alice", Email: "alice@example.com", PasswordHash: "x", Role: "member", Active: true},
+        &models.User{ID: "bob", Username: "bob", Email: "bob@example.com", PasswordHash: "x", Role: "member", Active: true},
+    )
+    return &SessionManager{DB: db, Keys: keys, AccessTokenTTL: time.Minute, RefreshTokenTTL: time.Hour}
+}
+
+func TestRefreshReuseDetection(t *testing.T) {
+    m := newTestSessions(t)
+
+    first, err := m.Issue("alice", "member")
+    if err != nil {
+        t.Fatal(err)
+    }
+    second, _, err := m.Refresh(first.RefreshToken)
+    if err != nil {
+        t.Fatalf("first refresh: %v", err)
+    }
+    if _, err := m.Validate(second.AccessToken); err != nil {
+        t.Fatalf("rotated access token: %v", err)
+    }
+
+    // Presenting the rotated token again revokes the whole session
+    steps := []struct {
+        name  string
+        token string
+        want  error
+    }{
+        {name: "rotated token reused", token: first.RefreshToken, want: ErrRefreshTokenReused},
+        {name: "current token after reuse", token: second.RefreshToken, want: ErrSessionRevoked},
+        {name: "rotated token once more", token: first.RefreshToken, want: ErrSessionRevoked},
+    }
+    for _, step := range steps {
+        if _, _, err := m.Refresh(step.token); !errors.Is(err, step.want) {
+            t.Fatalf("%s: got %v, want %v", step.name, err, step.want)
+        }
+    }
+    if _, err := m.Validate(second.AccessToken); !errors.Is(err, ErrSessionRevoked) {
+        t.Errorf("access token of the revoked session: got %v, want %v", err, ErrSessionRevoked)
+    }
+
+    // Other sessions of the user are left alone
+    other, err := m.Issue("alice", "member")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, _, err := m.Refresh(other.RefreshToken); err != nil {
+        t.Errorf("refreshing another session: %v", err)
+    }
+}
+
+func TestRefreshRejects(t *testing.T) {
+    m := newTestSessions(t)
+
+    tests := []struct {
+        name  string
+        setup func(t *testing.T, pair *TokenPair)
+        token func(pair *TokenPair) string
+        want  error
+    }{
+        {
+            name:  "unknown token",
+            token: func(*TokenPair) string { return "not-a-token" },
+            want:  ErrInvalidRefreshToken,
+        },
+        {
+            name: "expired token",
+            setup: func(t *testing.T, pair *TokenPair) {
+                if err := m.DB.Model(&models.RefreshToken{}).Where("token_hash = ?", HashToken(pair.RefreshToken)).
+                    Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+                    t.Fatal(err)
+                }
+            },
+            want: ErrInvalidRefreshToken,
+        },
+        {
+            name: "logged out",
+            setup: func(t *testing.T, pair *TokenPair) {
+                if err := m.RevokeByRefreshToken("alice", pair.RefreshToken); err != nil {
+                    t.Fatal(err)
+                }
+            },
+            want: ErrSessionRevoked,
+        },
+        {
+            name: "deactivated user",
+            setup: func(t *testing.T, pair *TokenPair) {
+                if err := m.DB.Model(&models.User{}).Where("id = ?", "alice").Update("active", false).Error; err != nil {
+                    t.Fatal(err)
+                }
+                t.Cleanup(func() { m.DB.Model(&models.User{}).Where("id = ?", "alice").Update("active", true) })
+            },
+            want: ErrAccountDeactivated,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pair, err := m.Issue("alice", "member")
+            if err != nil {
+                t.Fatal(err)
+            }
+            if tt.setup != nil {
+                tt.setup(t, pair)
+            }
+            token := pair.RefreshToken
+            if tt.token != nil {
+                token = tt.token(pair)
+            }
+            if _, _, err := m.Refresh(token); !errors.Is(err, tt.want) {
+                t.Errorf("got %v, want %v", err, tt.want)
+            }
+        })
+    }
+}
+
+func TestRevokeByRefreshTokenChecksOwner(t *testing.T) {
+    m := newTestSessions(t)
+
+    pair, err := m.Issue("alice", "member")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := m.RevokeByRefreshToken("bob", pair.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+        t.Fatalf("revoking another user's session: got %v, want %v", err, ErrInvalidRefreshToken)
+    }
+    if _, err := m.Validate(pair.AccessToken); err != nil {
+        t.Errorf("session was revoked by another user: %v", err)
+    }
+}
@@ -0,0 +1,422 @@
+package auth
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha1"
+    "crypto/subtle"
+    "encoding/base32"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "net/url"
+    "strings"
+    "time"
+    "user-team-asset-management/internal/audit"
+    "user-team-asset-management/internal/models"
+    "user-team-asset-management/internal/utils"
+
+    "golang.org/x/crypto/bcrypt"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+const (
+    totpPeriod = 30
+    totpDigits = 6
+    totpModulus = 1000000 // 10^totpDigits
+    // totpSkew is how many time steps a code may be off, to allow for
+    // clocks that drift and codes typed in just as they change.
+    totpSkew = 1
+
+    recoveryCodeCount = 10
+
+    // maxChallengeAttempts is how many wrong codes a login challenge takes
+    // before the password has to be entered again.
+    maxChallengeAttempts = 5
+)
+
+var (
+    ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+    ErrInvalidLoginChallenge   = errors.New("invalid or expired login challenge")
+    ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+    ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+    ErrTwoFactorNotStarted     = errors.New("two-factor enrollment has not been started")
+    ErrTwoFactorRequired       = errors.New("two-factor authentication is required for your role")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactorManager handles TOTP (RFC 6238) enrollment and the second step
+// of logging in. Enrolling users scan a provisioning URI into their
+// authenticator app and confirm it with a first code, which enables
+// two-factor authentication and hands out single-use recovery codes for
+// when the app is lost. From then on, a correct password only earns a
+// short-lived login challenge, which has to be completed with a code.
+type TwoFactorManager struct {
+    DB *gorm.DB
+
+    // Issuer names the application in authenticator apps.
+    Issuer       string
+    ChallengeTTL time.Duration
+
+    // RequiredRoles lists the roles whose users may not turn two-factor
+    // authentication off. The authorizer withholds their permissions
+    // until it is on.
+    RequiredRoles []string
+
+    // Logins counts wrong passwords given to Begin as failed logins. It may
+    // be nil.
+    Logins *LoginGuard
+}
+
+// Enrollment is a started enrollment: the secret to enter into an
+// authenticator app, and the same as an otpauth:// URI to show as QR code.
+type Enrollment struct {
+    Secret          string
+    ProvisioningURI string
+}
+
+// Required reports whether users with role must use two-factor
+// authentication.
+func (m *TwoFactorManager) Required(role string) bool {
+    for _, required := range m.RequiredRoles {
+        if required == role {
+            return true
+        }
+    }
+    return false
+}
+
+// Begin starts enrolling the user with a new secret, replacing that of any
+// enrollment that was never confirmed. The current password is required, so
+// that a stolen access token cannot be used to bind the account to someone
+// else's authenticator app.
+func (m *TwoFactorManager) Begin(userID, password, ip string) (*Enrollment, error) {
+    var user models.User
+    if err := m.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+        return nil, errors.New("user not found")
+    }
+    if user.TwoFactorEnabled() {
+        return nil, ErrTwoFactorAlreadyEnabled
+    }
+    if err := m.checkPassword(user, password, ip); err != nil {
+        return nil, err
+    }
+
+    secret := make([]byte, 20)
+    if _, err := rand.Read(secret); err != nil {
+        return nil, err
+    }
+    encoded := totpEncoding.EncodeToString(secret)
+    if err := m.DB.Model(&user).Updates(map[string]interface{}{
+        "totp_secret":    encoded,
+        "totp_last_step": 0,
+    }).Error; err != nil {
+        return nil, err
+    }
+
+    return &Enrollment{Secret: encoded, ProvisioningURI: m.provisioningURI(user.Email, encoded)}, nil
+}
+
+// checkPassword compares password with the user's, throttled like a login
+// from ip when Logins is set.
+func (m *TwoFactorManager) checkPassword(user models.User, password, ip string) error {
+    var attempt *LoginAttempt
+    if m.Logins != nil {
+        var err error
+        if attempt, err = m.Logins.Begin(user.Email, user.ID, ip); err != nil {
+            return err
+        }
+    }
+    if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+        if attempt != nil {
+            if err := attempt.Fail(); err != nil {
+                return err
+            }
+        }
+        return ErrWrongPassword
+    }
+    if attempt != nil {
+        return attempt.Pass()
+    }
+    return nil
+}
+
+func (m *TwoFactorManager) provisioningURI(account, secret string) string {
+    query := url.Values{}
+    query.Set("secret", secret)
+    query.Set("issuer", m.Issuer)
+    query.Set("algorithm", "SHA1")
+    query.Set("digits", fmt.Sprint(totpDigits))
+    query.Set("period", fmt.Sprint(totpPeriod))
+    label := url.PathEscape(m.Issuer) + ":" + url.PathEscape(account)
+    return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Confirm enables two-factor authentication once the user proves their app
+// works with a code for the secret from Begin, and returns the recovery
+// codes. They are only stored hashed, so this is the one chance to see them.
+func (m *TwoFactorManager) Confirm(userID, code string) ([]string, error) {
+    var codes []string
+    err := m.DB.Transaction(func(tx *gorm.DB) error {
+        user, err := lockUser(tx, userID)
+        if err != nil {
+            return err
+        }
+        if user.TwoFactorEnabled() {
+            return ErrTwoFactorAlreadyEnabled
+        }
+        if user.TOTPSecret == "" {
+            return ErrTwoFactorNotStarted
+        }
+
+        step, ok := matchTOTP(user.TOTPSecret, code, 0, time.Now())
+        if !ok {
+            return ErrInvalidTwoFactorCode
+        }
+
+        var hashes string
+        codes, hashes = newRecoveryCodes()
+        if err := tx.Model(user).Updates(map[string]interface{}{
+            "totp_enabled_at": time.Now(),
+            "totp_last_step":  step,
+            "recovery_codes":  hashes,
+        }).Error; err != nil {
+            return err
+        }
+        return audit.Record(tx, models.AuditEntry{
+            Action:       audit.ActionTwoFactorEnabled,
+            ActorID:      user.ID,
+            TargetUserID: user.ID,
+        })
+    })
+    return codes, err
+}
+
+// Disable turns two-factor authentication off after checking the user's
+// password and a code, which may be a recovery code.
+func (m *TwoFactorManager) Disable(userID, password, code string) error {
+    return m.DB.Transaction(func(tx *gorm.DB) error {
+        user, err := lockUser(tx, userID)
+        if err != nil {
+            return err
+        }
+        if !user.TwoFactorEnabled() {
+            return ErrTwoFactorNotEnabled
+        }
+        if m.Required(user.Role) {
+            return ErrTwoFactorRequired
+        }
+        if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+            return ErrWrongPassword
+        }
+        if err := verifyCode(tx, user, code); err != nil {
+            return err
+        }
+
+        if err := tx.Model(user).Updates(map[string]interface{}{
+            "totp_secret":     "",
+            "totp_enabled_at": nil,
+            "totp_last_step":  0,
+            "recovery_codes":  "",
+        }).Error; err != nil {
+            return err
+        }
+        return audit.Record(tx, models.AuditEntry{
+            Action:       audit.ActionTwoFactorDisabled,
+            ActorID:      user.ID,
+            TargetUserID: user.ID,
+        })
+    })
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking
+// a code, and returns the new ones.
+func (m *TwoFactorManager) RegenerateRecoveryCodes(userID, code string) ([]string, error) {
+    var codes []string
+    err := m.DB.Transaction(func(tx *gorm.DB) error {
+        user, err := lockUser(tx, userID)
+        if err != nil {
+            return err
+        }
+        if !user.TwoFactorEnabled() {
+            return ErrTwoFactorNotEnabled
+        }
+        if err := verifyCode(tx, user, code); err != nil {
+            return err
+        }
+
+        var hashes string
+        codes, hashes = newRecoveryCodes()
+        return tx.Model(user).Update("recovery_codes", hashes).Error
+    })
+    return codes, err
+}
+
+// Challenge starts the second step of logging in for a user whose password
+// was correct, and returns the token to complete it with.
+func (m *TwoFactorManager) Challenge(userID string) (string, time.Time, error) {
+    // Expired challenges are cleaned up here rather than by a background job
+    if err := m.DB.Where("user_id = ? AND expires_at < ?", userID, time.Now()).
+        Delete(&models.LoginChallenge{}).Error; err != nil {
+        return "", time.Time{}, err
+    }
+
+    rawToken := utils.GenerateSecret()
+    expiresAt := time.Now().Add(m.ChallengeTTL)
+    err := m.DB.Create(&models.LoginChallenge{
+        ID:        utils.GenerateID(),
+        UserID:    userID,
+        TokenHash: HashToken(rawToken),
+        ExpiresAt: expiresAt,
+    }).Error
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    return rawToken, expiresAt, nil
+}
+
+// ChallengeUser returns the user a challenge was issued to, so that callers
+// can check whether they may try it before calling Verify.
+func (m *TwoFactorManager) ChallengeUser(rawToken string) (*models.User, error) {
+    var challenges []models.LoginChallenge
+    if err := m.DB.Where("token_hash = ?", HashToken(rawToken)).Limit(1).Find(&challenges).Error; err != nil {
+        return nil, err
+    }
+    if len(challenges) == 0 || time.Now().After(challenges[0].ExpiresAt) {
+        return nil, ErrInvalidLoginChallenge
+    }
+
+    var user models.User
+    if err := m.DB.Where("id = ?", challenges[0].UserID).First(&user).Error; err != nil {
+        return nil, ErrInvalidLoginChallenge
+    }
+    return &user, nil
+}
+
+// Verify completes a login challenge with a TOTP or recovery code and uses
+// the challenge up. Each wrong code counts against the challenge, which
+// stops working after a few.
+func (m *TwoFactorManager) Verify(rawToken, code string) (*models.User, error) {
+    var user *models.User
+    var wrong bool
+    err := m.DB.Transaction(func(tx *gorm.DB) error {
+        var challenge models.LoginChallenge
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("token_hash = ?", HashToken(rawToken)).
+            First(&challenge).Error; err != nil {
+            return ErrInvalidLoginChallenge
+        }
+        if time.Now().After(challenge.ExpiresAt) || challenge.Attempts >= maxChallengeAttempts {
+            return ErrInvalidLoginChallenge
+        }
+
+        var err error
+        user, err = lockUser(tx, challenge.UserID)
+        if err != nil || !user.TwoFactorEnabled() || !user.Active {
+            return ErrInvalidLoginChallenge
+        }
+
+        if err := verifyCode(tx, user, code); err != nil {
+            if !errors.Is(err, ErrInvalidTwoFactorCode) {
+                return err
+            }
+            // Committed on purpose, so that the attempt counts
+            wrong = true
+            return tx.Model(&challenge).Update("attempts", challenge.Attempts+1).Error
+        }
+        return tx.Delete(&challenge).Error
+    })
+    if err != nil {
+        return nil, err
+    }
+    if wrong {
+        return nil, ErrInvalidTwoFactorCode
+    }
+    return user, nil
+}
+
+func lockUser(tx *gorm.DB, userID string) (*models.User, error) {
+    var user models.User
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("id = ?", userID).
+        First(&user).Error; err != nil {
+        return nil, errors.New("user not found")
+    }
+    return &user, nil
+}
+
+// verifyCode accepts a TOTP code newer than the last one used, or one of the
+// unused recovery codes, and records that it has been used.
+func verifyCode(tx *gorm.DB, user *models.User, code string) error {
+    if step, ok := matchTOTP(user.TOTPSecret, code, user.TOTPLastStep, time.Now()); ok {
+        return tx.Model(user).Update("totp_last_step", step).Error
+    }
+
+    hash := HashToken(normalizeRecoveryCode(code))
+    hashes := strings.Fields(user.RecoveryCodes)
+    for i, stored := range hashes {
+        if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+            remaining := append(hashes[:i:i], hashes[i+1:]...)
+            return tx.Model(user).Update("recovery_codes", strings.Join(remaining, " ")).Error
+        }
+    }
+    return ErrInvalidTwoFactorCode
+}
+
+// matchTOTP returns the time step whose code matches, looking up to
+// totpSkew steps around now but never at or before lastStep, so that a
+// code cannot be replayed.
+func matchTOTP(secret, code string, lastStep int64, now time.Time) (int64, bool) {
+    key, err := totpEncoding.DecodeString(secret)
+    if err != nil || len(code) != totpDigits {
+        return 0, false
+    }
+
+    current := now.Unix() / totpPeriod
+    for step := current - totpSkew; step <= current+totpSkew; step++ {
+        if step <= lastStep {
+            continue
+        }
+        if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+            return step, true
+        }
+    }
+    return 0, false
+}
+
+// totpCode computes the HOTP value (RFC 4226) of the time step.
+func totpCode(key []byte, step int64) string {
+    var counter [8]byte
+    binary.BigEndian.PutUint64(counter[:], uint64(step))
+    mac := hmac.New(sha1.New, key)
+    mac.Write(counter[:])
+    sum := mac.Sum(nil)
+
+    offset := sum[len(sum)-1] & 0x0f
+    value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+    return fmt.Sprintf("%0*d", totpDigits, value%totpModulus)
+}
+
+// newRecoveryCodes returns fresh recovery codes, formatted like
+// "abcde-fghij", and their hashes as stored on the user.
+func newRecoveryCodes() ([]string, string) {
+    encoding := base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+    codes := make([]string, recoveryCodeCount)
+    hashes := make([]string, recoveryCodeCount)
+    for i := range codes {
+        raw := make([]byte, 7)
+        rand.Read(raw)
+        code := encoding.EncodeToString(raw)[:10]
+        codes[i] = code[:5] + "-" + code[5:]
+        hashes[i] = HashToken(code)
+    }
+    return codes, strings.Join(hashes, " ")
+}
+
+func normalizeRecoveryCode(code string) string {
+    code = strings.ToLower(code)
+    return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
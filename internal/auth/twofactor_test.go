@@ -0,0 +1,94 @@
+package auth
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// rfc6238Secret is the SHA1 key from the test vectors in RFC 6238,
+// appendix B.
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+    // The RFC lists eight-digit codes; ours are their last six digits
+    tests := []struct {
+        unix int64
+        want string
+    }{
+        {59, "287082"},
+        {1111111109, "081804"},
+        {1111111111, "050471"},
+        {1234567890, "005924"},
+        {2000000000, "279037"},
+        {20000000000, "353130"},
+    }
+    for _, tt := range tests {
+        step := tt.unix / totpPeriod
+        if got := totpCode([]byte("12345678901234567890"), step); got != tt.want {
+            t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+        }
+        if _, ok := matchTOTP(rfc6238Secret, tt.want, 0, time.Unix(tt.unix, 0)); !ok {
+            t.Errorf("matchTOTP rejected the code for %d", tt.unix)
+        }
+    }
+}
+
+func TestMatchTOTP(t *testing.T) {
+    now := time.Unix(1111111111, 0)
+    current := now.Unix() / totpPeriod
+    key := []byte("12345678901234567890")
+    codeAt := func(offset int64) string { return totpCode(key, current+offset) }
+
+    tests := []struct {
+        name     string
+        code     string
+        lastStep int64
+        wantStep int64
+        wantOK   bool
+    }{
+        {name: "current step", code: codeAt(0), wantStep: current, wantOK: true},
+        {name: "one step behind", code: codeAt(-1), wantStep: current - 1, wantOK: true},
+        {name: "one step ahead", code: codeAt(1), wantStep: current + 1, wantOK: true},
+        {name: "two steps behind", code: codeAt(-2)},
+        {name: "two steps ahead", code: codeAt(2)},
+        {name: "replayed step", code: codeAt(0), lastStep: current},
+        {name: "step before last used", code: codeAt(-1), lastStep: current},
+        {name: "newer step after last used", code: codeAt(1), lastStep: current, wantStep: current + 1, wantOK: true},
+        {name: "wrong code", code: "000000"},
+        {name: "too short", code: codeAt(0)[:5]},
+        {name: "empty", code: ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            step, ok := matchTOTP(rfc6238Secret, tt.code, tt.lastStep, now)
+            if ok != tt.wantOK || step != tt.wantStep {
+                t.Errorf("matchTOTP = (%d, %t), want (%d, %t)", step, ok, tt.wantStep, tt.wantOK)
+            }
+        })
+    }
+
+    if _, ok := matchTOTP("not base32!", codeAt(0), 0, now); ok {
+        t.Error("matchTOTP accepted a code for an undecodable secret")
+    }
+}
+
+func TestRecoveryCodes(t *testing.T) {
+    codes, hashes := newRecoveryCodes()
+    if len(codes) != recoveryCodeCount {
+        t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+    }
+
+    stored := strings.Fields(hashes)
+    for i, code := range codes {
+        if len(code) != 11 || code[5] != '-' {
+            t.Errorf("recovery code %q is not formatted like abcde-fghij", code)
+        }
+        // Users may type codes in upper case, with spaces or without the dash
+        for _, typed := range []string{code, strings.ToUpper(code), strings.Replace(code, "-", " ", 1), strings.Replace(code, "-", "", 1)} {
+            if HashToken(normalizeRecoveryCode(typed)) != stored[i] {
+                t.Errorf("typed recovery code %q does not match its hash", typed)
+            }
+        }
+    }
+}
@@ -3,17 +3,23 @@ package database
 import (
     "log"
     "user-team-asset-management/internal/models"
+    "user-team-asset-management/internal/search"
     
     "gorm.io/driver/postgres"
     "gorm.io/gorm"
 )
 
 func Connect(databaseURL string) *gorm.DB {
-    db, err := gorm.Open(postgres.Open(databaseURL), &gorm.Config{})
+    // TranslateError turns unique violations into gorm.ErrDuplicatedKey so
+    // handlers can answer 409 instead of 500
+    db, err := gorm.Open(postgres.Open(databaseURL), &gorm.Config{TranslateError: true})
     if err != nil {
         log.Fatal("Failed to connect to database:", err)
     }
     
+    // Users from before email verification count as verified
+    backfillVerified := !db.Migrator().HasColumn(&models.User{}, "email_verified_at")
+    
     // Auto migrate
     err = db.AutoMigrate(
         &models.User{},
@@ -24,10 +30,68 @@ func Connect(databaseURL string) *gorm.DB {
         &models.Note{},
         &models.FolderShare{},
         &models.NoteShare{},
+        &models.NoteRevision{},
+        &models.TrashEntry{},
+        &models.Session{},
+        &models.RefreshToken{},
+        &models.PersonalAccessToken{},
+        &models.PasswordResetToken{},
+        &models.EmailVerificationToken{},
+        &models.LoginThrottle{},
+        &models.LoginChallenge{},
+        &models.AuditEntry{},
+        &models.RoleAssignment{},
+        &models.PersistedQuery{},
+        &models.OutboxMessage{},
+        &models.ExternalIdentity{},
+        &models.OIDCAuthRequest{},
+        &models.OIDCResult{},
     )
     if err != nil {
         log.Fatal("Failed to migrate database:", err)
     }
     
+    // Roles are validated by the policy package now, so the old CHECK
+    // constraint would reject roles such as admin or viewer.
+    if db.Migrator().HasConstraint(&models.User{}, "chk_users_role") {
+        if err := db.Migrator().DropConstraint(&models.User{}, "chk_users_role"); err != nil {
+            log.Fatal("Failed to drop users role constraint:", err)
+        }
+    }
+    
+    if backfillVerified {
+        if err := db.Exec("UPDATE users SET email_verified_at = created_at").Error; err != nil {
+            log.Fatal("Failed to mark existing users as verified:", err)
+        }
+    }
+    
+    if err := foldEmails(db); err != nil {
+        log.Fatal("Failed to lower-case email addresses:", err)
+    }
+    
+    if err := search.Migrate(db); err != nil {
+        log.Fatal("Failed to create search indexes:", err)
+    }
+    
     return db
-}
\ No newline at end of file
+}
+// foldEmails lower-cases the addresses stored before they were normalized.
+// Addresses that only differ in case from another account's are left for an
+// admin to sort out, since folding them would break the unique index.
+func foldEmails(db *gorm.DB) error {
+    err := db.Exec(`UPDATE users SET email = LOWER(email)
+        WHERE email <> LOWER(email)
+        AND NOT EXISTS (SELECT 1 FROM users other WHERE LOWER(other.email) = LOWER(users.email) AND other.id <> users.id)`).Error
+    if err != nil {
+        return err
+    }
+    
+    var clashes []string
+    if err := db.Model(&models.User{}).Where("email <> LOWER(email)").Pluck("email", &clashes).Error; err != nil {
+        return err
+    }
+    for _, email := range clashes {
+        log.Printf("Email address %s is used by several accounts in different case; merge or change them", email)
+    }
+    return nil
+}
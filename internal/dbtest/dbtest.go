@@ -0,0 +1,68 @@
+// Package dbtest gives tests their own Postgres schema to run against.
+package dbtest
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"user-team-asset-management/internal/database"
+	"user-team-asset-management/internal/utils"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Open connects to TEST_DATABASE_URL, creates a fresh schema, migrates it
+// and drops it again when the test ends. Tests are skipped when
+// TEST_DATABASE_URL is not set.
+func Open(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + utils.GenerateID()[:12]
+	if err := admin.Exec(`CREATE SCHEMA "` + schema + `"`).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(`DROP SCHEMA "` + schema + `" CASCADE`)
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("TEST_DATABASE_URL must be a URL: %v", err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	db := database.Connect(u.String())
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db.Session(&gorm.Session{Logger: logger.Discard})
+}
+
+// Seed inserts rows in order and fails the test on the first error.
+func Seed(t *testing.T, db *gorm.DB, rows ...interface{}) {
+	t.Helper()
+	for _, row := range rows {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(fmt.Errorf("seeding %T: %w", row, err))
+		}
+	}
+}
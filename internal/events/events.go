@@ -0,0 +1,186 @@
+// Package events delivers notifications about changes to notes, folders and
+// team memberships to subscribers in the same process.
+package events
+
+import (
+	"sync"
+	"time"
+	"user-team-asset-management/internal/models"
+)
+
+// Actions carried by events. Note and folder subscribers see the plain
+// actions for the item itself; folder subscribers additionally see the
+// NOTE_ and SUBFOLDER_ actions for items directly inside the folder.
+const (
+	ActionCreated  = "CREATED"
+	ActionUpdated  = "UPDATED"
+	ActionMoved    = "MOVED"
+	ActionDeleted  = "DELETED"
+	ActionRestored = "RESTORED"
+
+	ActionNoteCreated  = "NOTE_CREATED"
+	ActionNoteUpdated  = "NOTE_UPDATED"
+	ActionNoteDeleted  = "NOTE_DELETED"
+	ActionNoteRestored = "NOTE_RESTORED"
+
+	ActionSubfolderAdded   = "SUBFOLDER_ADDED"
+	ActionSubfolderRemoved = "SUBFOLDER_REMOVED"
+
+	ActionMemberAdded    = "MEMBER_ADDED"
+	ActionMemberRemoved  = "MEMBER_REMOVED"
+	ActionManagerAdded   = "MANAGER_ADDED"
+	ActionManagerRemoved = "MANAGER_REMOVED"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// further events are dropped for it.
+const subscriberBuffer = 16
+
+type Event struct {
+	Action      string    `json:"action"`
+	NoteID      string    `json:"noteId,omitempty"`
+	FolderID    string    `json:"folderId,omitempty"`
+	SubfolderID string    `json:"subfolderId,omitempty"`
+	TeamID      string    `json:"teamId,omitempty"`
+	UserID      string    `json:"userId,omitempty"`
+	ActorID     string    `json:"actorId"`
+	OccurredAt  time.Time `json:"occurredAt"`
+}
+
+func NoteTopic(noteID string) string     { return "note:" + noteID }
+func FolderTopic(folderID string) string { return "folder:" + folderID }
+func TeamTopic(teamID string) string     { return "team:" + teamID }
+
+// Bus fans events out to the subscribers of a topic. Publishing never
+// blocks: a subscriber that is too slow to keep up misses events instead of
+// holding up the request that caused them. A nil *Bus discards everything,
+// so callers need not check whether notifications are enabled.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[chan Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[string]map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving the topic's events and a function
+// that ends the subscription and closes the channel.
+func (b *Bus) Subscribe(topic string) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = make(map[chan Event]struct{})
+	}
+	b.subscribers[topic][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			delete(b.subscribers[topic], ch)
+			if len(b.subscribers[topic]) == 0 {
+				delete(b.subscribers, topic)
+			}
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+func (b *Bus) Publish(topic string, event Event) {
+	if b == nil {
+		return
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers[topic] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// NoteChanged publishes action (CREATED, UPDATED, DELETED or RESTORED) to
+// the note's subscribers and the matching NOTE_ action to its folder's.
+func (b *Bus) NoteChanged(action, noteID, folderID, actorID string) {
+	event := Event{Action: action, NoteID: noteID, FolderID: folderID, ActorID: actorID}
+	b.Publish(NoteTopic(noteID), event)
+
+	event.Action = "NOTE_" + action
+	b.Publish(FolderTopic(folderID), event)
+}
+
+// FolderChanged publishes action to the folder's subscribers. Creating,
+// deleting and restoring a folder also notify its parent, if any.
+func (b *Bus) FolderChanged(action, folderID string, parentID *string, actorID string) {
+	b.Publish(FolderTopic(folderID), Event{Action: action, FolderID: folderID, ActorID: actorID})
+
+	if parentID == nil {
+		return
+	}
+	switch action {
+	case ActionCreated, ActionRestored:
+		b.subfolderChanged(ActionSubfolderAdded, *parentID, folderID, actorID)
+	case ActionDeleted:
+		b.subfolderChanged(ActionSubfolderRemoved, *parentID, folderID, actorID)
+	}
+}
+
+// FolderMoved publishes MOVED to the folder's subscribers and notifies the
+// old and new parents.
+func (b *Bus) FolderMoved(folderID string, oldParentID, newParentID *string, actorID string) {
+	b.Publish(FolderTopic(folderID), Event{Action: ActionMoved, FolderID: folderID, ActorID: actorID})
+
+	if oldParentID != nil {
+		b.subfolderChanged(ActionSubfolderRemoved, *oldParentID, folderID, actorID)
+	}
+	if newParentID != nil {
+		b.subfolderChanged(ActionSubfolderAdded, *newParentID, folderID, actorID)
+	}
+}
+
+func (b *Bus) subfolderChanged(action, parentID, folderID, actorID string) {
+	b.Publish(FolderTopic(parentID), Event{Action: action, FolderID: parentID, SubfolderID: folderID, ActorID: actorID})
+}
+
+// TrashChanged publishes action (DELETED or RESTORED) for everything moved
+// to or restored from the trash together. Parents and folders outside the
+// set are notified as well; folders inside it already get the action.
+func (b *Bus) TrashChanged(action string, folders []models.Folder, notes []models.Note, actorID string) {
+	inSet := make(map[string]bool, len(folders))
+	for _, folder := range folders {
+		inSet[folder.ID] = true
+	}
+
+	for _, folder := range folders {
+		parentID := folder.ParentID
+		if parentID != nil && inSet[*parentID] {
+			parentID = nil
+		}
+		b.FolderChanged(action, folder.ID, parentID, actorID)
+	}
+	for _, note := range notes {
+		if inSet[note.FolderID] {
+			b.Publish(NoteTopic(note.ID), Event{Action: action, NoteID: note.ID, FolderID: note.FolderID, ActorID: actorID})
+			continue
+		}
+		b.NoteChanged(action, note.ID, note.FolderID, actorID)
+	}
+}
+
+// MembershipChanged publishes one of the MEMBER_ or MANAGER_ actions to the
+// team's subscribers.
+func (b *Bus) MembershipChanged(action, teamID, userID, actorID string) {
+	b.Publish(TeamTopic(teamID), Event{Action: action, TeamID: teamID, UserID: userID, ActorID: actorID})
+}
@@ -0,0 +1,175 @@
+package graphql
+
+import (
+	"errors"
+	"user-team-asset-management/internal/accounts"
+	"user-team-asset-management/internal/auth"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/policy"
+
+	"github.com/graphql-go/graphql"
+)
+
+// accountErrors are the errors of the accounts service that clients are
+// shown as they are.
+var accountErrors = []error{
+	accounts.ErrUserNotFound,
+	accounts.ErrInvalidUsername,
+	accounts.ErrInvalidEmail,
+	accounts.ErrEmailTaken,
+	accounts.ErrPasswordRequired,
+	accounts.ErrInvalidVerificationToken,
+	accounts.ErrEmailAlreadyVerified,
+	accounts.ErrVerificationRecentlySent,
+	accounts.ErrDeactivateSelf,
+	accounts.ErrAlreadyDeactivated,
+	accounts.ErrNotDeactivated,
+	accounts.ErrInvalidTransferTarget,
+	auth.ErrWrongPassword,
+}
+
+func (r *Resolver) accountMutations(t *types) graphql.Fields {
+	return graphql.Fields{
+		"updateProfile": &graphql.Field{
+			Type:        t.user,
+			Description: "A new email address stays pending until the link mailed to it is opened, and needs currentPassword.",
+			Args: graphql.FieldConfigArgument{
+				"username":        &graphql.ArgumentConfig{Type: graphql.String},
+				"email":           &graphql.ArgumentConfig{Type: graphql.String},
+				"currentPassword": &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: r.updateProfile,
+		},
+		"verifyEmail": &graphql.Field{
+			Type: t.user,
+			Args: graphql.FieldConfigArgument{
+				"token": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: r.verifyEmail,
+		},
+		"resendEmailVerification": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "Mails a new link for the pending email address, or for the current one while it is unverified.",
+			Resolve:     r.resendEmailVerification,
+		},
+		"deactivateUser": &graphql.Field{
+			Type:        t.user,
+			Description: "Signs the user out and keeps them from logging in. transferTo receives their folders and notes.",
+			Args: graphql.FieldConfigArgument{
+				"userId":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"transferTo": &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: r.deactivateUser,
+		},
+		"reactivateUser": &graphql.Field{
+			Type: t.user,
+			Args: graphql.FieldConfigArgument{
+				"userId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: r.reactivateUser,
+		},
+	}
+}
+
+func (r *Resolver) updateProfile(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	update := accounts.ProfileUpdate{}
+	if username, ok := p.Args["username"].(string); ok {
+		update.Username = &username
+	}
+	if email, ok := p.Args["email"].(string); ok {
+		update.Email = &email
+	}
+	update.CurrentPassword, _ = p.Args["currentPassword"].(string)
+
+	user, err := r.Accounts.UpdateProfile(userID, update)
+	if err != nil {
+		return nil, accountError(err, "failed to update profile")
+	}
+	return user, nil
+}
+
+func (r *Resolver) verifyEmail(p graphql.ResolveParams) (interface{}, error) {
+	user, err := r.Accounts.VerifyEmail(p.Args["token"].(string))
+	if err != nil {
+		return nil, accountError(err, "failed to verify email address")
+	}
+	return user, nil
+}
+
+func (r *Resolver) resendEmailVerification(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.Accounts.ResendVerification(userID); err != nil {
+		return nil, accountError(err, "failed to send verification link")
+	}
+	return true, nil
+}
+
+func (r *Resolver) deactivateUser(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	targetID := p.Args["userId"].(string)
+	if err := r.authorizeDeactivate(userID, targetID); err != nil {
+		return nil, err
+	}
+
+	transferTo, _ := p.Args["transferTo"].(string)
+	user, err := r.Accounts.Deactivate(userID, targetID, transferTo)
+	if err != nil {
+		return nil, accountError(err, "failed to deactivate user")
+	}
+	return user, nil
+}
+
+func (r *Resolver) reactivateUser(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	targetID := p.Args["userId"].(string)
+	if err := r.authorizeDeactivate(userID, targetID); err != nil {
+		return nil, err
+	}
+
+	user, err := r.Accounts.Reactivate(userID, targetID)
+	if err != nil {
+		return nil, accountError(err, "failed to reactivate user")
+	}
+	return user, nil
+}
+
+// authorizeDeactivate requires user.deactivate, plus role.assign when the
+// target has a role above member, like DeactivateUser.
+func (r *Resolver) authorizeDeactivate(userID, targetID string) error {
+	if err := r.authorize(userID, policy.UserDeactivate, ""); err != nil {
+		return err
+	}
+
+	var target models.User
+	if err := r.DB.Select("role").Where("id = ?", targetID).First(&target).Error; err != nil {
+		return accounts.ErrUserNotFound
+	}
+	if policy.Elevated(target.Role) {
+		return r.authorize(userID, policy.RoleAssign, "")
+	}
+	return nil
+}
+
+func accountError(err error, fallback string) error {
+	for _, target := range accountErrors {
+		if errors.Is(err, target) {
+			return err
+		}
+	}
+	return errors.New(fallback)
+}
@@ -0,0 +1,624 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"user-team-asset-management/internal/assets"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/pagination"
+	"user-team-asset-management/internal/policy"
+	"user-team-asset-management/internal/trash"
+
+	"github.com/graphql-go/graphql"
+	"gorm.io/gorm"
+)
+
+var (
+	errNoFolderAccess = errors.New("no access to this folder")
+	errNoNoteAccess   = errors.New("no access to this note")
+)
+
+// versionConflictError is the GraphQL counterpart of a 412 response: the
+// version argument did not match the stored version.
+type versionConflictError struct {
+	Current int
+}
+
+func (e *versionConflictError) Error() string {
+	return fmt.Sprintf("resource was modified by someone else (current version %d)", e.Current)
+}
+
+func (e *versionConflictError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"currentVersion": e.Current}
+}
+
+// versionCheck plays the role of If-Match: a missing version argument
+// accepts any version.
+func versionCheck(p graphql.ResolveParams) assets.VersionCheck {
+	expected, ok := p.Args["version"].(int)
+	if !ok {
+		return nil
+	}
+	return func(current int) bool {
+		return current == expected
+	}
+}
+
+// assetError passes on errors from the assets service that the caller can
+// act on and hides the rest behind fallback.
+func assetError(err error, fallback string) error {
+	var denied *assets.AccessError
+	var conflict *assets.VersionConflictError
+	var missingRevision *assets.RevisionNotFoundError
+	switch {
+	case errors.As(err, &conflict):
+		return &versionConflictError{Current: conflict.Current}
+	case errors.As(err, &denied),
+		errors.As(err, &missingRevision),
+		errors.Is(err, assets.ErrFolderNotFound),
+		errors.Is(err, assets.ErrNoteNotFound),
+		errors.Is(err, assets.ErrTrashNotFound),
+		errors.Is(err, assets.ErrFolderCycle),
+		errors.Is(err, assets.ErrAlreadyShared),
+		errors.Is(err, assets.ErrNothingToUpdate),
+		errors.Is(err, trash.ErrParentTrashed):
+		return err
+	default:
+		return errors.New(fallback)
+	}
+}
+
+var shareAccess = graphql.NewEnum(graphql.EnumConfig{
+	Name: "ShareAccess",
+	Values: graphql.EnumValueConfigMap{
+		"read":  &graphql.EnumValueConfig{Value: "read"},
+		"write": &graphql.EnumValueConfig{Value: "write"},
+	},
+})
+
+func (r *Resolver) assetQueries(t *types) graphql.Fields {
+	folderArgs := graphql.FieldConfigArgument{
+		"folderId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+	}
+	noteArgs := graphql.FieldConfigArgument{
+		"noteId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+	}
+
+	return graphql.Fields{
+		"myFolders": &graphql.Field{
+			Type:    t.folderPage,
+			Args:    pageArgs("ownership", "name", "ownerId", "parentId"),
+			Resolve: r.myFolders,
+		},
+		"teamAssets": &graphql.Field{
+			Type:    t.folderPage,
+			Args:    withArgs(pageArgs("ownership", "name", "ownerId", "parentId"), "teamId"),
+			Resolve: r.teamAssets,
+		},
+		"userAssets": &graphql.Field{
+			Type:    t.folderPage,
+			Args:    withArgs(pageArgs("ownership", "name", "ownerId", "parentId"), "userId"),
+			Resolve: r.userAssets,
+		},
+		"folder": &graphql.Field{
+			Type:    t.folder,
+			Args:    folderArgs,
+			Resolve: r.folder,
+		},
+		"folderPermissions": &graphql.Field{
+			Type:    t.permissions,
+			Args:    folderArgs,
+			Resolve: r.folderPermissions,
+		},
+		"note": &graphql.Field{
+			Type:    t.note,
+			Args:    noteArgs,
+			Resolve: r.note,
+		},
+		"notePermissions": &graphql.Field{
+			Type:    t.permissions,
+			Args:    noteArgs,
+			Resolve: r.notePermissions,
+		},
+		"search": &graphql.Field{
+			Type: t.searchResults,
+			Args: graphql.FieldConfigArgument{
+				"q":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"type":   &graphql.ArgumentConfig{Type: graphql.String},
+				"limit":  &graphql.ArgumentConfig{Type: graphql.Int},
+				"offset": &graphql.ArgumentConfig{Type: graphql.Int},
+			},
+			Resolve: r.search,
+		},
+	}
+}
+
+func (r *Resolver) assetMutations(t *types) graphql.Fields {
+	share := func(idArg string) graphql.FieldConfigArgument {
+		return graphql.FieldConfigArgument{
+			idArg:    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			"userId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			"access": &graphql.ArgumentConfig{Type: graphql.NewNonNull(shareAccess)},
+		}
+	}
+	revoke := func(idArg string) graphql.FieldConfigArgument {
+		return graphql.FieldConfigArgument{
+			idArg:    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			"userId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+		}
+	}
+
+	return graphql.Fields{
+		"createFolder": &graphql.Field{
+			Type: t.folder,
+			Args: graphql.FieldConfigArgument{
+				"name":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"parentId": &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: r.createFolder,
+		},
+		"updateFolder": &graphql.Field{
+			Type: t.folder,
+			Args: graphql.FieldConfigArgument{
+				"folderId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"name":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"version":  &graphql.ArgumentConfig{Type: graphql.Int},
+			},
+			Resolve: r.updateFolder,
+		},
+		"moveFolder": &graphql.Field{
+			Type: t.folder,
+			Args: graphql.FieldConfigArgument{
+				"folderId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"parentId": &graphql.ArgumentConfig{Type: graphql.String},
+				"version":  &graphql.ArgumentConfig{Type: graphql.Int},
+			},
+			Resolve: r.moveFolder,
+		},
+		"deleteFolder": &graphql.Field{
+			Type: t.trashEntry,
+			Args: graphql.FieldConfigArgument{
+				"folderId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"version":  &graphql.ArgumentConfig{Type: graphql.Int},
+			},
+			Resolve: r.deleteFolder,
+		},
+		"createNote": &graphql.Field{
+			Type: t.note,
+			Args: graphql.FieldConfigArgument{
+				"folderId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"title":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"body":     &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: r.createNote,
+		},
+		"updateNote": &graphql.Field{
+			Type: t.note,
+			Args: graphql.FieldConfigArgument{
+				"noteId":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"title":   &graphql.ArgumentConfig{Type: graphql.String},
+				"body":    &graphql.ArgumentConfig{Type: graphql.String},
+				"version": &graphql.ArgumentConfig{Type: graphql.Int},
+			},
+			Resolve: r.updateNote,
+		},
+		"deleteNote": &graphql.Field{
+			Type: t.trashEntry,
+			Args: graphql.FieldConfigArgument{
+				"noteId":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"version": &graphql.ArgumentConfig{Type: graphql.Int},
+			},
+			Resolve: r.deleteNote,
+		},
+		"shareFolder": &graphql.Field{
+			Type:    t.folderShare,
+			Args:    share("folderId"),
+			Resolve: r.shareFolder,
+		},
+		"revokeFolderShare": &graphql.Field{
+			Type:    graphql.Boolean,
+			Args:    revoke("folderId"),
+			Resolve: r.revokeFolderShare,
+		},
+		"shareNote": &graphql.Field{
+			Type:    t.noteShare,
+			Args:    share("noteId"),
+			Resolve: r.shareNote,
+		},
+		"revokeNoteShare": &graphql.Field{
+			Type:    graphql.Boolean,
+			Args:    revoke("noteId"),
+			Resolve: r.revokeNoteShare,
+		},
+	}
+}
+
+// listPage parses pagination arguments and loads one page of query.
+func listPage[T any](p graphql.ResolveParams, query *gorm.DB, spec pagination.Spec[T]) (interface{}, error) {
+	req, err := pagination.ParseRequest(argString(p.Args), spec)
+	if err != nil {
+		return nil, err
+	}
+	return pagination.Paginate(query, req, spec)
+}
+
+// listFolders lists the folders owned by or shared with any of userIDs.
+func (r *Resolver) listFolders(p graphql.ResolveParams, userIDs []string) (interface{}, error) {
+	ownership, _ := p.Args["ownership"].(string)
+	query, err := r.Access.FoldersOf(userIDs, ownership)
+	if err != nil {
+		return nil, err
+	}
+	return listPage(p, query, pagination.Folders)
+}
+
+func (r *Resolver) myFolders(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	return r.listFolders(p, []string{userID})
+}
+
+func (r *Resolver) teamAssets(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	teamID := p.Args["teamId"].(string)
+
+	if err := r.authorize(userID, policy.TeamAssetsRead, teamID); err != nil {
+		return nil, err
+	}
+
+	var memberIDs []string
+	if err := r.DB.Model(&models.TeamMember{}).Where("team_id = ?", teamID).Pluck("user_id", &memberIDs).Error; err != nil {
+		return nil, err
+	}
+	return r.listFolders(p, memberIDs)
+}
+
+func (r *Resolver) userAssets(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	targetID := p.Args["userId"].(string)
+
+	if userID != targetID {
+		if err := r.authorize(userID, policy.UserAssetsRead, ""); err != nil {
+			return nil, err
+		}
+	}
+	return r.listFolders(p, []string{targetID})
+}
+
+func (r *Resolver) folder(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	folderID := p.Args["folderId"].(string)
+
+	if !r.canReadFolder(userID, folderID) {
+		return nil, errNoFolderAccess
+	}
+	return r.loaders(p).folders.thunk(folderID), nil
+}
+
+func (r *Resolver) folderPermissions(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	decision, err := r.Access.Folder(userID, p.Args["folderId"].(string))
+	if err != nil {
+		return nil, errors.New("failed to resolve permissions")
+	}
+	if !decision.CanRead() {
+		return nil, errNoFolderAccess
+	}
+	return decision, nil
+}
+
+func (r *Resolver) note(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	noteID := p.Args["noteId"].(string)
+
+	if !r.canReadNote(userID, noteID) {
+		return nil, errNoNoteAccess
+	}
+	return r.loaders(p).notes.thunk(noteID), nil
+}
+
+func (r *Resolver) notePermissions(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	decision, err := r.Access.Note(userID, p.Args["noteId"].(string))
+	if err != nil {
+		return nil, errors.New("failed to resolve permissions")
+	}
+	if !decision.CanRead() {
+		return nil, errNoNoteAccess
+	}
+	return decision, nil
+}
+
+func (r *Resolver) folderOwner(p graphql.ResolveParams) (interface{}, error) {
+	return r.loaders(p).users.thunk(source[models.Folder](p).OwnerID), nil
+}
+
+func (r *Resolver) folderPath(p graphql.ResolveParams) (interface{}, error) {
+	return r.Access.Path(source[models.Folder](p).ID)
+}
+
+// folderChildren, folderNotes and noteFolder check read access themselves
+// because folders can be reached through asset lists that only required
+// permission to see the list. Nested fields check access against the
+// request's loaders so that a long list costs one query per level rather
+// than one per folder.
+func (r *Resolver) folderChildren(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	folder := source[models.Folder](p)
+	l := r.loaders(p)
+
+	if ok, err := l.canReadFolder(userID, folder.ID); err != nil || !ok {
+		return nil, errNoFolderAccess
+	}
+	return l.folderChildren.thunk(folder.ID), nil
+}
+
+func (r *Resolver) folderNotes(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	folder := source[models.Folder](p)
+	l := r.loaders(p)
+
+	if ok, err := l.canReadFolder(userID, folder.ID); err != nil || !ok {
+		return nil, errNoFolderAccess
+	}
+	return l.folderNotes.thunk(folder.ID), nil
+}
+
+// folderShares and noteShares compare the owner on the resolved object
+// itself, which is what Access.Folder and Access.Note would decide.
+func (r *Resolver) folderShares(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	folder := source[models.Folder](p)
+
+	if folder.OwnerID != userID {
+		return nil, errors.New("only folder owner can view shares")
+	}
+	return r.loaders(p).folderShares.thunk(folder.ID), nil
+}
+
+func (r *Resolver) noteOwner(p graphql.ResolveParams) (interface{}, error) {
+	return r.loaders(p).users.thunk(source[models.Note](p).OwnerID), nil
+}
+
+func (r *Resolver) noteFolder(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	note := source[models.Note](p)
+	l := r.loaders(p)
+
+	// A note shared on its own does not reveal its folder
+	if ok, err := l.canReadFolder(userID, note.FolderID); err != nil || !ok {
+		return nil, errNoFolderAccess
+	}
+	return l.folders.thunk(note.FolderID), nil
+}
+
+func (r *Resolver) noteShares(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	note := source[models.Note](p)
+
+	if note.OwnerID != userID {
+		return nil, errors.New("only note owner can view shares")
+	}
+	return r.loaders(p).noteShares.thunk(note.ID), nil
+}
+
+func (r *Resolver) createFolder(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var parentID *string
+	if id, ok := p.Args["parentId"].(string); ok {
+		parentID = &id
+	}
+
+	folder, err := r.Assets.CreateFolder(userID, p.Args["name"].(string), parentID)
+	if err != nil {
+		return nil, assetError(err, "failed to create folder")
+	}
+	return *folder, nil
+}
+
+func (r *Resolver) updateFolder(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	folder, err := r.Assets.RenameFolder(userID, p.Args["folderId"].(string), p.Args["name"].(string), versionCheck(p))
+	if err != nil {
+		return nil, assetError(err, "failed to update folder")
+	}
+	return *folder, nil
+}
+
+func (r *Resolver) moveFolder(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	// A missing parentId moves the folder to the top level
+	var parentID *string
+	if id, ok := p.Args["parentId"].(string); ok {
+		parentID = &id
+	}
+
+	folder, err := r.Assets.MoveFolder(userID, p.Args["folderId"].(string), parentID, versionCheck(p))
+	if err != nil {
+		return nil, assetError(err, "failed to move folder")
+	}
+	return *folder, nil
+}
+
+func (r *Resolver) deleteFolder(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := r.Assets.DeleteFolder(userID, p.Args["folderId"].(string), versionCheck(p))
+	if err != nil {
+		return nil, assetError(err, "failed to delete folder")
+	}
+	return entry, nil
+}
+
+func (r *Resolver) createNote(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	body, _ := p.Args["body"].(string)
+	note, err := r.Assets.CreateNote(userID, p.Args["folderId"].(string), p.Args["title"].(string), body)
+	if err != nil {
+		return nil, assetError(err, "failed to create note")
+	}
+	return *note, nil
+}
+
+func (r *Resolver) updateNote(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	title, _ := p.Args["title"].(string)
+	body, _ := p.Args["body"].(string)
+	note, _, err := r.Assets.UpdateNote(userID, p.Args["noteId"].(string), title, body, versionCheck(p))
+	if err != nil {
+		return nil, assetError(err, "failed to update note")
+	}
+	return *note, nil
+}
+
+func (r *Resolver) deleteNote(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := r.Assets.DeleteNote(userID, p.Args["noteId"].(string), versionCheck(p))
+	if err != nil {
+		return nil, assetError(err, "failed to delete note")
+	}
+	return entry, nil
+}
+
+func (r *Resolver) shareFolder(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	share, err := r.Assets.ShareFolder(userID, p.Args["folderId"].(string), p.Args["userId"].(string), p.Args["access"].(string))
+	if err != nil {
+		return nil, assetError(err, "failed to share folder")
+	}
+	return *share, nil
+}
+
+func (r *Resolver) revokeFolderShare(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.Assets.RevokeFolderShare(userID, p.Args["folderId"].(string), p.Args["userId"].(string)); err != nil {
+		return nil, assetError(err, "failed to revoke folder sharing")
+	}
+	return true, nil
+}
+
+func (r *Resolver) shareNote(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	share, err := r.Assets.ShareNote(userID, p.Args["noteId"].(string), p.Args["userId"].(string), p.Args["access"].(string))
+	if err != nil {
+		return nil, assetError(err, "failed to share note")
+	}
+	return *share, nil
+}
+
+func (r *Resolver) revokeNoteShare(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.Assets.RevokeNoteShare(userID, p.Args["noteId"].(string), p.Args["userId"].(string)); err != nil {
+		return nil, assetError(err, "failed to revoke note sharing")
+	}
+	return true, nil
+}
+
+func (r *Resolver) findFolder(folderID string) (models.Folder, error) {
+	var folder models.Folder
+	if err := r.DB.Where("id = ?", folderID).First(&folder).Error; err != nil {
+		return folder, errors.New("folder not found")
+	}
+	return folder, nil
+}
+
+func (r *Resolver) findNote(noteID string) (models.Note, error) {
+	var note models.Note
+	if err := r.DB.Where("id = ?", noteID).First(&note).Error; err != nil {
+		return note, errors.New("note not found")
+	}
+	return note, nil
+}
+
+// canReadFolder and canReadNote match the helpers on AssetHandler.
+// Resolution errors deny access.
+
+func (r *Resolver) canReadFolder(userID, folderID string) bool {
+	decision, err := r.Access.Folder(userID, folderID)
+	return err == nil && decision.CanRead()
+}
+
+func (r *Resolver) canReadNote(userID, noteID string) bool {
+	decision, err := r.Access.Note(userID, noteID)
+	return err == nil && decision.CanRead()
+}
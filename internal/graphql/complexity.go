@@ -0,0 +1,252 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"user-team-asset-management/internal/pagination"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// assumedListSize is how many items an unpaged list field is expected to
+// return when estimating the cost of what is selected inside it.
+const assumedListSize = 10
+
+// defaultFieldCosts are the weights of fields that do more work than a
+// single lookup, keyed "Type.field".
+var defaultFieldCosts = map[string]int{
+	"Query.search":            5,
+	"Query.folderPermissions": 3,
+	"Query.notePermissions":   3,
+	"Query.noteRevisionDiff":  3,
+	"Folder.path":             2,
+	"Mutation.deleteFolder":   5,
+	"Mutation.restoreTrash":   5,
+}
+
+// Limits bound the work a single operation may ask for. Operations over a
+// limit are rejected before any field is resolved. A limit of zero or less
+// is not enforced, though the cost is still computed and reported.
+type Limits struct {
+	MaxDepth      int
+	MaxComplexity int
+
+	// FieldCosts overrides the weight of single fields, keyed "Type.field".
+	// Fields not listed cost 1 when they return objects and 0 otherwise.
+	FieldCosts map[string]int
+}
+
+// Cost is what an operation was estimated to need. It is reported under
+// "cost" in the response extensions.
+type Cost struct {
+	Depth         int `json:"depth"`
+	Complexity    int `json:"complexity"`
+	MaxDepth      int `json:"maxDepth,omitempty"`
+	MaxComplexity int `json:"maxComplexity,omitempty"`
+}
+
+// Check returns the errors to reply with when cost is over the limits.
+func (l Limits) Check(cost Cost) []gqlerrors.FormattedError {
+	var errs []gqlerrors.FormattedError
+	if l.MaxDepth > 0 && cost.Depth > l.MaxDepth {
+		errs = append(errs, gqlerrors.FormattedError{
+			Message: fmt.Sprintf("query depth %d exceeds the maximum of %d", cost.Depth, l.MaxDepth),
+			Extensions: map[string]interface{}{
+				"code":     "DEPTH_LIMIT_EXCEEDED",
+				"depth":    cost.Depth,
+				"maxDepth": l.MaxDepth,
+			},
+		})
+	}
+	if l.MaxComplexity > 0 && cost.Complexity > l.MaxComplexity {
+		errs = append(errs, gqlerrors.FormattedError{
+			Message: fmt.Sprintf("query complexity %d exceeds the maximum of %d", cost.Complexity, l.MaxComplexity),
+			Extensions: map[string]interface{}{
+				"code":          "COMPLEXITY_LIMIT_EXCEEDED",
+				"complexity":    cost.Complexity,
+				"maxComplexity": l.MaxComplexity,
+			},
+		})
+	}
+	return errs
+}
+
+// Measure estimates the depth and complexity of the named operation in doc,
+// which must already have been validated against schema.
+//
+// Depth counts nested fields, so { me { username } } has a depth of 2.
+// Complexity adds up the weights of all selected fields, with what is
+// selected inside a field multiplied by how many items it may return: its
+// "limit" argument for paged fields and searches, and assumedListSize for
+// other lists. Introspection fields are free.
+func (l Limits) Measure(schema *graphql.Schema, doc *ast.Document, operationName string, variables map[string]interface{}) Cost {
+	m := &measurer{
+		limits:    l,
+		schema:    schema,
+		variables: variables,
+		fragments: make(map[string]*ast.FragmentDefinition),
+	}
+
+	var operation *ast.OperationDefinition
+	for _, definition := range doc.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			m.fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			if operation == nil && (operationName == "" || (definition.Name != nil && definition.Name.Value == operationName)) {
+				operation = definition
+			}
+		}
+	}
+
+	cost := Cost{MaxDepth: l.MaxDepth, MaxComplexity: l.MaxComplexity}
+	if operation == nil {
+		return cost
+	}
+
+	var root *graphql.Object
+	switch operation.Operation {
+	case ast.OperationTypeMutation:
+		root = schema.MutationType()
+	case ast.OperationTypeSubscription:
+		root = schema.SubscriptionType()
+	default:
+		root = schema.QueryType()
+	}
+	cost.Complexity, cost.Depth = m.selectionSet(root, operation.SelectionSet, false, nil)
+	return cost
+}
+
+type measurer struct {
+	limits    Limits
+	schema    *graphql.Schema
+	variables map[string]interface{}
+	fragments map[string]*ast.FragmentDefinition
+}
+
+// selectionSet returns the complexity and depth of the selections made on
+// parent. paged is set for the selections of a field whose limit already
+// accounts for how many items its list returns. spreading holds the
+// fragments being expanded, so that a cycle cannot recurse forever.
+func (m *measurer) selectionSet(parent graphql.Type, set *ast.SelectionSet, paged bool, spreading map[string]bool) (complexity, depth int) {
+	if set == nil {
+		return 0, 0
+	}
+
+	for _, selection := range set.Selections {
+		var c, d int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			c, d = m.field(parent, selection, paged, spreading)
+		case *ast.InlineFragment:
+			on := parent
+			if selection.TypeCondition != nil {
+				on = m.schema.Type(selection.TypeCondition.Name.Value)
+			}
+			c, d = m.selectionSet(on, selection.SelectionSet, paged, spreading)
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			fragment, ok := m.fragments[name]
+			if !ok || spreading[name] {
+				continue
+			}
+			nested := map[string]bool{name: true}
+			for spread := range spreading {
+				nested[spread] = true
+			}
+			c, d = m.selectionSet(m.schema.Type(fragment.TypeCondition.Name.Value), fragment.SelectionSet, paged, nested)
+		}
+
+		complexity += c
+		if d > depth {
+			depth = d
+		}
+	}
+	return complexity, depth
+}
+
+func (m *measurer) field(parent graphql.Type, field *ast.Field, paged bool, spreading map[string]bool) (complexity, depth int) {
+	name := field.Name.Value
+	if strings.HasPrefix(name, "__") {
+		return 0, 0
+	}
+
+	object, ok := parent.(*graphql.Object)
+	if !ok {
+		return 0, 1
+	}
+	definition, ok := object.Fields()[name]
+	if !ok {
+		return 0, 1
+	}
+
+	multiplier, childrenPaged := 1, false
+	if hasArgument(definition, "limit") {
+		multiplier, childrenPaged = m.limit(field), true
+	} else if _, isList := graphql.GetNullable(definition.Type).(*graphql.List); isList && !paged {
+		multiplier = assumedListSize
+	}
+
+	named, _ := graphql.GetNamed(definition.Type).(graphql.Type)
+	children, childDepth := m.selectionSet(named, field.SelectionSet, childrenPaged, spreading)
+	return m.weight(object.Name(), name, named) + multiplier*children, 1 + childDepth
+}
+
+func (m *measurer) weight(typeName, fieldName string, named graphql.Type) int {
+	key := typeName + "." + fieldName
+	if cost, ok := m.limits.FieldCosts[key]; ok {
+		return cost
+	}
+	if cost, ok := defaultFieldCosts[key]; ok {
+		return cost
+	}
+	if _, ok := named.(*graphql.Object); ok {
+		return 1
+	}
+	return 0
+}
+
+// limit reads the field's limit argument the way the resolvers do, falling
+// back to the default page size when it is absent or not a number and never
+// exceeding the largest page size.
+func (m *measurer) limit(field *ast.Field) int {
+	limit := pagination.DefaultLimit
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != "limit" {
+			continue
+		}
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(value.Value); err == nil {
+				limit = n
+			}
+		case *ast.Variable:
+			switch n := m.variables[value.Name.Value].(type) {
+			case int:
+				limit = n
+			case float64:
+				limit = int(n)
+			}
+		}
+	}
+
+	if limit < 1 {
+		return 1
+	}
+	if limit > pagination.MaxLimit {
+		return pagination.MaxLimit
+	}
+	return limit
+}
+
+func hasArgument(definition *graphql.FieldDefinition, name string) bool {
+	for _, argument := range definition.Args {
+		if argument.Name() == name {
+			return true
+		}
+	}
+	return false
+}
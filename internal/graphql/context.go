@@ -0,0 +1,91 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"user-team-asset-management/internal/policy"
+
+	"github.com/graphql-go/graphql"
+)
+
+type contextKey int
+
+const (
+	userIDKey contextKey = iota
+	loadersKey
+	clientIPKey
+	sessionIDKey
+)
+
+var errUnauthenticated = errors.New("authentication required")
+
+// WithUserID returns a copy of ctx carrying the authenticated user's ID for
+// resolvers to read.
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+// WithClientIP returns a copy of ctx carrying the address the request came
+// from, which login uses to throttle failed attempts per client.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// clientIP returns the address set with WithClientIP, or "" if unknown.
+func clientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey).(string)
+	return ip
+}
+
+// WithSessionID returns a copy of ctx carrying the session of the access
+// token the request was authenticated with, which logout revokes.
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey, sessionID)
+}
+
+// sessionID returns the session set with WithSessionID, or "" if unknown.
+func sessionID(ctx context.Context) string {
+	id, _ := ctx.Value(sessionIDKey).(string)
+	return id
+}
+
+// RequestContext prepares ctx for executing one GraphQL request on behalf
+// of userID, which may be empty for anonymous requests. Each request gets
+// its own loaders so that batching and caching never span requests.
+func (r *Resolver) RequestContext(ctx context.Context, userID string) context.Context {
+	ctx = WithUserID(ctx, userID)
+	return context.WithValue(ctx, loadersKey, r.newLoaders())
+}
+
+// loaders returns the request's loaders. Without RequestContext every call
+// gets fresh loaders, which is correct but does not batch.
+func (r *Resolver) loaders(p graphql.ResolveParams) *loaders {
+	if l, ok := p.Context.Value(loadersKey).(*loaders); ok {
+		return l
+	}
+	return r.newLoaders()
+}
+
+// currentUserID returns the authenticated user's ID, or errUnauthenticated
+// when the request carried no valid token.
+func currentUserID(p graphql.ResolveParams) (string, error) {
+	userID, _ := p.Context.Value(userIDKey).(string)
+	if userID == "" {
+		return "", errUnauthenticated
+	}
+	return userID, nil
+}
+
+// authorize checks perm for userID, scoped to teamID when it is not empty,
+// with the same semantics as the REST handlers.
+func (r *Resolver) authorize(userID string, perm policy.Permission, teamID string) error {
+	allowed, err := r.Authz.Authorize(userID, perm, teamID)
+	if err != nil {
+		return errors.New("failed to check permissions")
+	}
+	if !allowed {
+		return fmt.Errorf("permission %s required", perm)
+	}
+	return nil
+}
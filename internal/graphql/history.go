@@ -0,0 +1,212 @@
+package graphql
+
+import (
+	"fmt"
+	"user-team-asset-management/internal/diff"
+	"user-team-asset-management/internal/models"
+
+	"github.com/graphql-go/graphql"
+)
+
+func (r *Resolver) historyQueries(t *types) graphql.Fields {
+	return graphql.Fields{
+		"noteRevisions": &graphql.Field{
+			Type: graphql.NewList(t.revision),
+			Args: graphql.FieldConfigArgument{
+				"noteId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: r.noteRevisions,
+		},
+		"noteRevision": &graphql.Field{
+			Type: t.revision,
+			Args: graphql.FieldConfigArgument{
+				"noteId":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"revision": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+			},
+			Resolve: r.noteRevision,
+		},
+		"noteRevisionDiff": &graphql.Field{
+			Type: t.revisionDiff,
+			Args: graphql.FieldConfigArgument{
+				"noteId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"from":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				"to":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+			},
+			Resolve: r.noteRevisionDiff,
+		},
+		"trash": &graphql.Field{
+			Type:    graphql.NewList(t.trashEntry),
+			Resolve: r.trash,
+		},
+	}
+}
+
+func (r *Resolver) historyMutations(t *types) graphql.Fields {
+	trashArgs := graphql.FieldConfigArgument{
+		"trashId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+	}
+
+	return graphql.Fields{
+		"restoreNoteRevision": &graphql.Field{
+			Type: t.revision,
+			Args: graphql.FieldConfigArgument{
+				"noteId":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"revision": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				"version":  &graphql.ArgumentConfig{Type: graphql.Int},
+			},
+			Resolve: r.restoreNoteRevision,
+		},
+		"restoreTrash": &graphql.Field{
+			Type:    t.trashEntry,
+			Args:    trashArgs,
+			Resolve: r.restoreTrash,
+		},
+		"purgeTrash": &graphql.Field{
+			Type:    graphql.Boolean,
+			Args:    trashArgs,
+			Resolve: r.purgeTrash,
+		},
+	}
+}
+
+func (r *Resolver) noteRevisions(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	return r.revisionsOf(userID, p.Args["noteId"].(string))
+}
+
+// noteRevisionsOf resolves Note.revisions.
+func (r *Resolver) noteRevisionsOf(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	note := source[models.Note](p)
+	l := r.loaders(p)
+
+	if ok, err := l.canReadNote(userID, note); err != nil || !ok {
+		return nil, errNoNoteAccess
+	}
+	return l.noteRevisions.thunk(note.ID), nil
+}
+
+func (r *Resolver) revisionsOf(userID, noteID string) (interface{}, error) {
+	if !r.canReadNote(userID, noteID) {
+		return nil, errNoNoteAccess
+	}
+
+	var history []models.NoteRevision
+	if err := r.DB.Where("note_id = ?", noteID).Order("revision DESC").Find(&history).Error; err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (r *Resolver) noteRevision(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	noteID := p.Args["noteId"].(string)
+
+	if !r.canReadNote(userID, noteID) {
+		return nil, errNoNoteAccess
+	}
+	return r.findRevision(noteID, p.Args["revision"].(int))
+}
+
+func (r *Resolver) noteRevisionDiff(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	noteID := p.Args["noteId"].(string)
+
+	if !r.canReadNote(userID, noteID) {
+		return nil, errNoNoteAccess
+	}
+
+	from, err := r.findRevision(noteID, p.Args["from"].(int))
+	if err != nil {
+		return nil, err
+	}
+	to, err := r.findRevision(noteID, p.Args["to"].(int))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"from":      from.Revision,
+		"to":        to.Revision,
+		"fromTitle": from.Title,
+		"toTitle":   to.Title,
+		"diff": diff.Unified(
+			fmt.Sprintf("revision %d", from.Revision),
+			fmt.Sprintf("revision %d", to.Revision),
+			from.Body, to.Body, 3,
+		),
+	}, nil
+}
+
+// restoreNoteRevision records the restore as a new revision, like
+// AssetHandler.RestoreNoteRevision.
+func (r *Resolver) restoreNoteRevision(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	_, head, err := r.Assets.RestoreRevision(userID, p.Args["noteId"].(string), p.Args["revision"].(int), versionCheck(p))
+	if err != nil {
+		return nil, assetError(err, "failed to restore revision")
+	}
+	return head, nil
+}
+
+func (r *Resolver) findRevision(noteID string, number int) (*models.NoteRevision, error) {
+	revision, err := r.Assets.FindRevision(noteID, number)
+	if err != nil {
+		return nil, assetError(err, "failed to load revision")
+	}
+	return revision, nil
+}
+
+func (r *Resolver) trash(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []models.TrashEntry
+	if err := r.DB.Where("owner_id = ?", userID).Order("deleted_at DESC").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (r *Resolver) restoreTrash(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := r.Assets.RestoreTrash(userID, p.Args["trashId"].(string))
+	if err != nil {
+		return nil, assetError(err, "failed to restore item")
+	}
+	return entry, nil
+}
+
+func (r *Resolver) purgeTrash(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.Assets.PurgeTrash(userID, p.Args["trashId"].(string)); err != nil {
+		return nil, assetError(err, "failed to purge item")
+	}
+	return true, nil
+}
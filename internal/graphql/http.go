@@ -0,0 +1,173 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/handler"
+)
+
+// Handler serves GraphQL over HTTP. Queries and mutations are accepted as
+// GET or POST requests in the formats graphql-go/handler understands, and
+// WebSocket upgrades are handed to the subscription server. Callers attach
+// the authenticated user with RequestContext before calling ServeHTTP.
+type Handler struct {
+	Schema   graphql.Schema
+	Resolver *Resolver
+
+	subscriptions *SubscriptionServer
+	graphiql      *handler.Handler
+}
+
+// NewHandler returns a handler for schema. With graphiql set, browsers are
+// shown the GraphiQL playground instead of a JSON response.
+func NewHandler(schema graphql.Schema, resolver *Resolver, graphiql bool) *Handler {
+	h := &Handler{
+		Schema:        schema,
+		Resolver:      resolver,
+		subscriptions: NewSubscriptionServer(schema, resolver),
+	}
+	if graphiql {
+		h.graphiql = handler.New(&handler.Config{Schema: &h.Schema, GraphiQL: true})
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.subscriptions.Accepts(req) {
+		h.subscriptions.ServeHTTP(w, req)
+		return
+	}
+
+	if h.graphiql != nil && wantsGraphiQL(req) {
+		// graphql-go/handler runs the query in the URL while rendering the
+		// page, which would skip the limits, so the playground is served
+		// without it.
+		page := req.Clone(req.Context())
+		page.URL.RawQuery = ""
+		h.graphiql.ServeHTTP(w, page)
+		return
+	}
+
+	result := h.Resolver.Execute(req.Context(), h.Schema, parseRequest(req))
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	body, _ := json.MarshalIndent(result, "", "\t")
+	w.Write(body)
+}
+
+// Request is one GraphQL operation as sent by a client.
+type Request struct {
+	handler.RequestOptions
+	Extensions struct {
+		PersistedQuery *persistedQuery `json:"persistedQuery"`
+	} `json:"extensions"`
+}
+
+// parseRequest reads a request the way graphql-go/handler does, plus the
+// extensions it ignores. GET requests carry every parameter, JSON-encoded
+// where needed, in the URL; a persisted query may leave out the document.
+func parseRequest(req *http.Request) *Request {
+	request := &Request{}
+
+	values := req.URL.Query()
+	if values.Get("query") != "" || values.Get("extensions") != "" {
+		request.Query = values.Get("query")
+		request.OperationName = values.Get("operationName")
+		json.Unmarshal([]byte(values.Get("variables")), &request.Variables)
+		json.Unmarshal([]byte(values.Get("extensions")), &request.Extensions)
+		return request
+	}
+
+	contentType := strings.TrimSpace(strings.Split(req.Header.Get("Content-Type"), ";")[0])
+	if req.Method == http.MethodPost && req.Body != nil &&
+		contentType != handler.ContentTypeGraphQL && contentType != handler.ContentTypeFormURLEncoded {
+		body, err := io.ReadAll(req.Body)
+		if err == nil && json.Unmarshal(body, request) == nil {
+			return request
+		}
+		// Let graphql-go/handler deal with variables sent as a string
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	request.RequestOptions = *handler.NewRequestOptions(req)
+	return request
+}
+
+// wantsGraphiQL mirrors graphql-go/handler's choice between the playground
+// and a JSON response.
+func wantsGraphiQL(req *http.Request) bool {
+	accept := req.Header.Get("Accept")
+	_, raw := req.URL.Query()["raw"]
+	return !raw && !strings.Contains(accept, "application/json") && strings.Contains(accept, "text/html")
+}
+
+// Execute runs a query or mutation for the user attached to ctx. Operations
+// over the resolver's limits are rejected before any field is resolved, and
+// the computed cost is reported in the result's extensions either way.
+func (r *Resolver) Execute(ctx context.Context, schema graphql.Schema, opts *Request) *graphql.Result {
+	userID, _ := ctx.Value(userIDKey).(string)
+	doc, cost, rejected := r.prepare(&schema, opts, userID)
+	if rejected != nil {
+		return rejected
+	}
+
+	return r.execute(ctx, schema, doc, opts, cost)
+}
+
+func (r *Resolver) execute(ctx context.Context, schema graphql.Schema, doc *ast.Document, opts *Request, cost Cost) *graphql.Result {
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        schema,
+		AST:           doc,
+		OperationName: opts.OperationName,
+		Args:          opts.Variables,
+		Context:       ctx,
+	})
+	return withCost(result, cost)
+}
+
+// prepare looks up persisted queries, parses and validates the request and
+// checks it against the limits. A non-nil result carries the errors to
+// reply with instead of executing.
+func (r *Resolver) prepare(schema *graphql.Schema, opts *Request, userID string) (*ast.Document, Cost, *graphql.Result) {
+	register, errs := r.resolvePersisted(opts)
+	if len(errs) > 0 {
+		return nil, Cost{}, &graphql.Result{Errors: errs}
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: opts.Query})
+	if err != nil {
+		return nil, Cost{}, &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	if validation := graphql.ValidateDocument(schema, doc, nil); !validation.IsValid {
+		return nil, Cost{}, &graphql.Result{Errors: validation.Errors}
+	}
+
+	cost := r.Limits.Measure(schema, doc, opts.OperationName, opts.Variables)
+	if errs := r.Limits.Check(cost); len(errs) > 0 {
+		return nil, cost, withCost(&graphql.Result{Errors: errs}, cost)
+	}
+
+	// Anonymous callers could otherwise fill the store without limit
+	if register && userID != "" {
+		r.registerPersisted(opts.Query)
+	}
+	return doc, cost, nil
+}
+
+func withCost(result *graphql.Result, cost Cost) *graphql.Result {
+	if result.Extensions == nil {
+		result.Extensions = make(map[string]interface{})
+	}
+	result.Extensions["cost"] = cost
+	return result
+}
@@ -0,0 +1,430 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"user-team-asset-management/internal/access"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/pagination"
+	"user-team-asset-management/internal/policy"
+
+	"github.com/graphql-go/graphql"
+)
+
+// loader batches lookups by key within one request. Resolvers queue their
+// key and return a thunk; graphql-go runs thunks breadth first, so the first
+// thunk to run fetches every key queued by its siblings in one go. Results
+// are cached for the rest of the request.
+type loader[V any] struct {
+	fetch func(keys []string) (map[string]V, error)
+	// notFound is returned for keys missing from the fetched results. When
+	// nil, missing keys resolve to the zero value.
+	notFound error
+
+	mu      sync.Mutex
+	pending []string
+	results map[string]V
+	errs    map[string]error
+}
+
+func newLoader[V any](fetch func(keys []string) (map[string]V, error), notFound error) *loader[V] {
+	return &loader[V]{
+		fetch:    fetch,
+		notFound: notFound,
+		results:  make(map[string]V),
+		errs:     make(map[string]error),
+	}
+}
+
+// queue adds key to the next batch unless it is already known.
+func (l *loader[V]) queue(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.results[key]; ok {
+		return
+	}
+	if _, ok := l.errs[key]; ok {
+		return
+	}
+	for _, pending := range l.pending {
+		if pending == key {
+			return
+		}
+	}
+	l.pending = append(l.pending, key)
+}
+
+// get returns the value for key, fetching the pending batch if the key has
+// not been loaded yet.
+func (l *loader[V]) get(key string) (V, error) {
+	l.queue(key)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.results[key]; !ok && l.errs[key] == nil {
+		keys := l.pending
+		l.pending = nil
+
+		found, err := l.fetch(keys)
+		for _, k := range keys {
+			if err != nil {
+				l.errs[k] = err
+			} else if v, ok := found[k]; ok {
+				l.results[k] = v
+			} else if l.notFound != nil {
+				l.errs[k] = l.notFound
+			} else {
+				var zero V
+				l.results[k] = zero
+			}
+		}
+	}
+
+	return l.results[key], l.errs[key]
+}
+
+// thunk queues key and returns a resolver thunk yielding its value.
+func (l *loader[V]) thunk(key string) func() (interface{}, error) {
+	l.queue(key)
+	return func() (interface{}, error) {
+		return l.get(key)
+	}
+}
+
+// loaders holds the per-request loaders and caches used by query
+// resolvers. Mutations keep using the database directly so that they always
+// act on current data.
+type loaders struct {
+	r *Resolver
+
+	users   *loader[models.User]
+	teams   *loader[models.Team]
+	folders *loader[models.Folder]
+	notes   *loader[models.Note]
+
+	teamManagers   *loader[[]models.User]
+	teamMembers    *loader[[]models.User]
+	memberTeamIDs  *loader[[]string]
+	folderChildren *loader[[]models.Folder]
+	folderNotes    *loader[[]models.Note]
+	folderShares   *loader[[]models.FolderShare]
+	noteShares     *loader[[]models.NoteShare]
+	noteRevisions  *loader[[]models.NoteRevision]
+
+	mu          sync.Mutex
+	folderPages map[string]*loader[pagination.Page[models.Folder]]
+	decisions   map[string]error
+	readable    map[string]map[string]bool
+	sharedNotes map[string]map[string]bool
+}
+
+func (r *Resolver) newLoaders() *loaders {
+	return &loaders{
+		r:              r,
+		users:          newLoader(r.loadUsers, errors.New("user not found")),
+		teams:          newLoader(r.loadTeams, errors.New("team not found")),
+		folders:        newLoader(r.loadFolders, errors.New("folder not found")),
+		notes:          newLoader(r.loadNotes, errors.New("note not found")),
+		teamManagers:   newLoader(r.teamUsersLoader("team_managers"), nil),
+		teamMembers:    newLoader(r.teamUsersLoader("team_members"), nil),
+		memberTeamIDs:  newLoader(r.loadMemberTeamIDs, nil),
+		folderChildren: newLoader(r.loadFolderChildren, nil),
+		folderNotes:    newLoader(r.loadFolderNotes, nil),
+		folderShares:   newLoader(r.loadFolderShares, nil),
+		noteShares:     newLoader(r.loadNoteShares, nil),
+		noteRevisions:  newLoader(r.loadNoteRevisions, nil),
+		folderPages:    make(map[string]*loader[pagination.Page[models.Folder]]),
+		decisions:      make(map[string]error),
+		readable:       make(map[string]map[string]bool),
+		sharedNotes:    make(map[string]map[string]bool),
+	}
+}
+
+// authorize is Resolver.authorize with the decision cached per request.
+func (l *loaders) authorize(userID string, perm policy.Permission, teamID string) error {
+	key := userID + "|" + string(perm) + "|" + teamID
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err, ok := l.decisions[key]; ok {
+		return err
+	}
+	err := l.r.authorize(userID, perm, teamID)
+	l.decisions[key] = err
+	return err
+}
+
+// canReadFolder answers from the set of folders the user can read, loaded
+// once per request.
+func (l *loaders) canReadFolder(userID, folderID string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	readable, ok := l.readable[userID]
+	if !ok {
+		var err error
+		if readable, err = l.r.Access.ReadableFolderIDs(userID); err != nil {
+			return false, err
+		}
+		l.readable[userID] = readable
+	}
+	return readable[folderID], nil
+}
+
+// canReadNote matches access.Resolver.Note: the owner, users the note is
+// shared with and anyone who can read its folder may read it.
+func (l *loaders) canReadNote(userID string, note models.Note) (bool, error) {
+	if note.OwnerID == userID {
+		return true, nil
+	}
+
+	l.mu.Lock()
+	shared, ok := l.sharedNotes[userID]
+	if !ok {
+		var err error
+		if shared, err = l.r.Access.SharedNoteIDs(userID); err != nil {
+			l.mu.Unlock()
+			return false, err
+		}
+		l.sharedNotes[userID] = shared
+	}
+	l.mu.Unlock()
+
+	if shared[note.ID] {
+		return true, nil
+	}
+	return l.canReadFolder(userID, note.FolderID)
+}
+
+// canViewAssetsOf reports whether viewerID may list userID's folders: it
+// takes user.assets.read, or team.assets.read on a team the user belongs to.
+func (l *loaders) canViewAssetsOf(viewerID, userID string) error {
+	denied := l.authorize(viewerID, policy.UserAssetsRead, "")
+	if denied == nil {
+		return nil
+	}
+
+	teamIDs, err := l.memberTeamIDs.get(userID)
+	if err != nil {
+		return err
+	}
+	for _, teamID := range teamIDs {
+		if l.authorize(viewerID, policy.TeamAssetsRead, teamID) == nil {
+			return nil
+		}
+	}
+	return denied
+}
+
+// folderPagesFor returns the loader for users' folder pages with the
+// arguments of the field being resolved. Sibling fields with the same
+// arguments share one loader and therefore one batch.
+func (l *loaders) folderPagesFor(p graphql.ResolveParams) (*loader[pagination.Page[models.Folder]], error) {
+	req, err := pagination.ParseRequest(argString(p.Args), pagination.Folders)
+	if err != nil {
+		return nil, err
+	}
+	ownership, _ := p.Args["ownership"].(string)
+	key := fmt.Sprint(p.Args)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if pages, ok := l.folderPages[key]; ok {
+		return pages, nil
+	}
+	pages := newLoader(func(userIDs []string) (map[string]pagination.Page[models.Folder], error) {
+		query, err := l.r.Access.FoldersByUser(userIDs, ownership)
+		if err != nil {
+			return nil, err
+		}
+		found, err := pagination.PaginateGroups(query, access.FolderUsersColumn, req, pagination.Folders)
+		if err != nil {
+			return nil, err
+		}
+		for _, userID := range userIDs {
+			if _, ok := found[userID]; !ok {
+				found[userID] = pagination.Page[models.Folder]{Items: []models.Folder{}}
+			}
+		}
+		return found, nil
+	}, nil)
+	l.folderPages[key] = pages
+	return pages, nil
+}
+
+func (r *Resolver) loadUsers(ids []string) (map[string]models.User, error) {
+	var users []models.User
+	if err := r.DB.Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]models.User, len(users))
+	for _, user := range users {
+		found[user.ID] = user
+	}
+	return found, nil
+}
+
+func (r *Resolver) loadTeams(ids []string) (map[string]models.Team, error) {
+	var teams []models.Team
+	if err := r.DB.Where("id IN ?", ids).Find(&teams).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]models.Team, len(teams))
+	for _, team := range teams {
+		found[team.ID] = team
+	}
+	return found, nil
+}
+
+func (r *Resolver) loadNotes(ids []string) (map[string]models.Note, error) {
+	var notes []models.Note
+	if err := r.DB.Where("id IN ?", ids).Find(&notes).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]models.Note, len(notes))
+	for _, note := range notes {
+		found[note.ID] = note
+	}
+	return found, nil
+}
+
+func (r *Resolver) loadFolders(ids []string) (map[string]models.Folder, error) {
+	var folders []models.Folder
+	if err := r.DB.Where("id IN ?", ids).Find(&folders).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]models.Folder, len(folders))
+	for _, folder := range folders {
+		found[folder.ID] = folder
+	}
+	return found, nil
+}
+
+// teamUsersLoader returns a fetch function for the users listed in table,
+// either team_managers or team_members, for several teams at once.
+func (r *Resolver) teamUsersLoader(table string) func(teamIDs []string) (map[string][]models.User, error) {
+	return func(teamIDs []string) (map[string][]models.User, error) {
+		var rows []struct {
+			TeamID string
+			models.User
+		}
+		if err := r.DB.Model(&models.User{}).
+			Select("users.*, "+table+".team_id").
+			Joins("JOIN "+table+" ON users.id = "+table+".user_id").
+			Where(table+".team_id IN ?", teamIDs).
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+
+		found := make(map[string][]models.User, len(teamIDs))
+		for _, teamID := range teamIDs {
+			found[teamID] = []models.User{}
+		}
+		for _, row := range rows {
+			found[row.TeamID] = append(found[row.TeamID], row.User)
+		}
+		return found, nil
+	}
+}
+
+func (r *Resolver) loadMemberTeamIDs(userIDs []string) (map[string][]string, error) {
+	var memberships []models.TeamMember
+	if err := r.DB.Where("user_id IN ?", userIDs).Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[string][]string, len(userIDs))
+	for _, membership := range memberships {
+		found[membership.UserID] = append(found[membership.UserID], membership.TeamID)
+	}
+	return found, nil
+}
+
+func (r *Resolver) loadFolderChildren(parentIDs []string) (map[string][]models.Folder, error) {
+	var children []models.Folder
+	if err := r.DB.Where("parent_id IN ?", parentIDs).Find(&children).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[string][]models.Folder, len(parentIDs))
+	for _, id := range parentIDs {
+		found[id] = []models.Folder{}
+	}
+	for _, child := range children {
+		found[*child.ParentID] = append(found[*child.ParentID], child)
+	}
+	return found, nil
+}
+
+func (r *Resolver) loadFolderNotes(folderIDs []string) (map[string][]models.Note, error) {
+	var notes []models.Note
+	if err := r.DB.Where("folder_id IN ?", folderIDs).Find(&notes).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[string][]models.Note, len(folderIDs))
+	for _, id := range folderIDs {
+		found[id] = []models.Note{}
+	}
+	for _, note := range notes {
+		found[note.FolderID] = append(found[note.FolderID], note)
+	}
+	return found, nil
+}
+
+func (r *Resolver) loadFolderShares(folderIDs []string) (map[string][]models.FolderShare, error) {
+	var shares []models.FolderShare
+	if err := r.DB.Where("folder_id IN ?", folderIDs).Find(&shares).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[string][]models.FolderShare, len(folderIDs))
+	for _, id := range folderIDs {
+		found[id] = []models.FolderShare{}
+	}
+	for _, share := range shares {
+		found[share.FolderID] = append(found[share.FolderID], share)
+	}
+	return found, nil
+}
+
+func (r *Resolver) loadNoteShares(noteIDs []string) (map[string][]models.NoteShare, error) {
+	var shares []models.NoteShare
+	if err := r.DB.Where("note_id IN ?", noteIDs).Find(&shares).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[string][]models.NoteShare, len(noteIDs))
+	for _, id := range noteIDs {
+		found[id] = []models.NoteShare{}
+	}
+	for _, share := range shares {
+		found[share.NoteID] = append(found[share.NoteID], share)
+	}
+	return found, nil
+}
+
+func (r *Resolver) loadNoteRevisions(noteIDs []string) (map[string][]models.NoteRevision, error) {
+	var history []models.NoteRevision
+	if err := r.DB.Where("note_id IN ?", noteIDs).Order("revision DESC").Find(&history).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[string][]models.NoteRevision, len(noteIDs))
+	for _, id := range noteIDs {
+		found[id] = []models.NoteRevision{}
+	}
+	for _, revision := range history {
+		found[revision.NoteID] = append(found[revision.NoteID], revision)
+	}
+	return found, nil
+}
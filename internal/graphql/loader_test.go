@@ -0,0 +1,114 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+	"user-team-asset-management/internal/access"
+	"user-team-asset-management/internal/dbtest"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/policy"
+
+	"github.com/graphql-go/graphql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// statementCounter is a GORM logger that counts the statements it traces.
+type statementCounter struct {
+	logger.Interface
+	n atomic.Int64
+}
+
+func (c *statementCounter) LogMode(logger.LogLevel) logger.Interface { return c }
+
+func (c *statementCounter) Trace(context.Context, time.Time, func() (string, int64), error) {
+	c.n.Add(1)
+}
+
+const deepTeamQuery = `{
+	team(teamId: "team") {
+		members {
+			username
+			folders(limit: 50) {
+				items {
+					name
+					owner { username }
+					notes { title owner { username } folder { name } }
+				}
+			}
+		}
+	}
+}`
+
+// countTeamQueryStatements seeds a team with the given number of members,
+// each owning folders with notes, and returns how many SQL statements
+// deepTeamQuery takes and how many members it returned.
+func countTeamQueryStatements(t *testing.T, members int) (int64, int) {
+	t.Helper()
+
+	db := dbtest.Open(t)
+
+	seed := []interface{}{
+		&models.User{ID: "admin", Username: "admin", Email: "admin@example.com", PasswordHash: "x", Role: policy.RoleAdmin},
+		&models.Team{ID: "team", TeamName: "Team"},
+		&models.TeamManager{TeamID: "team", UserID: "admin"},
+	}
+	for i := 0; i < members; i++ {
+		userID := fmt.Sprintf("user%d", i)
+		seed = append(seed,
+			&models.User{ID: userID, Username: userID, Email: userID + "@example.com", PasswordHash: "x", Role: policy.RoleMember},
+			&models.TeamMember{TeamID: "team", UserID: userID},
+		)
+		for j := 0; j < 3; j++ {
+			folderID := fmt.Sprintf("%s-folder%d", userID, j)
+			seed = append(seed,
+				&models.Folder{ID: folderID, Name: folderID, OwnerID: userID},
+				&models.FolderShare{FolderID: folderID, UserID: "admin", Access: "read"},
+				&models.Note{ID: folderID + "-note", Title: "Note", OwnerID: userID, FolderID: folderID},
+			)
+		}
+	}
+	dbtest.Seed(t, db, seed...)
+
+	counter := &statementCounter{Interface: logger.Discard}
+	counted := db.Session(&gorm.Session{Logger: counter})
+	r := &Resolver{DB: counted, Authz: policy.NewAuthorizer(counted), Access: access.NewResolver(counted)}
+	schema, err := r.CreateSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:        schema,
+		RequestString: deepTeamQuery,
+		Context:       r.RequestContext(context.Background(), "admin"),
+	})
+	if len(result.Errors) > 0 {
+		t.Fatalf("query failed: %v", result.Errors)
+	}
+
+	team := result.Data.(map[string]interface{})["team"].(map[string]interface{})
+	return counter.n.Load(), len(team["members"].([]interface{}))
+}
+
+// TestTeamQueryStatementsDoNotGrowWithData guards the loaders: a query that
+// walks members, their folders and the folders' notes must take the same
+// number of statements however many rows it returns. It runs against
+// Postgres because paging relies on window functions and ILIKE.
+func TestTeamQueryStatementsDoNotGrowWithData(t *testing.T) {
+	small, smallMembers := countTeamQueryStatements(t, 2)
+	large, largeMembers := countTeamQueryStatements(t, 20)
+
+	if smallMembers != 2 || largeMembers != 20 {
+		t.Fatalf("got %d and %d members, want 2 and 20", smallMembers, largeMembers)
+	}
+	if small == 0 {
+		t.Fatal("no statements were counted")
+	}
+	if small != large {
+		t.Errorf("query took %d statements for 2 members but %d for 20", small, large)
+	}
+}
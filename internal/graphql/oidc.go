@@ -0,0 +1,135 @@
+package graphql
+
+import (
+	"errors"
+	"user-team-asset-management/internal/auth"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/oidc"
+
+	"github.com/graphql-go/graphql"
+)
+
+var errOIDCDisabled = errors.New("single sign-on is not configured")
+
+// oidcErrors are the errors of the single sign-on mutations that clients are
+// shown as they are.
+var oidcErrors = []error{
+	oidc.ErrInvalidCode,
+	oidc.ErrAccountExists,
+	oidc.ErrNoAccount,
+	oidc.ErrEmailRequired,
+	oidc.ErrIdentityTaken,
+	oidc.ErrIdentityNotFound,
+	auth.ErrAccountDeactivated,
+}
+
+func (r *Resolver) oidcMutations(t *types) graphql.Fields {
+	code := graphql.FieldConfigArgument{
+		"code": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+	}
+
+	return graphql.Fields{
+		"completeOidcLogin": &graphql.Field{
+			Type:        t.loginResponse,
+			Description: "Logs in with the code the single sign-on callback handed the frontend for flow=login.",
+			Args:        code,
+			Resolve:     r.completeOidcLogin,
+		},
+		"startOidcLink": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Returns the identity provider's login page for linking an identity to your account.",
+			Resolve:     r.startOidcLink,
+		},
+		"linkOidcIdentity": &graphql.Field{
+			Type:        t.identity,
+			Description: "Links the identity behind a flow=link code to your account.",
+			Args:        code,
+			Resolve:     r.linkOidcIdentity,
+		},
+		"unlinkExternalIdentity": &graphql.Field{
+			Type: graphql.Boolean,
+			Args: graphql.FieldConfigArgument{
+				"identityId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: r.unlinkExternalIdentity,
+		},
+	}
+}
+
+func (r *Resolver) completeOidcLogin(p graphql.ResolveParams) (interface{}, error) {
+	if r.OIDC == nil {
+		return nil, errOIDCDisabled
+	}
+
+	user, err := r.OIDC.Login(p.Args["code"].(string))
+	if err != nil {
+		return nil, oidcError(err, "failed to log in")
+	}
+	return r.loginResponse(user)
+}
+
+func (r *Resolver) startOidcLink(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	if r.OIDC == nil {
+		return nil, errOIDCDisabled
+	}
+
+	// Links are bound to the user when the code is redeemed, so the state
+	// is not needed here
+	authURL, _, err := r.OIDC.Begin(p.Context, userID)
+	if err != nil {
+		return nil, errors.New("failed to reach the identity provider")
+	}
+	return authURL, nil
+}
+
+func (r *Resolver) linkOidcIdentity(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	if r.OIDC == nil {
+		return nil, errOIDCDisabled
+	}
+
+	identity, err := r.OIDC.Link(userID, p.Args["code"].(string))
+	if err != nil {
+		return nil, oidcError(err, "failed to link identity")
+	}
+	return identity, nil
+}
+
+func (r *Resolver) unlinkExternalIdentity(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	if r.OIDC == nil {
+		return nil, errOIDCDisabled
+	}
+
+	if err := r.OIDC.Unlink(userID, p.Args["identityId"].(string)); err != nil {
+		return nil, oidcError(err, "failed to unlink identity")
+	}
+	return true, nil
+}
+
+func (r *Resolver) userIdentities(p graphql.ResolveParams) (interface{}, error) {
+	user := source[models.User](p)
+	if viewerID, _ := currentUserID(p); viewerID != user.ID || r.OIDC == nil {
+		return nil, nil
+	}
+	return r.OIDC.Identities(user.ID)
+}
+
+func oidcError(err error, fallback string) error {
+	for _, target := range oidcErrors {
+		if errors.Is(err, target) {
+			return err
+		}
+	}
+	return errors.New(fallback)
+}
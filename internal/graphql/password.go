@@ -0,0 +1,104 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"user-team-asset-management/internal/auth"
+	"user-team-asset-management/internal/models"
+
+	"github.com/graphql-go/graphql"
+)
+
+// resetThrottledError carries the same extensions as a throttled login.
+type resetThrottledError struct {
+	*loginThrottledError
+}
+
+func (e *resetThrottledError) Error() string {
+	return fmt.Sprintf("too many password reset requests, try again in %s", e.RetryAfter)
+}
+
+func (r *Resolver) passwordMutations(t *types) graphql.Fields {
+	return graphql.Fields{
+		"changePassword": &graphql.Field{
+			Type:        t.loginResponse,
+			Description: "Signs out every session, including the current one, and returns tokens for a new session.",
+			Args: graphql.FieldConfigArgument{
+				"currentPassword": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"newPassword":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: r.changePassword,
+		},
+		"requestPasswordReset": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "Mails a reset link if the address has an account. Returns true unless requests for the address or client are throttled.",
+			Args: graphql.FieldConfigArgument{
+				"email": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: r.requestPasswordReset,
+		},
+		"resetPassword": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "Sets a new password with a token from requestPasswordReset and signs out every session.",
+			Args: graphql.FieldConfigArgument{
+				"token":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"newPassword": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: r.resetPassword,
+		},
+	}
+}
+
+func (r *Resolver) changePassword(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	currentPassword := p.Args["currentPassword"].(string)
+	newPassword := p.Args["newPassword"].(string)
+
+	if err := r.Passwords.Change(userID, currentPassword, newPassword, clientIP(p.Context)); err != nil {
+		var throttled *auth.LoginThrottledError
+		if errors.As(err, &throttled) {
+			return nil, &loginThrottledError{throttled}
+		}
+		return nil, err
+	}
+
+	var user models.User
+	if err := r.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		return nil, errors.New("user not found")
+	}
+	pair, err := r.Sessions.Issue(user.ID, user.Role)
+	if err != nil {
+		return nil, err
+	}
+	return tokenResponse(pair, user), nil
+}
+
+func (r *Resolver) requestPasswordReset(p graphql.ResolveParams) (interface{}, error) {
+	email := p.Args["email"].(string)
+
+	if err := r.Passwords.RequestReset(email, clientIP(p.Context)); err != nil {
+		var throttled *auth.LoginThrottledError
+		if errors.As(err, &throttled) {
+			return nil, &resetThrottledError{&loginThrottledError{throttled}}
+		}
+		return nil, errors.New("failed to request a password reset")
+	}
+	return true, nil
+}
+
+func (r *Resolver) resetPassword(p graphql.ResolveParams) (interface{}, error) {
+	token := p.Args["token"].(string)
+	newPassword := p.Args["newPassword"].(string)
+
+	if err := r.Passwords.Reset(token, newPassword); err != nil {
+		var policyErr *auth.PasswordPolicyError
+		if errors.Is(err, auth.ErrInvalidResetToken) || errors.As(err, &policyErr) {
+			return nil, err
+		}
+		return nil, errors.New("failed to reset password")
+	}
+	return true, nil
+}
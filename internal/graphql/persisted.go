@@ -0,0 +1,98 @@
+package graphql
+
+import (
+	"fmt"
+	"user-team-asset-management/internal/logger"
+	"user-team-asset-management/internal/persisted"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// persistedQuery is the persistedQuery request extension of Apollo's
+// automatic persisted queries.
+type persistedQuery struct {
+	Version    int    `json:"version"`
+	Sha256Hash string `json:"sha256Hash"`
+}
+
+// resolvePersisted fills in the document of a request that only sends the
+// hash of a persisted query, and enforces the allowlist. It reports whether
+// the request's document should be registered once it has been validated.
+//
+// A client sending an unknown hash is told PERSISTED_QUERY_NOT_FOUND and
+// retries with the full document, which is then registered if the client is
+// authenticated. With the allowlist, unknown documents are refused with
+// PERSISTED_QUERY_NOT_IN_LIST whether they are sent as a hash or in full.
+func (r *Resolver) resolvePersisted(req *Request) (bool, []gqlerrors.FormattedError) {
+	store := r.PersistedQueries
+	extension := req.Extensions.PersistedQuery
+
+	if extension == nil {
+		if store == nil || !store.Allowlist || req.Query == "" {
+			return false, nil
+		}
+		_, known, err := store.Get(persisted.Hash(req.Query))
+		if err != nil {
+			return false, persistedError(err)
+		}
+		if !known {
+			return false, persistedQueryErrors("PersistedQueryNotInList", "PERSISTED_QUERY_NOT_IN_LIST")
+		}
+		return false, nil
+	}
+
+	if store == nil {
+		return false, persistedQueryErrors("PersistedQueryNotSupported", "PERSISTED_QUERY_NOT_SUPPORTED")
+	}
+	if extension.Version != 1 {
+		return false, persistedQueryErrors("Unsupported persisted query version", "BAD_USER_INPUT")
+	}
+
+	query, known, err := store.Get(extension.Sha256Hash)
+	if err != nil {
+		return false, persistedError(err)
+	}
+
+	if req.Query == "" {
+		if known {
+			req.Query = query
+			return false, nil
+		}
+		if store.Allowlist {
+			return false, persistedQueryErrors("PersistedQueryNotInList", "PERSISTED_QUERY_NOT_IN_LIST")
+		}
+		return false, persistedQueryErrors("PersistedQueryNotFound", "PERSISTED_QUERY_NOT_FOUND")
+	}
+
+	if persisted.Hash(req.Query) != extension.Sha256Hash {
+		return false, persistedQueryErrors("provided sha does not match query", "BAD_USER_INPUT")
+	}
+	if known {
+		return false, nil
+	}
+	if store.Allowlist {
+		return false, persistedQueryErrors("PersistedQueryNotInList", "PERSISTED_QUERY_NOT_IN_LIST")
+	}
+	return true, nil
+}
+
+// registerPersisted stores a validated document sent with its hash. The
+// request still runs if that fails; the client just has to send the full
+// document again next time.
+func (r *Resolver) registerPersisted(query string) {
+	if err := r.PersistedQueries.Put(query); err != nil {
+		logger.DefaultLogger.Error(fmt.Sprintf("Failed to register persisted query: %v", err))
+	}
+}
+
+func persistedQueryErrors(message, code string) []gqlerrors.FormattedError {
+	return []gqlerrors.FormattedError{{
+		Message:    message,
+		Extensions: map[string]interface{}{"code": code},
+	}}
+}
+
+func persistedError(err error) []gqlerrors.FormattedError {
+	logger.DefaultLogger.Error(fmt.Sprintf("Failed to look up persisted query: %v", err))
+	return persistedQueryErrors("failed to look up persisted query", "INTERNAL_SERVER_ERROR")
+}
@@ -0,0 +1,161 @@
+package graphql
+
+import (
+	"errors"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/policy"
+	"user-team-asset-management/internal/utils"
+
+	"github.com/graphql-go/graphql"
+	"gorm.io/gorm"
+)
+
+func (r *Resolver) roleQueries(t *types) graphql.Fields {
+	return graphql.Fields{
+		"roles": &graphql.Field{
+			Type:    graphql.NewList(t.role),
+			Resolve: r.roles,
+		},
+		"userRoles": &graphql.Field{
+			Type: t.userRoles,
+			Args: graphql.FieldConfigArgument{
+				"userId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: r.userRoles,
+		},
+	}
+}
+
+func (r *Resolver) roleMutations(t *types) graphql.Fields {
+	return graphql.Fields{
+		"assignRole": &graphql.Field{
+			Type: t.roleAssignment,
+			Args: graphql.FieldConfigArgument{
+				"userId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"role":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"teamId": &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: r.assignRole,
+		},
+		"revokeRole": &graphql.Field{
+			Type: graphql.Boolean,
+			Args: graphql.FieldConfigArgument{
+				"userId":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"assignmentId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: r.revokeRole,
+		},
+	}
+}
+
+func (r *Resolver) roles(p graphql.ResolveParams) (interface{}, error) {
+	if _, err := currentUserID(p); err != nil {
+		return nil, err
+	}
+
+	roles := make([]map[string]interface{}, 0)
+	for _, name := range policy.Roles() {
+		roles = append(roles, map[string]interface{}{
+			"role":        name,
+			"permissions": policy.Permissions(name),
+		})
+	}
+	return roles, nil
+}
+
+func (r *Resolver) userRoles(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	targetID := p.Args["userId"].(string)
+
+	if userID != targetID {
+		if err := r.authorize(userID, policy.RoleAssign, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	var user models.User
+	if err := r.DB.Where("id = ?", targetID).First(&user).Error; err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	var assignments []models.RoleAssignment
+	if err := r.DB.Where("user_id = ?", targetID).Find(&assignments).Error; err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"role":        user.Role,
+		"assignments": assignments,
+	}, nil
+}
+
+func (r *Resolver) assignRole(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	targetID := p.Args["userId"].(string)
+	role := p.Args["role"].(string)
+	teamID, _ := p.Args["teamId"].(string)
+
+	if err := r.authorize(userID, policy.RoleAssign, teamID); err != nil {
+		return nil, err
+	}
+	if !policy.IsRole(role) {
+		return nil, errors.New("unknown role")
+	}
+	if teamID == "" && !policy.IsGlobalRole(role) {
+		return nil, errors.New("role " + role + " can only be assigned within a team")
+	}
+
+	var count int64
+	if err := r.DB.Model(&models.User{}).Where("id = ?", targetID).Count(&count).Error; err != nil {
+		return nil, errors.New("failed to look up user")
+	}
+	if count == 0 {
+		return nil, errors.New("user not found")
+	}
+	if teamID != "" {
+		if _, err := r.findTeam(teamID); err != nil {
+			return nil, err
+		}
+	}
+
+	assignment := models.RoleAssignment{
+		ID:     utils.GenerateID(),
+		UserID: targetID,
+		Role:   role,
+		TeamID: teamID,
+	}
+	if err := r.DB.Create(&assignment).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, errors.New("role is already assigned")
+		}
+		return nil, errors.New("failed to assign role")
+	}
+	return assignment, nil
+}
+
+func (r *Resolver) revokeRole(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var assignment models.RoleAssignment
+	if err := r.DB.Where("id = ? AND user_id = ?", p.Args["assignmentId"], p.Args["userId"]).First(&assignment).Error; err != nil {
+		return nil, errors.New("role assignment not found")
+	}
+
+	if err := r.authorize(userID, policy.RoleAssign, assignment.TeamID); err != nil {
+		return nil, err
+	}
+
+	if err := r.DB.Delete(&assignment).Error; err != nil {
+		return nil, errors.New("failed to revoke role")
+	}
+	return true, nil
+}
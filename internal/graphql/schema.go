@@ -2,80 +2,117 @@ package graphql
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"user-team-asset-management/internal/access"
+	"user-team-asset-management/internal/accounts"
+	"user-team-asset-management/internal/assets"
 	"user-team-asset-management/internal/auth"
+	"user-team-asset-management/internal/events"
+	"user-team-asset-management/internal/mail"
 	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/oidc"
+	"user-team-asset-management/internal/pagination"
+	"user-team-asset-management/internal/persisted"
+	"user-team-asset-management/internal/policy"
+	"user-team-asset-management/internal/search"
 	"user-team-asset-management/internal/utils"
 
 	"github.com/graphql-go/graphql"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 type Resolver struct {
 	DB        *gorm.DB
-	JWTSecret string
+	Sessions  *auth.SessionManager
+	Authz     policy.Authorizer
+	Access    *access.Resolver
+	Search    *search.Service
+	Passwords *auth.PasswordManager
+	Logins    *auth.LoginGuard
+	TwoFactor *auth.TwoFactorManager
+	Accounts  *accounts.Service
+	Assets    *assets.Service
+	Events    *events.Bus
+	Limits    Limits
+
+	// PersistedQueries enables automatic persisted queries when set.
+	PersistedQueries *persisted.Store
+
+	// OIDC enables single sign-on when set.
+	OIDC *oidc.Service
 }
 
+// CreateSchema builds the schema. Queries and mutations mirror the REST
+// routes and enforce the same permission checks; each area of the API adds
+// its fields from its own file. Only login, verifyTwoFactor, refreshToken,
+// requestPasswordReset, resetPassword, verifyEmail and completeOidcLogin can
+// be used without an access token; all but login carry their own credential.
 func (r *Resolver) CreateSchema() (graphql.Schema, error) {
-	userType := graphql.NewObject(graphql.ObjectConfig{
-		Name: "User",
-		Fields: graphql.Fields{
-			"userId":   &graphql.Field{Type: graphql.String},
-			"username": &graphql.Field{Type: graphql.String},
-			"email":    &graphql.Field{Type: graphql.String},
-			"role":     &graphql.Field{Type: graphql.String},
-		},
-	})
+	t := r.newTypes()
 
-	loginResponseType := graphql.NewObject(graphql.ObjectConfig{
-		Name: "LoginResponse",
-		Fields: graphql.Fields{
-			"token": &graphql.Field{Type: graphql.String},
-			"user":  &graphql.Field{Type: userType},
+	queries := graphql.Fields{
+		"me": &graphql.Field{
+			Type:    t.user,
+			Resolve: r.me,
 		},
-	})
+		"fetchUsers": &graphql.Field{
+			Type:    t.userPage,
+			Args:    pageArgs("username", "email", "role"),
+			Resolve: r.fetchUsers,
+		},
+	}
 
-	queryType := graphql.NewObject(graphql.ObjectConfig{
-		Name: "Query",
-		Fields: graphql.Fields{
-			"fetchUsers": &graphql.Field{
-				Type:    graphql.NewList(userType),
-				Resolve: r.fetchUsers,
+	mutations := graphql.Fields{
+		"createUser": &graphql.Field{
+			Type: t.user,
+			Args: graphql.FieldConfigArgument{
+				"username": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"email":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"password": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"role":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
 			},
+			Resolve: r.createUser,
 		},
-	})
-
-	mutationType := graphql.NewObject(graphql.ObjectConfig{
-		Name: "Mutation",
-		Fields: graphql.Fields{
-			"createUser": &graphql.Field{
-				Type: userType,
-				Args: graphql.FieldConfigArgument{
-					"username": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
-					"email":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
-					"password": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
-					"role":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
-				},
-				Resolve: r.createUser,
+		"login": &graphql.Field{
+			Type: t.loginResponse,
+			Args: graphql.FieldConfigArgument{
+				"email":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"password": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
 			},
-			"login": &graphql.Field{
-				Type: loginResponseType,
-				Args: graphql.FieldConfigArgument{
-					"email":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
-					"password": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
-				},
-				Resolve: r.login,
+			Resolve: r.login,
+		},
+		"refreshToken": &graphql.Field{
+			Type: t.loginResponse,
+			Args: graphql.FieldConfigArgument{
+				"refreshToken": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
 			},
-			"logout": &graphql.Field{
-				Type:    graphql.String,
-				Resolve: r.logout,
+			Resolve: r.refreshToken,
+		},
+		"logout": &graphql.Field{
+			Type: graphql.String,
+			Args: graphql.FieldConfigArgument{
+				"refreshToken": &graphql.ArgumentConfig{Type: graphql.String},
 			},
+			Resolve: r.logout,
 		},
-	})
+	}
+
+	for _, fields := range []graphql.Fields{r.teamQueries(t), r.assetQueries(t), r.historyQueries(t), r.roleQueries(t), r.securityQueries(t)} {
+		for name, field := range fields {
+			queries[name] = field
+		}
+	}
+	for _, fields := range []graphql.Fields{r.teamMutations(t), r.assetMutations(t), r.historyMutations(t), r.roleMutations(t), r.passwordMutations(t), r.securityMutations(t), r.twoFactorMutations(t), r.accountMutations(t), r.oidcMutations(t)} {
+		for name, field := range fields {
+			mutations[name] = field
+		}
+	}
 
 	return graphql.NewSchema(graphql.SchemaConfig{
-		Query:    queryType,
-		Mutation: mutationType,
+		Query:        graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: queries}),
+		Mutation:     graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: mutations}),
+		Subscription: graphql.NewObject(graphql.ObjectConfig{Name: "Subscription", Fields: r.subscriptions(t)}),
 	})
 }
 
@@ -85,11 +122,20 @@ func (r *Resolver) createUser(p graphql.ResolveParams) (interface{}, error) {
 	password := p.Args["password"].(string)
 	role := p.Args["role"].(string)
 
-	if role != "manager" && role != "member" {
-		return nil, errors.New("role must be 'manager' or 'member'")
+	if !policy.IsGlobalRole(role) {
+		return nil, fmt.Errorf("unknown role %q", role)
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err := r.authorizeCreateUser(p, role); err != nil {
+		return nil, err
+	}
+
+	email, err := mail.NormalizeAddress(email)
+	if err != nil {
+		return nil, accounts.ErrInvalidEmail
+	}
+
+	hashedPassword, err := r.Passwords.Hash(password, username, email)
 	if err != nil {
 		return nil, err
 	}
@@ -99,51 +145,195 @@ func (r *Resolver) createUser(p graphql.ResolveParams) (interface{}, error) {
 		ID:           userID,
 		Username:     username,
 		Email:        email,
-		PasswordHash: string(hashedPassword),
+		PasswordHash: hashedPassword,
 		Role:         role,
 	}
 
-	if err := r.DB.Create(&user).Error; err != nil {
-		return nil, err
+	if err := r.Accounts.Register(&user); err != nil {
+		return nil, accountError(err, "failed to create user")
 	}
 
 	return user, nil
 }
 
+// authorizeCreateUser requires user.create, plus role.assign for any role
+// above member. The first admin is seeded at startup instead, see
+// ADMIN_EMAIL in the README.
+func (r *Resolver) authorizeCreateUser(p graphql.ResolveParams, role string) error {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return err
+	}
+
+	if err := r.authorize(userID, policy.UserCreate, ""); err != nil {
+		return err
+	}
+	if policy.Elevated(role) {
+		return r.authorize(userID, policy.RoleAssign, "")
+	}
+	return nil
+}
+
 func (r *Resolver) login(p graphql.ResolveParams) (interface{}, error) {
 	email := p.Args["email"].(string)
 	password := p.Args["password"].(string)
 
-	var user models.User
-	if err := r.DB.Where("email = ?", email).First(&user).Error; err != nil {
-		return nil, errors.New("invalid credentials")
+	user, err := r.Logins.Authenticate(email, password, clientIP(p.Context))
+	if err != nil {
+		return nil, loginError(err, auth.ErrInvalidCredentials, auth.ErrAccountDeactivated)
 	}
+	return r.loginResponse(user)
+}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
-		return nil, errors.New("invalid credentials")
+// loginResponse signs in a user whose credentials have been checked, or asks
+// for the second factor first when they have it enabled.
+func (r *Resolver) loginResponse(user *models.User) (interface{}, error) {
+	if user.TwoFactorEnabled() {
+		token, expiresAt, err := r.TwoFactor.Challenge(user.ID)
+		if err != nil {
+			return nil, errors.New("failed to log in")
+		}
+		return map[string]interface{}{
+			"twoFactorRequired":  true,
+			"challengeToken":     token,
+			"challengeExpiresAt": expiresAt,
+		}, nil
+	}
+
+	pair, err := r.Sessions.Issue(user.ID, user.Role)
+	if err != nil {
+		return nil, err
 	}
 
-	token, err := auth.GenerateToken(user.ID, user.Role, r.JWTSecret)
+	response := tokenResponse(pair, *user)
+	response["twoFactorEnrollmentRequired"] = r.TwoFactor.Required(user.Role)
+	return response, nil
+}
+
+func (r *Resolver) refreshToken(p graphql.ResolveParams) (interface{}, error) {
+	refreshToken := p.Args["refreshToken"].(string)
+
+	pair, user, err := r.Sessions.Refresh(refreshToken)
 	if err != nil {
 		return nil, err
 	}
 
+	return tokenResponse(pair, *user), nil
+}
+
+func tokenResponse(pair *auth.TokenPair, user models.User) map[string]interface{} {
 	return map[string]interface{}{
-		"token": token,
-		"user":  user,
+		"token":        pair.AccessToken,
+		"refreshToken": pair.RefreshToken,
+		"expiresAt":    pair.ExpiresAt,
+		"user":         user,
+
+		"twoFactorRequired": false,
+	}
+}
+
+func (r *Resolver) me(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	return r.userByID(userID)
+}
+
+func (r *Resolver) userByID(userID string) (interface{}, error) {
+	var user models.User
+	if err := r.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+// userFolders lists the folders of the user being resolved. Like
+// GET /api/users/:userId/assets, other users' folders need user.assets.read,
+// or team.assets.read on a team the user belongs to.
+func (r *Resolver) userFolders(p graphql.ResolveParams) (interface{}, error) {
+	viewerID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	user := source[models.User](p)
+	l := r.loaders(p)
+
+	pages, err := l.folderPagesFor(p)
+	if err != nil {
+		return nil, err
+	}
+	pages.queue(user.ID)
+	if viewerID != user.ID {
+		l.memberTeamIDs.queue(user.ID)
+	}
+
+	return func() (interface{}, error) {
+		if viewerID != user.ID {
+			if err := l.canViewAssetsOf(viewerID, user.ID); err != nil {
+				return nil, err
+			}
+		}
+		return pages.get(user.ID)
 	}, nil
 }
 
 func (r *Resolver) fetchUsers(p graphql.ResolveParams) (interface{}, error) {
-	var users []models.User
-	if err := r.DB.Find(&users).Error; err != nil {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.authorize(userID, policy.UserList, ""); err != nil {
+		return nil, err
+	}
+
+	req, err := pagination.ParseRequest(argString(p.Args), pagination.Users)
+	if err != nil {
 		return nil, err
 	}
-	return users, nil
+	return pagination.Paginate(r.DB.Model(&models.User{}), req, pagination.Users)
+}
+
+// argString adapts GraphQL arguments to the string lookups used by the
+// pagination package.
+func argString(args map[string]interface{}) func(string) string {
+	return func(name string) string {
+		switch v := args[name].(type) {
+		case string:
+			return v
+		case int:
+			return strconv.Itoa(v)
+		}
+		return ""
+	}
 }
 
 func (r *Resolver) logout(p graphql.ResolveParams) (interface{}, error) {
-	// With JWT, logout is typically handled client-side by removing the token
-	// Server-side logout would require token blacklisting which is not implemented here
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Revoking the session invalidates its refresh tokens and every access
+	// token issued for it, so AuthMiddleware rejects them immediately. A
+	// refresh token may name another session, but only one of the caller's.
+	refreshToken, _ := p.Args["refreshToken"].(string)
+	sid := sessionID(p.Context)
+	if refreshToken == "" && sid == "" {
+		return nil, errors.New("refreshToken is required")
+	}
+	if refreshToken != "" {
+		if err := r.Sessions.RevokeByRefreshToken(userID, refreshToken); err != nil {
+			if errors.Is(err, auth.ErrInvalidRefreshToken) {
+				return nil, errors.New("invalid refresh token")
+			}
+			return nil, errors.New("failed to log out")
+		}
+	}
+	if sid != "" {
+		if err := r.Sessions.Revoke(sid); err != nil {
+			return nil, errors.New("failed to log out")
+		}
+	}
 	return "Successfully logged out", nil
 }
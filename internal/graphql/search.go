@@ -0,0 +1,56 @@
+package graphql
+
+import (
+	"errors"
+	"user-team-asset-management/internal/search"
+
+	"github.com/graphql-go/graphql"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// search mirrors SearchHandler.Search.
+func (r *Resolver) search(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	itemType, _ := p.Args["type"].(string)
+	if itemType != "" && itemType != search.TypeNote && itemType != search.TypeFolder {
+		return nil, errors.New("type must be 'note' or 'folder'")
+	}
+
+	limit := defaultSearchLimit
+	if n, ok := p.Args["limit"].(int); ok {
+		limit = n
+	}
+	if limit < 1 || limit > maxSearchLimit {
+		return nil, errors.New("limit must be between 1 and 100")
+	}
+	offset, _ := p.Args["offset"].(int)
+	if offset < 0 {
+		return nil, errors.New("offset must be a non-negative integer")
+	}
+
+	results, total, err := r.Search.Search(search.Query{
+		UserID: userID,
+		Text:   p.Args["q"].(string),
+		Type:   itemType,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		return nil, errors.New("search failed")
+	}
+
+	return map[string]interface{}{
+		"results":    results,
+		"totalCount": total,
+		"limit":      limit,
+		"offset":     offset,
+	}, nil
+}
@@ -0,0 +1,95 @@
+package graphql
+
+import (
+	"errors"
+	"math"
+	"user-team-asset-management/internal/auth"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/pagination"
+	"user-team-asset-management/internal/policy"
+
+	"github.com/graphql-go/graphql"
+	"gorm.io/gorm"
+)
+
+// loginThrottledError exposes how long a throttled client has to wait
+// under the error's extensions, in whole seconds.
+type loginThrottledError struct {
+	*auth.LoginThrottledError
+}
+
+func (e *loginThrottledError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":       "LOGIN_THROTTLED",
+		"retryAfter": int(math.Ceil(e.RetryAfter.Seconds())),
+		"locked":     e.Locked,
+	}
+}
+
+// loginError passes on the errors a client logging in may see, and hides
+// any other.
+func loginError(err error, expected ...error) error {
+	var throttled *auth.LoginThrottledError
+	if errors.As(err, &throttled) {
+		return &loginThrottledError{throttled}
+	}
+	for _, target := range expected {
+		if errors.Is(err, target) {
+			return err
+		}
+	}
+	return errors.New("failed to log in")
+}
+
+func (r *Resolver) securityQueries(t *types) graphql.Fields {
+	return graphql.Fields{
+		"auditEntries": &graphql.Field{
+			Type:    t.auditPage,
+			Args:    pageArgs("action", "actorId", "targetUserId"),
+			Resolve: r.auditEntries,
+		},
+	}
+}
+
+func (r *Resolver) securityMutations(t *types) graphql.Fields {
+	return graphql.Fields{
+		"unlockUser": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "Clears the user's failed logins. Returns whether the account was blocked.",
+			Args: graphql.FieldConfigArgument{
+				"userId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: r.unlockUser,
+		},
+	}
+}
+
+func (r *Resolver) auditEntries(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.authorize(userID, policy.AuditRead, ""); err != nil {
+		return nil, err
+	}
+	return listPage(p, r.DB.Model(&models.AuditEntry{}), pagination.AuditEntries)
+}
+
+func (r *Resolver) unlockUser(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.authorize(userID, policy.UserUnlock, ""); err != nil {
+		return nil, err
+	}
+
+	blocked, err := r.Logins.Unlock(p.Args["userId"].(string), userID, clientIP(p.Context))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("user not found")
+	}
+	if err != nil {
+		return nil, errors.New("failed to unlock user")
+	}
+	return blocked, nil
+}
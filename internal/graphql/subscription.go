@@ -0,0 +1,267 @@
+package graphql
+
+import (
+	"user-team-asset-management/internal/events"
+	"user-team-asset-management/internal/policy"
+
+	"github.com/graphql-go/graphql"
+)
+
+var eventAction = graphql.NewEnum(graphql.EnumConfig{
+	Name: "EventAction",
+	Values: graphql.EnumValueConfigMap{
+		events.ActionCreated:          &graphql.EnumValueConfig{Value: events.ActionCreated},
+		events.ActionUpdated:          &graphql.EnumValueConfig{Value: events.ActionUpdated},
+		events.ActionMoved:            &graphql.EnumValueConfig{Value: events.ActionMoved},
+		events.ActionDeleted:          &graphql.EnumValueConfig{Value: events.ActionDeleted},
+		events.ActionRestored:         &graphql.EnumValueConfig{Value: events.ActionRestored},
+		events.ActionNoteCreated:      &graphql.EnumValueConfig{Value: events.ActionNoteCreated},
+		events.ActionNoteUpdated:      &graphql.EnumValueConfig{Value: events.ActionNoteUpdated},
+		events.ActionNoteDeleted:      &graphql.EnumValueConfig{Value: events.ActionNoteDeleted},
+		events.ActionNoteRestored:     &graphql.EnumValueConfig{Value: events.ActionNoteRestored},
+		events.ActionSubfolderAdded:   &graphql.EnumValueConfig{Value: events.ActionSubfolderAdded},
+		events.ActionSubfolderRemoved: &graphql.EnumValueConfig{Value: events.ActionSubfolderRemoved},
+		events.ActionMemberAdded:      &graphql.EnumValueConfig{Value: events.ActionMemberAdded},
+		events.ActionMemberRemoved:    &graphql.EnumValueConfig{Value: events.ActionMemberRemoved},
+		events.ActionManagerAdded:     &graphql.EnumValueConfig{Value: events.ActionManagerAdded},
+		events.ActionManagerRemoved:   &graphql.EnumValueConfig{Value: events.ActionManagerRemoved},
+	},
+})
+
+// subscriptions need the same access to start as reading the item does, and
+// that access is checked again before every event, so a subscriber who loses
+// it stops receiving events and the subscription completes. The items on an
+// event are loaded when it is delivered and are null once deleted.
+func (r *Resolver) subscriptions(t *types) graphql.Fields {
+	actor := &graphql.Field{Type: t.user, Resolve: r.eventActor}
+
+	noteEvent := graphql.NewObject(graphql.ObjectConfig{
+		Name: "NoteEvent",
+		Fields: graphql.Fields{
+			"action":     &graphql.Field{Type: eventAction},
+			"noteId":     &graphql.Field{Type: graphql.String},
+			"folderId":   &graphql.Field{Type: graphql.String},
+			"note":       &graphql.Field{Type: t.note, Resolve: r.eventNote},
+			"actor":      actor,
+			"occurredAt": &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	folderEvent := graphql.NewObject(graphql.ObjectConfig{
+		Name: "FolderEvent",
+		Fields: graphql.Fields{
+			"action":   &graphql.Field{Type: eventAction},
+			"folderId": &graphql.Field{Type: graphql.String},
+			"noteId": &graphql.Field{
+				Type:    graphql.String,
+				Resolve: optionalID(func(e events.Event) string { return e.NoteID }),
+			},
+			"subfolderId": &graphql.Field{
+				Type:    graphql.String,
+				Resolve: optionalID(func(e events.Event) string { return e.SubfolderID }),
+			},
+			"folder": &graphql.Field{
+				Type: t.folder,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.eventFolder(p, source[events.Event](p).FolderID)
+				},
+			},
+			"note": &graphql.Field{Type: t.note, Resolve: r.eventNote},
+			"subfolder": &graphql.Field{
+				Type: t.folder,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.eventFolder(p, source[events.Event](p).SubfolderID)
+				},
+			},
+			"actor":      actor,
+			"occurredAt": &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	teamMembershipEvent := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TeamMembershipEvent",
+		Fields: graphql.Fields{
+			"action": &graphql.Field{Type: eventAction},
+			"teamId": &graphql.Field{Type: graphql.String},
+			"userId": &graphql.Field{Type: graphql.String},
+			"team": &graphql.Field{
+				Type: t.team,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.findTeam(source[events.Event](p).TeamID)
+				},
+			},
+			"user": &graphql.Field{
+				Type: t.user,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.userByID(source[events.Event](p).UserID)
+				},
+			},
+			"actor":      actor,
+			"occurredAt": &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	return graphql.Fields{
+		"noteUpdated": &graphql.Field{
+			Type:        graphql.NewNonNull(noteEvent),
+			Description: "Edits, revision restores, deletion and restores of the note.",
+			Args: graphql.FieldConfigArgument{
+				"noteId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Subscribe: r.subscribeNote,
+			Resolve:   eventSource,
+		},
+		"folderChanged": &graphql.Field{
+			Type:        graphql.NewNonNull(folderEvent),
+			Description: "Changes to the folder and to the notes and subfolders directly inside it.",
+			Args: graphql.FieldConfigArgument{
+				"folderId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Subscribe: r.subscribeFolder,
+			Resolve:   eventSource,
+		},
+		"teamMembershipChanged": &graphql.Field{
+			Type:        graphql.NewNonNull(teamMembershipEvent),
+			Description: "Members and managers added to or removed from the team.",
+			Args: graphql.FieldConfigArgument{
+				"teamId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Subscribe: r.subscribeTeam,
+			Resolve:   eventSource,
+		},
+	}
+}
+
+// eventSource resolves a subscription field to the event being delivered,
+// which graphql-go passes as the source.
+func eventSource(p graphql.ResolveParams) (interface{}, error) {
+	return p.Source, nil
+}
+
+// optionalID resolves IDs that only some events carry to null when absent.
+func optionalID(id func(events.Event) string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		if value := id(source[events.Event](p)); value != "" {
+			return value, nil
+		}
+		return nil, nil
+	}
+}
+
+func (r *Resolver) subscribeNote(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	noteID := p.Args["noteId"].(string)
+
+	if !r.canReadNote(userID, noteID) {
+		return nil, errNoNoteAccess
+	}
+	return r.stream(p, events.NoteTopic(noteID), func() bool {
+		return r.canReadNote(userID, noteID)
+	}), nil
+}
+
+func (r *Resolver) subscribeFolder(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	folderID := p.Args["folderId"].(string)
+
+	if !r.canReadFolder(userID, folderID) {
+		return nil, errNoFolderAccess
+	}
+	return r.stream(p, events.FolderTopic(folderID), func() bool {
+		return r.canReadFolder(userID, folderID)
+	}), nil
+}
+
+func (r *Resolver) subscribeTeam(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	teamID := p.Args["teamId"].(string)
+
+	if err := r.authorize(userID, policy.TeamRead, teamID); err != nil {
+		return nil, err
+	}
+	return r.stream(p, events.TeamTopic(teamID), func() bool {
+		return r.authorize(userID, policy.TeamRead, teamID) == nil
+	}), nil
+}
+
+// stream forwards the topic's events until the subscription is cancelled or
+// allowed reports that the subscriber lost access. A DELETED event is still
+// delivered, since deleting the item is what took the access away, and ends
+// the stream.
+func (r *Resolver) stream(p graphql.ResolveParams, topic string, allowed func() bool) chan interface{} {
+	received, cancel := r.Events.Subscribe(topic)
+	out := make(chan interface{})
+
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		for {
+			select {
+			case <-p.Context.Done():
+				return
+			case event, ok := <-received:
+				if !ok {
+					return
+				}
+				deleted := event.Action == events.ActionDeleted
+				if !deleted && !allowed() {
+					return
+				}
+
+				select {
+				case out <- event:
+				case <-p.Context.Done():
+					return
+				}
+				if deleted {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+func (r *Resolver) eventActor(p graphql.ResolveParams) (interface{}, error) {
+	actorID := source[events.Event](p).ActorID
+	if actorID == "" {
+		return nil, nil
+	}
+	return r.userByID(actorID)
+}
+
+func (r *Resolver) eventNote(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	noteID := source[events.Event](p).NoteID
+
+	if noteID == "" || !r.canReadNote(userID, noteID) {
+		return nil, nil
+	}
+	return r.findNote(noteID)
+}
+
+func (r *Resolver) eventFolder(p graphql.ResolveParams, folderID string) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if folderID == "" || !r.canReadFolder(userID, folderID) {
+		return nil, nil
+	}
+	return r.findFolder(folderID)
+}
@@ -0,0 +1,422 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"user-team-asset-management/internal/events"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/pagination"
+	"user-team-asset-management/internal/policy"
+	"user-team-asset-management/internal/utils"
+
+	"github.com/graphql-go/graphql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errLastManager = errors.New("cannot remove the last manager")
+
+// rejectedUsersError reports the user IDs from createTeam that do not exist.
+// The IDs are exposed under the error's extensions.
+type rejectedUsersError struct {
+	Managers []string
+	Members  []string
+}
+
+func (e *rejectedUsersError) Error() string {
+	return "some users do not exist"
+}
+
+func (e *rejectedUsersError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"rejected": map[string][]string{
+			"managers": e.Managers,
+			"members":  e.Members,
+		},
+	}
+}
+
+func (r *Resolver) teamQueries(t *types) graphql.Fields {
+	return graphql.Fields{
+		"team": &graphql.Field{
+			Type: t.team,
+			Args: graphql.FieldConfigArgument{
+				"teamId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: r.team,
+		},
+		"teams": &graphql.Field{
+			Type:        t.teamPage,
+			Description: "Teams the current user manages or belongs to.",
+			Args:        pageArgs("name"),
+			Resolve:     r.searchTeams,
+		},
+		"allTeams": &graphql.Field{
+			Type:    t.teamPage,
+			Args:    pageArgs("name"),
+			Resolve: r.allTeams,
+		},
+		"myTeams": &graphql.Field{
+			Type:    t.myTeams,
+			Resolve: r.myTeams,
+		},
+	}
+}
+
+func (r *Resolver) teamMutations(t *types) graphql.Fields {
+	membership := graphql.FieldConfigArgument{
+		"teamId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+		"userId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+	}
+
+	return graphql.Fields{
+		"createTeam": &graphql.Field{
+			Type: t.team,
+			Args: graphql.FieldConfigArgument{
+				"teamName":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"managerIds": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+				"memberIds":  &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			},
+			Resolve: r.createTeam,
+		},
+		"addTeamMember": &graphql.Field{
+			Type:    t.team,
+			Args:    membership,
+			Resolve: r.addTeamMember,
+		},
+		"removeTeamMember": &graphql.Field{
+			Type:    t.team,
+			Args:    membership,
+			Resolve: r.removeTeamMember,
+		},
+		"addTeamManager": &graphql.Field{
+			Type:    t.team,
+			Args:    membership,
+			Resolve: r.addTeamManager,
+		},
+		"removeTeamManager": &graphql.Field{
+			Type:    t.team,
+			Args:    membership,
+			Resolve: r.removeTeamManager,
+		},
+	}
+}
+
+func (r *Resolver) team(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	teamID := p.Args["teamId"].(string)
+
+	if err := r.authorize(userID, policy.TeamRead, teamID); err != nil {
+		return nil, err
+	}
+	return r.loaders(p).teams.thunk(teamID), nil
+}
+
+func (r *Resolver) searchTeams(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.DB.Model(&models.Team{}).Where(`
+		teams.id IN (
+			SELECT team_id FROM team_managers WHERE user_id = ?
+			UNION
+			SELECT team_id FROM team_members WHERE user_id = ?
+		)
+	`, userID, userID)
+
+	return listPage(p, query, pagination.Teams)
+}
+
+func (r *Resolver) allTeams(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.authorize(userID, policy.TeamListAll, ""); err != nil {
+		return nil, err
+	}
+
+	return listPage(p, r.DB.Model(&models.Team{}), pagination.Teams)
+}
+
+func (r *Resolver) myTeams(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var managerTeams []models.Team
+	if err := r.DB.Joins("JOIN team_managers ON teams.id = team_managers.team_id").
+		Where("team_managers.user_id = ?", userID).
+		Find(&managerTeams).Error; err != nil {
+		return nil, err
+	}
+
+	var memberTeams []models.Team
+	if err := r.DB.Joins("JOIN team_members ON teams.id = team_members.team_id").
+		Where("team_members.user_id = ?", userID).
+		Find(&memberTeams).Error; err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"managerTeams": managerTeams,
+		"memberTeams":  memberTeams,
+	}, nil
+}
+
+// teamManagers and teamMembers need team.read on the team being resolved,
+// since team lists such as allTeams do not imply it.
+func (r *Resolver) teamManagers(p graphql.ResolveParams) (interface{}, error) {
+	return r.teamUsers(p, "team_managers")
+}
+
+func (r *Resolver) teamMembers(p graphql.ResolveParams) (interface{}, error) {
+	return r.teamUsers(p, "team_members")
+}
+
+func (r *Resolver) teamUsers(p graphql.ResolveParams, table string) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	team := source[models.Team](p)
+	l := r.loaders(p)
+
+	if err := l.authorize(userID, policy.TeamRead, team.ID); err != nil {
+		return nil, err
+	}
+	if table == "team_managers" {
+		return l.teamManagers.thunk(team.ID), nil
+	}
+	return l.teamMembers.thunk(team.ID), nil
+}
+
+func (r *Resolver) createTeam(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.authorize(userID, policy.TeamCreate, ""); err != nil {
+		return nil, err
+	}
+
+	// The creator always manages the team
+	managerIDs := uniqueIDs(append([]string{userID}, stringList(p.Args["managerIds"])...))
+	memberIDs := uniqueIDs(stringList(p.Args["memberIds"]))
+
+	// Reject the whole request if any referenced user does not exist
+	var found []string
+	if err := r.DB.Model(&models.User{}).
+		Where("id IN ?", append(append([]string{}, managerIDs...), memberIDs...)).
+		Pluck("id", &found).Error; err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(found))
+	for _, id := range found {
+		existing[id] = true
+	}
+	rejected := &rejectedUsersError{
+		Managers: missingIDs(managerIDs, existing),
+		Members:  missingIDs(memberIDs, existing),
+	}
+	if len(rejected.Managers) > 0 || len(rejected.Members) > 0 {
+		return nil, rejected
+	}
+
+	team := models.Team{
+		ID:       utils.GenerateID(),
+		TeamName: p.Args["teamName"].(string),
+	}
+
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&team).Error; err != nil {
+			return err
+		}
+		for _, managerID := range managerIDs {
+			if err := tx.Create(&models.TeamManager{TeamID: team.ID, UserID: managerID}).Error; err != nil {
+				return err
+			}
+		}
+		for _, memberID := range memberIDs {
+			if err := tx.Create(&models.TeamMember{TeamID: team.ID, UserID: memberID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.New("failed to create team")
+	}
+
+	return team, nil
+}
+
+func (r *Resolver) addTeamMember(p graphql.ResolveParams) (interface{}, error) {
+	teamID, memberID, err := r.membershipArgs(p, policy.TeamMembersWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.DB.Create(&models.TeamMember{TeamID: teamID, UserID: memberID}).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, errors.New("user is already a member")
+		}
+		return nil, errors.New("failed to add member")
+	}
+
+	r.publishMembership(p, events.ActionMemberAdded, teamID, memberID)
+	return r.findTeam(teamID)
+}
+
+func (r *Resolver) removeTeamMember(p graphql.ResolveParams) (interface{}, error) {
+	teamID, memberID, err := r.membershipArgs(p, policy.TeamMembersWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	result := r.DB.Where("team_id = ? AND user_id = ?", teamID, memberID).Delete(&models.TeamMember{})
+	if result.Error != nil {
+		return nil, errors.New("failed to remove member")
+	}
+
+	if result.RowsAffected > 0 {
+		r.publishMembership(p, events.ActionMemberRemoved, teamID, memberID)
+	}
+	return r.findTeam(teamID)
+}
+
+func (r *Resolver) addTeamManager(p graphql.ResolveParams) (interface{}, error) {
+	teamID, managerID, err := r.membershipArgs(p, policy.TeamManagersWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.DB.Create(&models.TeamManager{TeamID: teamID, UserID: managerID}).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, errors.New("user is already a manager")
+		}
+		return nil, errors.New("failed to add manager")
+	}
+
+	r.publishMembership(p, events.ActionManagerAdded, teamID, managerID)
+	return r.findTeam(teamID)
+}
+
+func (r *Resolver) removeTeamManager(p graphql.ResolveParams) (interface{}, error) {
+	teamID, managerID, err := r.membershipArgs(p, policy.TeamManagersWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prevent removing the last manager; see TeamHandler.RemoveManager
+	var removed int64
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		var team models.Team
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", teamID).First(&team).Error; err != nil {
+			return err
+		}
+
+		var managerCount int64
+		if err := tx.Model(&models.TeamManager{}).Where("team_id = ?", teamID).Count(&managerCount).Error; err != nil {
+			return err
+		}
+		if managerCount <= 1 {
+			return errLastManager
+		}
+
+		result := tx.Where("team_id = ? AND user_id = ?", teamID, managerID).Delete(&models.TeamManager{})
+		removed = result.RowsAffected
+		return result.Error
+	})
+	if errors.Is(err, errLastManager) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.New("failed to remove manager")
+	}
+
+	if removed > 0 {
+		r.publishMembership(p, events.ActionManagerRemoved, teamID, managerID)
+	}
+	return r.findTeam(teamID)
+}
+
+func (r *Resolver) publishMembership(p graphql.ResolveParams, action, teamID, userID string) {
+	actorID, _ := currentUserID(p)
+	r.Events.MembershipChanged(action, teamID, userID, actorID)
+}
+
+// membershipArgs authorizes a membership change and checks that both the
+// team and the user exist.
+func (r *Resolver) membershipArgs(p graphql.ResolveParams, perm policy.Permission) (string, string, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return "", "", err
+	}
+	teamID := p.Args["teamId"].(string)
+	targetID := p.Args["userId"].(string)
+
+	if err := r.authorize(userID, perm, teamID); err != nil {
+		return "", "", err
+	}
+	if _, err := r.findTeam(teamID); err != nil {
+		return "", "", err
+	}
+
+	var count int64
+	if err := r.DB.Model(&models.User{}).Where("id = ?", targetID).Count(&count).Error; err != nil {
+		return "", "", errors.New("failed to look up user")
+	}
+	if count == 0 {
+		return "", "", fmt.Errorf("user %s not found", targetID)
+	}
+	return teamID, targetID, nil
+}
+
+func (r *Resolver) findTeam(teamID string) (models.Team, error) {
+	var team models.Team
+	if err := r.DB.Where("id = ?", teamID).First(&team).Error; err != nil {
+		return team, errors.New("team not found")
+	}
+	return team, nil
+}
+
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+func missingIDs(ids []string, existing map[string]bool) []string {
+	missing := []string{}
+	for _, id := range ids {
+		if !existing[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// stringList converts a [String] argument into a slice.
+func stringList(arg interface{}) []string {
+	items, _ := arg.([]interface{})
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
@@ -0,0 +1,170 @@
+package graphql
+
+import (
+	"errors"
+	"user-team-asset-management/internal/auth"
+
+	"github.com/graphql-go/graphql"
+)
+
+// twoFactorErrors are the errors of the two-factor mutations that clients
+// are shown as they are.
+var twoFactorErrors = []error{
+	auth.ErrInvalidTwoFactorCode,
+	auth.ErrTwoFactorAlreadyEnabled,
+	auth.ErrTwoFactorNotEnabled,
+	auth.ErrTwoFactorNotStarted,
+	auth.ErrTwoFactorRequired,
+	auth.ErrWrongPassword,
+}
+
+func (r *Resolver) twoFactorMutations(t *types) graphql.Fields {
+	code := &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}
+
+	return graphql.Fields{
+		"verifyTwoFactor": &graphql.Field{
+			Type:        t.loginResponse,
+			Description: "Completes a login with a code from the authenticator app or a recovery code.",
+			Args: graphql.FieldConfigArgument{
+				"challengeToken": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"code":           code,
+			},
+			Resolve: r.verifyTwoFactor,
+		},
+		"beginTwoFactorEnrollment": &graphql.Field{
+			Type:        t.enrollment,
+			Description: "Starts enrolling an authenticator app. Two-factor authentication is on once confirmed.",
+			Args: graphql.FieldConfigArgument{
+				"password": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: r.beginTwoFactorEnrollment,
+		},
+		"confirmTwoFactorEnrollment": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "Enables two-factor authentication and returns the recovery codes, which are not shown again.",
+			Args:        graphql.FieldConfigArgument{"code": code},
+			Resolve:     r.confirmTwoFactorEnrollment,
+		},
+		"disableTwoFactor": &graphql.Field{
+			Type: graphql.Boolean,
+			Args: graphql.FieldConfigArgument{
+				"password": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"code":     code,
+			},
+			Resolve: r.disableTwoFactor,
+		},
+		"regenerateRecoveryCodes": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "Replaces every recovery code.",
+			Args:        graphql.FieldConfigArgument{"code": code},
+			Resolve:     r.regenerateRecoveryCodes,
+		},
+	}
+}
+
+// verifyTwoFactor counts wrong codes as failed logins, so that guessing
+// codes is throttled like guessing passwords.
+func (r *Resolver) verifyTwoFactor(p graphql.ResolveParams) (interface{}, error) {
+	token := p.Args["challengeToken"].(string)
+	code := p.Args["code"].(string)
+	ip := clientIP(p.Context)
+
+	user, err := r.TwoFactor.ChallengeUser(token)
+	if err != nil {
+		return nil, loginError(err, auth.ErrInvalidLoginChallenge)
+	}
+	attempt, err := r.Logins.Begin(user.Email, user.ID, ip)
+	if err != nil {
+		return nil, loginError(err)
+	}
+
+	if _, err := r.TwoFactor.Verify(token, code); err != nil {
+		finish := attempt.Pass
+		if errors.Is(err, auth.ErrInvalidTwoFactorCode) {
+			finish = attempt.Fail
+		}
+		if err := finish(); err != nil {
+			return nil, loginError(err)
+		}
+		return nil, loginError(err, auth.ErrInvalidTwoFactorCode, auth.ErrInvalidLoginChallenge)
+	}
+	if err := attempt.Pass(); err != nil {
+		return nil, loginError(err)
+	}
+	if err := r.Logins.Succeed(user.Email); err != nil {
+		return nil, loginError(err)
+	}
+
+	pair, err := r.Sessions.Issue(user.ID, user.Role)
+	if err != nil {
+		return nil, err
+	}
+	return tokenResponse(pair, *user), nil
+}
+
+func (r *Resolver) beginTwoFactorEnrollment(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollment, err := r.TwoFactor.Begin(userID, p.Args["password"].(string), clientIP(p.Context))
+	if err != nil {
+		return nil, twoFactorError(err)
+	}
+	return map[string]interface{}{
+		"secret":          enrollment.Secret,
+		"provisioningUri": enrollment.ProvisioningURI,
+	}, nil
+}
+
+func (r *Resolver) confirmTwoFactorEnrollment(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := r.TwoFactor.Confirm(userID, p.Args["code"].(string))
+	if err != nil {
+		return nil, twoFactorError(err)
+	}
+	return codes, nil
+}
+
+func (r *Resolver) disableTwoFactor(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.TwoFactor.Disable(userID, p.Args["password"].(string), p.Args["code"].(string)); err != nil {
+		return nil, twoFactorError(err)
+	}
+	return true, nil
+}
+
+func (r *Resolver) regenerateRecoveryCodes(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := r.TwoFactor.RegenerateRecoveryCodes(userID, p.Args["code"].(string))
+	if err != nil {
+		return nil, twoFactorError(err)
+	}
+	return codes, nil
+}
+
+func twoFactorError(err error) error {
+	var throttled *auth.LoginThrottledError
+	if errors.As(err, &throttled) {
+		return &loginThrottledError{throttled}
+	}
+	for _, target := range twoFactorErrors {
+		if errors.Is(err, target) {
+			return err
+		}
+	}
+	return errors.New("failed to update two-factor authentication")
+}
@@ -0,0 +1,463 @@
+package graphql
+
+import (
+	"user-team-asset-management/internal/access"
+	"user-team-asset-management/internal/models"
+
+	"github.com/graphql-go/graphql"
+)
+
+// types holds the object types shared by the query and mutation fields.
+// Types that refer to each other use field thunks so they can be declared
+// in any order.
+type types struct {
+	user           *graphql.Object
+	team           *graphql.Object
+	myTeams        *graphql.Object
+	folder         *graphql.Object
+	folderCrumb    *graphql.Object
+	note           *graphql.Object
+	folderShare    *graphql.Object
+	noteShare      *graphql.Object
+	revision       *graphql.Object
+	revisionDiff   *graphql.Object
+	trashEntry     *graphql.Object
+	permissions    *graphql.Object
+	grant          *graphql.Object
+	role           *graphql.Object
+	roleAssignment *graphql.Object
+	userRoles      *graphql.Object
+	searchResult   *graphql.Object
+	searchResults  *graphql.Object
+	loginResponse  *graphql.Object
+	enrollment     *graphql.Object
+	auditEntry     *graphql.Object
+	identity       *graphql.Object
+	userPage       *graphql.Object
+	teamPage       *graphql.Object
+	folderPage     *graphql.Object
+	auditPage      *graphql.Object
+}
+
+func (r *Resolver) newTypes() *types {
+	t := &types{}
+
+	t.user = graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"userId":    &graphql.Field{Type: graphql.String},
+				"username":  &graphql.Field{Type: graphql.String},
+				"email":     &graphql.Field{Type: graphql.String},
+				"role":      &graphql.Field{Type: graphql.String},
+				"createdAt": &graphql.Field{Type: graphql.DateTime},
+				"updatedAt": &graphql.Field{Type: graphql.DateTime},
+
+				"active":        &graphql.Field{Type: graphql.Boolean},
+				"deactivatedAt": &graphql.Field{Type: graphql.DateTime},
+				"pendingEmail": &graphql.Field{
+					Type:        graphql.String,
+					Description: "The email address waiting to be verified. Only shown to the user themselves.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						user := source[models.User](p)
+						if viewerID, _ := currentUserID(p); viewerID != user.ID || user.PendingEmail == "" {
+							return nil, nil
+						}
+						return user.PendingEmail, nil
+					},
+				},
+				"emailVerified": &graphql.Field{
+					Type: graphql.Boolean,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return source[models.User](p).EmailVerified(), nil
+					},
+				},
+				"twoFactorEnabled": &graphql.Field{
+					Type: graphql.Boolean,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return source[models.User](p).TwoFactorEnabled(), nil
+					},
+				},
+				"serviceAccount": &graphql.Field{Type: graphql.Boolean},
+				"externalIdentities": &graphql.Field{
+					Type:        graphql.NewList(t.identity),
+					Description: "Identity provider accounts the user can log in with. Only shown to the user themselves.",
+					Resolve:     r.userIdentities,
+				},
+				"folders": &graphql.Field{
+					Type:        t.folderPage,
+					Description: "Folders owned by or shared with the user.",
+					Args:        pageArgs("ownership", "name", "ownerId", "parentId"),
+					Resolve:     r.userFolders,
+				},
+			}
+		}),
+	})
+
+	t.team = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Team",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"teamId":    &graphql.Field{Type: graphql.String},
+				"teamName":  &graphql.Field{Type: graphql.String},
+				"createdAt": &graphql.Field{Type: graphql.DateTime},
+				"updatedAt": &graphql.Field{Type: graphql.DateTime},
+				"managers": &graphql.Field{
+					Type:    graphql.NewList(t.user),
+					Resolve: r.teamManagers,
+				},
+				"members": &graphql.Field{
+					Type:    graphql.NewList(t.user),
+					Resolve: r.teamMembers,
+				},
+			}
+		}),
+	})
+
+	t.myTeams = graphql.NewObject(graphql.ObjectConfig{
+		Name: "MyTeams",
+		Fields: graphql.Fields{
+			"managerTeams": &graphql.Field{Type: graphql.NewList(t.team)},
+			"memberTeams":  &graphql.Field{Type: graphql.NewList(t.team)},
+		},
+	})
+
+	t.folderCrumb = graphql.NewObject(graphql.ObjectConfig{
+		Name: "FolderCrumb",
+		Fields: graphql.Fields{
+			"folderId": &graphql.Field{Type: graphql.String},
+			"name":     &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	t.folder = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Folder",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"folderId":  &graphql.Field{Type: graphql.String},
+				"name":      &graphql.Field{Type: graphql.String},
+				"ownerId":   &graphql.Field{Type: graphql.String},
+				"parentId":  &graphql.Field{Type: graphql.String},
+				"version":   &graphql.Field{Type: graphql.Int},
+				"createdAt": &graphql.Field{Type: graphql.DateTime},
+				"updatedAt": &graphql.Field{Type: graphql.DateTime},
+				"owner": &graphql.Field{
+					Type:    t.user,
+					Resolve: r.folderOwner,
+				},
+				"path": &graphql.Field{
+					Type:    graphql.NewList(t.folderCrumb),
+					Resolve: r.folderPath,
+				},
+				"children": &graphql.Field{
+					Type:    graphql.NewList(t.folder),
+					Resolve: r.folderChildren,
+				},
+				"notes": &graphql.Field{
+					Type:    graphql.NewList(t.note),
+					Resolve: r.folderNotes,
+				},
+				"shares": &graphql.Field{
+					Type:        graphql.NewList(t.folderShare),
+					Description: "Only visible to the folder owner.",
+					Resolve:     r.folderShares,
+				},
+			}
+		}),
+	})
+
+	t.note = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Note",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"noteId":    &graphql.Field{Type: graphql.String},
+				"title":     &graphql.Field{Type: graphql.String},
+				"body":      &graphql.Field{Type: graphql.String},
+				"folderId":  &graphql.Field{Type: graphql.String},
+				"ownerId":   &graphql.Field{Type: graphql.String},
+				"version":   &graphql.Field{Type: graphql.Int},
+				"createdAt": &graphql.Field{Type: graphql.DateTime},
+				"updatedAt": &graphql.Field{Type: graphql.DateTime},
+				"owner": &graphql.Field{
+					Type:    t.user,
+					Resolve: r.noteOwner,
+				},
+				"folder": &graphql.Field{
+					Type:    t.folder,
+					Resolve: r.noteFolder,
+				},
+				"shares": &graphql.Field{
+					Type:        graphql.NewList(t.noteShare),
+					Description: "Only visible to the note owner.",
+					Resolve:     r.noteShares,
+				},
+				"revisions": &graphql.Field{
+					Type:    graphql.NewList(t.revision),
+					Resolve: r.noteRevisionsOf,
+				},
+			}
+		}),
+	})
+
+	t.folderShare = graphql.NewObject(graphql.ObjectConfig{
+		Name: "FolderShare",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"folderId":  &graphql.Field{Type: graphql.String},
+				"userId":    &graphql.Field{Type: graphql.String},
+				"access":    &graphql.Field{Type: graphql.String},
+				"createdAt": &graphql.Field{Type: graphql.DateTime},
+				"user": &graphql.Field{
+					Type: t.user,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return r.loaders(p).users.thunk(source[models.FolderShare](p).UserID), nil
+					},
+				},
+			}
+		}),
+	})
+
+	t.noteShare = graphql.NewObject(graphql.ObjectConfig{
+		Name: "NoteShare",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"noteId":    &graphql.Field{Type: graphql.String},
+				"userId":    &graphql.Field{Type: graphql.String},
+				"access":    &graphql.Field{Type: graphql.String},
+				"createdAt": &graphql.Field{Type: graphql.DateTime},
+				"user": &graphql.Field{
+					Type: t.user,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return r.loaders(p).users.thunk(source[models.NoteShare](p).UserID), nil
+					},
+				},
+			}
+		}),
+	})
+
+	t.revision = graphql.NewObject(graphql.ObjectConfig{
+		Name: "NoteRevision",
+		Fields: graphql.Fields{
+			"revisionId":   &graphql.Field{Type: graphql.String},
+			"noteId":       &graphql.Field{Type: graphql.String},
+			"revision":     &graphql.Field{Type: graphql.Int},
+			"title":        &graphql.Field{Type: graphql.String},
+			"body":         &graphql.Field{Type: graphql.String},
+			"authorId":     &graphql.Field{Type: graphql.String},
+			"restoredFrom": &graphql.Field{Type: graphql.Int},
+			"createdAt":    &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	t.revisionDiff = graphql.NewObject(graphql.ObjectConfig{
+		Name: "NoteRevisionDiff",
+		Fields: graphql.Fields{
+			"from":      &graphql.Field{Type: graphql.Int},
+			"to":        &graphql.Field{Type: graphql.Int},
+			"fromTitle": &graphql.Field{Type: graphql.String},
+			"toTitle":   &graphql.Field{Type: graphql.String},
+			"diff":      &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	t.trashEntry = graphql.NewObject(graphql.ObjectConfig{
+		Name: "TrashEntry",
+		Fields: graphql.Fields{
+			"trashId":   &graphql.Field{Type: graphql.String},
+			"itemType":  &graphql.Field{Type: graphql.String},
+			"itemId":    &graphql.Field{Type: graphql.String},
+			"name":      &graphql.Field{Type: graphql.String},
+			"ownerId":   &graphql.Field{Type: graphql.String},
+			"deletedBy": &graphql.Field{Type: graphql.String},
+			"deletedAt": &graphql.Field{Type: graphql.DateTime},
+			"purgeAt":   &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	t.grant = graphql.NewObject(graphql.ObjectConfig{
+		Name: "AccessGrant",
+		Fields: graphql.Fields{
+			"source": &graphql.Field{Type: graphql.String},
+			"access": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return source[access.Grant](p).Access.String(), nil
+				},
+			},
+			"folderId":  &graphql.Field{Type: graphql.String},
+			"noteId":    &graphql.Field{Type: graphql.String},
+			"inherited": &graphql.Field{Type: graphql.Boolean},
+		},
+	})
+
+	t.permissions = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Permissions",
+		Fields: graphql.Fields{
+			"access": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return source[access.Decision](p).Access.String(), nil
+				},
+			},
+			"grants": &graphql.Field{Type: graphql.NewList(t.grant)},
+		},
+	})
+
+	t.role = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Role",
+		Fields: graphql.Fields{
+			"role":        &graphql.Field{Type: graphql.String},
+			"permissions": &graphql.Field{Type: graphql.NewList(graphql.String)},
+		},
+	})
+
+	t.roleAssignment = graphql.NewObject(graphql.ObjectConfig{
+		Name: "RoleAssignment",
+		Fields: graphql.Fields{
+			"assignmentId": &graphql.Field{Type: graphql.String},
+			"userId":       &graphql.Field{Type: graphql.String},
+			"role":         &graphql.Field{Type: graphql.String},
+			"teamId":       &graphql.Field{Type: graphql.String},
+			"createdAt":    &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	t.userRoles = graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserRoles",
+		Fields: graphql.Fields{
+			"role":        &graphql.Field{Type: graphql.String},
+			"assignments": &graphql.Field{Type: graphql.NewList(t.roleAssignment)},
+		},
+	})
+
+	t.searchResult = graphql.NewObject(graphql.ObjectConfig{
+		Name: "SearchResult",
+		Fields: graphql.Fields{
+			"type":      &graphql.Field{Type: graphql.String},
+			"id":        &graphql.Field{Type: graphql.String},
+			"title":     &graphql.Field{Type: graphql.String},
+			"snippet":   &graphql.Field{Type: graphql.String},
+			"folderId":  &graphql.Field{Type: graphql.String},
+			"rank":      &graphql.Field{Type: graphql.Float},
+			"updatedAt": &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	t.searchResults = graphql.NewObject(graphql.ObjectConfig{
+		Name: "SearchResults",
+		Fields: graphql.Fields{
+			"results":    &graphql.Field{Type: graphql.NewList(t.searchResult)},
+			"totalCount": &graphql.Field{Type: graphql.Int},
+			"limit":      &graphql.Field{Type: graphql.Int},
+			"offset":     &graphql.Field{Type: graphql.Int},
+		},
+	})
+
+	t.loginResponse = graphql.NewObject(graphql.ObjectConfig{
+		Name: "LoginResponse",
+		Fields: graphql.Fields{
+			"token":        &graphql.Field{Type: graphql.String},
+			"refreshToken": &graphql.Field{Type: graphql.String},
+			"expiresAt":    &graphql.Field{Type: graphql.DateTime},
+			"user":         &graphql.Field{Type: t.user},
+
+			// Set instead of the tokens when the user has to complete
+			// verifyTwoFactor first
+			"twoFactorRequired":  &graphql.Field{Type: graphql.Boolean},
+			"challengeToken":     &graphql.Field{Type: graphql.String},
+			"challengeExpiresAt": &graphql.Field{Type: graphql.DateTime},
+
+			"twoFactorEnrollmentRequired": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Whether the user's role needs two-factor authentication, which the user has not enabled yet.",
+			},
+		},
+	})
+
+	t.enrollment = graphql.NewObject(graphql.ObjectConfig{
+		Name: "TwoFactorEnrollment",
+		Fields: graphql.Fields{
+			"secret":          &graphql.Field{Type: graphql.String},
+			"provisioningUri": &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	t.auditEntry = graphql.NewObject(graphql.ObjectConfig{
+		Name: "AuditEntry",
+		Fields: graphql.Fields{
+			"auditId":      &graphql.Field{Type: graphql.String},
+			"action":       &graphql.Field{Type: graphql.String},
+			"actorId":      &graphql.Field{Type: graphql.String},
+			"targetUserId": &graphql.Field{Type: graphql.String},
+			"ip":           &graphql.Field{Type: graphql.String},
+			"detail":       &graphql.Field{Type: graphql.String},
+			"createdAt":    &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	t.identity = graphql.NewObject(graphql.ObjectConfig{
+		Name: "ExternalIdentity",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.String},
+			"issuer":      &graphql.Field{Type: graphql.String},
+			"subject":     &graphql.Field{Type: graphql.String},
+			"email":       &graphql.Field{Type: graphql.String},
+			"createdAt":   &graphql.Field{Type: graphql.DateTime},
+			"lastLoginAt": &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	t.userPage = pageType("UserPage", t.user)
+	t.teamPage = pageType("TeamPage", t.team)
+	t.folderPage = pageType("FolderPage", t.folder)
+	t.auditPage = pageType("AuditPage", t.auditEntry)
+
+	return t
+}
+
+// pageType declares the GraphQL counterpart of pagination.Page.
+func pageType(name string, item graphql.Output) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: name,
+		Fields: graphql.Fields{
+			"items":      &graphql.Field{Type: graphql.NewList(item)},
+			"nextCursor": &graphql.Field{Type: graphql.String},
+			"totalCount": &graphql.Field{Type: graphql.Int},
+		},
+	})
+}
+
+// pageArgs declares the pagination arguments plus the given string filters.
+func pageArgs(filters ...string) graphql.FieldConfigArgument {
+	args := graphql.FieldConfigArgument{
+		"limit":  &graphql.ArgumentConfig{Type: graphql.Int},
+		"cursor": &graphql.ArgumentConfig{Type: graphql.String},
+		"sort":   &graphql.ArgumentConfig{Type: graphql.String},
+		"order":  &graphql.ArgumentConfig{Type: graphql.String},
+	}
+	for _, name := range filters {
+		args[name] = &graphql.ArgumentConfig{Type: graphql.String}
+	}
+	return args
+}
+
+// withArgs adds required arguments to a set of pagination arguments.
+func withArgs(args graphql.FieldConfigArgument, required ...string) graphql.FieldConfigArgument {
+	for _, name := range required {
+		args[name] = &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}
+	}
+	return args
+}
+
+// source returns the object being resolved, whether the parent resolver
+// returned it by value or by pointer.
+func source[T any](p graphql.ResolveParams) T {
+	switch v := p.Source.(type) {
+	case T:
+		return v
+	case *T:
+		return *v
+	}
+	var zero T
+	return zero
+}
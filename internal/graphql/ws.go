@@ -0,0 +1,373 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// wsProtocol is the WebSocket subprotocol of the graphql-ws library.
+const wsProtocol = "graphql-transport-ws"
+
+const (
+	wsInitTimeout  = 10 * time.Second
+	wsWriteTimeout = 10 * time.Second
+	wsReadLimit    = 64 << 10
+
+	// wsSessionCheckInterval is how often a connection's token is checked
+	// again, so expired tokens and revoked sessions are cut off.
+	wsSessionCheckInterval = time.Minute
+)
+
+// Close codes defined by the protocol.
+const (
+	closeBadRequest       = 4400
+	closeUnauthorized     = 4401
+	closeForbidden        = 4403
+	closeInitTimeout      = 4408
+	closeSubscriberExists = 4409
+	closeTooManyInits     = 4429
+)
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// SubscriptionServer serves GraphQL over WebSocket using the
+// graphql-transport-ws protocol. Clients authenticate with the same access
+// token as REST, sent either in the Authorization header of the upgrade
+// request or as "Authorization" in the connection_init payload.
+type SubscriptionServer struct {
+	Schema   graphql.Schema
+	Resolver *Resolver
+
+	upgrader websocket.Upgrader
+}
+
+func NewSubscriptionServer(schema graphql.Schema, resolver *Resolver) *SubscriptionServer {
+	return &SubscriptionServer{
+		Schema:   schema,
+		Resolver: resolver,
+		upgrader: websocket.Upgrader{Subprotocols: []string{wsProtocol}},
+	}
+}
+
+// Accepts reports whether req asks to upgrade to a WebSocket.
+func (s *SubscriptionServer) Accepts(req *http.Request) bool {
+	return websocket.IsWebSocketUpgrade(req)
+}
+
+func (s *SubscriptionServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// The upgrader has already replied
+		return
+	}
+
+	c := &wsConn{
+		server:        s,
+		conn:          conn,
+		authorization: req.Header.Get("Authorization"),
+		operations:    make(map[string]*wsOperation),
+	}
+	// Operations outlive the upgrade request, so only its client IP is kept
+	c.ctx, c.cancel = context.WithCancel(WithClientIP(context.Background(), clientIP(req.Context())))
+	c.run()
+}
+
+// wsConn is one client connection. Reads happen on the connection's own
+// goroutine; every operation writes its results from its own goroutine.
+type wsConn struct {
+	server        *SubscriptionServer
+	conn          *websocket.Conn
+	authorization string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	writeMu sync.Mutex
+
+	mu         sync.Mutex
+	initiated  bool
+	userID     string
+	token      string
+	operations map[string]*wsOperation
+}
+
+// wsOperation is a running operation. Its pointer identifies it, since the
+// client may reuse an ID once the operation has completed.
+type wsOperation struct {
+	cancel context.CancelFunc
+}
+
+func (c *wsConn) run() {
+	defer c.conn.Close()
+	defer c.cancel()
+
+	if c.conn.Subprotocol() != wsProtocol {
+		c.close(websocket.CloseProtocolError, "Subprotocol "+wsProtocol+" is required")
+		return
+	}
+
+	c.conn.SetReadLimit(wsReadLimit)
+	initTimer := time.AfterFunc(wsInitTimeout, func() {
+		if c.currentUserID() == "" {
+			c.close(closeInitTimeout, "Connection initialisation timeout")
+		}
+	})
+	defer initTimer.Stop()
+
+	for {
+		var msg wsMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				c.close(closeBadRequest, "Invalid message received")
+			}
+			return
+		}
+
+		switch msg.Type {
+		case "connection_init":
+			if !c.init(msg.Payload) {
+				return
+			}
+		case "ping":
+			c.send(wsMessage{Type: "pong"})
+		case "pong":
+		case "subscribe":
+			if !c.subscribe(msg) {
+				return
+			}
+		case "complete":
+			c.mu.Lock()
+			if operation, ok := c.operations[msg.ID]; ok {
+				operation.cancel()
+				delete(c.operations, msg.ID)
+			}
+			c.mu.Unlock()
+		default:
+			c.close(closeBadRequest, "Invalid message received")
+			return
+		}
+	}
+}
+
+// init authenticates the connection, preferring a token in the payload over
+// the upgrade request's header.
+func (c *wsConn) init(payload json.RawMessage) bool {
+	c.mu.Lock()
+	if c.initiated {
+		c.mu.Unlock()
+		c.close(closeTooManyInits, "Too many initialisation requests")
+		return false
+	}
+	c.initiated = true
+	c.mu.Unlock()
+
+	var params map[string]interface{}
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &params); err != nil {
+			c.close(closeBadRequest, "Invalid connection_init payload")
+			return false
+		}
+	}
+	authorization := c.authorization
+	for _, key := range []string{"Authorization", "authorization"} {
+		if value, ok := params[key].(string); ok {
+			authorization = value
+		}
+	}
+
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	claims, err := c.server.Resolver.Sessions.Validate(token)
+	if token == "" || err != nil {
+		c.close(closeForbidden, "Forbidden")
+		return false
+	}
+
+	c.mu.Lock()
+	c.userID = claims.UserID
+	c.token = token
+	c.mu.Unlock()
+
+	c.send(wsMessage{Type: "connection_ack"})
+	go c.watchSession()
+	return true
+}
+
+// watchSession closes the connection once its token expires or its session
+// is revoked.
+func (c *wsConn) watchSession() {
+	ticker := time.NewTicker(wsSessionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			token := c.token
+			c.mu.Unlock()
+
+			if _, err := c.server.Resolver.Sessions.Validate(token); err != nil {
+				c.close(closeUnauthorized, "Token expired or revoked")
+				return
+			}
+		}
+	}
+}
+
+func (c *wsConn) subscribe(msg wsMessage) bool {
+	userID := c.currentUserID()
+	if userID == "" {
+		c.close(closeUnauthorized, "Unauthorized")
+		return false
+	}
+
+	var payload Request
+	if msg.ID == "" || json.Unmarshal(msg.Payload, &payload) != nil {
+		c.close(closeBadRequest, "Invalid message received")
+		return false
+	}
+
+	c.mu.Lock()
+	if _, exists := c.operations[msg.ID]; exists {
+		c.mu.Unlock()
+		c.close(closeSubscriberExists, fmt.Sprintf("Subscriber for %s already exists", msg.ID))
+		return false
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	operation := &wsOperation{cancel: cancel}
+	c.operations[msg.ID] = operation
+	c.mu.Unlock()
+
+	go c.execute(ctx, operation, msg.ID, userID, &payload)
+	return true
+}
+
+// execute runs one operation and sends its results. Queries and mutations
+// are accepted as well and complete after their single result. Operations
+// over the limits are refused with an error message.
+func (c *wsConn) execute(ctx context.Context, operation *wsOperation, id, userID string, payload *Request) {
+	defer func() {
+		operation.cancel()
+		c.mu.Lock()
+		if c.operations[id] == operation {
+			delete(c.operations, id)
+		}
+		c.mu.Unlock()
+	}()
+
+	schema := c.server.Schema
+	resolver := c.server.Resolver
+	doc, cost, rejected := resolver.prepare(&schema, payload, userID)
+	if rejected != nil {
+		c.sendErrors(id, rejected.Errors)
+		return
+	}
+
+	if operationType(doc, payload.OperationName) != ast.OperationTypeSubscription {
+		result := resolver.execute(resolver.RequestContext(ctx, userID), schema, doc, payload, cost)
+		c.sendResult(ctx, id, result)
+		c.complete(ctx, id)
+		return
+	}
+
+	results := graphql.ExecuteSubscription(graphql.ExecuteParams{
+		Schema:        schema,
+		AST:           doc,
+		OperationName: payload.OperationName,
+		Args:          payload.Variables,
+		Context:       WithUserID(ctx, userID),
+	})
+
+	// Errors before the first event mean the subscription never started.
+	// Results are drained even after cancellation so that graphql-go's
+	// goroutine can finish.
+	first := true
+	for result := range results {
+		if first && result.Data == nil && result.HasErrors() {
+			c.sendErrors(id, result.Errors)
+			for range results {
+			}
+			return
+		}
+		first = false
+		c.sendResult(ctx, id, result)
+	}
+	c.complete(ctx, id)
+}
+
+func operationType(doc *ast.Document, name string) string {
+	for _, definition := range doc.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" || (operation.Name != nil && operation.Name.Value == name) {
+			return operation.Operation
+		}
+	}
+	return ""
+}
+
+func (c *wsConn) currentUserID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.userID
+}
+
+// sendResult and complete send nothing once the client has completed the
+// operation itself.
+func (c *wsConn) sendResult(ctx context.Context, id string, result *graphql.Result) {
+	if ctx.Err() != nil {
+		return
+	}
+	payload, _ := json.Marshal(result)
+	c.send(wsMessage{ID: id, Type: "next", Payload: payload})
+}
+
+func (c *wsConn) complete(ctx context.Context, id string) {
+	if ctx.Err() != nil {
+		return
+	}
+	c.send(wsMessage{ID: id, Type: "complete"})
+}
+
+func (c *wsConn) sendErrors(id string, errs []gqlerrors.FormattedError) {
+	payload, _ := json.Marshal(errs)
+	c.send(wsMessage{ID: id, Type: "error", Payload: payload})
+}
+
+func (c *wsConn) send(msg wsMessage) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := c.conn.WriteJSON(msg); err != nil {
+		c.cancel()
+	}
+}
+
+// close ends the connection with one of the protocol's close codes.
+func (c *wsConn) close(code int, reason string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	message := websocket.FormatCloseMessage(code, reason)
+	c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsWriteTimeout))
+	c.cancel()
+	c.conn.Close()
+}
@@ -1,21 +1,31 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"user-team-asset-management/internal/access"
+	"user-team-asset-management/internal/assets"
 	"user-team-asset-management/internal/models"
-	"user-team-asset-management/internal/utils"
+	"user-team-asset-management/internal/policy"
+	"user-team-asset-management/internal/trash"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
+// AssetHandler serves folders and notes. Changes go through Assets; reads
+// are answered here.
 type AssetHandler struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Authz  policy.Authorizer
+	Access *access.Resolver
+	Assets *assets.Service
 }
 
 func (h *AssetHandler) CreateFolder(c *gin.Context) {
 	var req struct {
-		Name string `json:"name" binding:"required"`
+		Name     string  `json:"name" binding:"required"`
+		ParentID *string `json:"parentId"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -23,15 +33,9 @@ func (h *AssetHandler) CreateFolder(c *gin.Context) {
 		return
 	}
 
-	userID := c.GetString("userID")
-	folder := models.Folder{
-		ID:      utils.GenerateID(),
-		Name:    req.Name,
-		OwnerID: userID,
-	}
-
-	if err := h.DB.Create(&folder).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create folder"})
+	folder, err := h.Assets.CreateFolder(c.GetString("userID"), req.Name, req.ParentID)
+	if err != nil {
+		assetError(c, err, "Failed to create folder")
 		return
 	}
 
@@ -39,7 +43,6 @@ func (h *AssetHandler) CreateFolder(c *gin.Context) {
 }
 
 func (h *AssetHandler) CreateNote(c *gin.Context) {
-	folderID := c.Param("folderId")
 	var req struct {
 		Title string `json:"title" binding:"required"`
 		Body  string `json:"body"`
@@ -50,23 +53,9 @@ func (h *AssetHandler) CreateNote(c *gin.Context) {
 		return
 	}
 
-	userID := c.GetString("userID")
-
-	if !h.canWriteToFolder(userID, folderID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "No write access to this folder"})
-		return
-	}
-
-	note := models.Note{
-		ID:       utils.GenerateID(),
-		Title:    req.Title,
-		Body:     req.Body,
-		FolderID: folderID,
-		OwnerID:  userID,
-	}
-
-	if err := h.DB.Create(&note).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create note"})
+	note, err := h.Assets.CreateNote(c.GetString("userID"), c.Param("folderId"), req.Title, req.Body)
+	if err != nil {
+		assetError(c, err, "Failed to create note")
 		return
 	}
 
@@ -74,7 +63,6 @@ func (h *AssetHandler) CreateNote(c *gin.Context) {
 }
 
 func (h *AssetHandler) ShareFolder(c *gin.Context) {
-	folderID := c.Param("folderId")
 	var req struct {
 		UserID string `json:"userId" binding:"required"`
 		Access string `json:"access" binding:"required,oneof=read write"`
@@ -85,20 +73,8 @@ func (h *AssetHandler) ShareFolder(c *gin.Context) {
 		return
 	}
 
-	userID := c.GetString("userID")
-	if !h.ownsFolder(userID, folderID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only folder owner can share"})
-		return
-	}
-
-	share := models.FolderShare{
-		FolderID: folderID,
-		UserID:   req.UserID,
-		Access:   req.Access,
-	}
-
-	if err := h.DB.Create(&share).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share folder"})
+	if _, err := h.Assets.ShareFolder(c.GetString("userID"), c.Param("folderId"), req.UserID, req.Access); err != nil {
+		assetError(c, err, "Failed to share folder")
 		return
 	}
 
@@ -107,76 +83,23 @@ func (h *AssetHandler) ShareFolder(c *gin.Context) {
 
 func (h *AssetHandler) GetTeamAssets(c *gin.Context) {
 	teamID := c.Param("teamId")
-	userID := c.GetString("userID")
 
-	if !h.isTeamManager(userID, teamID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Manager access required"})
+	if !authorize(c, h.Authz, policy.TeamAssetsRead, teamID) {
 		return
 	}
 
-	// Get all team members
+	// Folders owned by or shared with team members
 	var memberIDs []string
-	h.DB.Model(&models.TeamMember{}).Where("team_id = ?", teamID).Pluck("user_id", &memberIDs)
-
-	// Get folders owned by team members
-	var folders []models.Folder
-	h.DB.Preload("Notes").Where("owner_id IN ?", memberIDs).Find(&folders)
-
-	// Get shared folders accessible by team members
-	var sharedFolders []models.Folder
-	h.DB.Preload("Notes").
-		Joins("JOIN folder_shares ON folders.id = folder_shares.folder_id").
-		Where("folder_shares.user_id IN ?", memberIDs).
-		Find(&sharedFolders)
-
-	c.JSON(http.StatusOK, gin.H{
-		"ownedFolders":  folders,
-		"sharedFolders": sharedFolders,
-	})
-}
-
-func (h *AssetHandler) ownsFolder(userID, folderID string) bool {
-	var count int64
-	h.DB.Model(&models.Folder{}).Where("id = ? AND owner_id = ?", folderID, userID).Count(&count)
-	return count > 0
-}
-
-func (h *AssetHandler) canWriteToFolder(userID, folderID string) bool {
-	if h.ownsFolder(userID, folderID) {
-		return true
+	if err := h.DB.Model(&models.TeamMember{}).Where("team_id = ?", teamID).Pluck("user_id", &memberIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch team members"})
+		return
 	}
 
-	var count int64
-	h.DB.Model(&models.FolderShare{}).
-		Where("folder_id = ? AND user_id = ? AND access = 'write'", folderID, userID).
-		Count(&count)
-	return count > 0
-}
-
-func (h *AssetHandler) isTeamManager(userID, teamID string) bool {
-	var count int64
-	h.DB.Model(&models.TeamManager{}).Where("team_id = ? AND user_id = ?", teamID, userID).Count(&count)
-	return count > 0
+	listFolders(c, h.Access, memberIDs)
 }
 
 func (h *AssetHandler) GetUserFolders(c *gin.Context) {
-	userID := c.GetString("userID")
-
-	// Get owned folders
-	var ownedFolders []models.Folder
-	h.DB.Preload("Notes").Where("owner_id = ?", userID).Find(&ownedFolders)
-
-	// Get shared folders
-	var sharedFolders []models.Folder
-	h.DB.Preload("Notes").
-		Joins("JOIN folder_shares ON folders.id = folder_shares.folder_id").
-		Where("folder_shares.user_id = ?", userID).
-		Find(&sharedFolders)
-
-	c.JSON(http.StatusOK, gin.H{
-		"ownedFolders":  ownedFolders,
-		"sharedFolders": sharedFolders,
-	})
+	listFolders(c, h.Access, []string{c.GetString("userID")})
 }
 
 func (h *AssetHandler) GetFolder(c *gin.Context) {
@@ -189,23 +112,44 @@ func (h *AssetHandler) GetFolder(c *gin.Context) {
 	}
 
 	var folder models.Folder
-	if err := h.DB.Preload("Notes").Where("id = ?", folderID).First(&folder).Error; err != nil {
+	if err := h.DB.Preload("Notes").Preload("Children").Where("id = ?", folderID).First(&folder).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
 		return
 	}
 
+	path, err := h.Access.Path(folderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve folder path"})
+		return
+	}
+	folder.Path = path
+
+	c.Header("ETag", etag(folder.Version))
 	c.JSON(http.StatusOK, folder)
 }
 
-func (h *AssetHandler) UpdateFolder(c *gin.Context) {
-	folderID := c.Param("folderId")
-	userID := c.GetString("userID")
+func (h *AssetHandler) MoveFolder(c *gin.Context) {
+	// A null parentId moves the folder to the top level
+	var req struct {
+		ParentID *string `json:"parentId"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	if !h.ownsFolder(userID, folderID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only folder owner can update"})
+	folder, err := h.Assets.MoveFolder(c.GetString("userID"), c.Param("folderId"), req.ParentID, versionCheck(c))
+	if err != nil {
+		assetError(c, err, "Failed to move folder")
 		return
 	}
 
+	c.Header("ETag", etag(folder.Version))
+	c.JSON(http.StatusOK, gin.H{"message": "Folder moved successfully", "version": folder.Version})
+}
+
+func (h *AssetHandler) UpdateFolder(c *gin.Context) {
 	var req struct {
 		Name string `json:"name" binding:"required"`
 	}
@@ -215,31 +159,24 @@ func (h *AssetHandler) UpdateFolder(c *gin.Context) {
 		return
 	}
 
-	if err := h.DB.Model(&models.Folder{}).Where("id = ?", folderID).Update("name", req.Name).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update folder"})
+	folder, err := h.Assets.RenameFolder(c.GetString("userID"), c.Param("folderId"), req.Name, versionCheck(c))
+	if err != nil {
+		assetError(c, err, "Failed to update folder")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Folder updated successfully"})
+	c.Header("ETag", etag(folder.Version))
+	c.JSON(http.StatusOK, gin.H{"message": "Folder updated successfully", "version": folder.Version})
 }
 
 func (h *AssetHandler) DeleteFolder(c *gin.Context) {
-	folderID := c.Param("folderId")
-	userID := c.GetString("userID")
-
-	if !h.ownsFolder(userID, folderID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only folder owner can delete"})
+	entry, err := h.Assets.DeleteFolder(c.GetString("userID"), c.Param("folderId"), versionCheck(c))
+	if err != nil {
+		assetError(c, err, "Failed to delete folder")
 		return
 	}
 
-	// Delete all notes in folder first
-	h.DB.Where("folder_id = ?", folderID).Delete(&models.Note{})
-	// Delete folder shares
-	h.DB.Where("folder_id = ?", folderID).Delete(&models.FolderShare{})
-	// Delete folder
-	h.DB.Where("id = ?", folderID).Delete(&models.Folder{})
-
-	c.JSON(http.StatusOK, gin.H{"message": "Folder deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Folder moved to trash", "trashId": entry.ID})
 }
 
 func (h *AssetHandler) GetNote(c *gin.Context) {
@@ -257,18 +194,11 @@ func (h *AssetHandler) GetNote(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", etag(note.Version))
 	c.JSON(http.StatusOK, note)
 }
 
 func (h *AssetHandler) UpdateNote(c *gin.Context) {
-	noteID := c.Param("noteId")
-	userID := c.GetString("userID")
-
-	if !h.canWriteToNote(userID, noteID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "No write access to this note"})
-		return
-	}
-
 	var req struct {
 		Title string `json:"title"`
 		Body  string `json:"body"`
@@ -279,41 +209,31 @@ func (h *AssetHandler) UpdateNote(c *gin.Context) {
 		return
 	}
 
-	updates := make(map[string]interface{})
-	if req.Title != "" {
-		updates["title"] = req.Title
-	}
-	if req.Body != "" {
-		updates["body"] = req.Body
-	}
-
-	if err := h.DB.Model(&models.Note{}).Where("id = ?", noteID).Updates(updates).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update note"})
+	note, revision, err := h.Assets.UpdateNote(c.GetString("userID"), c.Param("noteId"), req.Title, req.Body, versionCheck(c))
+	if err != nil {
+		assetError(c, err, "Failed to update note")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Note updated successfully"})
+	c.Header("ETag", etag(note.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Note updated successfully",
+		"version":  note.Version,
+		"revision": revision.Revision,
+	})
 }
 
 func (h *AssetHandler) DeleteNote(c *gin.Context) {
-	noteID := c.Param("noteId")
-	userID := c.GetString("userID")
-
-	if !h.ownsNote(userID, noteID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only note owner can delete"})
+	entry, err := h.Assets.DeleteNote(c.GetString("userID"), c.Param("noteId"), versionCheck(c))
+	if err != nil {
+		assetError(c, err, "Failed to delete note")
 		return
 	}
 
-	// Delete note shares first
-	h.DB.Where("note_id = ?", noteID).Delete(&models.NoteShare{})
-	// Delete note
-	h.DB.Where("id = ?", noteID).Delete(&models.Note{})
-
-	c.JSON(http.StatusOK, gin.H{"message": "Note deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Note moved to trash", "trashId": entry.ID})
 }
 
 func (h *AssetHandler) ShareNote(c *gin.Context) {
-	noteID := c.Param("noteId")
 	var req struct {
 		UserID string `json:"userId" binding:"required"`
 		Access string `json:"access" binding:"required,oneof=read write"`
@@ -324,20 +244,8 @@ func (h *AssetHandler) ShareNote(c *gin.Context) {
 		return
 	}
 
-	userID := c.GetString("userID")
-	if !h.ownsNote(userID, noteID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only note owner can share"})
-		return
-	}
-
-	share := models.NoteShare{
-		NoteID: noteID,
-		UserID: req.UserID,
-		Access: req.Access,
-	}
-
-	if err := h.DB.Create(&share).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share note"})
+	if _, err := h.Assets.ShareNote(c.GetString("userID"), c.Param("noteId"), req.UserID, req.Access); err != nil {
+		assetError(c, err, "Failed to share note")
 		return
 	}
 
@@ -345,17 +253,8 @@ func (h *AssetHandler) ShareNote(c *gin.Context) {
 }
 
 func (h *AssetHandler) RevokeFolderShare(c *gin.Context) {
-	folderID := c.Param("folderId")
-	shareUserID := c.Param("userId")
-	userID := c.GetString("userID")
-
-	if !h.ownsFolder(userID, folderID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only folder owner can revoke sharing"})
-		return
-	}
-
-	if err := h.DB.Where("folder_id = ? AND user_id = ?", folderID, shareUserID).Delete(&models.FolderShare{}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke folder sharing"})
+	if err := h.Assets.RevokeFolderShare(c.GetString("userID"), c.Param("folderId"), c.Param("userId")); err != nil {
+		assetError(c, err, "Failed to revoke folder sharing")
 		return
 	}
 
@@ -363,17 +262,8 @@ func (h *AssetHandler) RevokeFolderShare(c *gin.Context) {
 }
 
 func (h *AssetHandler) RevokeNoteShare(c *gin.Context) {
-	noteID := c.Param("noteId")
-	shareUserID := c.Param("userId")
-	userID := c.GetString("userID")
-
-	if !h.ownsNote(userID, noteID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only note owner can revoke sharing"})
-		return
-	}
-
-	if err := h.DB.Where("note_id = ? AND user_id = ?", noteID, shareUserID).Delete(&models.NoteShare{}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke note sharing"})
+	if err := h.Assets.RevokeNoteShare(c.GetString("userID"), c.Param("noteId"), c.Param("userId")); err != nil {
+		assetError(c, err, "Failed to revoke note sharing")
 		return
 	}
 
@@ -383,74 +273,85 @@ func (h *AssetHandler) RevokeNoteShare(c *gin.Context) {
 func (h *AssetHandler) GetUserAssets(c *gin.Context) {
 	targetUserID := c.Param("userId")
 	currentUserID := c.GetString("userID")
-	currentRole := c.GetString("role")
 
-	// Only managers can view other users' assets
-	if currentRole != "manager" && currentUserID != targetUserID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Manager role required"})
+	// Viewing other users' assets needs an explicit permission
+	if currentUserID != targetUserID && !authorize(c, h.Authz, policy.UserAssetsRead, "") {
 		return
 	}
 
-	// Get owned folders
-	var ownedFolders []models.Folder
-	h.DB.Preload("Notes").Where("owner_id = ?", targetUserID).Find(&ownedFolders)
-
-	// Get shared folders
-	var sharedFolders []models.Folder
-	h.DB.Preload("Notes").
-		Joins("JOIN folder_shares ON folders.id = folder_shares.folder_id").
-		Where("folder_shares.user_id = ?", targetUserID).
-		Find(&sharedFolders)
-
-	c.JSON(http.StatusOK, gin.H{
-		"ownedFolders":  ownedFolders,
-		"sharedFolders": sharedFolders,
-	})
+	listFolders(c, h.Access, []string{targetUserID})
 }
 
-func (h *AssetHandler) canReadFolder(userID, folderID string) bool {
-	// Check if user owns the folder
-	var count int64
-	h.DB.Model(&models.Folder{}).Where("id = ? AND owner_id = ?", folderID, userID).Count(&count)
-	if count > 0 {
-		return true
+func (h *AssetHandler) GetNotePermissions(c *gin.Context) {
+	noteID := c.Param("noteId")
+	userID := c.GetString("userID")
+
+	decision, err := h.Access.Note(userID, noteID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve permissions"})
+		return
+	}
+	if !decision.CanRead() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No access to this note"})
+		return
 	}
 
-	// Check if folder is shared with user
-	h.DB.Model(&models.FolderShare{}).Where("folder_id = ? AND user_id = ?", folderID, userID).Count(&count)
-	return count > 0
+	c.JSON(http.StatusOK, decision)
 }
 
-func (h *AssetHandler) canReadNote(userID, noteID string) bool {
-	// Check if user owns the note
-	var count int64
-	h.DB.Model(&models.Note{}).Where("id = ? AND owner_id = ?", noteID, userID).Count(&count)
-	if count > 0 {
-		return true
+func (h *AssetHandler) GetFolderPermissions(c *gin.Context) {
+	folderID := c.Param("folderId")
+	userID := c.GetString("userID")
+
+	decision, err := h.Access.Folder(userID, folderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve permissions"})
+		return
+	}
+	if !decision.CanRead() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No access to this folder"})
+		return
 	}
 
-	// Check if note is shared with user
-	h.DB.Model(&models.NoteShare{}).Where("note_id = ? AND user_id = ?", noteID, userID).Count(&count)
-	return count > 0
+	c.JSON(http.StatusOK, decision)
 }
 
-func (h *AssetHandler) canWriteToNote(userID, noteID string) bool {
-	// Check if user owns the note
-	var count int64
-	h.DB.Model(&models.Note{}).Where("id = ? AND owner_id = ?", noteID, userID).Count(&count)
-	if count > 0 {
-		return true
-	}
+// The helpers below answer yes/no questions from the effective permission
+// computed by the access resolver. Resolution errors deny access.
 
-	// Check if note is shared with write access
-	h.DB.Model(&models.NoteShare{}).
-		Where("note_id = ? AND user_id = ? AND access = 'write'", noteID, userID).
-		Count(&count)
-	return count > 0
+func (h *AssetHandler) canReadFolder(userID, folderID string) bool {
+	decision, err := h.Access.Folder(userID, folderID)
+	return err == nil && decision.CanRead()
 }
 
-func (h *AssetHandler) ownsNote(userID, noteID string) bool {
-	var count int64
-	h.DB.Model(&models.Note{}).Where("id = ? AND owner_id = ?", noteID, userID).Count(&count)
-	return count > 0
+func (h *AssetHandler) canReadNote(userID, noteID string) bool {
+	decision, err := h.Access.Note(userID, noteID)
+	return err == nil && decision.CanRead()
+}
+
+// assetError answers with the status matching an error from the assets
+// service, hiding unexpected errors behind fallback.
+func assetError(c *gin.Context, err error, fallback string) {
+	var denied *assets.AccessError
+	var conflict *assets.VersionConflictError
+	var missingRevision *assets.RevisionNotFoundError
+	switch {
+	case errors.As(err, &conflict):
+		preconditionFailed(c, conflict.Current)
+	case errors.As(err, &denied):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, assets.ErrFolderNotFound),
+		errors.Is(err, assets.ErrNoteNotFound),
+		errors.Is(err, assets.ErrTrashNotFound),
+		errors.As(err, &missingRevision):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, assets.ErrFolderCycle),
+		errors.Is(err, assets.ErrAlreadyShared),
+		errors.Is(err, trash.ErrParentTrashed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, assets.ErrNothingToUpdate):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
 }
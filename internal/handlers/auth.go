@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+	"user-team-asset-management/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuthHandler struct {
+	Keys *auth.Keyring
+}
+
+// JWKS publishes the verification keys so other services can validate
+// access tokens without sharing a secret.
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.Keys.JWKS())
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+	"user-team-asset-management/internal/policy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authorize checks perm for the current user, scoped to teamID when it is
+// not empty. On failure it writes the error response and returns false.
+func authorize(c *gin.Context, authz policy.Authorizer, perm policy.Permission, teamID string) bool {
+	allowed, err := authz.Authorize(c.GetString("userID"), perm, teamID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission " + string(perm) + " required"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"user-team-asset-management/internal/assets"
+
+	"github.com/gin-gonic/gin"
+)
+
+func etag(version int) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// ifMatch reports whether the request's If-Match header accepts the current
+// version. A missing header or "*" accepts any version.
+func ifMatch(c *gin.Context, current int) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		tag = strings.Trim(strings.TrimPrefix(tag, "W/"), "\"")
+		if version, err := strconv.Atoi(tag); err == nil && version == current {
+			return true
+		}
+	}
+	return false
+}
+
+// versionCheck lets the assets service check versions against If-Match.
+func versionCheck(c *gin.Context) assets.VersionCheck {
+	return func(current int) bool {
+		return ifMatch(c, current)
+	}
+}
+
+func preconditionFailed(c *gin.Context, current int) {
+	c.Header("ETag", etag(current))
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error":          "Resource was modified by someone else",
+		"currentVersion": current,
+	})
+}
@@ -2,20 +2,26 @@ package handlers
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
+	"user-team-asset-management/internal/accounts"
+	"user-team-asset-management/internal/auth"
+	"user-team-asset-management/internal/mail"
 	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/policy"
 	"user-team-asset-management/internal/utils"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 type ImportHandler struct {
 	DB        *gorm.DB
-	JWTSecret string
+	Authz     policy.Authorizer
+	Passwords *auth.PasswordManager
+	Accounts  *accounts.Service
 }
 
 type ImportResult struct {
@@ -34,10 +40,7 @@ type UserRow struct {
 }
 
 func (h *ImportHandler) ImportUsers(c *gin.Context) {
-	// Check if user is manager
-	role := c.GetString("role")
-	if role != "manager" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Manager role required"})
+	if !authorize(c, h.Authz, policy.UserImport, "") {
 		return
 	}
 
@@ -82,13 +85,27 @@ func (h *ImportHandler) ImportUsers(c *gin.Context) {
 		})
 	}
 
+	// Roles above member need role.assign, like creating a single user.
+	// Without it those rows are rejected and the rest imported.
+	canAssign := true
+	for _, userRow := range userRows {
+		if policy.Elevated(userRow.Role) {
+			canAssign, err = h.Authz.Authorize(c.GetString("userID"), policy.RoleAssign, "")
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+				return
+			}
+			break
+		}
+	}
+
 	// Process users with goroutines
-	result := h.processUsersWithWorkerPool(userRows, 5) // 5 workers
+	result := h.processUsersWithWorkerPool(userRows, 5, canAssign) // 5 workers
 
 	c.JSON(http.StatusOK, result)
 }
 
-func (h *ImportHandler) processUsersWithWorkerPool(userRows []UserRow, numWorkers int) ImportResult {
+func (h *ImportHandler) processUsersWithWorkerPool(userRows []UserRow, numWorkers int, canAssign bool) ImportResult {
 	jobs := make(chan UserRow, len(userRows))
 	results := make(chan ProcessResult, len(userRows))
 
@@ -96,7 +113,7 @@ func (h *ImportHandler) processUsersWithWorkerPool(userRows []UserRow, numWorker
 	var wg sync.WaitGroup
 	for w := 1; w <= numWorkers; w++ {
 		wg.Add(1)
-		go h.worker(jobs, results, &wg)
+		go h.worker(jobs, results, &wg, canAssign)
 	}
 
 	// Send jobs
@@ -139,58 +156,74 @@ type ProcessResult struct {
 	RowNum  int
 }
 
-func (h *ImportHandler) worker(jobs <-chan UserRow, results chan<- ProcessResult, wg *sync.WaitGroup) {
+func (h *ImportHandler) worker(jobs <-chan UserRow, results chan<- ProcessResult, wg *sync.WaitGroup, canAssign bool) {
 	defer wg.Done()
 
 	for userRow := range jobs {
-		result := h.createUserFromRow(userRow)
+		result := h.createUserFromRow(userRow, canAssign)
 		results <- result
 	}
 }
 
-func (h *ImportHandler) createUserFromRow(userRow UserRow) ProcessResult {
+func (h *ImportHandler) createUserFromRow(userRow UserRow, canAssign bool) ProcessResult {
 	// Validate role
-	if userRow.Role != "manager" && userRow.Role != "member" {
+	if !policy.IsGlobalRole(userRow.Role) {
+		return ProcessResult{
+			Success: false,
+			Error:   "invalid role " + userRow.Role,
+			RowNum:  userRow.RowNum,
+		}
+	}
+	if policy.Elevated(userRow.Role) && !canAssign {
 		return ProcessResult{
 			Success: false,
-			Error:   "invalid role, must be 'manager' or 'member'",
+			Error:   "permission role.assign required for role " + userRow.Role,
 			RowNum:  userRow.RowNum,
 		}
 	}
 
-	// Check if email already exists
-	var existingUser models.User
-	if err := h.DB.Where("email = ?", userRow.Email).First(&existingUser).Error; err == nil {
+	// Validate and normalize the email address
+	email, err := mail.NormalizeAddress(userRow.Email)
+	if err != nil {
 		return ProcessResult{
 			Success: false,
-			Error:   "email already exists",
+			Error:   "invalid email " + userRow.Email,
 			RowNum:  userRow.RowNum,
 		}
 	}
 
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(userRow.Password), bcrypt.DefaultCost)
+	// Check the password policy and hash the password
+	hashedPassword, err := h.Passwords.Hash(userRow.Password, userRow.Username, email)
 	if err != nil {
+		var policyErr *auth.PasswordPolicyError
+		message := "failed to hash password"
+		if errors.As(err, &policyErr) {
+			message = policyErr.Error()
+		}
 		return ProcessResult{
 			Success: false,
-			Error:   "failed to hash password",
+			Error:   message,
 			RowNum:  userRow.RowNum,
 		}
 	}
 
-	// Create user
+	// Create user and mail them a verification link
 	user := models.User{
 		ID:           utils.GenerateID(),
 		Username:     userRow.Username,
-		Email:        userRow.Email,
-		PasswordHash: string(hashedPassword),
+		Email:        email,
+		PasswordHash: hashedPassword,
 		Role:         userRow.Role,
 	}
 
-	if err := h.DB.Create(&user).Error; err != nil {
+	if err := h.Accounts.Register(&user); err != nil {
+		message := "failed to create user in database"
+		if errors.Is(err, accounts.ErrEmailTaken) {
+			message = "email already exists"
+		}
 		return ProcessResult{
 			Success: false,
-			Error:   "failed to create user in database",
+			Error:   message,
 			RowNum:  userRow.RowNum,
 		}
 	}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"user-team-asset-management/internal/access"
+	"user-team-asset-management/internal/pagination"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// parseListRequest reads pagination parameters from the query string,
+// answering 400 when they are invalid.
+func parseListRequest[T any](c *gin.Context, spec pagination.Spec[T]) (pagination.Request, bool) {
+	req, err := pagination.ParseRequest(c.Query, spec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+	return req, true
+}
+
+// listFolders answers with one page of the folders owned by or shared with
+// any of userIDs. The ownership parameter narrows the list to "owned" or
+// "shared" folders.
+func listFolders(c *gin.Context, resolver *access.Resolver, userIDs []string) {
+	req, ok := parseListRequest(c, pagination.Folders)
+	if !ok {
+		return
+	}
+
+	query, err := resolver.FoldersOf(userIDs, c.Query("ownership"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := pagination.Paginate(query, req, pagination.Folders)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch folders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"folders":    page.Items,
+		"nextCursor": page.NextCursor,
+		"totalCount": page.TotalCount,
+	})
+}
+
+// listTeams answers with one page of teams matching query.
+func listTeams(c *gin.Context, query *gorm.DB) {
+	req, ok := parseListRequest(c, pagination.Teams)
+	if !ok {
+		return
+	}
+
+	page, err := pagination.Paginate(query, req, pagination.Teams)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch teams"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"teams":      page.Items,
+		"nextCursor": page.NextCursor,
+		"totalCount": page.TotalCount,
+	})
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"user-team-asset-management/internal/logger"
+	"user-team-asset-management/internal/oidc"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oidcStateCookie binds a login started at the provider to the browser that
+// started it.
+const oidcStateCookie = "oidc_state"
+
+// OIDCHandler sends browsers to the OpenID Connect provider and takes them
+// back. The callback ends at FrontendURL with a one-time code, which the
+// client redeems through GraphQL for a session or a linked identity.
+type OIDCHandler struct {
+	OIDC        *oidc.Service
+	FrontendURL string
+}
+
+// Login redirects to the provider's login page.
+func (h *OIDCHandler) Login(c *gin.Context) {
+	authURL, state, err := h.OIDC.Begin(c.Request.Context(), "")
+	if err != nil {
+		logger.DefaultLogger.Error("Failed to start single sign-on: " + err.Error())
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to reach the identity provider"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oidc.RequestTTL.Seconds()), "/auth/oidc", "", h.secure(), true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback receives the provider's answer and redirects to the frontend
+// with either a code and the flow it is for, login or link, or an error.
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	boundState, _ := c.Cookie(oidcStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", h.secure(), true)
+
+	// The user cancelled or the provider refused
+	if reason := c.Query("error"); reason != "" {
+		h.finish(c, url.Values{"error": {reason}})
+		return
+	}
+
+	code, link, err := h.OIDC.Callback(c.Request.Context(), c.Query("state"), c.Query("code"), boundState)
+	if err != nil {
+		reason := "login_failed"
+		if errors.Is(err, oidc.ErrInvalidState) {
+			reason = "invalid_state"
+		} else {
+			logger.DefaultLogger.Error("Single sign-on callback failed: " + err.Error())
+		}
+		h.finish(c, url.Values{"error": {reason}})
+		return
+	}
+
+	flow := "login"
+	if link {
+		flow = "link"
+	}
+	h.finish(c, url.Values{"code": {code}, "flow": {flow}})
+}
+
+func (h *OIDCHandler) finish(c *gin.Context, params url.Values) {
+	target, err := url.Parse(h.FrontendURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid frontend URL"})
+		return
+	}
+	query := target.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	target.RawQuery = query.Encode()
+	c.Redirect(http.StatusFound, target.String())
+}
+
+// secure marks the state cookie as HTTPS-only whenever the provider sends
+// users back over HTTPS.
+func (h *OIDCHandler) secure() bool {
+	return strings.HasPrefix(h.OIDC.Provider.RedirectURL, "https://")
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"user-team-asset-management/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// outboxLimit is how many messages ListMessages answers with at most.
+const outboxLimit = 50
+
+// OutboxHandler shows the mail kept by the outbox mail driver. It is meant
+// for development only: the messages hold password reset and verification
+// links.
+type OutboxHandler struct {
+	DB *gorm.DB
+}
+
+// ListMessages answers with the newest messages, optionally only those sent
+// to the address in the "to" query parameter.
+func (h *OutboxHandler) ListMessages(c *gin.Context) {
+	query := h.DB.Order("created_at DESC").Limit(outboxLimit)
+	if to := strings.TrimSpace(c.Query("to")); to != "" {
+		query = query.Where("LOWER(\"to\") = LOWER(?)", to)
+	}
+
+	var messages []models.OutboxMessage
+	if err := query.Find(&messages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"user-team-asset-management/internal/diff"
+	"user-team-asset-management/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *AssetHandler) ListNoteRevisions(c *gin.Context) {
+	noteID := c.Param("noteId")
+	userID := c.GetString("userID")
+
+	if !h.canReadNote(userID, noteID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No access to this note"})
+		return
+	}
+
+	var history []models.NoteRevision
+	if err := h.DB.Where("note_id = ?", noteID).Order("revision DESC").Find(&history).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revisions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revisions": history})
+}
+
+func (h *AssetHandler) GetNoteRevision(c *gin.Context) {
+	noteID := c.Param("noteId")
+	userID := c.GetString("userID")
+
+	if !h.canReadNote(userID, noteID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No access to this note"})
+		return
+	}
+
+	revision, err := h.findRevision(noteID, c.Param("revision"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, revision)
+}
+
+// DiffNoteRevisions returns a unified diff of the body between two revisions,
+// given as ?from=N&to=M.
+func (h *AssetHandler) DiffNoteRevisions(c *gin.Context) {
+	noteID := c.Param("noteId")
+	userID := c.GetString("userID")
+
+	if !h.canReadNote(userID, noteID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No access to this note"})
+		return
+	}
+
+	from, err := h.findRevision(noteID, c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := h.findRevision(noteID, c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":      from.Revision,
+		"to":        to.Revision,
+		"fromTitle": from.Title,
+		"toTitle":   to.Title,
+		"diff": diff.Unified(
+			fmt.Sprintf("revision %d", from.Revision),
+			fmt.Sprintf("revision %d", to.Revision),
+			from.Body, to.Body, 3,
+		),
+	})
+}
+
+// RestoreNoteRevision copies an old revision's content back onto the note.
+// The restore is recorded as a new revision rather than rewriting history.
+func (h *AssetHandler) RestoreNoteRevision(c *gin.Context) {
+	number, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid revision number"})
+		return
+	}
+
+	note, head, err := h.Assets.RestoreRevision(c.GetString("userID"), c.Param("noteId"), number, versionCheck(c))
+	if err != nil {
+		assetError(c, err, "Failed to restore revision")
+		return
+	}
+
+	c.Header("ETag", etag(note.Version))
+	c.JSON(http.StatusOK, head)
+}
+
+func (h *AssetHandler) findRevision(noteID, number string) (*models.NoteRevision, error) {
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return nil, errors.New("Invalid revision number")
+	}
+	return h.Assets.FindRevision(noteID, n)
+}
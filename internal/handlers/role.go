@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/policy"
+	"user-team-asset-management/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RoleHandler struct {
+	DB    *gorm.DB
+	Authz policy.Authorizer
+}
+
+func (h *RoleHandler) ListRoles(c *gin.Context) {
+	roles := make([]gin.H, 0)
+	for _, name := range policy.Roles() {
+		roles = append(roles, gin.H{
+			"role":        name,
+			"permissions": policy.Permissions(name),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+func (h *RoleHandler) GetUserRoles(c *gin.Context) {
+	targetUserID := c.Param("userId")
+
+	if c.GetString("userID") != targetUserID && !authorize(c, h.Authz, policy.RoleAssign, "") {
+		return
+	}
+
+	var user models.User
+	if err := h.DB.Where("id = ?", targetUserID).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var assignments []models.RoleAssignment
+	if err := h.DB.Where("user_id = ?", targetUserID).Find(&assignments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch role assignments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"role":        user.Role,
+		"assignments": assignments,
+	})
+}
+
+func (h *RoleHandler) AssignRole(c *gin.Context) {
+	targetUserID := c.Param("userId")
+	var req struct {
+		Role   string `json:"role" binding:"required"`
+		TeamID string `json:"teamId"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !authorize(c, h.Authz, policy.RoleAssign, req.TeamID) {
+		return
+	}
+
+	if !policy.IsRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+	if req.TeamID == "" && !policy.IsGlobalRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role " + req.Role + " can only be assigned within a team"})
+		return
+	}
+
+	var count int64
+	if err := h.DB.Model(&models.User{}).Where("id = ?", targetUserID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if req.TeamID != "" {
+		if err := h.DB.Model(&models.Team{}).Where("id = ?", req.TeamID).Count(&count).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up team"})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Team not found"})
+			return
+		}
+	}
+
+	assignment := models.RoleAssignment{
+		ID:     utils.GenerateID(),
+		UserID: targetUserID,
+		Role:   req.Role,
+		TeamID: req.TeamID,
+	}
+
+	if err := h.DB.Create(&assignment).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Role is already assigned"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign role"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, assignment)
+}
+
+func (h *RoleHandler) RevokeRole(c *gin.Context) {
+	targetUserID := c.Param("userId")
+	assignmentID := c.Param("assignmentId")
+
+	var assignment models.RoleAssignment
+	if err := h.DB.Where("id = ? AND user_id = ?", assignmentID, targetUserID).First(&assignment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role assignment not found"})
+		return
+	}
+
+	if !authorize(c, h.Authz, policy.RoleAssign, assignment.TeamID) {
+		return
+	}
+
+	if err := h.DB.Delete(&assignment).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke role"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role revoked successfully"})
+}
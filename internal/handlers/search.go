@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"user-team-asset-management/internal/search"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+type SearchHandler struct {
+	Service *search.Service
+}
+
+// Search runs a full-text query over notes and folders the caller can read.
+// Supports ?q=, ?type=note|folder, ?limit= and ?offset=.
+func (h *SearchHandler) Search(c *gin.Context) {
+	text := c.Query("q")
+	if text == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter q is required"})
+		return
+	}
+
+	itemType := c.Query("type")
+	if itemType != "" && itemType != search.TypeNote && itemType != search.TypeFolder {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be 'note' or 'folder'"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSearchLimit)))
+	if err != nil || limit < 1 || limit > maxSearchLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxSearchLimit)})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+		return
+	}
+
+	results, total, err := h.Service.Search(search.Query{
+		UserID: c.GetString("userID"),
+		Text:   text,
+		Type:   itemType,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":    results,
+		"totalCount": total,
+		"limit":      limit,
+		"offset":     offset,
+	})
+}
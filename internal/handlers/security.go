@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"user-team-asset-management/internal/auth"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/pagination"
+	"user-team-asset-management/internal/policy"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SecurityHandler struct {
+	DB     *gorm.DB
+	Authz  policy.Authorizer
+	Logins *auth.LoginGuard
+}
+
+// UnlockUser clears the failed logins of a user's account, ending any
+// backoff or lockout.
+func (h *SecurityHandler) UnlockUser(c *gin.Context) {
+	if !authorize(c, h.Authz, policy.UserUnlock, "") {
+		return
+	}
+
+	blocked, err := h.Logins.Unlock(c.Param("userId"), c.GetString("userID"), c.ClientIP())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"unlocked": blocked})
+}
+
+// ListAuditEntries answers with one page of the audit log, newest first.
+func (h *SecurityHandler) ListAuditEntries(c *gin.Context) {
+	if !authorize(c, h.Authz, policy.AuditRead, "") {
+		return
+	}
+
+	req, ok := parseListRequest(c, pagination.AuditEntries)
+	if !ok {
+		return
+	}
+
+	page, err := pagination.Paginate(h.DB.Model(&models.AuditEntry{}), req, pagination.AuditEntries)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit entries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries":    page.Items,
+		"nextCursor": page.NextCursor,
+		"totalCount": page.TotalCount,
+	})
+}
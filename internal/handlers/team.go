@@ -1,16 +1,24 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"user-team-asset-management/internal/events"
 	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/policy"
 	"user-team-asset-management/internal/utils"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var errLastManager = errors.New("cannot remove the last manager")
+
 type TeamHandler struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Authz  policy.Authorizer
+	Events *events.Bus
 }
 
 type CreateTeamRequest struct {
@@ -32,33 +40,116 @@ func (h *TeamHandler) CreateTeam(c *gin.Context) {
 		return
 	}
 
+	if !authorize(c, h.Authz, policy.TeamCreate, "") {
+		return
+	}
+
 	userID := c.GetString("userID")
 	teamID := utils.GenerateID()
 
+	// The creator always manages the team
+	managerIDs := []string{userID}
+	for _, manager := range req.Managers {
+		managerIDs = append(managerIDs, manager.ManagerID)
+	}
+	memberIDs := make([]string, 0, len(req.Members))
+	for _, member := range req.Members {
+		memberIDs = append(memberIDs, member.MemberID)
+	}
+	managerIDs = uniqueIDs(managerIDs)
+	memberIDs = uniqueIDs(memberIDs)
+
+	// Reject the whole request if any referenced user does not exist
+	existing, err := h.existingUserIDs(append(append([]string{}, managerIDs...), memberIDs...))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate users"})
+		return
+	}
+	rejected := RejectedIDs{
+		Managers: missingIDs(managerIDs, existing),
+		Members:  missingIDs(memberIDs, existing),
+	}
+	if !rejected.Empty() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Some users do not exist",
+			"rejected": rejected,
+		})
+		return
+	}
+
 	team := models.Team{
 		ID:       teamID,
 		TeamName: req.TeamName,
 	}
 
-	if err := h.DB.Create(&team).Error; err != nil {
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&team).Error; err != nil {
+			return err
+		}
+		for _, managerID := range managerIDs {
+			if err := tx.Create(&models.TeamManager{TeamID: teamID, UserID: managerID}).Error; err != nil {
+				return err
+			}
+		}
+		for _, memberID := range memberIDs {
+			if err := tx.Create(&models.TeamMember{TeamID: teamID, UserID: memberID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create team"})
 		return
 	}
 
-	// Add creator as manager
-	h.DB.Create(&models.TeamManager{TeamID: teamID, UserID: userID})
+	c.JSON(http.StatusCreated, team)
+}
 
-	// Add other managers
-	for _, manager := range req.Managers {
-		h.DB.Create(&models.TeamManager{TeamID: teamID, UserID: manager.ManagerID})
+// RejectedIDs lists the user IDs from a request that failed validation.
+type RejectedIDs struct {
+	Managers []string `json:"managers"`
+	Members  []string `json:"members"`
+}
+
+func (r RejectedIDs) Empty() bool {
+	return len(r.Managers) == 0 && len(r.Members) == 0
+}
+
+// existingUserIDs returns the subset of ids that belong to existing users.
+func (h *TeamHandler) existingUserIDs(ids []string) (map[string]bool, error) {
+	var found []string
+	if err := h.DB.Model(&models.User{}).Where("id IN ?", ids).Pluck("id", &found).Error; err != nil {
+		return nil, err
 	}
 
-	// Add members
-	for _, member := range req.Members {
-		h.DB.Create(&models.TeamMember{TeamID: teamID, UserID: member.MemberID})
+	existing := make(map[string]bool, len(found))
+	for _, id := range found {
+		existing[id] = true
+	}
+	return existing, nil
+}
+
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
 	}
+	return unique
+}
 
-	c.JSON(http.StatusCreated, team)
+func missingIDs(ids []string, existing map[string]bool) []string {
+	missing := []string{}
+	for _, id := range ids {
+		if !existing[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
 }
 
 func (h *TeamHandler) AddMember(c *gin.Context) {
@@ -72,9 +163,11 @@ func (h *TeamHandler) AddMember(c *gin.Context) {
 		return
 	}
 
-	userID := c.GetString("userID")
-	if !h.isTeamManager(userID, teamID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to manage this team"})
+	if !authorize(c, h.Authz, policy.TeamMembersWrite, teamID) {
+		return
+	}
+
+	if !h.teamExists(c, teamID) || !h.userExists(c, req.MemberID) {
 		return
 	}
 
@@ -84,28 +177,35 @@ func (h *TeamHandler) AddMember(c *gin.Context) {
 	}
 
 	if err := h.DB.Create(&teamMember).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "User is already a member"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add member"})
 		return
 	}
 
+	h.Events.MembershipChanged(events.ActionMemberAdded, teamID, req.MemberID, c.GetString("userID"))
 	c.JSON(http.StatusOK, gin.H{"message": "Member added successfully"})
 }
 
 func (h *TeamHandler) RemoveMember(c *gin.Context) {
 	teamID := c.Param("teamId")
 	memberID := c.Param("memberId")
-	userID := c.GetString("userID")
 
-	if !h.isTeamManager(userID, teamID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to manage this team"})
+	if !authorize(c, h.Authz, policy.TeamMembersWrite, teamID) {
 		return
 	}
 
-	if err := h.DB.Where("team_id = ? AND user_id = ?", teamID, memberID).Delete(&models.TeamMember{}).Error; err != nil {
+	result := h.DB.Where("team_id = ? AND user_id = ?", teamID, memberID).Delete(&models.TeamMember{})
+	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
 		return
 	}
 
+	if result.RowsAffected > 0 {
+		h.Events.MembershipChanged(events.ActionMemberRemoved, teamID, memberID, c.GetString("userID"))
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
 }
 
@@ -120,15 +220,11 @@ func (h *TeamHandler) AddManager(c *gin.Context) {
 		return
 	}
 
-	userID := c.GetString("userID")
-	if !h.isTeamManager(userID, teamID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to manage this team"})
+	if !authorize(c, h.Authz, policy.TeamManagersWrite, teamID) {
 		return
 	}
 
-	// Check if user is already a manager
-	if h.isTeamManager(req.ManagerID, teamID) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User is already a manager"})
+	if !h.teamExists(c, teamID) || !h.userExists(c, req.ManagerID) {
 		return
 	}
 
@@ -138,51 +234,98 @@ func (h *TeamHandler) AddManager(c *gin.Context) {
 	}
 
 	if err := h.DB.Create(&teamManager).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "User is already a manager"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add manager"})
 		return
 	}
 
+	h.Events.MembershipChanged(events.ActionManagerAdded, teamID, req.ManagerID, c.GetString("userID"))
 	c.JSON(http.StatusOK, gin.H{"message": "Manager added successfully"})
 }
 
 func (h *TeamHandler) RemoveManager(c *gin.Context) {
 	teamID := c.Param("teamId")
 	managerID := c.Param("managerId")
-	userID := c.GetString("userID")
 
-	if !h.isTeamManager(userID, teamID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to manage this team"})
+	if !authorize(c, h.Authz, policy.TeamManagersWrite, teamID) {
 		return
 	}
 
-	// Prevent removing the last manager
-	var managerCount int64
-	h.DB.Model(&models.TeamManager{}).Where("team_id = ?", teamID).Count(&managerCount)
-	if managerCount <= 1 {
+	// Prevent removing the last manager. The team row is locked so that two
+	// concurrent removals cannot both pass the check.
+	var removed int64
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var team models.Team
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", teamID).First(&team).Error; err != nil {
+			return err
+		}
+
+		var managerCount int64
+		if err := tx.Model(&models.TeamManager{}).Where("team_id = ?", teamID).Count(&managerCount).Error; err != nil {
+			return err
+		}
+		if managerCount <= 1 {
+			return errLastManager
+		}
+
+		result := tx.Where("team_id = ? AND user_id = ?", teamID, managerID).Delete(&models.TeamManager{})
+		removed = result.RowsAffected
+		return result.Error
+	})
+	if errors.Is(err, errLastManager) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot remove the last manager"})
 		return
 	}
-
-	if err := h.DB.Where("team_id = ? AND user_id = ?", teamID, managerID).Delete(&models.TeamManager{}).Error; err != nil {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Team not found"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove manager"})
 		return
 	}
 
+	if removed > 0 {
+		h.Events.MembershipChanged(events.ActionManagerRemoved, teamID, managerID, c.GetString("userID"))
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Manager removed successfully"})
 }
 
-func (h *TeamHandler) isTeamManager(userID, teamID string) bool {
+// teamExists writes a 404 response and returns false if the team is missing.
+func (h *TeamHandler) teamExists(c *gin.Context, teamID string) bool {
 	var count int64
-	h.DB.Model(&models.TeamManager{}).Where("team_id = ? AND user_id = ?", teamID, userID).Count(&count)
-	return count > 0
+	if err := h.DB.Model(&models.Team{}).Where("id = ?", teamID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up team"})
+		return false
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Team not found"})
+		return false
+	}
+	return true
+}
+
+// userExists writes a 404 response and returns false if the user is missing.
+func (h *TeamHandler) userExists(c *gin.Context, userID string) bool {
+	var count int64
+	if err := h.DB.Model(&models.User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return false
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found", "rejected": []string{userID}})
+		return false
+	}
+	return true
 }
 
 func (h *TeamHandler) GetTeam(c *gin.Context) {
 	teamID := c.Param("teamId")
-	userID := c.GetString("userID")
 
-	if !h.isTeamMember(userID, teamID) && !h.isTeamManager(userID, teamID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not a team member"})
+	if !authorize(c, h.Authz, policy.TeamRead, teamID) {
 		return
 	}
 
@@ -211,55 +354,26 @@ func (h *TeamHandler) GetTeam(c *gin.Context) {
 	})
 }
 
-func (h *TeamHandler) isTeamMember(userID, teamID string) bool {
-	var count int64
-	h.DB.Model(&models.TeamMember{}).Where("team_id = ? AND user_id = ?", teamID, userID).Count(&count)
-	return count > 0
-}
-
 func (h *TeamHandler) GetAllTeams(c *gin.Context) {
-	userRole := c.GetString("role")
-
-	// Chỉ manager mới có thể xem tất cả teams
-	if userRole != "manager" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Manager role required"})
-		return
-	}
-
-	var teams []models.Team
-	if err := h.DB.Find(&teams).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch teams"})
+	if !authorize(c, h.Authz, policy.TeamListAll, "") {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"teams": teams})
+	listTeams(c, h.DB.Model(&models.Team{}))
 }
 
 func (h *TeamHandler) SearchTeams(c *gin.Context) {
-	teamName := c.Query("name")
 	userID := c.GetString("userID")
 
-	var teams []models.Team
-	query := h.DB.Model(&models.Team{})
-
-	// Filter by name if provided
-	if teamName != "" {
-		query = query.Where("team_name ILIKE ?", "%"+teamName+"%")
-	}
-
-	// Only show teams where user is member or manager
-	query = query.Where(`
-		id IN (
+	// Only show teams where user is member or manager; the name filter is
+	// applied by the pagination spec
+	query := h.DB.Model(&models.Team{}).Where(`
+		teams.id IN (
 			SELECT team_id FROM team_managers WHERE user_id = ?
 			UNION
 			SELECT team_id FROM team_members WHERE user_id = ?
 		)
 	`, userID, userID)
 
-	if err := query.Find(&teams).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search teams"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"teams": teams})
+	listTeams(c, query)
 }
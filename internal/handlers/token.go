@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+	"user-team-asset-management/internal/accounts"
+	"user-team-asset-management/internal/auth"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/policy"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TokenHandler manages personal access tokens, both the caller's own and
+// those of service accounts. Its routes need a login session, so that a
+// token cannot be used to create tokens with more scopes.
+type TokenHandler struct {
+	DB       *gorm.DB
+	Authz    policy.Authorizer
+	Tokens   *auth.PATManager
+	Accounts *accounts.Service
+}
+
+type CreateTokenRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type CreateServiceAccountRequest struct {
+	Username string `json:"username" binding:"required"`
+	Role     string `json:"role" binding:"required"`
+}
+
+// tokenResponse shows a token with its scopes as a list.
+type tokenResponse struct {
+	models.PersonalAccessToken
+	Scopes []string `json:"scopes"`
+}
+
+func newTokenResponse(token models.PersonalAccessToken) tokenResponse {
+	return tokenResponse{PersonalAccessToken: token, Scopes: token.ScopeList()}
+}
+
+// ListScopes describes the scopes tokens can be granted.
+func (h *TokenHandler) ListScopes(c *gin.Context) {
+	names := make([]string, 0, len(auth.Scopes))
+	for name := range auth.Scopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	scopes := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		scopes = append(scopes, gin.H{"scope": name, "description": auth.Scopes[name]})
+	}
+	c.JSON(http.StatusOK, gin.H{"scopes": scopes})
+}
+
+func (h *TokenHandler) ListTokens(c *gin.Context) {
+	h.listTokens(c, c.GetString("userID"))
+}
+
+// CreateToken answers with the token itself, which cannot be shown again.
+func (h *TokenHandler) CreateToken(c *gin.Context) {
+	h.createToken(c, c.GetString("userID"))
+}
+
+func (h *TokenHandler) RevokeToken(c *gin.Context) {
+	h.revokeToken(c, c.GetString("userID"))
+}
+
+func (h *TokenHandler) ListServiceAccounts(c *gin.Context) {
+	if !authorize(c, h.Authz, policy.ServiceAccountManage, "") {
+		return
+	}
+
+	var users []models.User
+	if err := h.DB.Where("service_account").Order("username").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch service accounts"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"serviceAccounts": users})
+}
+
+// CreateServiceAccount needs role.assign as well for roles above member,
+// like deactivating users with those roles.
+func (h *TokenHandler) CreateServiceAccount(c *gin.Context) {
+	var req CreateServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !authorize(c, h.Authz, policy.ServiceAccountManage, "") {
+		return
+	}
+	if !policy.IsGlobalRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+	if policy.Elevated(req.Role) && !authorize(c, h.Authz, policy.RoleAssign, "") {
+		return
+	}
+
+	user, err := h.Accounts.CreateServiceAccount(c.GetString("userID"), req.Username, req.Role)
+	if err != nil {
+		accountError(c, err, "Failed to create service account")
+		return
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+func (h *TokenHandler) ListServiceAccountTokens(c *gin.Context) {
+	if userID, ok := h.serviceAccount(c); ok {
+		h.listTokens(c, userID)
+	}
+}
+
+func (h *TokenHandler) CreateServiceAccountToken(c *gin.Context) {
+	if userID, ok := h.serviceAccount(c); ok {
+		h.createToken(c, userID)
+	}
+}
+
+func (h *TokenHandler) RevokeServiceAccountToken(c *gin.Context) {
+	if userID, ok := h.serviceAccount(c); ok {
+		h.revokeToken(c, userID)
+	}
+}
+
+// serviceAccount checks that the caller manages service accounts and that
+// the userId parameter is one.
+func (h *TokenHandler) serviceAccount(c *gin.Context) (string, bool) {
+	if !authorize(c, h.Authz, policy.ServiceAccountManage, "") {
+		return "", false
+	}
+	user, err := h.Accounts.ServiceAccount(c.Param("userId"))
+	if err != nil {
+		accountError(c, err, "Failed to fetch service account")
+		return "", false
+	}
+	return user.ID, true
+}
+
+func (h *TokenHandler) listTokens(c *gin.Context, userID string) {
+	tokens, err := h.Tokens.List(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tokens"})
+		return
+	}
+
+	response := make([]tokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		response = append(response, newTokenResponse(token))
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": response})
+}
+
+func (h *TokenHandler) createToken(c *gin.Context, userID string) {
+	var req CreateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, rawToken, err := h.Tokens.Create(userID, c.GetString("userID"), req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		tokenError(c, err, "Failed to create token")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"token":       rawToken,
+		"accessToken": newTokenResponse(*token),
+	})
+}
+
+func (h *TokenHandler) revokeToken(c *gin.Context, userID string) {
+	if err := h.Tokens.Revoke(userID, c.Param("tokenId"), c.GetString("userID")); err != nil {
+		tokenError(c, err, "Failed to revoke token")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func tokenError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, auth.ErrPATNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrPATNameRequired),
+		errors.Is(err, auth.ErrPATScopeRequired),
+		errors.Is(err, auth.ErrUnknownScope),
+		errors.Is(err, auth.ErrPATExpiry):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"user-team-asset-management/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *AssetHandler) ListTrash(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	var entries []models.TrashEntry
+	if err := h.DB.Where("owner_id = ?", userID).Order("deleted_at DESC").Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": entries})
+}
+
+func (h *AssetHandler) RestoreTrash(c *gin.Context) {
+	entry, err := h.Assets.RestoreTrash(c.GetString("userID"), c.Param("trashId"))
+	if err != nil {
+		assetError(c, err, "Failed to restore item")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Item restored successfully",
+		"itemType": entry.ItemType,
+		"itemId":   entry.ItemID,
+	})
+}
+
+// PurgeTrash permanently deletes a trashed item without waiting for the
+// retention period.
+func (h *AssetHandler) PurgeTrash(c *gin.Context) {
+	if err := h.Assets.PurgeTrash(c.GetString("userID"), c.Param("trashId")); err != nil {
+		assetError(c, err, "Failed to purge item")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Item permanently deleted"})
+}
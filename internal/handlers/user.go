@@ -1,15 +1,21 @@
 package handlers
 
 import (
+    "errors"
     "net/http"
+    "user-team-asset-management/internal/accounts"
+    "user-team-asset-management/internal/auth"
     "user-team-asset-management/internal/models"
+    "user-team-asset-management/internal/policy"
     
     "github.com/gin-gonic/gin"
     "gorm.io/gorm"
 )
 
 type UserHandler struct {
-    DB *gorm.DB
+    DB       *gorm.DB
+    Authz    policy.Authorizer
+    Accounts *accounts.Service
 }
 
 func (h *UserHandler) GetProfile(c *gin.Context) {
@@ -43,4 +49,150 @@ func (h *UserHandler) GetUserTeams(c *gin.Context) {
         "managerTeams": managerTeams,
         "memberTeams":  memberTeams,
     })
-}
\ No newline at end of file
+}
+
+// UpdateProfile changes the caller's username and email address. A new
+// email address stays pending until the link mailed to it is opened.
+func (h *UserHandler) UpdateProfile(c *gin.Context) {
+    var req struct {
+        Username        *string `json:"username"`
+        Email           *string `json:"email"`
+        CurrentPassword string  `json:"currentPassword"`
+    }
+    
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    
+    user, err := h.Accounts.UpdateProfile(c.GetString("userID"), accounts.ProfileUpdate{
+        Username:        req.Username,
+        Email:           req.Email,
+        CurrentPassword: req.CurrentPassword,
+    })
+    if err != nil {
+        accountError(c, err, "Failed to update profile")
+        return
+    }
+    
+    c.JSON(http.StatusOK, user)
+}
+
+// VerifyEmail confirms a new email address with the token mailed to it. The
+// token is the credential, so no access token is needed.
+func (h *UserHandler) VerifyEmail(c *gin.Context) {
+    var req struct {
+        Token string `json:"token" binding:"required"`
+    }
+    
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    
+    user, err := h.Accounts.VerifyEmail(req.Token)
+    if err != nil {
+        accountError(c, err, "Failed to verify email address")
+        return
+    }
+    
+    c.JSON(http.StatusOK, user)
+}
+
+// ResendVerification mails a new verification link for the caller's pending
+// email address, or for their current one while it is unverified.
+func (h *UserHandler) ResendVerification(c *gin.Context) {
+    if err := h.Accounts.ResendVerification(c.GetString("userID")); err != nil {
+        accountError(c, err, "Failed to send verification link")
+        return
+    }
+    
+    c.JSON(http.StatusOK, gin.H{"message": "Verification link sent"})
+}
+
+// DeactivateUser signs a user out and keeps them from logging in. The
+// optional transferTo hands their folders and notes to another user.
+func (h *UserHandler) DeactivateUser(c *gin.Context) {
+    var req struct {
+        TransferTo string `json:"transferTo"`
+    }
+    
+    if c.Request.ContentLength != 0 {
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+    
+    if !h.authorizeDeactivate(c) {
+        return
+    }
+    
+    user, err := h.Accounts.Deactivate(c.GetString("userID"), c.Param("userId"), req.TransferTo)
+    if err != nil {
+        accountError(c, err, "Failed to deactivate user")
+        return
+    }
+    
+    c.JSON(http.StatusOK, user)
+}
+
+func (h *UserHandler) ReactivateUser(c *gin.Context) {
+    if !h.authorizeDeactivate(c) {
+        return
+    }
+    
+    user, err := h.Accounts.Reactivate(c.GetString("userID"), c.Param("userId"))
+    if err != nil {
+        accountError(c, err, "Failed to reactivate user")
+        return
+    }
+    
+    c.JSON(http.StatusOK, user)
+}
+
+// authorizeDeactivate requires user.deactivate, plus role.assign when the
+// user has a role above member, so that managers cannot lock out admins.
+func (h *UserHandler) authorizeDeactivate(c *gin.Context) bool {
+    if !authorize(c, h.Authz, policy.UserDeactivate, "") {
+        return false
+    }
+    
+    var user models.User
+    if err := h.DB.Select("role").Where("id = ?", c.Param("userId")).First(&user).Error; err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+        return false
+    }
+    if policy.Elevated(user.Role) {
+        return authorize(c, h.Authz, policy.RoleAssign, "")
+    }
+    return true
+}
+
+// accountError answers with the status matching an error from the accounts
+// service, hiding unexpected errors behind fallback.
+func accountError(c *gin.Context, err error, fallback string) {
+    switch {
+    case errors.Is(err, accounts.ErrUserNotFound),
+        errors.Is(err, accounts.ErrNotServiceAccount):
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+    case errors.Is(err, accounts.ErrEmailTaken),
+        errors.Is(err, accounts.ErrAlreadyDeactivated),
+        errors.Is(err, accounts.ErrNotDeactivated),
+        errors.Is(err, accounts.ErrEmailAlreadyVerified):
+        c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+    case errors.Is(err, accounts.ErrVerificationRecentlySent):
+        c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+    case errors.Is(err, auth.ErrWrongPassword):
+        c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+    case errors.Is(err, accounts.ErrInvalidUsername),
+        errors.Is(err, accounts.ErrInvalidEmail),
+        errors.Is(err, accounts.ErrPasswordRequired),
+        errors.Is(err, accounts.ErrInvalidVerificationToken),
+        errors.Is(err, accounts.ErrDeactivateSelf),
+        errors.Is(err, accounts.ErrInvalidTransferTarget):
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    default:
+        c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+    }
+}
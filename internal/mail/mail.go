@@ -0,0 +1,116 @@
+// Package mail sends email on behalf of the application. Mailer is the
+// extension point for real delivery; the stand-ins here write messages to
+// the log, to files or to a database outbox so that flows such as password
+// resets can be used locally.
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"user-team-asset-management/internal/logger"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidAddress = errors.New("invalid email address")
+
+// NormalizeAddress checks that address is a bare email address, without a
+// display name, and returns it lower-cased so that addresses differing only
+// in case are treated as one.
+func NormalizeAddress(address string) (string, error) {
+	parsed, err := netmail.ParseAddress(strings.TrimSpace(address))
+	if err != nil || parsed.Name != "" {
+		return "", ErrInvalidAddress
+	}
+	return strings.ToLower(parsed.Address), nil
+}
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the stand-in mailer for driver: "log", "file", which writes
+// into dir, or "outbox", which stores messages in db.
+func New(driver, dir, from string, db *gorm.DB) (Mailer, error) {
+	switch driver {
+	case "log":
+		return &LogMailer{From: from}, nil
+	case "outbox":
+		return &OutboxMailer{DB: db, From: from}, nil
+	case "file":
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		return &FileMailer{Dir: dir, From: from}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", driver)
+	}
+}
+
+// LogMailer writes every message to the application log.
+type LogMailer struct {
+	From string
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	logger.DefaultLogger.Info("Mail not delivered, logging it instead:\n" + format(m.From, msg))
+	return nil
+}
+
+// FileMailer writes every message to its own .eml file in Dir, which most
+// mail clients can open.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	name := time.Now().UTC().Format("20060102T150405") + "-" + utils.GenerateID() + ".eml"
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(format(m.From, msg)), 0600)
+}
+
+// OutboxMailer stores every message as a models.OutboxMessage, where
+// development tooling and tests can read it.
+type OutboxMailer struct {
+	DB   *gorm.DB
+	From string
+}
+
+func (m *OutboxMailer) Send(ctx context.Context, msg Message) error {
+	return m.DB.WithContext(ctx).Create(&models.OutboxMessage{
+		ID:      utils.GenerateID(),
+		From:    m.From,
+		To:      msg.To,
+		Subject: msg.Subject,
+		Body:    msg.Body,
+	}).Error
+}
+
+// format renders msg as an RFC 5322 message. Line breaks are dropped from
+// header values so that they cannot add headers of their own.
+func format(from string, msg Message) string {
+	header := strings.NewReplacer("\r", "", "\n", "")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", header.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", header.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", header.Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(msg.Body)
+	return b.String()
+}
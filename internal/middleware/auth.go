@@ -1,14 +1,20 @@
 package middleware
 
 import (
+    "errors"
     "net/http"
     "strings"
     "user-team-asset-management/internal/auth"
+    "user-team-asset-management/internal/models"
     
     "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
 )
 
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+// AuthMiddleware accepts access tokens from logins and, when tokens is set,
+// personal access tokens. Requests with a personal access token carry its
+// ID and scopes, which RequireScope checks.
+func AuthMiddleware(sessions *auth.SessionManager, tokens *auth.PATManager) gin.HandlerFunc {
     return func(c *gin.Context) {
         authHeader := c.GetHeader("Authorization")
         if authHeader == "" {
@@ -18,7 +24,22 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
         }
         
         tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-        claims, err := auth.ValidateToken(tokenString, jwtSecret)
+        if strings.HasPrefix(tokenString, auth.PATPrefix) {
+            if tokens == nil {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Personal access tokens are only accepted by the REST API"})
+                c.Abort()
+                return
+            }
+            authenticateToken(c, tokens, tokenString)
+            return
+        }
+        
+        claims, err := sessions.Validate(tokenString)
+        if errors.Is(err, auth.ErrAccountDeactivated) {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is deactivated"})
+            c.Abort()
+            return
+        }
         if err != nil {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
             c.Abort()
@@ -27,18 +48,104 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
         
         c.Set("userID", claims.UserID)
         c.Set("role", claims.Role)
+        c.Set("sessionID", claims.SessionID)
+        c.Next()
+    }
+}
+
+func authenticateToken(c *gin.Context, tokens *auth.PATManager, tokenString string) {
+    token, user, err := tokens.Validate(tokenString, c.ClientIP())
+    if errors.Is(err, auth.ErrAccountDeactivated) {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is deactivated"})
+        c.Abort()
+        return
+    }
+    if errors.Is(err, auth.ErrInvalidPAT) {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+        c.Abort()
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check token"})
+        c.Abort()
+        return
+    }
+    
+    c.Set("userID", user.ID)
+    c.Set("role", user.Role)
+    c.Set("tokenID", token.ID)
+    c.Set("scopes", token.ScopeList())
+    c.Next()
+}
+
+// RequireScope turns away requests made with a personal access token that
+// was not granted scope. Requests from logins are let through. Use it after
+// AuthMiddleware.
+func RequireScope(scope string) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if c.GetString("tokenID") == "" {
+            c.Next()
+            return
+        }
+        for _, granted := range c.GetStringSlice("scopes") {
+            if granted == scope {
+                c.Next()
+                return
+            }
+        }
+        c.JSON(http.StatusForbidden, gin.H{"error": "Token scope " + scope + " required"})
+        c.Abort()
+    }
+}
+
+// RequireSession turns away requests made with a personal access token, for
+// routes such as managing tokens that need the user to have logged in.
+func RequireSession() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if c.GetString("tokenID") != "" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "This route cannot be used with a personal access token"})
+            c.Abort()
+            return
+        }
         c.Next()
     }
 }
 
-func RequireManager() gin.HandlerFunc {
+// OptionalAuth lets requests without an Authorization header through
+// anonymously and otherwise behaves like AuthMiddleware, so a stale token is
+// reported instead of silently downgrading the caller. Handlers behind it
+// decide for themselves what anonymous callers may do. Personal access
+// tokens are refused, since scopes only cover the REST API.
+func OptionalAuth(sessions *auth.SessionManager) gin.HandlerFunc {
+    required := AuthMiddleware(sessions, nil)
     return func(c *gin.Context) {
-        role, exists := c.Get("role")
-        if !exists || role != "manager" {
-            c.JSON(http.StatusForbidden, gin.H{"error": "Manager role required"})
+        if c.GetHeader("Authorization") == "" {
+            c.Next()
+            return
+        }
+        required(c)
+    }
+}
+
+// RequireVerifiedEmail turns away users who have not verified their email
+// address yet. It reads the user from the database on every request, so it
+// lets them in as soon as they open the link. Use it after AuthMiddleware.
+func RequireVerifiedEmail(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var users []models.User
+        if err := db.Select("email_verified_at").
+            Where("id = ?", c.GetString("userID")).
+            Limit(1).Find(&users).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check email verification"})
             c.Abort()
             return
         }
+        if len(users) == 0 || !users[0].EmailVerified() {
+            c.JSON(http.StatusForbidden, gin.H{"error": "Email address not verified"})
+            c.Abort()
+            return
+        }
+        
         c.Next()
     }
-}
\ No newline at end of file
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+)
+
+func TestRequireScope(t *testing.T) {
+    gin.SetMode(gin.TestMode)
+
+    tests := []struct {
+        name    string
+        tokenID string
+        scopes  []string
+        want    int
+    }{
+        {name: "login session", want: http.StatusOK},
+        {name: "token with the scope", tokenID: "t1", scopes: []string{"teams:read", "teams:write"}, want: http.StatusOK},
+        {name: "token without the scope", tokenID: "t1", scopes: []string{"teams:read"}, want: http.StatusForbidden},
+        {name: "token without scopes", tokenID: "t1", want: http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := gin.New()
+            r.Use(func(c *gin.Context) {
+                if tt.tokenID != "" {
+                    c.Set("tokenID", tt.tokenID)
+                    c.Set("scopes", tt.scopes)
+                }
+            })
+            r.POST("/teams", RequireScope("teams:write"), func(c *gin.Context) {
+                c.Status(http.StatusOK)
+            })
+
+            w := httptest.NewRecorder()
+            r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/teams", nil))
+            if w.Code != tt.want {
+                t.Errorf("status = %d, want %d", w.Code, tt.want)
+            }
+        })
+    }
+}
+
+func TestRequireSession(t *testing.T) {
+    gin.SetMode(gin.TestMode)
+
+    tests := []struct {
+        name    string
+        tokenID string
+        want    int
+    }{
+        {name: "login session", want: http.StatusOK},
+        {name: "personal access token", tokenID: "t1", want: http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := gin.New()
+            r.Use(func(c *gin.Context) {
+                if tt.tokenID != "" {
+                    c.Set("tokenID", tt.tokenID)
+                }
+            })
+            r.GET("/tokens", RequireSession(), func(c *gin.Context) {
+                c.Status(http.StatusOK)
+            })
+
+            w := httptest.NewRecorder()
+            r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tokens", nil))
+            if w.Code != tt.want {
+                t.Errorf("status = %d, want %d", w.Code, tt.want)
+            }
+        })
+    }
+}
@@ -1,17 +1,36 @@
 package models
 
-import "time"
+import (
+    "time"
+    
+    "gorm.io/gorm"
+)
 
 type Folder struct {
     ID        string    `json:"folderId" gorm:"primaryKey"`
     Name      string    `json:"name" gorm:"not null"`
     OwnerID   string    `json:"ownerId" gorm:"not null"`
+    ParentID  *string   `json:"parentId" gorm:"index"`
+    Version   int       `json:"version" gorm:"not null;default:1"`
     CreatedAt time.Time `json:"createdAt"`
     UpdatedAt time.Time `json:"updatedAt"`
     
-    Owner  User   `json:"owner" gorm:"foreignKey:OwnerID"`
-    Notes  []Note `json:"notes" gorm:"foreignKey:FolderID"`
-    Shares []FolderShare `json:"shares" gorm:"foreignKey:FolderID"`
+    // Trashed folders are soft deleted; TrashID links them to their TrashEntry.
+    DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+    TrashID   string         `json:"-" gorm:"not null;default:'';index"`
+    
+    Owner    User          `json:"owner" gorm:"foreignKey:OwnerID"`
+    Notes    []Note        `json:"notes" gorm:"foreignKey:FolderID"`
+    Shares   []FolderShare `json:"shares" gorm:"foreignKey:FolderID"`
+    Children []Folder      `json:"children,omitempty" gorm:"foreignKey:ParentID"`
+    
+    // Path lists the folders from the root down to this one.
+    Path []FolderCrumb `json:"path,omitempty" gorm:"-"`
+}
+
+type FolderCrumb struct {
+    ID   string `json:"folderId"`
+    Name string `json:"name"`
 }
 
 type Note struct {
@@ -20,9 +39,13 @@ type Note struct {
     Body      string    `json:"body"`
     FolderID  string    `json:"folderId" gorm:"not null"`
     OwnerID   string    `json:"ownerId" gorm:"not null"`
+    Version   int       `json:"version" gorm:"not null;default:1"`
     CreatedAt time.Time `json:"createdAt"`
     UpdatedAt time.Time `json:"updatedAt"`
     
+    DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+    TrashID   string         `json:"-" gorm:"not null;default:'';index"`
+    
     Owner  User        `json:"owner" gorm:"foreignKey:OwnerID"`
     Folder Folder      `json:"folder" gorm:"foreignKey:FolderID"`
     Shares []NoteShare `json:"shares" gorm:"foreignKey:NoteID"`
@@ -40,4 +63,31 @@ type NoteShare struct {
     UserID   string `json:"userId" gorm:"primaryKey"`
     Access   string `json:"access" gorm:"not null;check:access IN ('read','write')"`
     CreatedAt time.Time `json:"createdAt"`
-}
\ No newline at end of file
+}
+
+// NoteRevision is an immutable snapshot of a note taken on every change.
+// Revisions are numbered from 1 per note.
+type NoteRevision struct {
+    ID           string    `json:"revisionId" gorm:"primaryKey"`
+    NoteID       string    `json:"noteId" gorm:"not null;uniqueIndex:idx_note_revision"`
+    Revision     int       `json:"revision" gorm:"not null;uniqueIndex:idx_note_revision"`
+    Title        string    `json:"title" gorm:"not null"`
+    Body         string    `json:"body"`
+    AuthorID     string    `json:"authorId" gorm:"not null"`
+    RestoredFrom *int      `json:"restoredFrom,omitempty"`
+    CreatedAt    time.Time `json:"createdAt"`
+}
+
+// TrashEntry records one delete operation. Everything soft deleted by that
+// operation shares the entry's ID as its TrashID, so restoring or purging
+// the entry handles the folder together with its subfolders and notes.
+type TrashEntry struct {
+    ID        string    `json:"trashId" gorm:"primaryKey"`
+    ItemType  string    `json:"itemType" gorm:"not null;check:item_type IN ('folder','note')"`
+    ItemID    string    `json:"itemId" gorm:"not null"`
+    Name      string    `json:"name"`
+    OwnerID   string    `json:"ownerId" gorm:"not null;index"`
+    DeletedBy string    `json:"deletedBy" gorm:"not null"`
+    DeletedAt time.Time `json:"deletedAt" gorm:"not null;index"`
+    PurgeAt   time.Time `json:"purgeAt" gorm:"not null"`
+}
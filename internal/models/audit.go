@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// AuditEntry records a security-relevant event. ActorID is empty when the
+// system acted on its own, and TargetUserID when the event concerns an email
+// address without an account.
+type AuditEntry struct {
+    ID           string    `json:"auditId" gorm:"primaryKey"`
+    Action       string    `json:"action" gorm:"not null;index"`
+    ActorID      string    `json:"actorId" gorm:"not null;default:''"`
+    TargetUserID string    `json:"targetUserId" gorm:"not null;default:'';index"`
+    IP           string    `json:"ip" gorm:"not null;default:''"`
+    Detail       string    `json:"detail" gorm:"not null;default:''"`
+    CreatedAt    time.Time `json:"createdAt" gorm:"index"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// ExternalIdentity links an account at an OpenID Connect provider, named by
+// its issuer and subject, to a user who can then log in through it.
+type ExternalIdentity struct {
+    ID          string     `json:"id" gorm:"primaryKey"`
+    UserID      string     `json:"userId" gorm:"not null;index"`
+    Issuer      string     `json:"issuer" gorm:"not null;uniqueIndex:idx_external_identities_subject"`
+    Subject     string     `json:"subject" gorm:"not null;uniqueIndex:idx_external_identities_subject"`
+    Email       string     `json:"email" gorm:"not null;default:''"`
+    CreatedAt   time.Time  `json:"createdAt"`
+    LastLoginAt *time.Time `json:"lastLoginAt,omitempty"`
+}
+
+// OIDCAuthRequest is a login at the provider that has been started but not
+// come back yet. It is found by the hash of the state parameter and holds the
+// nonce and PKCE verifier the provider's answer is checked against.
+// LinkUserID is set when a logged-in user links an identity instead.
+type OIDCAuthRequest struct {
+    ID           string    `gorm:"primaryKey"`
+    StateHash    string    `gorm:"uniqueIndex;not null"`
+    Nonce        string    `gorm:"not null"`
+    CodeVerifier string    `gorm:"not null"`
+    LinkUserID   string    `gorm:"not null;default:''"`
+    ExpiresAt    time.Time `gorm:"not null;index"`
+    CreatedAt    time.Time
+}
+
+func (OIDCAuthRequest) TableName() string {
+    return "oidc_auth_requests"
+}
+
+// OIDCResult is an identity verified by the provider, waiting for the client
+// to redeem the one-time code it was handed. Role is the role mapped from the
+// provider's claims, or empty when no rule matched.
+type OIDCResult struct {
+    ID            string    `gorm:"primaryKey"`
+    CodeHash      string    `gorm:"uniqueIndex;not null"`
+    LinkUserID    string    `gorm:"not null;default:''"`
+    Issuer        string    `gorm:"not null"`
+    Subject       string    `gorm:"not null"`
+    Email         string    `gorm:"not null;default:''"`
+    EmailVerified bool      `gorm:"not null;default:false"`
+    Username      string    `gorm:"not null;default:''"`
+    Role          string    `gorm:"not null;default:''"`
+    ExpiresAt     time.Time `gorm:"not null;index"`
+    CreatedAt     time.Time
+}
+
+func (OIDCResult) TableName() string {
+    return "oidc_results"
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// OutboxMessage is a mail kept in the database by the outbox mailer instead
+// of being delivered.
+type OutboxMessage struct {
+    ID        string    `json:"id" gorm:"primaryKey"`
+    From      string    `json:"from" gorm:"not null"`
+    To        string    `json:"to" gorm:"not null;index"`
+    Subject   string    `json:"subject" gorm:"not null"`
+    Body      string    `json:"body" gorm:"not null"`
+    CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// PersistedQuery is a GraphQL document registered under the hex-encoded
+// SHA-256 hash of its text.
+type PersistedQuery struct {
+    Hash      string    `json:"hash" gorm:"primaryKey"`
+    Query     string    `json:"query" gorm:"not null"`
+    CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// RoleAssignment grants a role on top of User.Role. An empty TeamID makes the
+// assignment global; otherwise it only applies within that team.
+type RoleAssignment struct {
+    ID        string    `json:"assignmentId" gorm:"primaryKey"`
+    UserID    string    `json:"userId" gorm:"not null;uniqueIndex:idx_role_assignment"`
+    Role      string    `json:"role" gorm:"not null;uniqueIndex:idx_role_assignment"`
+    TeamID    string    `json:"teamId" gorm:"not null;default:'';uniqueIndex:idx_role_assignment"`
+    CreatedAt time.Time `json:"createdAt"`
+}
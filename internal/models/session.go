@@ -0,0 +1,96 @@
+package models
+
+import (
+    "strings"
+    "time"
+)
+
+// Session groups every refresh token issued from a single login. Revoking
+// the session invalidates the whole token family and any access token
+// carrying its ID.
+type Session struct {
+    ID         string     `json:"sessionId" gorm:"primaryKey"`
+    UserID     string     `json:"userId" gorm:"not null;index"`
+    RevokedAt  *time.Time `json:"revokedAt"`
+    LastUsedAt time.Time  `json:"lastUsedAt"`
+    CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type RefreshToken struct {
+    ID        string     `json:"-" gorm:"primaryKey"`
+    SessionID string     `json:"-" gorm:"not null;index"`
+    TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+    ExpiresAt time.Time  `json:"-" gorm:"not null"`
+    RotatedAt *time.Time `json:"-"`
+    CreatedAt time.Time  `json:"-"`
+}
+
+// PasswordResetToken is a single-use token mailed to a user who forgot
+// their password. Only its hash is stored.
+type PasswordResetToken struct {
+    ID        string     `json:"-" gorm:"primaryKey"`
+    UserID    string     `json:"-" gorm:"not null;index"`
+    TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+    ExpiresAt time.Time  `json:"-" gorm:"not null"`
+    UsedAt    *time.Time `json:"-"`
+    CreatedAt time.Time  `json:"-"`
+}
+
+// EmailVerificationToken confirms that the user controls Email, the address
+// they asked to change to.
+type EmailVerificationToken struct {
+    ID        string     `json:"-" gorm:"primaryKey"`
+    UserID    string     `json:"-" gorm:"not null;index"`
+    Email     string     `json:"-" gorm:"not null"`
+    TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+    ExpiresAt time.Time  `json:"-" gorm:"not null"`
+    UsedAt    *time.Time `json:"-"`
+    CreatedAt time.Time  `json:"-"`
+}
+
+// LoginThrottle counts the recent failed logins for one email address or
+// client IP, identified by Subject ("email:..." or "ip:..."). Further
+// attempts are refused until BlockedUntil; LockedAt is set when the
+// failures reached the lockout threshold rather than just the backoff one.
+type LoginThrottle struct {
+    Subject       string     `json:"subject" gorm:"primaryKey"`
+    Failures      int        `json:"failures" gorm:"not null;default:0"`
+    LastFailureAt time.Time  `json:"lastFailureAt"`
+    BlockedUntil  *time.Time `json:"blockedUntil"`
+    LockedAt      *time.Time `json:"lockedAt"`
+}
+
+// LoginChallenge lets a user who entered the right password finish logging
+// in with a second factor. Attempts counts the wrong codes sent for it.
+type LoginChallenge struct {
+    ID        string    `json:"-" gorm:"primaryKey"`
+    UserID    string    `json:"-" gorm:"not null;index"`
+    TokenHash string    `json:"-" gorm:"uniqueIndex;not null"`
+    Attempts  int       `json:"-" gorm:"not null;default:0"`
+    ExpiresAt time.Time `json:"-" gorm:"not null"`
+    CreatedAt time.Time `json:"-"`
+}
+
+// PersonalAccessToken lets scripts call the REST API as its user, limited to
+// its scopes. Only the hash of the token is stored; Prefix is kept so that
+// users can tell their tokens apart.
+type PersonalAccessToken struct {
+    ID         string     `json:"tokenId" gorm:"primaryKey"`
+    UserID     string     `json:"userId" gorm:"not null;index"`
+    Name       string     `json:"name" gorm:"not null"`
+    Prefix     string     `json:"prefix" gorm:"not null"`
+    TokenHash  string     `json:"-" gorm:"uniqueIndex;not null"`
+    Scopes     string     `json:"-" gorm:"not null"`
+    CreatedBy  string     `json:"createdBy" gorm:"not null"`
+    ExpiresAt  *time.Time `json:"expiresAt"`
+    LastUsedAt *time.Time `json:"lastUsedAt"`
+    LastUsedIP string     `json:"lastUsedIp" gorm:"not null;default:''"`
+    RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+    CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// ScopeList returns the scopes of the token, which are stored separated by
+// spaces.
+func (t PersonalAccessToken) ScopeList() []string {
+    return strings.Fields(t.Scopes)
+}
@@ -8,6 +8,9 @@ type Team struct {
     CreatedAt time.Time `json:"createdAt"`
     UpdatedAt time.Time `json:"updatedAt"`
     
+    // ExternalID is the identifier a SCIM client keeps for the team.
+    ExternalID string `json:"-" gorm:"not null;default:''"`
+    
     Managers []User `json:"managers" gorm:"many2many:team_managers;"`
     Members  []User `json:"members" gorm:"many2many:team_members;"`
 }
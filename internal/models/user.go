@@ -9,9 +9,48 @@ type User struct {
     Username     string    `json:"username" gorm:"not null"`
     Email        string    `json:"email" gorm:"uniqueIndex;not null"`
     PasswordHash string    `json:"-" gorm:"not null"`
-    Role         string    `json:"role" gorm:"not null;check:role IN ('manager','member')"`
+    Role         string    `json:"role" gorm:"not null;default:'member'"`
     CreatedAt    time.Time `json:"createdAt"`
     UpdatedAt    time.Time `json:"updatedAt"`
+
+    // PendingEmail is the address the user asked to change to, which
+    // replaces Email once it has been verified.
+    PendingEmail string `json:"pendingEmail,omitempty" gorm:"not null;default:''"`
+
+    // EmailVerifiedAt is set once the user opened a link mailed to Email.
+    EmailVerifiedAt *time.Time `json:"emailVerifiedAt,omitempty"`
+
+    // ExternalID is the identifier a SCIM client keeps for the user.
+    ExternalID string `json:"-" gorm:"not null;default:''"`
+
+    // ServiceAccount users are not people. They cannot log in and only act
+    // through personal access tokens that admins create for them.
+    ServiceAccount bool `json:"serviceAccount" gorm:"not null;default:false"`
+
+    // Deactivated users cannot log in or use their sessions, but keep their
+    // data so that they can be reactivated.
+    Active        bool       `json:"active" gorm:"not null;default:true"`
+    DeactivatedAt *time.Time `json:"deactivatedAt,omitempty"`
+
+    // TOTPSecret is set when enrollment starts and only used once
+    // TOTPEnabledAt is set. TOTPLastStep is the time step of the last
+    // accepted code, which cannot be used again, and RecoveryCodes holds the
+    // hashes of the unused recovery codes, separated by spaces.
+    TOTPSecret    string     `json:"-" gorm:"not null;default:''"`
+    TOTPEnabledAt *time.Time `json:"-"`
+    TOTPLastStep  int64      `json:"-" gorm:"not null;default:0"`
+    RecoveryCodes string     `json:"-" gorm:"not null;default:''"`
+}
+
+// TwoFactorEnabled reports whether logging in needs a second factor.
+func (u User) TwoFactorEnabled() bool {
+    return u.TOTPEnabledAt != nil
+}
+
+// EmailVerified reports whether the user has proven they receive mail sent
+// to Email.
+func (u User) EmailVerified() bool {
+    return u.EmailVerifiedAt != nil
 }
 
 func (User) TableName() string {
@@ -0,0 +1,267 @@
+// Package mockoidc is a minimal OpenID Connect provider for trying single
+// sign-on locally. It signs in whoever fills in its login form as whatever
+// identity they enter, so it must never be reachable from outside.
+package mockoidc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"user-team-asset-management/internal/auth"
+	"user-team-asset-management/internal/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// codeTTL is how long an authorization code can be redeemed.
+const codeTTL = time.Minute
+
+// Identity is what the provider asserts about the user who logs in.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Groups        []string
+}
+
+// Provider serves discovery, the login form, the token endpoint and its
+// keys. Any client ID is accepted and client secrets are not checked, but
+// PKCE and redirect URIs are.
+type Provider struct {
+	Issuer string
+
+	// Identity fills in the login form.
+	Identity Identity
+
+	keys  *auth.Keyring
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+type grant struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	identity    Identity
+	expiresAt   time.Time
+}
+
+// New returns a provider reachable at issuer, with a fresh signing key.
+func New(issuer string) (*Provider, error) {
+	keys, err := auth.GenerateKeyring(auth.AlgRS256)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		Issuer: strings.TrimSuffix(issuer, "/"),
+		Identity: Identity{
+			Subject:       "mock-user",
+			Email:         "mock.user@example.com",
+			EmailVerified: true,
+			Name:          "Mock User",
+		},
+		keys:  keys,
+		codes: make(map[string]grant),
+	}, nil
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                                p.Issuer,
+			"authorization_endpoint":                p.Issuer + "/authorize",
+			"token_endpoint":                        p.Issuer + "/token",
+			"jwks_uri":                              p.Issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{auth.AlgRS256},
+			"code_challenge_methods_supported":      []string{"S256"},
+			"scopes_supported":                      []string{"openid", "email", "profile"},
+		})
+	case "/jwks":
+		writeJSON(w, http.StatusOK, p.keys.JWKS())
+	case "/authorize":
+		if r.Method == http.MethodPost {
+			p.authorize(w, r)
+			return
+		}
+		p.loginForm(w, r)
+	case "/token":
+		p.token(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><title>Mock OIDC login</title></head>
+<body>
+<h1>Mock OIDC login</h1>
+<p>Signing in to <code>{{.Query.client_id}}</code>. Anything entered here is trusted.</p>
+<form method="post" action="authorize">
+{{range $name, $values := .Query}}<input type="hidden" name="{{$name}}" value="{{index $values 0}}">
+{{end}}<p><label>Subject <input name="sub" value="{{.Identity.Subject}}"></label></p>
+<p><label>Email <input name="email" value="{{.Identity.Email}}"></label></p>
+<p><label><input type="checkbox" name="email_verified" value="true"{{if .Identity.EmailVerified}} checked{{end}}> Email verified</label></p>
+<p><label>Name <input name="name" value="{{.Identity.Name}}"></label></p>
+<p><label>Groups (comma-separated) <input name="groups" value="{{.Groups}}"></label></p>
+<p><button type="submit">Sign in</button></p>
+</form>
+</body></html>
+`))
+
+func (p *Provider) loginForm(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if msg := checkAuthRequest(query); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	loginPage.Execute(w, map[string]interface{}{
+		"Query":    query,
+		"Identity": p.Identity,
+		"Groups":   strings.Join(p.Identity.Groups, ","),
+	})
+}
+
+// authorize takes the submitted login form and sends the browser back to
+// the client with an authorization code.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	if msg := checkAuthRequest(r.PostForm); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	identity := Identity{
+		Subject:       strings.TrimSpace(r.PostForm.Get("sub")),
+		Email:         strings.TrimSpace(r.PostForm.Get("email")),
+		EmailVerified: r.PostForm.Get("email_verified") == "true",
+		Name:          strings.TrimSpace(r.PostForm.Get("name")),
+	}
+	for _, group := range strings.Split(r.PostForm.Get("groups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			identity.Groups = append(identity.Groups, group)
+		}
+	}
+	if identity.Subject == "" {
+		http.Error(w, "subject is required", http.StatusBadRequest)
+		return
+	}
+
+	code := utils.GenerateSecret()
+	p.mu.Lock()
+	p.codes[code] = grant{
+		clientID:    r.PostForm.Get("client_id"),
+		redirectURI: r.PostForm.Get("redirect_uri"),
+		challenge:   r.PostForm.Get("code_challenge"),
+		nonce:       r.PostForm.Get("nonce"),
+		identity:    identity,
+		expiresAt:   time.Now().Add(codeTTL),
+	}
+	p.mu.Unlock()
+
+	redirect, _ := url.Parse(r.PostForm.Get("redirect_uri"))
+	query := redirect.Query()
+	query.Set("code", code)
+	query.Set("state", r.PostForm.Get("state"))
+	redirect.RawQuery = query.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func checkAuthRequest(params url.Values) string {
+	switch {
+	case params.Get("response_type") != "code":
+		return "only response_type=code is supported"
+	case params.Get("client_id") == "":
+		return "client_id is required"
+	case params.Get("code_challenge") == "" || params.Get("code_challenge_method") != "S256":
+		return "PKCE with code_challenge_method=S256 is required"
+	}
+	if redirect, err := url.Parse(params.Get("redirect_uri")); err != nil || !redirect.IsAbs() {
+		return "an absolute redirect_uri is required"
+	}
+	return ""
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	clientID, _, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, found := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(verifier[:])
+	if !found || time.Now().After(g.expiresAt) ||
+		g.clientID != clientID ||
+		g.redirectURI != r.PostForm.Get("redirect_uri") ||
+		subtle.ConstantTimeCompare([]byte(g.challenge), []byte(challenge)) != 1 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.Issuer,
+		"sub":            g.identity.Subject,
+		"aud":            g.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"email":          g.identity.Email,
+		"email_verified": g.identity.EmailVerified,
+		"name":           g.identity.Name,
+		"groups":         g.identity.Groups,
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	idToken, err := p.keys.Sign(claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": utils.GenerateSecret(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,431 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"user-team-asset-management/internal/accounts"
+	"user-team-asset-management/internal/audit"
+	"user-team-asset-management/internal/auth"
+	"user-team-asset-management/internal/logger"
+	"user-team-asset-management/internal/mail"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/policy"
+	"user-team-asset-management/internal/utils"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// RequestTTL is how long a user has to log in at the provider.
+	RequestTTL = 10 * time.Minute
+
+	// codeTTL is how long the client has to redeem the code the callback
+	// hands it.
+	codeTTL = time.Minute
+)
+
+var (
+	ErrInvalidState     = errors.New("invalid or expired login state")
+	ErrInvalidCode      = errors.New("invalid or expired single sign-on code")
+	ErrAccountExists    = errors.New("an account with this email address already exists, log in and link the identity to it")
+	ErrNoAccount        = errors.New("no account is linked to this identity")
+	ErrEmailRequired    = errors.New("the identity provider did not share a valid email address")
+	ErrIdentityTaken    = errors.New("this identity is already linked to another account")
+	ErrIdentityNotFound = errors.New("linked identity not found")
+)
+
+// RoleRule gives users whose role claim contains Value the role Role.
+type RoleRule struct {
+	Value string
+	Role  string
+}
+
+// ParseRoleMapping reads rules written as "value=role", such as
+// "idp-admins=admin".
+func ParseRoleMapping(pairs []string) ([]RoleRule, error) {
+	var rules []RoleRule
+	for _, pair := range pairs {
+		value, role, ok := strings.Cut(pair, "=")
+		value, role = strings.TrimSpace(value), strings.TrimSpace(role)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid role mapping %q, expected value=role", pair)
+		}
+		if !policy.IsGlobalRole(role) {
+			return nil, fmt.Errorf("unknown role %q in role mapping", role)
+		}
+		rules = append(rules, RoleRule{Value: value, Role: role})
+	}
+	return rules, nil
+}
+
+// Service runs logins through the provider. The callback does not sign the
+// user in itself: it stores the verified identity under a one-time code,
+// which the client redeems with Login or Link.
+type Service struct {
+	DB       *gorm.DB
+	Provider *Provider
+	Accounts *accounts.Service
+
+	// AutoProvision creates accounts for identities nobody has linked yet.
+	AutoProvision bool
+
+	// LinkByEmail links an unknown identity to the account with the same
+	// email address, if the provider has verified it. Otherwise the user has
+	// to log in and link the identity themselves.
+	LinkByEmail bool
+
+	// RoleClaim names the claim, a string or a list of strings, that
+	// RoleMapping is matched against; the first matching rule wins.
+	// Provisioned users get DefaultRole when no rule matches, and with
+	// SyncRoles every login updates the role of users a rule matches.
+	RoleClaim   string
+	RoleMapping []RoleRule
+	DefaultRole string
+	SyncRoles   bool
+}
+
+// Begin starts a login at the provider and returns its login page along
+// with the state, which the caller should bind to the browser. With
+// linkUserID set, the identity is linked to that user instead.
+func (s *Service) Begin(ctx context.Context, linkUserID string) (authURL, state string, err error) {
+	state = utils.GenerateSecret()
+	request := models.OIDCAuthRequest{
+		ID:           utils.GenerateID(),
+		StateHash:    auth.HashToken(state),
+		Nonce:        utils.GenerateSecret(),
+		CodeVerifier: utils.GenerateSecret(),
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(RequestTTL),
+	}
+
+	authURL, err = s.Provider.AuthCodeURL(ctx, state, request.Nonce, request.CodeVerifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Abandoned logins are cleaned up as new ones start
+	if err := s.DB.Where("expires_at < ?", time.Now()).Delete(&models.OIDCAuthRequest{}).Error; err != nil {
+		return "", "", err
+	}
+	if err := s.DB.Where("expires_at < ?", time.Now()).Delete(&models.OIDCResult{}).Error; err != nil {
+		return "", "", err
+	}
+	if err := s.DB.Create(&request).Error; err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// Callback finishes the login the provider sent the browser back from and
+// returns a one-time code for the verified identity, and whether it is to
+// be linked rather than logged in. boundState is the state bound to the
+// browser by Begin's caller; it must match for logins, so that nobody can
+// make a victim's browser finish a login they started. Links are checked
+// when the code is redeemed instead.
+func (s *Service) Callback(ctx context.Context, state, code, boundState string) (loginCode string, link bool, err error) {
+	var request models.OIDCAuthRequest
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		var requests []models.OIDCAuthRequest
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("state_hash = ?", auth.HashToken(state)).
+			Limit(1).Find(&requests).Error; err != nil {
+			return err
+		}
+		if len(requests) == 0 {
+			return ErrInvalidState
+		}
+		request = requests[0]
+		return tx.Delete(&request).Error
+	})
+	if err != nil {
+		return "", false, err
+	}
+	if time.Now().After(request.ExpiresAt) || (request.LinkUserID == "" && boundState != state) {
+		return "", false, ErrInvalidState
+	}
+
+	identity, err := s.Provider.Exchange(ctx, code, request.CodeVerifier, request.Nonce)
+	if err != nil {
+		return "", false, err
+	}
+
+	loginCode = utils.GenerateSecret()
+	result := models.OIDCResult{
+		ID:            utils.GenerateID(),
+		CodeHash:      auth.HashToken(loginCode),
+		LinkUserID:    request.LinkUserID,
+		Issuer:        identity.Issuer,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Username:      username(identity),
+		Role:          s.mapRole(identity),
+		ExpiresAt:     time.Now().Add(codeTTL),
+	}
+	if err := s.DB.Create(&result).Error; err != nil {
+		return "", false, err
+	}
+	return loginCode, request.LinkUserID != "", nil
+}
+
+// Login redeems a code from a login and returns the user to sign in,
+// linking or provisioning an account first if needed.
+func (s *Service) Login(code string) (*models.User, error) {
+	var user models.User
+	var verify bool
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		result, err := redeem(tx, code, "")
+		if err != nil {
+			return err
+		}
+
+		var identities []models.ExternalIdentity
+		if err := tx.Where("issuer = ? AND subject = ?", result.Issuer, result.Subject).
+			Limit(1).Find(&identities).Error; err != nil {
+			return err
+		}
+		if len(identities) == 1 {
+			if err := tx.Where("id = ?", identities[0].UserID).First(&user).Error; err != nil {
+				return ErrNoAccount
+			}
+			return s.loggedIn(tx, &user, identities[0], result)
+		}
+
+		email, err := mail.NormalizeAddress(result.Email)
+		if err != nil {
+			return ErrEmailRequired
+		}
+		var existing []models.User
+		if err := tx.Where("email = ?", email).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+		if len(existing) == 1 {
+			if !s.LinkByEmail || !result.EmailVerified {
+				return ErrAccountExists
+			}
+			user = existing[0]
+			identity, err := link(tx, user.ID, result)
+			if err != nil {
+				return err
+			}
+			return s.loggedIn(tx, &user, *identity, result)
+		}
+
+		if !s.AutoProvision {
+			return ErrNoAccount
+		}
+		verify = !result.EmailVerified
+		return s.provision(tx, &user, email, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !user.Active {
+		return nil, auth.ErrAccountDeactivated
+	}
+
+	if verify {
+		if err := s.Accounts.ResendVerification(user.ID); err != nil {
+			logger.DefaultLogger.Error(fmt.Sprintf("Failed to send verification mail to provisioned user %s: %v", user.ID, err))
+		}
+	}
+	return &user, nil
+}
+
+// loggedIn records the login through identity and, with SyncRoles, applies
+// the role mapped from the provider's claims.
+func (s *Service) loggedIn(tx *gorm.DB, user *models.User, identity models.ExternalIdentity, result *models.OIDCResult) error {
+	if err := tx.Model(&identity).Update("last_login_at", time.Now()).Error; err != nil {
+		return err
+	}
+	if s.SyncRoles && result.Role != "" && result.Role != user.Role {
+		return tx.Model(user).Update("role", result.Role).Error
+	}
+	return nil
+}
+
+// provision creates an account for the identity. Its password is random, so
+// the user can only log in through the provider until they reset it.
+func (s *Service) provision(tx *gorm.DB, user *models.User, email string, result *models.OIDCResult) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(utils.GenerateSecret()), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	*user = models.User{
+		ID:           utils.GenerateID(),
+		Username:     result.Username,
+		Email:        email,
+		PasswordHash: string(hash),
+		Role:         result.Role,
+		Active:       true,
+	}
+	if user.Username == "" {
+		user.Username = strings.Split(email, "@")[0]
+	}
+	if user.Role == "" {
+		user.Role = s.DefaultRole
+	}
+	if result.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return err
+	}
+
+	identity, err := link(tx, user.ID, result)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(identity).Update("last_login_at", time.Now()).Error; err != nil {
+		return err
+	}
+	return audit.Record(tx, models.AuditEntry{
+		Action:       audit.ActionUserProvisioned,
+		ActorID:      user.ID,
+		TargetUserID: user.ID,
+		Detail:       fmt.Sprintf("from %s as %s", result.Issuer, user.Role),
+	})
+}
+
+// Link redeems a code from a login started for linking, and links the
+// identity to userID, who must be the user who started it.
+func (s *Service) Link(userID, code string) (*models.ExternalIdentity, error) {
+	var identity *models.ExternalIdentity
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		result, err := redeem(tx, code, userID)
+		if err != nil {
+			return err
+		}
+
+		var existing []models.ExternalIdentity
+		if err := tx.Where("issuer = ? AND subject = ?", result.Issuer, result.Subject).
+			Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+		if len(existing) == 1 {
+			if existing[0].UserID != userID {
+				return ErrIdentityTaken
+			}
+			identity = &existing[0]
+			return nil
+		}
+
+		identity, err = link(tx, userID, result)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// Unlink removes one of the user's linked identities.
+func (s *Service) Unlink(userID, identityID string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var identity models.ExternalIdentity
+		if err := tx.Where("id = ? AND user_id = ?", identityID, userID).First(&identity).Error; err != nil {
+			return ErrIdentityNotFound
+		}
+		if err := tx.Delete(&identity).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, models.AuditEntry{
+			Action:       audit.ActionIdentityUnlinked,
+			ActorID:      userID,
+			TargetUserID: userID,
+			Detail:       fmt.Sprintf("%s at %s", identity.Subject, identity.Issuer),
+		})
+	})
+}
+
+// Identities returns the identities linked to the user, oldest first.
+func (s *Service) Identities(userID string) ([]models.ExternalIdentity, error) {
+	var identities []models.ExternalIdentity
+	err := s.DB.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error
+	return identities, err
+}
+
+// redeem uses up the code, which must come from a login started for
+// linkUserID, or from a plain login when linkUserID is empty.
+func redeem(tx *gorm.DB, code, linkUserID string) (*models.OIDCResult, error) {
+	var results []models.OIDCResult
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("code_hash = ?", auth.HashToken(code)).
+		Limit(1).Find(&results).Error; err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrInvalidCode
+	}
+	result := results[0]
+	if err := tx.Delete(&result).Error; err != nil {
+		return nil, err
+	}
+	if time.Now().After(result.ExpiresAt) || result.LinkUserID != linkUserID {
+		return nil, ErrInvalidCode
+	}
+	return &result, nil
+}
+
+// link links the identity in result to the user, who is always the one
+// asking for it.
+func link(tx *gorm.DB, userID string, result *models.OIDCResult) (*models.ExternalIdentity, error) {
+	identity := models.ExternalIdentity{
+		ID:      utils.GenerateID(),
+		UserID:  userID,
+		Issuer:  result.Issuer,
+		Subject: result.Subject,
+		Email:   result.Email,
+	}
+	if err := tx.Create(&identity).Error; err != nil {
+		return nil, err
+	}
+	if err := audit.Record(tx, models.AuditEntry{
+		Action:       audit.ActionIdentityLinked,
+		ActorID:      userID,
+		TargetUserID: userID,
+		Detail:       fmt.Sprintf("%s at %s", result.Subject, result.Issuer),
+	}); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (s *Service) mapRole(identity *Identity) string {
+	var values []string
+	switch claim := identity.Claims[s.RoleClaim].(type) {
+	case string:
+		values = []string{claim}
+	case []interface{}:
+		for _, v := range claim {
+			if value, ok := v.(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+
+	for _, rule := range s.RoleMapping {
+		for _, value := range values {
+			if value == rule.Value {
+				return rule.Role
+			}
+		}
+	}
+	return ""
+}
+
+func username(identity *Identity) string {
+	for _, name := range []string{identity.PreferredUsername, identity.Name} {
+		if name = strings.TrimSpace(name); name != "" {
+			return name
+		}
+	}
+	return ""
+}
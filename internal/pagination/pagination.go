@@ -0,0 +1,315 @@
+// Package pagination implements keyset pagination with opaque cursors,
+// sorting and simple field filters shared by REST and GraphQL list
+// endpoints.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Sort describes one sortable key. Column must be qualified when the query
+// joins other tables, and Value extracts the same value from a loaded item.
+type Sort[T any] struct {
+	Column string
+	Value  func(*T) interface{}
+}
+
+// Filter matches a request parameter against a column, either exactly or
+// as a case-insensitive substring.
+type Filter struct {
+	Column   string
+	Contains bool
+}
+
+// Spec declares how a resource can be listed.
+type Spec[T any] struct {
+	Sorts       map[string]Sort[T]
+	DefaultSort string
+	DefaultDesc bool
+	Filters     map[string]Filter
+	IDColumn    string
+	ID          func(*T) string
+}
+
+// Request is a parsed list request.
+type Request struct {
+	Limit   int
+	Sort    string
+	Desc    bool
+	After   *cursor
+	Filters map[string]string
+}
+
+// Page is one page of results. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor"`
+	TotalCount int64  `json:"totalCount"`
+}
+
+type cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	Time  bool   `json:"t,omitempty"`
+	ID    string `json:"id"`
+}
+
+// ParseRequest reads limit, cursor, sort, order and the spec's filters
+// through get, which returns "" for missing parameters.
+func ParseRequest[T any](get func(string) string, spec Spec[T]) (Request, error) {
+	req := Request{
+		Limit:   DefaultLimit,
+		Sort:    spec.DefaultSort,
+		Desc:    spec.DefaultDesc,
+		Filters: map[string]string{},
+	}
+
+	if limit := get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > MaxLimit {
+			return req, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		req.Limit = n
+	}
+
+	if sort := get("sort"); sort != "" {
+		if _, ok := spec.Sorts[sort]; !ok {
+			return req, fmt.Errorf("cannot sort by %q", sort)
+		}
+		req.Sort = sort
+	}
+
+	switch strings.ToLower(get("order")) {
+	case "":
+	case "asc":
+		req.Desc = false
+	case "desc":
+		req.Desc = true
+	default:
+		return req, errors.New("order must be 'asc' or 'desc'")
+	}
+
+	if raw := get("cursor"); raw != "" {
+		c, err := decodeCursor(raw)
+		if err != nil {
+			return req, err
+		}
+		// A cursor only makes sense for the ordering it was issued for
+		if c.Sort != req.Sort || c.Desc != req.Desc {
+			return req, ErrInvalidCursor
+		}
+		req.After = c
+	}
+
+	for name := range spec.Filters {
+		if value := get(name); value != "" {
+			req.Filters[name] = value
+		}
+	}
+
+	return req, nil
+}
+
+// Paginate applies the request's filters, ordering and cursor to query and
+// loads one page. query must already carry the caller's access conditions.
+func Paginate[T any](query *gorm.DB, req Request, spec Spec[T]) (Page[T], error) {
+	page := Page[T]{Items: []T{}}
+	query = applyFilters(query, req, spec)
+
+	if err := query.Session(&gorm.Session{}).Count(&page.TotalCount).Error; err != nil {
+		return page, err
+	}
+
+	sort := spec.Sorts[req.Sort]
+	direction, comparison := orderBy(req)
+
+	if req.After != nil {
+		value, err := req.After.value()
+		if err != nil {
+			return page, err
+		}
+		query = query.Where(
+			fmt.Sprintf("(%s, %s) %s (?, ?)", sort.Column, spec.IDColumn, comparison),
+			value, req.After.ID,
+		)
+	}
+
+	// Fetch one extra row to learn whether another page exists
+	var items []T
+	err := query.
+		Order(sort.Column + " " + direction).
+		Order(spec.IDColumn + " " + direction).
+		Limit(req.Limit + 1).
+		Find(&items).Error
+	if err != nil {
+		return page, err
+	}
+
+	page.Items, page.NextCursor = trimPage(items, req, spec)
+	return page, nil
+}
+
+// PaginateGroups loads the same page for several groups at once, such as
+// the first page of folders for every member of a team. groupColumn names
+// the column of query that identifies a row's group; a row may belong to
+// several groups when query joins a membership table. Every group is
+// resolved with two statements in total, whatever the number of groups.
+func PaginateGroups[T any](query *gorm.DB, groupColumn string, req Request, spec Spec[T]) (map[string]Page[T], error) {
+	query = applyFilters(query, req, spec)
+	sort := spec.Sorts[req.Sort]
+	direction, comparison := orderBy(req)
+
+	// Rank the matching rows within each group, counting the group's total
+	// before the cursor is applied
+	matching := query.Select(fmt.Sprintf(
+		"%s AS page_group, %s AS page_id, %s AS page_sort, COUNT(*) OVER (PARTITION BY %s) AS page_total",
+		groupColumn, spec.IDColumn, sort.Column, groupColumn,
+	))
+	db := query.Session(&gorm.Session{NewDB: true})
+	ranked := db.Table("(?) AS matching", matching).Select(fmt.Sprintf(
+		"page_group, page_id, page_total, ROW_NUMBER() OVER (PARTITION BY page_group ORDER BY page_sort %s, page_id %s) AS page_row",
+		direction, direction,
+	))
+	if req.After != nil {
+		value, err := req.After.value()
+		if err != nil {
+			return nil, err
+		}
+		ranked = ranked.Where("(page_sort, page_id) "+comparison+" (?, ?)", value, req.After.ID)
+	}
+
+	var rows []struct {
+		PageGroup string
+		PageID    string
+		PageTotal int64
+	}
+	err := db.Table("(?) AS ranked", ranked).
+		Where("page_row <= ?", req.Limit+1).
+		Order("page_group, page_row").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.PageID)
+	}
+	var items []T
+	if len(ids) > 0 {
+		if err := db.Where(spec.IDColumn+" IN ?", ids).Find(&items).Error; err != nil {
+			return nil, err
+		}
+	}
+	byID := make(map[string]T, len(items))
+	for _, item := range items {
+		byID[spec.ID(&item)] = item
+	}
+
+	grouped := make(map[string][]T)
+	pages := make(map[string]Page[T])
+	for _, row := range rows {
+		if item, ok := byID[row.PageID]; ok {
+			grouped[row.PageGroup] = append(grouped[row.PageGroup], item)
+		}
+		pages[row.PageGroup] = Page[T]{TotalCount: row.PageTotal}
+	}
+	for group, page := range pages {
+		page.Items, page.NextCursor = trimPage(grouped[group], req, spec)
+		pages[group] = page
+	}
+	return pages, nil
+}
+
+func applyFilters[T any](query *gorm.DB, req Request, spec Spec[T]) *gorm.DB {
+	for name, value := range req.Filters {
+		filter := spec.Filters[name]
+		if filter.Contains {
+			query = query.Where(filter.Column+" ILIKE ?", "%"+escapeLike(value)+"%")
+		} else {
+			query = query.Where(filter.Column+" = ?", value)
+		}
+	}
+	return query
+}
+
+func orderBy(req Request) (direction, comparison string) {
+	if req.Desc {
+		return "DESC", "<"
+	}
+	return "ASC", ">"
+}
+
+// trimPage drops the extra row fetched past the limit and, if there was
+// one, returns the cursor for the next page.
+func trimPage[T any](items []T, req Request, spec Spec[T]) ([]T, string) {
+	if items == nil {
+		items = []T{}
+	}
+	if len(items) <= req.Limit {
+		return items, ""
+	}
+
+	items = items[:req.Limit]
+	last := &items[len(items)-1]
+	value := spec.Sorts[req.Sort].Value(last)
+
+	next := cursor{Sort: req.Sort, Desc: req.Desc, ID: spec.ID(last)}
+	if t, ok := value.(time.Time); ok {
+		next.Value, next.Time = t.UTC().Format(time.RFC3339Nano), true
+	} else {
+		next.Value = fmt.Sprint(value)
+	}
+	return items, encodeCursor(next)
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// value returns the cursor's sort value typed so the database compares
+// timestamps as timestamps rather than strings.
+func (c *cursor) value() (interface{}, error) {
+	if !c.Time {
+		return c.Value, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, c.Value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return t, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,71 @@
+package pagination
+
+import "user-team-asset-management/internal/models"
+
+// Teams lists teams by name or timestamps, filtered by name.
+var Teams = Spec[models.Team]{
+	Sorts: map[string]Sort[models.Team]{
+		"name":      {Column: "teams.team_name", Value: func(t *models.Team) interface{} { return t.TeamName }},
+		"createdAt": {Column: "teams.created_at", Value: func(t *models.Team) interface{} { return t.CreatedAt }},
+		"updatedAt": {Column: "teams.updated_at", Value: func(t *models.Team) interface{} { return t.UpdatedAt }},
+	},
+	DefaultSort: "name",
+	Filters: map[string]Filter{
+		"name": {Column: "teams.team_name", Contains: true},
+	},
+	IDColumn: "teams.id",
+	ID:       func(t *models.Team) string { return t.ID },
+}
+
+// Folders lists folders by name or timestamps, filtered by name, owner or
+// parent.
+var Folders = Spec[models.Folder]{
+	Sorts: map[string]Sort[models.Folder]{
+		"name":      {Column: "folders.name", Value: func(f *models.Folder) interface{} { return f.Name }},
+		"createdAt": {Column: "folders.created_at", Value: func(f *models.Folder) interface{} { return f.CreatedAt }},
+		"updatedAt": {Column: "folders.updated_at", Value: func(f *models.Folder) interface{} { return f.UpdatedAt }},
+	},
+	DefaultSort: "name",
+	Filters: map[string]Filter{
+		"name":     {Column: "folders.name", Contains: true},
+		"ownerId":  {Column: "folders.owner_id"},
+		"parentId": {Column: "folders.parent_id"},
+	},
+	IDColumn: "folders.id",
+	ID:       func(f *models.Folder) string { return f.ID },
+}
+
+// Users lists users by username or timestamps, filtered by username, email
+// or role.
+var Users = Spec[models.User]{
+	Sorts: map[string]Sort[models.User]{
+		"name":      {Column: "users.username", Value: func(u *models.User) interface{} { return u.Username }},
+		"createdAt": {Column: "users.created_at", Value: func(u *models.User) interface{} { return u.CreatedAt }},
+		"updatedAt": {Column: "users.updated_at", Value: func(u *models.User) interface{} { return u.UpdatedAt }},
+	},
+	DefaultSort: "name",
+	Filters: map[string]Filter{
+		"username": {Column: "users.username", Contains: true},
+		"email":    {Column: "users.email", Contains: true},
+		"role":     {Column: "users.role"},
+	},
+	IDColumn: "users.id",
+	ID:       func(u *models.User) string { return u.ID },
+}
+
+// AuditEntries lists audit entries newest first, filtered by action, actor
+// or target user.
+var AuditEntries = Spec[models.AuditEntry]{
+	Sorts: map[string]Sort[models.AuditEntry]{
+		"createdAt": {Column: "audit_entries.created_at", Value: func(e *models.AuditEntry) interface{} { return e.CreatedAt }},
+	},
+	DefaultSort: "createdAt",
+	DefaultDesc: true,
+	Filters: map[string]Filter{
+		"action":       {Column: "audit_entries.action"},
+		"actorId":      {Column: "audit_entries.actor_id"},
+		"targetUserId": {Column: "audit_entries.target_user_id"},
+	},
+	IDColumn: "audit_entries.id",
+	ID:       func(e *models.AuditEntry) string { return e.ID },
+}
@@ -0,0 +1,173 @@
+// Package persisted stores GraphQL documents under the SHA-256 hash of their
+// text, for automatic persisted queries and for allowlisting the documents
+// clients may run.
+package persisted
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"user-team-asset-management/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Hash returns the hex-encoded SHA-256 hash that identifies query.
+func Hash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// Store looks documents up by hash. Documents loaded from a manifest are
+// always kept; documents registered by clients are kept in memory up to the
+// store's capacity, least recently used first out, and also in the
+// database when DB is set, so that they survive restarts and are shared
+// between instances.
+type Store struct {
+	DB *gorm.DB
+
+	// Allowlist restricts clients to documents that are already known:
+	// nothing is registered on their behalf and any other document is
+	// refused.
+	Allowlist bool
+
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	cached   map[string]*list.Element
+	manifest map[string]string
+}
+
+type cachedQuery struct {
+	hash  string
+	query string
+}
+
+// NewStore returns a store caching up to capacity documents in memory. db
+// may be nil to keep documents in memory only.
+func NewStore(db *gorm.DB, capacity int) *Store {
+	return &Store{
+		DB:       db,
+		capacity: capacity,
+		order:    list.New(),
+		cached:   make(map[string]*list.Element),
+		manifest: make(map[string]string),
+	}
+}
+
+// Get returns the document registered under hash, if any.
+func (s *Store) Get(hash string) (string, bool, error) {
+	s.mu.Lock()
+	if query, ok := s.manifest[hash]; ok {
+		s.mu.Unlock()
+		return query, true, nil
+	}
+	if element, ok := s.cached[hash]; ok {
+		s.order.MoveToFront(element)
+		s.mu.Unlock()
+		return element.Value.(*cachedQuery).query, true, nil
+	}
+	s.mu.Unlock()
+
+	if s.DB == nil {
+		return "", false, nil
+	}
+	// Find rather than Take, which would log every miss
+	var rows []models.PersistedQuery
+	if err := s.DB.Where("hash = ?", hash).Limit(1).Find(&rows).Error; err != nil {
+		return "", false, err
+	}
+	if len(rows) == 0 {
+		return "", false, nil
+	}
+
+	s.remember(hash, rows[0].Query)
+	return rows[0].Query, true, nil
+}
+
+// Put registers query under its hash.
+func (s *Store) Put(query string) error {
+	hash := Hash(query)
+	if s.DB != nil {
+		row := &models.PersistedQuery{Hash: hash, Query: query}
+		if err := s.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(row).Error; err != nil {
+			return err
+		}
+	}
+
+	s.remember(hash, query)
+	return nil
+}
+
+func (s *Store) remember(hash, query string) {
+	if s.capacity <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.cached[hash]; ok {
+		s.order.MoveToFront(element)
+		return
+	}
+	s.cached[hash] = s.order.PushFront(&cachedQuery{hash: hash, query: query})
+
+	for s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.cached, oldest.Value.(*cachedQuery).hash)
+	}
+}
+
+// manifest is the persisted query manifest format of Apollo's
+// generate-persisted-query-manifest tool.
+type manifest struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	Operations []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Body string `json:"body"`
+	} `json:"operations"`
+}
+
+// LoadManifest adds every operation in the manifest at path and returns
+// how many there were. An operation whose ID is not the hash of its body
+// fails the whole load, since clients could never send a matching hash.
+func (s *Store) LoadManifest(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return 0, fmt.Errorf("invalid persisted query manifest: %w", err)
+	}
+	if m.Format != "apollo-persisted-query-manifest" || m.Version != 1 {
+		return 0, errors.New("unsupported persisted query manifest format")
+	}
+
+	loaded := make(map[string]string, len(m.Operations))
+	for _, operation := range m.Operations {
+		if Hash(operation.Body) != operation.ID {
+			return 0, fmt.Errorf("persisted query %q: id is not the SHA-256 hash of its body", operation.Name)
+		}
+		loaded[operation.ID] = operation.Body
+	}
+
+	s.mu.Lock()
+	for hash, query := range loaded {
+		s.manifest[hash] = query
+	}
+	s.mu.Unlock()
+
+	return len(loaded), nil
+}
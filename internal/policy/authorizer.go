@@ -0,0 +1,103 @@
+package policy
+
+import (
+	"user-team-asset-management/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Authorizer decides whether a user holds a permission. teamID scopes the
+// check to a team; pass an empty string for actions that are not team bound.
+type Authorizer interface {
+	Authorize(userID string, perm Permission, teamID string) (bool, error)
+}
+
+// DBAuthorizer resolves roles from the user's global role, explicit role
+// assignments and team membership.
+type DBAuthorizer struct {
+	DB *gorm.DB
+
+	// TwoFactorRoles lists roles that only grant what RoleMember does to
+	// users who have not enabled two-factor authentication, other than
+	// service accounts.
+	TwoFactorRoles []string
+}
+
+func NewAuthorizer(db *gorm.DB) *DBAuthorizer {
+	return &DBAuthorizer{DB: db}
+}
+
+func (a *DBAuthorizer) Authorize(userID string, perm Permission, teamID string) (bool, error) {
+	roles, err := a.rolesFor(userID, teamID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, role := range roles {
+		if Grants(role, perm) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (a *DBAuthorizer) rolesFor(userID, teamID string) ([]string, error) {
+	var user models.User
+	if err := a.DB.Select("role", "totp_enabled_at", "service_account").Where("id = ?", userID).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	roles := []string{user.Role}
+
+	query := a.DB.Model(&models.RoleAssignment{}).Where("user_id = ?", userID)
+	if teamID != "" {
+		query = query.Where("team_id = '' OR team_id = ?", teamID)
+	} else {
+		query = query.Where("team_id = ''")
+	}
+	var assigned []string
+	if err := query.Pluck("role", &assigned).Error; err != nil {
+		return nil, err
+	}
+	roles = append(roles, assigned...)
+
+	// Service accounts cannot enroll; their tokens are created by admins
+	if !user.TwoFactorEnabled() && !user.ServiceAccount {
+		roles = a.withoutTwoFactorRoles(roles)
+	}
+
+	if teamID == "" {
+		return roles, nil
+	}
+
+	var count int64
+	if err := a.DB.Model(&models.TeamManager{}).Where("team_id = ? AND user_id = ?", teamID, userID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		roles = append(roles, RoleTeamManager)
+	}
+
+	if err := a.DB.Model(&models.TeamMember{}).Where("team_id = ? AND user_id = ?", teamID, userID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		roles = append(roles, RoleTeamMember)
+	}
+
+	return roles, nil
+}
+
+func (a *DBAuthorizer) withoutTwoFactorRoles(roles []string) []string {
+	for i, role := range roles {
+		for _, twoFactorRole := range a.TwoFactorRoles {
+			if role == twoFactorRole {
+				roles[i] = RoleMember
+				break
+			}
+		}
+	}
+	return roles
+}
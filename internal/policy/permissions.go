@@ -0,0 +1,29 @@
+package policy
+
+// Permission names a single action that can be granted through a role.
+type Permission string
+
+const (
+	TeamCreate        Permission = "team.create"
+	TeamRead          Permission = "team.read"
+	TeamListAll       Permission = "team.list_all"
+	TeamMembersWrite  Permission = "team.members.write"
+	TeamManagersWrite Permission = "team.managers.write"
+	TeamAssetsRead    Permission = "team.assets.read"
+
+	UserCreate     Permission = "user.create"
+	UserList       Permission = "user.list"
+	UserImport     Permission = "user.import"
+	UserAssetsRead Permission = "user.assets.read"
+	UserUnlock     Permission = "user.unlock"
+	UserDeactivate Permission = "user.deactivate"
+	RoleAssign     Permission = "role.assign"
+	AuditRead      Permission = "audit.read"
+
+	ServiceAccountManage Permission = "service_account.manage"
+
+	FolderCreate Permission = "folder.create"
+	FolderShare  Permission = "folder.share"
+	NoteCreate   Permission = "note.create"
+	NoteShare    Permission = "note.share"
+)
@@ -0,0 +1,83 @@
+package policy
+
+import "sort"
+
+const (
+	RoleAdmin   = "admin"
+	RoleManager = "manager"
+	RoleMember  = "member"
+	RoleViewer  = "viewer"
+
+	// Team roles are implied by the team_managers and team_members tables
+	// and are normally only assigned with a team scope.
+	RoleTeamManager = "team_manager"
+	RoleTeamMember  = "team_member"
+)
+
+var contentPermissions = []Permission{
+	FolderCreate,
+	FolderShare,
+	NoteCreate,
+	NoteShare,
+}
+
+var roles = map[string][]Permission{
+	RoleAdmin: {
+		TeamCreate, TeamRead, TeamListAll, TeamMembersWrite, TeamManagersWrite, TeamAssetsRead,
+		UserCreate, UserList, UserImport, UserAssetsRead, UserUnlock, UserDeactivate, RoleAssign, AuditRead,
+		ServiceAccountManage,
+		FolderCreate, FolderShare, NoteCreate, NoteShare,
+	},
+	RoleManager: append([]Permission{
+		TeamCreate, TeamListAll,
+		UserCreate, UserList, UserImport, UserAssetsRead, UserDeactivate,
+	}, contentPermissions...),
+	RoleMember: contentPermissions,
+	RoleViewer: {},
+
+	RoleTeamManager: {TeamRead, TeamMembersWrite, TeamManagersWrite, TeamAssetsRead},
+	RoleTeamMember:  {TeamRead},
+}
+
+// IsRole reports whether name is a defined role.
+func IsRole(name string) bool {
+	_, ok := roles[name]
+	return ok
+}
+
+// IsGlobalRole reports whether name is a role that applies outside any one
+// team, so that it can be a user's role or be assigned without a team.
+func IsGlobalRole(name string) bool {
+	return IsRole(name) && name != RoleTeamManager && name != RoleTeamMember
+}
+
+// Elevated reports whether a user with role holds more than member, so that
+// giving or taking away that role requires role.assign.
+func Elevated(role string) bool {
+	return role != RoleMember && role != RoleViewer
+}
+
+// Roles returns every defined role name in sorted order.
+func Roles() []string {
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Permissions returns the permissions granted by a role.
+func Permissions(role string) []Permission {
+	return roles[role]
+}
+
+// Grants reports whether the role includes the permission.
+func Grants(role string, perm Permission) bool {
+	for _, p := range roles[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
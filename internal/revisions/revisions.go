@@ -0,0 +1,50 @@
+// Package revisions records the edit history of notes.
+package revisions
+
+import (
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+// Record snapshots the note's current content as the next revision.
+func Record(tx *gorm.DB, note *models.Note, authorID string, restoredFrom *int) (*models.NoteRevision, error) {
+	var latest int
+	if err := tx.Model(&models.NoteRevision{}).
+		Where("note_id = ?", note.ID).
+		Select("COALESCE(MAX(revision), 0)").
+		Scan(&latest).Error; err != nil {
+		return nil, err
+	}
+
+	revision := models.NoteRevision{
+		ID:           utils.GenerateID(),
+		NoteID:       note.ID,
+		Revision:     latest + 1,
+		Title:        note.Title,
+		Body:         note.Body,
+		AuthorID:     authorID,
+		RestoredFrom: restoredFrom,
+	}
+	if err := tx.Create(&revision).Error; err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// EnsureBase records the note's content as revision 1 if it has no history
+// yet, so that notes created before revisions existed don't lose their
+// original content on the first edit.
+func EnsureBase(tx *gorm.DB, note *models.Note) error {
+	var count int64
+	if err := tx.Model(&models.NoteRevision{}).Where("note_id = ?", note.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	_, err := Record(tx, note, note.OwnerID, nil)
+	return err
+}
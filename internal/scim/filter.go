@@ -0,0 +1,397 @@
+package scim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A filter (RFC 7644 section 3.4.2.2) is parsed into a tree of these nodes.
+type node interface{}
+
+type logicalNode struct {
+	op          string // "and" or "or"
+	left, right node
+}
+
+type notNode struct {
+	inner node
+}
+
+type compareNode struct {
+	attr  string      // lower-cased, such as "emails.value"
+	op    string      // lower-cased, such as "eq" or "pr"
+	value interface{} // string, bool, float64 or nil
+}
+
+// valuePathNode filters the values of a multi-valued attribute, as in
+// emails[type eq "work"]. The attributes in inner are relative to attr.
+type valuePathNode struct {
+	attr  string
+	inner node
+}
+
+var compareOps = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true,
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenLBracket
+	tokenRBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		switch ch := s[i]; {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "("})
+			i++
+		case ch == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")"})
+			i++
+		case ch == '[':
+			tokens = append(tokens, token{kind: tokenLBracket, text: "["})
+			i++
+		case ch == ']':
+			tokens = append(tokens, token{kind: tokenRBracket, text: "]"})
+			i++
+		case ch == '"':
+			// A JSON string, which ends at the first unescaped quote
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, badRequest("invalidFilter", "unterminated string in filter")
+			}
+			value, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, badRequest("invalidFilter", "invalid string %s in filter", s[i:end+1])
+			}
+			tokens = append(tokens, token{kind: tokenString, text: value})
+			i = end + 1
+		default:
+			end := i
+			for end < len(s) && !strings.ContainsRune(" \t\n\r()[]\"", rune(s[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: s[i:end]})
+			i = end
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parseFilter parses a filter expression. "not" binds tighter than "and",
+// which binds tighter than "or".
+func parseFilter(s string) (node, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, badRequest("invalidFilter", "unexpected %q in filter", p.peek().text)
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokenWord && strings.EqualFold(t.text, word)
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	if t := p.next(); t.kind != kind {
+		return badRequest("invalidFilter", "expected %q in filter", text)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.keyword("not") {
+		p.next()
+		if err := p.expect(tokenLParen, "("); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenRParen, ")"); err != nil {
+			return nil, err
+		}
+		return &notNode{inner: inner}, nil
+	}
+	if p.peek().kind == tokenLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenRParen, ")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+	return p.parseAttrExp()
+}
+
+func (p *parser) parseAttrExp() (node, error) {
+	t := p.next()
+	if t.kind != tokenWord {
+		return nil, badRequest("invalidFilter", "expected an attribute in filter")
+	}
+	attr := attributeName(t.text)
+
+	if p.peek().kind == tokenLBracket {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenRBracket, "]"); err != nil {
+			return nil, err
+		}
+		return &valuePathNode{attr: attr, inner: inner}, nil
+	}
+
+	opToken := p.next()
+	op := strings.ToLower(opToken.text)
+	if opToken.kind != tokenWord || (op != "pr" && !compareOps[op]) {
+		return nil, badRequest("invalidFilter", "expected an operator after %s in filter", t.text)
+	}
+	if op == "pr" {
+		return &compareNode{attr: attr, op: op}, nil
+	}
+
+	valueToken := p.next()
+	var value interface{}
+	switch valueToken.kind {
+	case tokenString:
+		value = valueToken.text
+	case tokenWord:
+		switch strings.ToLower(valueToken.text) {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			value = nil
+		default:
+			number, err := strconv.ParseFloat(valueToken.text, 64)
+			if err != nil {
+				return nil, badRequest("invalidFilter", "invalid value %s in filter", valueToken.text)
+			}
+			value = number
+		}
+	default:
+		return nil, badRequest("invalidFilter", "expected a value after %s %s in filter", t.text, opToken.text)
+	}
+	return &compareNode{attr: attr, op: op, value: value}, nil
+}
+
+// attributeName lower-cases an attribute name and drops the schema URN it
+// may be prefixed with, as in urn:ietf:params:scim:schemas:core:2.0:User:userName.
+func attributeName(name string) string {
+	name = strings.ToLower(name)
+	if strings.HasPrefix(name, "urn:") {
+		if i := strings.LastIndex(name, ":"); i >= 0 {
+			name = name[i+1:]
+		}
+	}
+	return name
+}
+
+type attributeKind int
+
+const (
+	kindString attributeKind = iota
+	kindBool
+	kindTime
+)
+
+// attribute maps a SCIM attribute to the SQL expression it is filtered on.
+type attribute struct {
+	column    string
+	kind      attributeKind
+	caseExact bool
+
+	// exists is a subquery with a %s for the condition, for attributes that
+	// live in another table
+	exists string
+}
+
+// toSQL translates a filter into a WHERE condition, looking attributes up
+// in attrs. prefix is the multi-valued attribute of an enclosing value path.
+func toSQL(n node, attrs map[string]attribute, prefix string) (string, []interface{}, error) {
+	switch n := n.(type) {
+	case *logicalNode:
+		left, leftArgs, err := toSQL(n.left, attrs, prefix)
+		if err != nil {
+			return "", nil, err
+		}
+		right, rightArgs, err := toSQL(n.right, attrs, prefix)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("(%s %s %s)", left, strings.ToUpper(n.op), right), append(leftArgs, rightArgs...), nil
+	case *notNode:
+		inner, args, err := toSQL(n.inner, attrs, prefix)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT " + inner, args, nil
+	case *valuePathNode:
+		if prefix != "" {
+			return "", nil, badRequest("invalidFilter", "value paths cannot be nested")
+		}
+		return toSQL(n.inner, attrs, n.attr+".")
+	case *compareNode:
+		attr, ok := attrs[prefix+n.attr]
+		if !ok {
+			return "", nil, badRequest("invalidFilter", "cannot filter on %s", prefix+n.attr)
+		}
+		cond, args, err := compare(attr, n.op, n.value)
+		if err != nil {
+			return "", nil, badRequest("invalidFilter", "%s %s: %v", prefix+n.attr, n.op, err)
+		}
+		if attr.exists != "" {
+			cond = "EXISTS (" + fmt.Sprintf(attr.exists, cond) + ")"
+		}
+		return cond, args, nil
+	}
+	return "", nil, badRequest("invalidFilter", "invalid filter")
+}
+
+var sqlOps = map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+
+func compare(attr attribute, op string, value interface{}) (string, []interface{}, error) {
+	column := attr.column
+	if op == "pr" {
+		if attr.kind == kindString {
+			return fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", column, column), nil, nil
+		}
+		return column + " IS NOT NULL", nil, nil
+	}
+	if value == nil {
+		switch op {
+		case "eq":
+			return column + " IS NULL", nil, nil
+		case "ne":
+			return column + " IS NOT NULL", nil, nil
+		}
+		return "", nil, fmt.Errorf("null can only be compared with eq and ne")
+	}
+
+	switch attr.kind {
+	case kindBool:
+		b, ok := value.(bool)
+		if !ok || (op != "eq" && op != "ne") {
+			return "", nil, fmt.Errorf("expected eq or ne and true or false")
+		}
+		return fmt.Sprintf("%s %s ?", column, sqlOps[op]), []interface{}{b}, nil
+	case kindTime:
+		s, _ := value.(string)
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil || sqlOps[op] == "" {
+			return "", nil, fmt.Errorf("expected a comparison with an RFC 3339 date and time")
+		}
+		return fmt.Sprintf("%s %s ?", column, sqlOps[op]), []interface{}{t}, nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return "", nil, fmt.Errorf("expected a string")
+	}
+	if !attr.caseExact {
+		column = "LOWER(" + column + ")"
+		s = strings.ToLower(s)
+	}
+	switch op {
+	case "co":
+		return column + ` LIKE ? ESCAPE '\'`, []interface{}{"%" + escapeLike(s) + "%"}, nil
+	case "sw":
+		return column + ` LIKE ? ESCAPE '\'`, []interface{}{escapeLike(s) + "%"}, nil
+	case "ew":
+		return column + ` LIKE ? ESCAPE '\'`, []interface{}{"%" + escapeLike(s)}, nil
+	}
+	return fmt.Sprintf("%s %s ?", column, sqlOps[op]), []interface{}{s}, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,117 @@
+package scim
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFilterToSQL(t *testing.T) {
+	tests := []struct {
+		filter string
+		sql    string
+		args   []interface{}
+	}{
+		{
+			filter: `userName eq "Alice"`,
+			sql:    `LOWER(users.username) = ?`,
+			args:   []interface{}{"alice"},
+		},
+		{
+			filter: `externalId eq "AbC"`,
+			sql:    `users.external_id = ?`,
+			args:   []interface{}{"AbC"},
+		},
+		{
+			filter: `urn:ietf:params:scim:schemas:core:2.0:User:userName EQ "x"`,
+			sql:    `LOWER(users.username) = ?`,
+			args:   []interface{}{"x"},
+		},
+		{
+			filter: `externalId pr`,
+			sql:    `(users.external_id IS NOT NULL AND users.external_id <> '')`,
+		},
+		{
+			filter: `externalId eq null`,
+			sql:    `users.external_id IS NULL`,
+		},
+		{
+			filter: `active eq true and not (userName sw "a_b")`,
+			sql:    `(users.active = ? AND NOT LOWER(users.username) LIKE ? ESCAPE '\')`,
+			args:   []interface{}{true, `a\_b%`},
+		},
+		{
+			// and binds tighter than or
+			filter: `userName eq "a" or userName eq "b" and active eq false`,
+			sql:    `(LOWER(users.username) = ? OR (LOWER(users.username) = ? AND users.active = ?))`,
+			args:   []interface{}{"a", "b", false},
+		},
+		{
+			filter: `(userName eq "a" or userName eq "b") and active eq false`,
+			sql:    `((LOWER(users.username) = ? OR LOWER(users.username) = ?) AND users.active = ?)`,
+			args:   []interface{}{"a", "b", false},
+		},
+		{
+			filter: `emails[type eq "work" and value co "Example.com"]`,
+			sql:    `(LOWER('work') = ? AND LOWER(users.email) LIKE ? ESCAPE '\')`,
+			args:   []interface{}{"work", "%example.com%"},
+		},
+		{
+			filter: `meta.lastModified gt "2024-01-02T03:04:05Z"`,
+			sql:    `users.updated_at > ?`,
+			args:   []interface{}{time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			n, err := parseFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("parseFilter: %v", err)
+			}
+			sql, args, err := toSQL(n, userAttributes, "")
+			if err != nil {
+				t.Fatalf("toSQL: %v", err)
+			}
+			if sql != tt.sql {
+				t.Errorf("sql = %s, want %s", sql, tt.sql)
+			}
+			if len(args) != 0 || len(tt.args) != 0 {
+				if !reflect.DeepEqual(args, tt.args) {
+					t.Errorf("args = %v, want %v", args, tt.args)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`userName eq`,
+		`userName xx "a"`,
+		`userName eq "a" extra`,
+		`(userName eq "a"`,
+		`not userName eq "a"`,
+		`userName eq "unterminated`,
+		`emails[type eq "work"`,
+		`emails[value[type eq "work"]]`,
+		`password eq "secret"`,
+		`userName eq 5`,
+		`active gt true`,
+		`meta.created gt "yesterday"`,
+		`userName gt null`,
+	}
+	for _, filter := range tests {
+		t.Run(filter, func(t *testing.T) {
+			n, err := parseFilter(filter)
+			if err == nil {
+				_, _, err = toSQL(n, userAttributes, "")
+			}
+			var scimErr *Error
+			if !errors.As(err, &scimErr) || scimErr.ScimType != "invalidFilter" {
+				t.Fatalf("got %v, want an invalidFilter error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,485 @@
+package scim
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"user-team-asset-management/internal/events"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// groupAttributes are the Group attributes that filters can use.
+var groupAttributes = map[string]attribute{
+	"id":                {column: "teams.id", caseExact: true},
+	"externalid":        {column: "teams.external_id", caseExact: true},
+	"displayname":       {column: "teams.team_name"},
+	"meta.created":      {column: "teams.created_at", kind: kindTime},
+	"meta.lastmodified": {column: "teams.updated_at", kind: kindTime},
+	"members": {
+		column:    "team_members.user_id",
+		caseExact: true,
+		exists:    "SELECT 1 FROM team_members WHERE team_members.team_id = teams.id AND %s",
+	},
+	"members.value": {
+		column:    "team_members.user_id",
+		caseExact: true,
+		exists:    "SELECT 1 FROM team_members WHERE team_members.team_id = teams.id AND %s",
+	},
+}
+
+type groupResource struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []reference `json:"members,omitempty"`
+	Meta        meta        `json:"meta"`
+}
+
+func (s *Server) groupResource(team models.Team, members []reference) groupResource {
+	return groupResource{
+		Schemas:     []string{schemaGroup},
+		ID:          team.ID,
+		ExternalID:  team.ExternalID,
+		DisplayName: team.TeamName,
+		Members:     members,
+		Meta: meta{
+			ResourceType: "Group",
+			Created:      team.CreatedAt.UTC().Format(time.RFC3339),
+			LastModified: team.UpdatedAt.UTC().Format(time.RFC3339),
+			Location:     s.location("Groups", team.ID),
+		},
+	}
+}
+
+// groupState holds the attributes of a group that requests can write.
+// Team managers and service accounts are not part of SCIM and are left
+// alone.
+type groupState struct {
+	displayName string
+	externalID  string
+	members     []string
+}
+
+func (g *groupState) set(p path, value json.RawMessage, op string) error {
+	var err error
+	switch p.attr {
+	case "displayname":
+		g.displayName, err = decodeString(value, "displayName")
+	case "externalid":
+		g.externalID, err = decodeString(value, "externalId")
+	case "members":
+		if p.filter != nil || p.subAttr != "" {
+			return badRequest("invalidPath", "members can only be added or replaced as a whole")
+		}
+		var ids []string
+		if ids, err = memberValues(value); err != nil {
+			return err
+		}
+		if op == "replace" {
+			g.members = nil
+		}
+		g.members = uniqueIDs(append(g.members, ids...))
+	}
+	return err
+}
+
+// remove drops the members selected by a path filter, or those listed in
+// value, or else every member.
+func (g *groupState) remove(p path, value json.RawMessage) error {
+	switch p.attr {
+	case "externalid":
+		g.externalID = ""
+	case "displayname":
+		return badRequest("mutability", "displayName cannot be removed")
+	case "members":
+		var ids []string
+		var err error
+		switch trimmed := bytes.TrimSpace(value); {
+		case p.filter != nil:
+			ids, err = filterValues(p.filter, "value")
+		case len(trimmed) > 0 && string(trimmed) != "null":
+			ids, err = memberValues(value)
+		default:
+			g.members = nil
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		removed := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			removed[id] = true
+		}
+		kept := g.members[:0]
+		for _, id := range g.members {
+			if !removed[id] {
+				kept = append(kept, id)
+			}
+		}
+		g.members = kept
+	}
+	return nil
+}
+
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// validateGroup rejects groups without a name or with members that are not users.
+func (s *Server) validateGroup(g *groupState) error {
+	if g.displayName == "" {
+		return badRequest("invalidValue", "displayName is required")
+	}
+	if len(g.members) == 0 {
+		return nil
+	}
+
+	var existing []string
+	if err := s.DB.Model(&models.User{}).Where("id IN ? AND NOT service_account", g.members).Pluck("id", &existing).Error; err != nil {
+		return err
+	}
+	found := make(map[string]bool, len(existing))
+	for _, id := range existing {
+		found[id] = true
+	}
+	var missing []string
+	for _, id := range g.members {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		return badRequest("invalidValue", "no users with the IDs %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (s *Server) findTeam(id string) (*models.Team, error) {
+	var teams []models.Team
+	if err := s.DB.Where("id = ?", id).Limit(1).Find(&teams).Error; err != nil {
+		return nil, err
+	}
+	if len(teams) == 0 {
+		return nil, notFound("Group", id)
+	}
+	return &teams[0], nil
+}
+
+// membersOf returns the members of each of the teams.
+func (s *Server) membersOf(teamIDs []string) (map[string][]reference, error) {
+	var rows []struct {
+		TeamID   string
+		UserID   string
+		Username string
+	}
+	if err := s.DB.Table("team_members").
+		Select("team_members.team_id, users.id AS user_id, users.username").
+		Joins("JOIN users ON users.id = team_members.user_id").
+		Where("team_members.team_id IN ? AND NOT users.service_account", teamIDs).
+		Order("users.username").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	members := make(map[string][]reference)
+	for _, row := range rows {
+		members[row.TeamID] = append(members[row.TeamID], reference{
+			Value:   row.UserID,
+			Display: row.Username,
+			Ref:     s.location("Users", row.UserID),
+		})
+	}
+	return members, nil
+}
+
+func (s *Server) stateOfTeam(team models.Team) (groupState, error) {
+	state := groupState{displayName: team.TeamName, externalID: team.ExternalID}
+	err := memberIDs(s.DB, team.ID, &state.members)
+	return state, err
+}
+
+// memberIDs loads the members of the team other than service accounts,
+// which identity providers do not know about and must not remove.
+func memberIDs(tx *gorm.DB, teamID string, ids *[]string) error {
+	return tx.Model(&models.TeamMember{}).
+		Joins("JOIN users ON users.id = team_members.user_id").
+		Where("team_members.team_id = ? AND NOT users.service_account", teamID).
+		Pluck("team_members.user_id", ids).Error
+}
+
+func (s *Server) writeGroup(c *gin.Context, status int, team *models.Team) error {
+	members, err := s.membersOf([]string{team.ID})
+	if err != nil {
+		return err
+	}
+	if status == http.StatusCreated {
+		c.Header("Location", s.location("Groups", team.ID))
+	}
+	write(c, status, s.groupResource(*team, members[team.ID]))
+	return nil
+}
+
+// listGroups leaves members out with excludedAttributes=members, which
+// identity providers use to check for groups without loading large ones.
+func (s *Server) listGroups(c *gin.Context) error {
+	query, err := filtered(c, s.DB.Model(&models.Team{}), groupAttributes)
+	if err != nil {
+		return err
+	}
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return err
+	}
+	startIndex, count := page(c)
+	var teams []models.Team
+	if count > 0 {
+		if err := query.Order("teams.created_at, teams.id").
+			Offset(startIndex - 1).
+			Limit(count).
+			Find(&teams).Error; err != nil {
+			return err
+		}
+	}
+
+	members := map[string][]reference{}
+	if !excludesMembers(c) {
+		teamIDs := make([]string, len(teams))
+		for i, team := range teams {
+			teamIDs[i] = team.ID
+		}
+		if members, err = s.membersOf(teamIDs); err != nil {
+			return err
+		}
+	}
+	resources := make([]interface{}, len(teams))
+	for i, team := range teams {
+		resources[i] = s.groupResource(team, members[team.ID])
+	}
+	write(c, http.StatusOK, newListResponse(total, startIndex, resources))
+	return nil
+}
+
+func excludesMembers(c *gin.Context) bool {
+	for _, attr := range strings.Split(c.Query("excludedAttributes"), ",") {
+		if attributeName(strings.TrimSpace(attr)) == "members" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) getGroup(c *gin.Context) error {
+	team, err := s.findTeam(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	if excludesMembers(c) {
+		write(c, http.StatusOK, s.groupResource(*team, nil))
+		return nil
+	}
+	return s.writeGroup(c, http.StatusOK, team)
+}
+
+// createGroup creates a team without managers; they can be added through
+// the REST API.
+func (s *Server) createGroup(c *gin.Context) error {
+	values, err := readObject(c)
+	if err != nil {
+		return err
+	}
+	var state groupState
+	if err := setAll(&state, values, "add"); err != nil {
+		return err
+	}
+	if err := s.validateGroup(&state); err != nil {
+		return err
+	}
+
+	team := &models.Team{
+		ID:         utils.GenerateID(),
+		TeamName:   state.displayName,
+		ExternalID: state.externalID,
+	}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(team).Error; err != nil {
+			return err
+		}
+		for _, userID := range state.members {
+			if err := tx.Create(&models.TeamMember{TeamID: team.ID, UserID: userID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range state.members {
+		s.Events.MembershipChanged(events.ActionMemberAdded, team.ID, userID, "")
+	}
+	return s.writeGroup(c, http.StatusCreated, team)
+}
+
+// replaceGroup sets the attributes in the request. Members are replaced
+// when the request lists them, and kept otherwise.
+func (s *Server) replaceGroup(c *gin.Context) error {
+	team, err := s.findTeam(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	values, err := readObject(c)
+	if err != nil {
+		return err
+	}
+	state, err := s.stateOfTeam(*team)
+	if err != nil {
+		return err
+	}
+	if err := setAll(&state, values, "replace"); err != nil {
+		return err
+	}
+	if err := s.updateGroup(team, state); err != nil {
+		return err
+	}
+	return s.writeGroup(c, http.StatusOK, team)
+}
+
+func (s *Server) patchGroup(c *gin.Context) error {
+	team, err := s.findTeam(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	var req patchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return badRequest("invalidSyntax", "invalid PATCH request")
+	}
+	state, err := s.stateOfTeam(*team)
+	if err != nil {
+		return err
+	}
+	if err := applyPatch(&state, req.Operations); err != nil {
+		return err
+	}
+	if err := s.updateGroup(team, state); err != nil {
+		return err
+	}
+
+	if excludesMembers(c) {
+		write(c, http.StatusOK, s.groupResource(*team, nil))
+		return nil
+	}
+	return s.writeGroup(c, http.StatusOK, team)
+}
+
+// updateGroup saves state, adding and removing members to match it.
+func (s *Server) updateGroup(team *models.Team, state groupState) error {
+	if err := s.validateGroup(&state); err != nil {
+		return err
+	}
+
+	var added, removed []string
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", team.ID).
+			First(team).Error; err != nil {
+			return err
+		}
+
+		var current []string
+		if err := memberIDs(tx, team.ID, &current); err != nil {
+			return err
+		}
+		wanted := make(map[string]bool, len(state.members))
+		for _, id := range state.members {
+			wanted[id] = true
+		}
+		existing := make(map[string]bool, len(current))
+		for _, id := range current {
+			existing[id] = true
+			if !wanted[id] {
+				removed = append(removed, id)
+			}
+		}
+		for _, id := range state.members {
+			if !existing[id] {
+				added = append(added, id)
+			}
+		}
+
+		if len(removed) > 0 {
+			if err := tx.Where("team_id = ? AND user_id IN ?", team.ID, removed).Delete(&models.TeamMember{}).Error; err != nil {
+				return err
+			}
+		}
+		for _, userID := range added {
+			if err := tx.Create(&models.TeamMember{TeamID: team.ID, UserID: userID}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(team).Updates(map[string]interface{}{
+			"team_name":   state.displayName,
+			"external_id": state.externalID,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range added {
+		s.Events.MembershipChanged(events.ActionMemberAdded, team.ID, userID, "")
+	}
+	for _, userID := range removed {
+		s.Events.MembershipChanged(events.ActionMemberRemoved, team.ID, userID, "")
+	}
+	return nil
+}
+
+// deleteGroup deletes the team with its memberships and the roles assigned
+// within it. Folders and notes are owned by users and stay.
+func (s *Server) deleteGroup(c *gin.Context) error {
+	team, err := s.findTeam(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	var members []string
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.TeamMember{}).Where("team_id = ?", team.ID).Pluck("user_id", &members).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&models.TeamMember{}, &models.TeamManager{}, &models.RoleAssignment{}} {
+			if err := tx.Where("team_id = ?", team.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(team).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range members {
+		s.Events.MembershipChanged(events.ActionMemberRemoved, team.ID, userID, "")
+	}
+	c.Status(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,265 @@
+package scim
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+type patchRequest struct {
+	Schemas    []string  `json:"schemas"`
+	Operations []patchOp `json:"Operations"`
+}
+
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// path is the target of a PATCH operation, such as "displayName",
+// `members[value eq "2819c223"]` or `emails[type eq "work"].value`.
+type path struct {
+	attr    string
+	filter  node
+	subAttr string
+}
+
+func parsePath(s string) (path, error) {
+	s = strings.TrimSpace(s)
+	head := s
+	if i := strings.Index(s, "["); i >= 0 {
+		head = s[:i]
+	}
+	if strings.HasPrefix(strings.ToLower(head), "urn:") {
+		if i := strings.LastIndex(head, ":"); i >= 0 {
+			s = s[i+1:]
+		}
+	}
+
+	open := strings.Index(s, "[")
+	if open < 0 {
+		attr, subAttr, _ := strings.Cut(strings.ToLower(s), ".")
+		if attr == "" {
+			return path{}, badRequest("invalidPath", "invalid path %q", s)
+		}
+		return path{attr: attr, subAttr: subAttr}, nil
+	}
+
+	end := strings.LastIndex(s, "]")
+	if end < open {
+		return path{}, badRequest("invalidPath", "invalid path %q", s)
+	}
+	filter, err := parseFilter(s[open+1 : end])
+	if err != nil {
+		return path{}, badRequest("invalidPath", "invalid filter in path %q", s)
+	}
+	p := path{attr: strings.ToLower(s[:open]), filter: filter}
+	if rest := s[end+1:]; rest != "" {
+		if !strings.HasPrefix(rest, ".") || len(rest) == 1 {
+			return path{}, badRequest("invalidPath", "invalid path %q", s)
+		}
+		p.subAttr = strings.ToLower(rest[1:])
+	}
+	return p, nil
+}
+
+// patchTarget is a resource that PATCH operations change. set handles add
+// and replace, which only differ for multi-valued attributes.
+type patchTarget interface {
+	set(p path, value json.RawMessage, op string) error
+	remove(p path, value json.RawMessage) error
+}
+
+// applyPatch applies the operations in order. Operation names are matched
+// case-insensitively, since some identity providers send "Replace".
+func applyPatch(target patchTarget, ops []patchOp) error {
+	if len(ops) == 0 {
+		return badRequest("invalidSyntax", "no operations")
+	}
+	for _, op := range ops {
+		name := strings.ToLower(op.Op)
+		if name != "add" && name != "replace" && name != "remove" {
+			return badRequest("invalidSyntax", "unknown operation %q", op.Op)
+		}
+
+		if op.Path == "" {
+			if name == "remove" {
+				return badRequest("noTarget", "remove needs a path")
+			}
+			values, err := decodeObject(op.Value)
+			if err != nil {
+				return err
+			}
+			if err := setAll(target, values, name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		p, err := parsePath(op.Path)
+		if err != nil {
+			return err
+		}
+		if name == "remove" {
+			err = target.remove(p, op.Value)
+		} else {
+			err = target.set(p, op.Value, name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setAll sets every attribute of a resource or of a PATCH value object.
+func setAll(target patchTarget, values map[string]json.RawMessage, op string) error {
+	for name, value := range values {
+		p, err := parsePath(name)
+		if err != nil {
+			return err
+		}
+		if err := target.set(p, value, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeObject decodes a JSON object with its keys lower-cased.
+func decodeObject(raw json.RawMessage) (map[string]json.RawMessage, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &values); err != nil || values == nil {
+		return nil, badRequest("invalidSyntax", "expected a JSON object")
+	}
+	lowered := make(map[string]json.RawMessage, len(values))
+	for key, value := range values {
+		lowered[strings.ToLower(key)] = value
+	}
+	return lowered, nil
+}
+
+// decodeList decodes the values of a multi-valued attribute. A single object
+// counts as a list of one.
+func decodeList(raw json.RawMessage) ([]map[string]json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '{' {
+		value, err := decodeObject(raw)
+		if err != nil {
+			return nil, err
+		}
+		return []map[string]json.RawMessage{value}, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, badRequest("invalidValue", "expected a list of values")
+	}
+	values := make([]map[string]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		value, err := decodeObject(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func decodeString(raw json.RawMessage, attr string) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", badRequest("invalidValue", "%s must be a string", attr)
+	}
+	return strings.TrimSpace(s), nil
+}
+
+// decodeBool also accepts "true" and "false" as strings, which some identity
+// providers send.
+func decodeBool(raw json.RawMessage, attr string) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, badRequest("invalidValue", "%s must be true or false", attr)
+}
+
+// primaryValue returns the "value" of the primary entry of a multi-valued
+// attribute, or of its first entry when none is primary.
+func primaryValue(raw json.RawMessage, attr string) (string, error) {
+	values, err := decodeList(raw)
+	if err != nil {
+		return "", err
+	}
+	if len(values) == 0 {
+		return "", nil
+	}
+	chosen := values[0]
+	for _, value := range values {
+		if primary, ok := value["primary"]; ok {
+			if b, err := decodeBool(primary, attr+".primary"); err == nil && b {
+				chosen = value
+				break
+			}
+		}
+	}
+	if chosen["value"] == nil {
+		return "", badRequest("invalidValue", "%s needs a value", attr)
+	}
+	return decodeString(chosen["value"], attr+".value")
+}
+
+// memberValues returns the "value" of every entry of a members list.
+func memberValues(raw json.RawMessage) ([]string, error) {
+	values, err := decodeList(raw)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(values))
+	for _, value := range values {
+		if value["value"] == nil {
+			return nil, badRequest("invalidValue", "members need a value")
+		}
+		id, err := decodeString(value["value"], "members.value")
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// filterValues returns the values that a path filter such as
+// `value eq "a" or value eq "b"` selects by equality on attr.
+func filterValues(n node, attr string) ([]string, error) {
+	switch n := n.(type) {
+	case *compareNode:
+		value, ok := n.value.(string)
+		if n.attr == attr && n.op == "eq" && ok {
+			return []string{value}, nil
+		}
+	case *logicalNode:
+		if n.op == "or" {
+			left, err := filterValues(n.left, attr)
+			if err != nil {
+				return nil, err
+			}
+			right, err := filterValues(n.right, attr)
+			if err != nil {
+				return nil, err
+			}
+			return append(left, right...), nil
+		}
+	}
+	return nil, badRequest("invalidFilter", "only %s eq filters joined by or are supported in paths", attr)
+}
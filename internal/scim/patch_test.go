@@ -0,0 +1,155 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// decodeOps reads the Operations of a PATCH request body.
+func decodeOps(t *testing.T, operations string) []patchOp {
+	t.Helper()
+	var req patchRequest
+	if err := json.Unmarshal([]byte(`{"Operations": `+operations+`}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	return req.Operations
+}
+
+func TestPatchUser(t *testing.T) {
+	initial := userState{userName: "alice", email: "alice@example.com", role: "member", active: true, externalID: "ext-1"}
+
+	tests := []struct {
+		name     string
+		ops      string
+		want     userState
+		wantType string
+	}{
+		{
+			name: "replace without path",
+			ops:  `[{"op": "Replace", "value": {"UserName": "bob", "active": false}}]`,
+			want: userState{userName: "bob", email: "alice@example.com", role: "member", externalID: "ext-1"},
+		},
+		{
+			name: "active as string",
+			ops:  `[{"op": "replace", "path": "active", "value": "False"}]`,
+			want: userState{userName: "alice", email: "alice@example.com", role: "member", externalID: "ext-1"},
+		},
+		{
+			name: "primary email",
+			ops:  `[{"op": "add", "path": "emails", "value": [{"value": "a@example.com"}, {"value": "b@example.com", "primary": true}]}]`,
+			want: userState{userName: "alice", email: "b@example.com", role: "member", active: true, externalID: "ext-1"},
+		},
+		{
+			name: "email through a value path",
+			ops:  `[{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "c@example.com"}]`,
+			want: userState{userName: "alice", email: "c@example.com", role: "member", active: true, externalID: "ext-1"},
+		},
+		{
+			name: "schema URN path",
+			ops:  `[{"op": "replace", "path": "urn:ietf:params:scim:schemas:core:2.0:User:userName", "value": "carol"}]`,
+			want: userState{userName: "carol", email: "alice@example.com", role: "member", active: true, externalID: "ext-1"},
+		},
+		{
+			name: "operations apply in order",
+			ops:  `[{"op": "remove", "path": "externalId"}, {"op": "remove", "path": "roles"}, {"op": "add", "path": "roles", "value": [{"value": "manager"}]}]`,
+			want: userState{userName: "alice", email: "alice@example.com", role: "manager", active: true},
+		},
+		{
+			name: "unknown attributes are ignored",
+			ops:  `[{"op": "add", "path": "name.givenName", "value": "Alice"}]`,
+			want: initial,
+		},
+		{name: "no operations", ops: `[]`, wantType: "invalidSyntax"},
+		{name: "unknown operation", ops: `[{"op": "move", "path": "userName"}]`, wantType: "invalidSyntax"},
+		{name: "remove without path", ops: `[{"op": "remove"}]`, wantType: "noTarget"},
+		{name: "remove required attribute", ops: `[{"op": "remove", "path": "userName"}]`, wantType: "mutability"},
+		{name: "bad path", ops: `[{"op": "replace", "path": "emails[type eq].value", "value": "x"}]`, wantType: "invalidPath"},
+		{name: "wrong value type", ops: `[{"op": "replace", "path": "active", "value": 1}]`, wantType: "invalidValue"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := initial
+			err := applyPatch(&state, decodeOps(t, tt.ops))
+			if tt.wantType != "" {
+				var scimErr *Error
+				if !errors.As(err, &scimErr) || scimErr.ScimType != tt.wantType {
+					t.Fatalf("got %v, want a %s error", err, tt.wantType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state != tt.want {
+				t.Errorf("got %+v, want %+v", state, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchGroup(t *testing.T) {
+	tests := []struct {
+		name     string
+		ops      string
+		want     []string
+		wantType string
+	}{
+		{
+			name: "add members",
+			ops:  `[{"op": "add", "path": "members", "value": [{"value": "d"}, {"value": "a"}]}]`,
+			want: []string{"a", "b", "c", "d"},
+		},
+		{
+			name: "replace members",
+			ops:  `[{"op": "replace", "path": "members", "value": [{"value": "d"}]}]`,
+			want: []string{"d"},
+		},
+		{
+			name: "remove by filter",
+			ops:  `[{"op": "remove", "path": "members[value eq \"a\" or value eq \"c\"]"}]`,
+			want: []string{"b"},
+		},
+		{
+			name: "remove listed members",
+			ops:  `[{"op": "remove", "path": "members", "value": [{"value": "b"}]}]`,
+			want: []string{"a", "c"},
+		},
+		{
+			name: "remove all members",
+			ops:  `[{"op": "remove", "path": "members"}]`,
+			want: []string{},
+		},
+		{
+			name: "members in a value object",
+			ops:  `[{"op": "add", "value": {"members": [{"value": "d"}]}}]`,
+			want: []string{"a", "b", "c", "d"},
+		},
+		{name: "filter on another attribute", ops: `[{"op": "remove", "path": "members[display eq \"Alice\"]"}]`, wantType: "invalidFilter"},
+		{name: "add through a filter", ops: `[{"op": "add", "path": "members[value eq \"a\"]", "value": [{"value": "a"}]}]`, wantType: "invalidPath"},
+		{name: "member without value", ops: `[{"op": "add", "path": "members", "value": [{"display": "Alice"}]}]`, wantType: "invalidValue"},
+		{name: "remove displayName", ops: `[{"op": "remove", "path": "displayName"}]`, wantType: "mutability"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := groupState{displayName: "Team", members: []string{"a", "b", "c"}}
+			err := applyPatch(&state, decodeOps(t, tt.ops))
+			if tt.wantType != "" {
+				var scimErr *Error
+				if !errors.As(err, &scimErr) || scimErr.ScimType != tt.wantType {
+					t.Fatalf("got %v, want a %s error", err, tt.wantType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(state.members) != 0 || len(tt.want) != 0 {
+				if !reflect.DeepEqual(state.members, tt.want) {
+					t.Errorf("members = %v, want %v", state.members, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+// Package scim serves the SCIM 2.0 protocol (RFC 7643 and 7644) so that an
+// identity provider can create, update and deactivate users and keep teams
+// and their members in sync. Users map to models.User and groups to
+// models.Team, with a group's members kept in team_members.
+package scim
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"user-team-asset-management/internal/accounts"
+	"user-team-asset-management/internal/auth"
+	"user-team-asset-management/internal/events"
+	"user-team-asset-management/internal/logger"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	schemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	schemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	schemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	schemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	schemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	schemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	schemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+
+	contentType = "application/scim+json; charset=utf-8"
+
+	// maxResults caps the count of a list request
+	maxResults = 200
+	// maxBodyBytes caps the size of request bodies
+	maxBodyBytes = 1 << 20
+)
+
+// Server answers SCIM requests authenticated with a single bearer token,
+// which is all that identity providers need. Changes are made on behalf of
+// no user, so audit entries record them without an actor.
+type Server struct {
+	DB        *gorm.DB
+	Accounts  *accounts.Service
+	Passwords *auth.PasswordManager
+	Events    *events.Bus
+
+	// Token is the bearer token the identity provider sends
+	Token string
+
+	// BaseURL is where the routes are reachable from the identity provider,
+	// for the location of resources, such as https://example.com/scim/v2
+	BaseURL string
+
+	// DefaultRole is given to users created without a role
+	DefaultRole string
+}
+
+// Register adds the SCIM routes to r, which should be the /scim/v2 group.
+func (s *Server) Register(r gin.IRouter) {
+	r.Use(s.authenticate)
+
+	r.GET("/ServiceProviderConfig", s.handle(s.serviceProviderConfig))
+	r.GET("/ResourceTypes", s.handle(s.resourceTypes))
+
+	r.GET("/Users", s.handle(s.listUsers))
+	r.POST("/Users", s.handle(s.createUser))
+	r.GET("/Users/:id", s.handle(s.getUser))
+	r.PUT("/Users/:id", s.handle(s.replaceUser))
+	r.PATCH("/Users/:id", s.handle(s.patchUser))
+	r.DELETE("/Users/:id", s.handle(s.deleteUser))
+
+	r.GET("/Groups", s.handle(s.listGroups))
+	r.POST("/Groups", s.handle(s.createGroup))
+	r.GET("/Groups/:id", s.handle(s.getGroup))
+	r.PUT("/Groups/:id", s.handle(s.replaceGroup))
+	r.PATCH("/Groups/:id", s.handle(s.patchGroup))
+	r.DELETE("/Groups/:id", s.handle(s.deleteGroup))
+}
+
+// authenticate compares hashes of the tokens, so that the comparison takes
+// as long whatever their lengths.
+func (s *Server) authenticate(c *gin.Context) {
+	header := c.GetHeader("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	want := sha256.Sum256([]byte(s.Token))
+	got := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	if !ok || s.Token == "" || subtle.ConstantTimeCompare(want[:], got[:]) != 1 {
+		c.Header("WWW-Authenticate", `Bearer realm="scim"`)
+		writeError(c, &Error{Status: http.StatusUnauthorized, Detail: "invalid bearer token"})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// Error is a SCIM error response. ScimType is only set for the 400 and 409
+// errors that RFC 7644 gives a type.
+type Error struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+func badRequest(scimType, format string, args ...interface{}) *Error {
+	return &Error{Status: http.StatusBadRequest, ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+func notFound(resource, id string) *Error {
+	return &Error{Status: http.StatusNotFound, Detail: fmt.Sprintf("%s %s not found", resource, id)}
+}
+
+func conflict(format string, args ...interface{}) *Error {
+	return &Error{Status: http.StatusConflict, ScimType: "uniqueness", Detail: fmt.Sprintf(format, args...)}
+}
+
+// handle turns the error of fn into a SCIM error response. Errors other
+// than *Error are logged and answered with a bare 500.
+func (s *Server) handle(fn func(c *gin.Context) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := fn(c)
+		if err == nil {
+			return
+		}
+		var scimErr *Error
+		if !errors.As(err, &scimErr) {
+			logger.DefaultLogger.Error(fmt.Sprintf("SCIM %s %s failed: %v", c.Request.Method, c.Request.URL.Path, err))
+			scimErr = &Error{Status: http.StatusInternalServerError, Detail: "internal server error"}
+		}
+		writeError(c, scimErr)
+	}
+}
+
+func writeError(c *gin.Context, e *Error) {
+	body := gin.H{
+		"schemas": []string{schemaError},
+		"status":  strconv.Itoa(e.Status),
+		"detail":  e.Detail,
+	}
+	if e.ScimType != "" {
+		body["scimType"] = e.ScimType
+	}
+	write(c, e.Status, body)
+}
+
+func write(c *gin.Context, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		logger.DefaultLogger.Error(fmt.Sprintf("Failed to encode SCIM response: %v", err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(status, contentType, body)
+}
+
+// readObject reads a JSON object from the request body, with its keys
+// lower-cased since SCIM attribute names are case-insensitive.
+func readObject(c *gin.Context) (map[string]json.RawMessage, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes)).Decode(&raw); err != nil {
+		return nil, badRequest("invalidSyntax", "request body is not valid JSON")
+	}
+	return decodeObject(raw)
+}
+
+// meta is the meta attribute of every resource.
+type meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created"`
+	LastModified string `json:"lastModified"`
+	Location     string `json:"location"`
+}
+
+// reference points from a user to a group or the other way around.
+type reference struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+func (s *Server) location(endpoint, id string) string {
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + endpoint + "/" + id
+}
+
+type listResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int64         `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+func newListResponse(total int64, startIndex int, resources []interface{}) listResponse {
+	if resources == nil {
+		resources = []interface{}{}
+	}
+	return listResponse{
+		Schemas:      []string{schemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+// page reads the 1-based startIndex and count of a list request. Values out
+// of range are clamped, as RFC 7644 asks.
+func page(c *gin.Context) (startIndex, count int) {
+	startIndex, err := strconv.Atoi(c.Query("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err = strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(maxResults)))
+	if err != nil || count > maxResults {
+		count = maxResults
+	}
+	if count < 0 {
+		count = 0
+	}
+	return startIndex, count
+}
+
+// filtered applies the filter of a list request to query.
+func filtered(c *gin.Context, query *gorm.DB, attrs map[string]attribute) (*gorm.DB, error) {
+	filter := strings.TrimSpace(c.Query("filter"))
+	if filter == "" {
+		return query, nil
+	}
+	n, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	cond, args, err := toSQL(n, attrs, "")
+	if err != nil {
+		return nil, err
+	}
+	return query.Where(cond, args...), nil
+}
+
+func (s *Server) serviceProviderConfig(c *gin.Context) error {
+	write(c, http.StatusOK, gin.H{
+		"schemas":          []string{schemaServiceProviderConfig},
+		"documentationUri": "https://datatracker.ietf.org/doc/html/rfc7644",
+		"patch":            gin.H{"supported": true},
+		"bulk":             gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           gin.H{"supported": true, "maxResults": maxResults},
+		"changePassword":   gin.H{"supported": false},
+		"sort":             gin.H{"supported": false},
+		"etag":             gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "The token configured in SCIM_TOKEN",
+			"primary":     true,
+		}},
+		"meta": gin.H{
+			"resourceType": "ServiceProviderConfig",
+			"location":     strings.TrimSuffix(s.BaseURL, "/") + "/ServiceProviderConfig",
+		},
+	})
+	return nil
+}
+
+func (s *Server) resourceTypes(c *gin.Context) error {
+	resourceType := func(name, endpoint, schema string) interface{} {
+		return gin.H{
+			"schemas":  []string{schemaResourceType},
+			"id":       name,
+			"name":     name,
+			"endpoint": "/" + endpoint,
+			"schema":   schema,
+			"meta": gin.H{
+				"resourceType": "ResourceType",
+				"location":     s.location("ResourceTypes", name),
+			},
+		}
+	}
+	write(c, http.StatusOK, newListResponse(2, 1, []interface{}{
+		resourceType("User", "Users", schemaUser),
+		resourceType("Group", "Groups", schemaGroup),
+	}))
+	return nil
+}
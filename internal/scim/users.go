@@ -0,0 +1,471 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"user-team-asset-management/internal/accounts"
+	"user-team-asset-management/internal/audit"
+	"user-team-asset-management/internal/auth"
+	"user-team-asset-management/internal/mail"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/policy"
+	"user-team-asset-management/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// userAttributes are the User attributes that filters can use. A user has
+// a single email address, of type "work", and a single role. Service
+// accounts are not users as far as SCIM is concerned.
+var userAttributes = map[string]attribute{
+	"id":                {column: "users.id", caseExact: true},
+	"externalid":        {column: "users.external_id", caseExact: true},
+	"username":          {column: "users.username"},
+	"displayname":       {column: "users.username"},
+	"emails":            {column: "users.email"},
+	"emails.value":      {column: "users.email"},
+	"emails.type":       {column: "'work'"},
+	"roles":             {column: "users.role"},
+	"roles.value":       {column: "users.role"},
+	"active":            {column: "users.active", kind: kindBool},
+	"meta.created":      {column: "users.created_at", kind: kindTime},
+	"meta.lastmodified": {column: "users.updated_at", kind: kindTime},
+}
+
+type userResource struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	UserName    string       `json:"userName"`
+	DisplayName string       `json:"displayName"`
+	Active      bool         `json:"active"`
+	Emails      []multiValue `json:"emails"`
+	Roles       []multiValue `json:"roles"`
+	Groups      []reference  `json:"groups"`
+	Meta        meta         `json:"meta"`
+}
+
+type multiValue struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary"`
+}
+
+func (s *Server) userResource(user models.User, groups []reference) userResource {
+	if groups == nil {
+		groups = []reference{}
+	}
+	return userResource{
+		Schemas:     []string{schemaUser},
+		ID:          user.ID,
+		ExternalID:  user.ExternalID,
+		UserName:    user.Username,
+		DisplayName: user.Username,
+		Active:      user.Active,
+		Emails:      []multiValue{{Value: user.Email, Type: "work", Primary: true}},
+		Roles:       []multiValue{{Value: user.Role, Primary: true}},
+		Groups:      groups,
+		Meta: meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt.UTC().Format(time.RFC3339),
+			LastModified: user.UpdatedAt.UTC().Format(time.RFC3339),
+			Location:     s.location("Users", user.ID),
+		},
+	}
+}
+
+// userState holds the attributes of a user that requests can write. Other
+// attributes, such as name or phoneNumbers, are accepted and ignored.
+type userState struct {
+	userName   string
+	email      string
+	externalID string
+	role       string
+	active     bool
+	password   string
+}
+
+func stateOfUser(user models.User) userState {
+	return userState{
+		userName:   user.Username,
+		email:      user.Email,
+		externalID: user.ExternalID,
+		role:       user.Role,
+		active:     user.Active,
+	}
+}
+
+func (u *userState) set(p path, value json.RawMessage, op string) error {
+	var err error
+	switch p.attr {
+	case "username":
+		u.userName, err = decodeString(value, "userName")
+	case "externalid":
+		u.externalID, err = decodeString(value, "externalId")
+	case "active":
+		u.active, err = decodeBool(value, "active")
+	case "password":
+		u.password, err = decodeString(value, "password")
+	case "emails":
+		// Sub-attributes other than value, such as type, describe the one
+		// address a user has
+		var email string
+		switch p.subAttr {
+		case "value":
+			email, err = decodeString(value, "emails.value")
+		case "":
+			email, err = primaryValue(value, "emails")
+		}
+		if email != "" {
+			u.email = email
+		}
+	case "roles":
+		var role string
+		switch p.subAttr {
+		case "value":
+			role, err = decodeString(value, "roles.value")
+		case "":
+			role, err = primaryValue(value, "roles")
+		}
+		if role != "" {
+			u.role = role
+		}
+	}
+	return err
+}
+
+func (u *userState) remove(p path, value json.RawMessage) error {
+	switch p.attr {
+	case "externalid":
+		u.externalID = ""
+	case "roles":
+		u.role = ""
+	case "username", "emails", "active":
+		return badRequest("mutability", "%s cannot be removed", p.attr)
+	}
+	return nil
+}
+
+// validate normalizes the email address, and gives users without a role
+// the default one.
+func (s *Server) validate(u *userState) error {
+	if u.userName == "" {
+		return badRequest("invalidValue", "userName is required")
+	}
+	email, err := mail.NormalizeAddress(u.email)
+	if err != nil {
+		return badRequest("invalidValue", "a valid email address is required")
+	}
+	u.email = email
+	if u.role == "" {
+		u.role = s.DefaultRole
+	}
+	if !policy.IsGlobalRole(u.role) {
+		return badRequest("invalidValue", "unknown role %q", u.role)
+	}
+	return nil
+}
+
+func (s *Server) findUser(id string) (*models.User, error) {
+	var users []models.User
+	if err := s.DB.Where("id = ? AND NOT service_account", id).Limit(1).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, notFound("User", id)
+	}
+	return &users[0], nil
+}
+
+// groupsOf returns the teams each of the users is a member of.
+func (s *Server) groupsOf(userIDs []string) (map[string][]reference, error) {
+	var rows []struct {
+		UserID   string
+		TeamID   string
+		TeamName string
+	}
+	if err := s.DB.Table("team_members").
+		Select("team_members.user_id, teams.id AS team_id, teams.team_name").
+		Joins("JOIN teams ON teams.id = team_members.team_id").
+		Where("team_members.user_id IN ?", userIDs).
+		Order("teams.team_name").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]reference)
+	for _, row := range rows {
+		groups[row.UserID] = append(groups[row.UserID], reference{
+			Value:   row.TeamID,
+			Display: row.TeamName,
+			Ref:     s.location("Groups", row.TeamID),
+		})
+	}
+	return groups, nil
+}
+
+func (s *Server) writeUser(c *gin.Context, status int, user *models.User) error {
+	groups, err := s.groupsOf([]string{user.ID})
+	if err != nil {
+		return err
+	}
+	if status == http.StatusCreated {
+		c.Header("Location", s.location("Users", user.ID))
+	}
+	write(c, status, s.userResource(*user, groups[user.ID]))
+	return nil
+}
+
+func (s *Server) listUsers(c *gin.Context) error {
+	query, err := filtered(c, s.DB.Model(&models.User{}).Where("NOT users.service_account"), userAttributes)
+	if err != nil {
+		return err
+	}
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return err
+	}
+	startIndex, count := page(c)
+	var users []models.User
+	if count > 0 {
+		if err := query.Order("users.created_at, users.id").
+			Offset(startIndex - 1).
+			Limit(count).
+			Find(&users).Error; err != nil {
+			return err
+		}
+	}
+
+	userIDs := make([]string, len(users))
+	for i, user := range users {
+		userIDs[i] = user.ID
+	}
+	groups, err := s.groupsOf(userIDs)
+	if err != nil {
+		return err
+	}
+	resources := make([]interface{}, len(users))
+	for i, user := range users {
+		resources[i] = s.userResource(user, groups[user.ID])
+	}
+	write(c, http.StatusOK, newListResponse(total, startIndex, resources))
+	return nil
+}
+
+func (s *Server) getUser(c *gin.Context) error {
+	user, err := s.findUser(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return s.writeUser(c, http.StatusOK, user)
+}
+
+// createUser creates a user whose email address counts as verified, since
+// the identity provider vouches for it. Without a password the user can
+// only log in through single sign-on until they reset it.
+func (s *Server) createUser(c *gin.Context) error {
+	values, err := readObject(c)
+	if err != nil {
+		return err
+	}
+	state := userState{active: true}
+	if err := setAll(&state, values, "add"); err != nil {
+		return err
+	}
+	if err := s.validate(&state); err != nil {
+		return err
+	}
+
+	var hash string
+	if state.password != "" {
+		hash, err = s.Passwords.Hash(state.password, state.userName, state.email)
+		var policyErr *auth.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return badRequest("invalidValue", "%v", err)
+		}
+	} else {
+		var raw []byte
+		raw, err = bcrypt.GenerateFromPassword([]byte(utils.GenerateSecret()), bcrypt.DefaultCost)
+		hash = string(raw)
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	user := &models.User{
+		ID:              utils.GenerateID(),
+		Username:        state.userName,
+		Email:           state.email,
+		PasswordHash:    hash,
+		Role:            state.role,
+		EmailVerifiedAt: &now,
+		ExternalID:      state.externalID,
+		Active:          true,
+	}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkEmailFree(tx, user.Email, user.ID); err != nil {
+			return err
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, models.AuditEntry{
+			Action:       audit.ActionUserProvisioned,
+			TargetUserID: user.ID,
+			Detail:       fmt.Sprintf("through SCIM as %s", user.Role),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if !state.active {
+		if user, err = s.setActive(user, false); err != nil {
+			return err
+		}
+	}
+	return s.writeUser(c, http.StatusCreated, user)
+}
+
+// replaceUser sets the attributes in the request. Writable attributes that
+// are left out keep their values, rather than being cleared.
+func (s *Server) replaceUser(c *gin.Context) error {
+	user, err := s.findUser(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	values, err := readObject(c)
+	if err != nil {
+		return err
+	}
+	state := stateOfUser(*user)
+	if err := setAll(&state, values, "replace"); err != nil {
+		return err
+	}
+	if user, err = s.updateUser(user, state); err != nil {
+		return err
+	}
+	return s.writeUser(c, http.StatusOK, user)
+}
+
+func (s *Server) patchUser(c *gin.Context) error {
+	user, err := s.findUser(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	var req patchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return badRequest("invalidSyntax", "invalid PATCH request")
+	}
+	state := stateOfUser(*user)
+	if err := applyPatch(&state, req.Operations); err != nil {
+		return err
+	}
+	if user, err = s.updateUser(user, state); err != nil {
+		return err
+	}
+	return s.writeUser(c, http.StatusOK, user)
+}
+
+// deleteUser deactivates the user rather than deleting them, so that their
+// folders and notes are kept. The user still shows up, with active false.
+func (s *Server) deleteUser(c *gin.Context) error {
+	user, err := s.findUser(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	if _, err := s.setActive(user, false); err != nil {
+		return err
+	}
+	c.Status(http.StatusNoContent)
+	return nil
+}
+
+// updateUser saves state. A new email address takes effect right away and
+// counts as verified. Passwords are only set when users are created.
+func (s *Server) updateUser(user *models.User, state userState) (*models.User, error) {
+	if err := s.validate(&state); err != nil {
+		return nil, err
+	}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", user.ID).
+			First(user).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"username":    state.userName,
+			"external_id": state.externalID,
+			"role":        state.role,
+		}
+		if state.email != user.Email {
+			if err := checkEmailFree(tx, state.email, user.ID); err != nil {
+				return err
+			}
+			// Links mailed for an earlier change must not undo this one
+			if err := tx.Model(&models.EmailVerificationToken{}).
+				Where("user_id = ? AND used_at IS NULL", user.ID).
+				Update("used_at", time.Now()).Error; err != nil {
+				return err
+			}
+			updates["email"] = state.email
+			updates["pending_email"] = ""
+			updates["email_verified_at"] = time.Now()
+			if err := audit.Record(tx, models.AuditEntry{
+				Action:       audit.ActionEmailChanged,
+				TargetUserID: user.ID,
+				Detail:       fmt.Sprintf("from %s to %s through SCIM", user.Email, state.email),
+			}); err != nil {
+				return err
+			}
+		}
+		return tx.Model(user).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.setActive(user, state.active)
+}
+
+// setActive deactivates or reactivates the user through the accounts
+// service, which signs deactivated users out.
+func (s *Server) setActive(user *models.User, active bool) (*models.User, error) {
+	var err error
+	switch {
+	case user.Active && !active:
+		_, err = s.Accounts.Deactivate("", user.ID, "")
+	case !user.Active && active:
+		_, err = s.Accounts.Reactivate("", user.ID)
+	default:
+		return user, nil
+	}
+	if err != nil && !errors.Is(err, accounts.ErrAlreadyDeactivated) && !errors.Is(err, accounts.ErrNotDeactivated) {
+		return nil, err
+	}
+	return s.findUser(user.ID)
+}
+
+func checkEmailFree(tx *gorm.DB, email, userID string) error {
+	var count int64
+	if err := tx.Model(&models.User{}).
+		Where("LOWER(email) = LOWER(?) AND id <> ?", email, userID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return conflict("email address %s is already in use", email)
+	}
+	return nil
+}
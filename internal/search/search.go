@@ -0,0 +1,176 @@
+// Package search implements full-text search over notes and folders using
+// Postgres tsvector columns.
+package search
+
+import (
+	"html"
+	"strings"
+	"time"
+	"user-team-asset-management/internal/access"
+
+	"gorm.io/gorm"
+)
+
+// TextSearchConfig is the Postgres text search configuration used for both
+// indexing and querying. "simple" does not stem, so it behaves the same for
+// every language.
+const TextSearchConfig = "simple"
+
+const (
+	TypeNote   = "note"
+	TypeFolder = "folder"
+)
+
+// ts_headline marks matches with these control characters rather than HTML,
+// so the stored text can be escaped before the marks become <mark> tags.
+// They are stripped from the source first so content cannot forge them.
+const (
+	startSel    = "\x02"
+	stopSel     = "\x03"
+	sentinels   = startSel + stopSel
+	headlineSel = `StartSel="` + startSel + `", StopSel="` + stopSel + `"`
+)
+
+var marks = strings.NewReplacer(startSel, "<mark>", stopSel, "</mark>")
+
+// highlight HTML-escapes a headline and turns its sentinels into <mark>
+// tags.
+func highlight(headline string) string {
+	return marks.Replace(html.EscapeString(headline))
+}
+
+// Migrate adds the generated tsvector columns and their GIN indexes.
+func Migrate(db *gorm.DB) error {
+	statements := []string{
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS search_vector tsvector
+			GENERATED ALWAYS AS (
+				setweight(to_tsvector('` + TextSearchConfig + `', coalesce(title, '')), 'A') ||
+				setweight(to_tsvector('` + TextSearchConfig + `', coalesce(body, '')), 'B')
+			) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_notes_search_vector ON notes USING GIN (search_vector)`,
+		`ALTER TABLE folders ADD COLUMN IF NOT EXISTS search_vector tsvector
+			GENERATED ALWAYS AS (to_tsvector('` + TextSearchConfig + `', coalesce(name, ''))) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_folders_search_vector ON folders USING GIN (search_vector)`,
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Result is a single ranked hit. Title and Snippet are HTML-escaped, with
+// matched terms wrapped in <mark></mark>.
+type Result struct {
+	Type      string    `json:"type"`
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Snippet   string    `json:"snippet"`
+	FolderID  *string   `json:"folderId"`
+	Rank      float64   `json:"rank"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type Query struct {
+	UserID string
+	Text   string
+	// Type limits results to TypeNote or TypeFolder; empty searches both.
+	Type   string
+	Limit  int
+	Offset int
+}
+
+type Service struct {
+	DB *gorm.DB
+}
+
+// Search returns the hits the user may read, best match first, along with
+// the total number of hits.
+func (s *Service) Search(q Query) ([]Result, int64, error) {
+	var rows []struct {
+		Result
+		Total int64
+	}
+
+	err := s.DB.Raw(hitsQuery(`'note' AS type, n.id,
+				ts_headline('`+TextSearchConfig+`', translate(n.title, @sentinels, ''), q.query, 'HighlightAll=true, `+headlineSel+`') AS title,
+				ts_headline('`+TextSearchConfig+`', translate(coalesce(n.body, ''), @sentinels, ''), q.query, 'MaxFragments=2, MaxWords=25, MinWords=8, `+headlineSel+`') AS snippet,
+				n.folder_id, ts_rank(n.search_vector, q.query) AS rank, n.updated_at`,
+		`'folder' AS type, f.id,
+				ts_headline('`+TextSearchConfig+`', translate(f.name, @sentinels, ''), q.query, 'HighlightAll=true, `+headlineSel+`') AS title,
+				'' AS snippet,
+				f.parent_id AS folder_id, ts_rank(f.search_vector, q.query) AS rank, f.updated_at`)+`
+		SELECT *, COUNT(*) OVER () AS total FROM hits
+		ORDER BY rank DESC, updated_at DESC, id
+		LIMIT @limit OFFSET @offset
+	`, map[string]interface{}{
+		"user_id":   q.UserID,
+		"text":      q.Text,
+		"type":      q.Type,
+		"limit":     q.Limit,
+		"offset":    q.Offset,
+		"sentinels": sentinels,
+	}).Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results := make([]Result, len(rows))
+	var total int64
+	for i, row := range rows {
+		results[i] = row.Result
+		results[i].Title = highlight(row.Title)
+		results[i].Snippet = highlight(row.Snippet)
+		total = row.Total
+	}
+
+	// Past the last page the window function has no rows to report on
+	if len(rows) == 0 && q.Offset > 0 {
+		total, err = s.count(q)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return results, total, nil
+}
+
+// count returns the number of hits without ranking or highlighting them.
+func (s *Service) count(q Query) (int64, error) {
+	var total int64
+	err := s.DB.Raw(hitsQuery("n.id", "f.id")+`
+		SELECT COUNT(*) FROM hits
+	`, map[string]interface{}{
+		"user_id": q.UserID,
+		"text":    q.Text,
+		"type":    q.Type,
+	}).Scan(&total).Error
+	return total, err
+}
+
+// hitsQuery returns the common table expressions that select the notes and
+// folders matching @text that @user_id may read into "hits", with the given
+// columns for either kind of hit.
+func hitsQuery(noteColumns, folderColumns string) string {
+	return `
+		WITH RECURSIVE ` + access.ReadableFoldersCTE + `,
+		q AS (SELECT websearch_to_tsquery('` + TextSearchConfig + `', @text) AS query),
+		hits AS (
+			SELECT ` + noteColumns + `
+			FROM notes n, q
+			WHERE n.deleted_at IS NULL AND n.search_vector @@ q.query
+				AND (@type = '' OR @type = 'note')
+				AND (
+					n.owner_id = @user_id OR
+					EXISTS (SELECT 1 FROM note_shares ns WHERE ns.note_id = n.id AND ns.user_id = @user_id) OR
+					n.folder_id IN (SELECT id FROM readable_folders)
+				)
+			UNION ALL
+			SELECT ` + folderColumns + `
+			FROM folders f, q
+			WHERE f.deleted_at IS NULL AND f.search_vector @@ q.query
+				AND (@type = '' OR @type = 'folder')
+				AND f.id IN (SELECT id FROM readable_folders)
+		)`
+}
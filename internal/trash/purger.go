@@ -0,0 +1,65 @@
+package trash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"user-team-asset-management/internal/logger"
+	"user-team-asset-management/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Purger periodically purges trash entries whose retention has expired.
+type Purger struct {
+	DB       *gorm.DB
+	Interval time.Duration
+}
+
+// Run purges expired entries every Interval until ctx is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := p.PurgeExpired()
+		if err != nil {
+			logger.DefaultLogger.Error(fmt.Sprintf("Trash purge incomplete: %v", err))
+		}
+		if n > 0 {
+			logger.DefaultLogger.Info(fmt.Sprintf("Purged %d expired trash entries", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeExpired permanently removes every entry past its purge time and
+// returns how many were removed. An entry that fails to purge is skipped so
+// it cannot hold back the rest; the failures are returned joined, one line
+// per entry.
+func (p *Purger) PurgeExpired() (int, error) {
+	var entries []models.TrashEntry
+	if err := p.DB.Where("purge_at <= ?", time.Now()).Find(&entries).Error; err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	var errs []error
+	for i := range entries {
+		err := p.DB.Transaction(func(tx *gorm.DB) error {
+			return Purge(tx, &entries[i])
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("trash entry %s: %w", entries[i].ID, err))
+			continue
+		}
+		purged++
+	}
+	return purged, errors.Join(errs...)
+}
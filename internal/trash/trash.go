@@ -0,0 +1,189 @@
+// Package trash implements soft deletion of folders and notes, restoring
+// them, and permanently purging them once the retention period is over.
+package trash
+
+import (
+	"errors"
+	"time"
+	"user-team-asset-management/internal/models"
+	"user-team-asset-management/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ItemFolder = "folder"
+	ItemNote   = "note"
+)
+
+// ErrParentTrashed is returned when restoring a note whose folder is itself
+// in the trash or gone.
+var ErrParentTrashed = errors.New("the containing folder is not available, restore it first")
+
+// TrashFolders soft deletes the given folders (the first being the one the
+// user deleted) and every live note inside them as one trash entry.
+func TrashFolders(tx *gorm.DB, folder *models.Folder, folderIDs []string, deletedBy string, retention time.Duration) (*models.TrashEntry, error) {
+	entry := newEntry(ItemFolder, folder.ID, folder.Name, folder.OwnerID, deletedBy, retention)
+	if err := tx.Create(entry).Error; err != nil {
+		return nil, err
+	}
+
+	trashed := map[string]interface{}{"deleted_at": entry.DeletedAt, "trash_id": entry.ID}
+	if err := tx.Model(&models.Note{}).Where("folder_id IN ?", folderIDs).Updates(trashed).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.Folder{}).Where("id IN ?", folderIDs).Updates(trashed).Error; err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// TrashNote soft deletes a single note.
+func TrashNote(tx *gorm.DB, note *models.Note, deletedBy string, retention time.Duration) (*models.TrashEntry, error) {
+	entry := newEntry(ItemNote, note.ID, note.Title, note.OwnerID, deletedBy, retention)
+	if err := tx.Create(entry).Error; err != nil {
+		return nil, err
+	}
+
+	trashed := map[string]interface{}{"deleted_at": entry.DeletedAt, "trash_id": entry.ID}
+	if err := tx.Model(&models.Note{}).Where("id = ?", note.ID).Updates(trashed).Error; err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Contents returns the folders and notes deleted together as the entry. Only
+// IDs and parent IDs are loaded. Restore unlinks them from the entry, so
+// call it before restoring.
+func Contents(tx *gorm.DB, entry *models.TrashEntry) ([]models.Folder, []models.Note, error) {
+	var folders []models.Folder
+	if err := tx.Unscoped().Select("id", "parent_id").Where("trash_id = ?", entry.ID).Find(&folders).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var notes []models.Note
+	if err := tx.Unscoped().Select("id", "folder_id").Where("trash_id = ?", entry.ID).Find(&notes).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return folders, notes, nil
+}
+
+// Restore brings back everything deleted with the entry. Shares were never
+// removed, so they become effective again as well. A folder whose parent is
+// no longer available is restored at the top level.
+func Restore(tx *gorm.DB, entry *models.TrashEntry) error {
+	switch entry.ItemType {
+	case ItemFolder:
+		var folder models.Folder
+		if err := tx.Unscoped().Where("id = ?", entry.ItemID).First(&folder).Error; err != nil {
+			return err
+		}
+		if folder.ParentID != nil {
+			var count int64
+			if err := tx.Model(&models.Folder{}).Where("id = ?", *folder.ParentID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				if err := tx.Unscoped().Model(&folder).Update("parent_id", nil).Error; err != nil {
+					return err
+				}
+			}
+		}
+	case ItemNote:
+		var note models.Note
+		if err := tx.Unscoped().Where("id = ?", entry.ItemID).First(&note).Error; err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.Folder{}).Where("id = ?", note.FolderID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrParentTrashed
+		}
+	}
+
+	restored := map[string]interface{}{"deleted_at": nil, "trash_id": ""}
+	if err := tx.Unscoped().Model(&models.Folder{}).Where("trash_id = ?", entry.ID).Updates(restored).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Model(&models.Note{}).Where("trash_id = ?", entry.ID).Updates(restored).Error; err != nil {
+		return err
+	}
+
+	return tx.Delete(entry).Error
+}
+
+// Purge permanently removes everything deleted with the entry, including
+// shares and revision history. Notes trashed earlier from a purged folder
+// can no longer be restored, so they are purged along with it.
+func Purge(tx *gorm.DB, entry *models.TrashEntry) error {
+	var folderIDs []string
+	if err := tx.Unscoped().Model(&models.Folder{}).Where("trash_id = ?", entry.ID).Pluck("id", &folderIDs).Error; err != nil {
+		return err
+	}
+
+	noteQuery := tx.Unscoped().Model(&models.Note{}).Where("trash_id = ?", entry.ID)
+	if len(folderIDs) > 0 {
+		noteQuery = noteQuery.Or("folder_id IN ?", folderIDs)
+	}
+	var notes []models.Note
+	if err := noteQuery.Select("id", "trash_id").Find(&notes).Error; err != nil {
+		return err
+	}
+
+	noteIDs := make([]string, 0, len(notes))
+	orphanedEntries := make([]string, 0)
+	for _, note := range notes {
+		noteIDs = append(noteIDs, note.ID)
+		if note.TrashID != "" && note.TrashID != entry.ID {
+			orphanedEntries = append(orphanedEntries, note.TrashID)
+		}
+	}
+
+	if len(noteIDs) > 0 {
+		if err := tx.Where("note_id IN ?", noteIDs).Delete(&models.NoteShare{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("note_id IN ?", noteIDs).Delete(&models.NoteRevision{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("id IN ?", noteIDs).Delete(&models.Note{}).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(folderIDs) > 0 {
+		if err := tx.Where("folder_id IN ?", folderIDs).Delete(&models.FolderShare{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("id IN ?", folderIDs).Delete(&models.Folder{}).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(orphanedEntries) > 0 {
+		if err := tx.Where("id IN ?", orphanedEntries).Delete(&models.TrashEntry{}).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Delete(entry).Error
+}
+
+func newEntry(itemType, itemID, name, ownerID, deletedBy string, retention time.Duration) *models.TrashEntry {
+	now := time.Now()
+	return &models.TrashEntry{
+		ID:        utils.GenerateID(),
+		ItemType:  itemType,
+		ItemID:    itemID,
+		Name:      name,
+		OwnerID:   ownerID,
+		DeletedBy: deletedBy,
+		DeletedAt: now,
+		PurgeAt:   now.Add(retention),
+	}
+}
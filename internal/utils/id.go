@@ -9,4 +9,11 @@ func GenerateID() string {
     bytes := make([]byte, 16)
     rand.Read(bytes)
     return hex.EncodeToString(bytes)
-}
\ No newline at end of file
+}
+
+// GenerateSecret returns a random 256-bit value suitable for opaque tokens.
+func GenerateSecret() string {
+    bytes := make([]byte, 32)
+    rand.Read(bytes)
+    return hex.EncodeToString(bytes)
+}